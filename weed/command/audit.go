@@ -0,0 +1,37 @@
+package command
+
+import (
+	"flag"
+
+	"github.com/chrislusf/seaweedfs/weed/glog"
+	"github.com/chrislusf/seaweedfs/weed/security"
+	"github.com/chrislusf/seaweedfs/weed/util"
+)
+
+// AuditOptions holds the audit log flags shared by the master, volume and filer commands.
+type AuditOptions struct {
+	file    *string
+	maxMB   *int
+	backups *int
+	webhook *string
+}
+
+func (o *AuditOptions) addFlags(flags *flag.FlagSet) {
+	o.file = flags.String("audit.file", "", "append the audit log of deletes, moves and other changes to this file, one JSON record per line")
+	o.maxMB = flags.Int("audit.maxMB", 100, "rotate -audit.file when it reaches this size in MB")
+	o.backups = flags.Int("audit.backups", 10, "number of rotated audit files to keep, as file.1, file.2, ...")
+	o.webhook = flags.String("audit.webhook", "", "http(s) url to post the audit records to in batches, one JSON record per line")
+}
+
+//失败时退出
+func (o *AuditOptions) setup() {
+	if err := security.SetupAudit(security.AuditOption{
+		File:      *o.file,
+		MaxMB:     *o.maxMB,
+		Backups:   *o.backups,
+		Webhook:   *o.webhook,
+		Transport: util.Transport,
+	}); err != nil {
+		glog.Fatalf("Audit log setup error: %v", err)
+	}
+}
@@ -191,7 +191,7 @@ func writeFiles(idChan chan int, fileIdLineChan chan string, s *stat) {
 				if df.enterTime.After(time.Now()) {
 					time.Sleep(df.enterTime.Sub(time.Now()))
 				}
-				if e := util.Delete("http://"+df.fp.Server+"/"+df.fp.Fid,
+				if e := util.Delete(util.SchemePrefix+df.fp.Server+"/"+df.fp.Fid,
 					security.GenJwt(secret, df.fp.Fid)); e == nil {
 					s.completed++
 				} else {
@@ -259,7 +259,7 @@ func readFiles(fileIdLineChan chan string, s *stat) {
 			continue
 		}
 		server := ret.Locations[rand.Intn(len(ret.Locations))].Url
-		url := "http://" + server + "/" + fid
+		url := util.SchemePrefix + server + "/" + fid
 		if bytesRead, err := util.Get(url); err == nil {
 			s.completed++
 			s.transferred += int64(len(bytesRead))
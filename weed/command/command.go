@@ -22,6 +22,7 @@ var Commands = []*Command{
 	cmdShell,
 	cmdVersion,
 	cmdVolume,
+	cmdVolumeCheck,
 	cmdExport,
 	cmdMount,
 }
@@ -37,7 +37,7 @@ func runCompact(cmd *Command, args []string) bool {
 	if err != nil {
 		glog.Fatalf("Load Volume [ERROR] %s\n", err)
 	}
-	if err = v.Compact(); err != nil {
+	if err = v.Compact(0); err != nil {
 		glog.Fatalf("Compact Volume [ERROR] %s\n", err)
 	}
 
@@ -38,6 +38,8 @@ var cmdExport = &Command{
 
 	The format of file name in the tar file can be customized. Default is {{.Mime}}/{{.Id}}:{{.Name}}. Also available is {{.Key}}.
 
+	User metadata of a file is kept as json in the "SEAWEEDFS.pairs" pax record of its tar header.
+
   `,
 }
 
@@ -155,6 +157,9 @@ func runExport(cmd *Command, args []string) bool {
 	return true
 }
 
+// the pax record holding the user metadata of a needle as json
+const tarPairsRecord = "SEAWEEDFS.pairs"
+
 type nameParams struct {
 	Name string
 	Id   uint64
@@ -192,6 +197,11 @@ func walker(vid storage.VolumeId, n *storage.Needle, version storage.Version) (e
 			tarHeader.ModTime = time.Unix(0, 0)
 		}
 		tarHeader.ChangeTime = tarHeader.ModTime
+		//用户元数据以json保存在pax扩展头中
+		tarHeader.PAXRecords = nil
+		if n.HasPairs() && len(n.Pairs) > 0 {
+			tarHeader.PAXRecords = map[string]string{tarPairsRecord: string(n.Pairs)}
+		}
 		if err = tarOutputFile.WriteHeader(&tarHeader); err != nil {
 			return err
 		}
@@ -201,13 +211,17 @@ func walker(vid storage.VolumeId, n *storage.Needle, version storage.Version) (e
 		if version == storage.Version1 {
 			size = n.Size
 		}
-		fmt.Printf("key=%s Name=%s Size=%d gzip=%t mime=%s\n",
+		fmt.Printf("key=%s Name=%s Size=%d gzip=%t mime=%s",
 			key,
 			n.Name,
 			size,
 			n.IsGzipped(),
 			n.Mime,
 		)
+		if n.HasPairs() {
+			fmt.Printf(" pairs=%s", n.Pairs)
+		}
+		fmt.Println()
 	}
 	return
 }
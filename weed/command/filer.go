@@ -4,6 +4,7 @@ import (
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/chrislusf/seaweedfs/weed/glog"
@@ -12,7 +13,11 @@ import (
 )
 
 var (
-	f FilerOptions
+	f                    FilerOptions
+	filerTLS             TLSOptions
+	filerAudit           AuditOptions
+	filerWhiteListOption *string
+	filerTrustedProxies  *string
 )
 
 type FilerOptions struct {
@@ -31,10 +36,15 @@ type FilerOptions struct {
 	redis_server            *string
 	redis_password          *string
 	redis_database          *int
+	whiteList               []string
+	trustedProxies          []string
+	readJwt                 *bool
 }
 
 func init() {
 	cmdFiler.Run = runFiler // break init cycle
+	filerTLS.addFlags(&cmdFiler.Flag)
+	filerAudit.addFlags(&cmdFiler.Flag)
 	f.master = cmdFiler.Flag.String("master", "localhost:9333", "master server location")
 	f.collection = cmdFiler.Flag.String("collection", "", "all data will be stored in this collection")
 	f.ip = cmdFiler.Flag.String("ip", "", "filer server http listen ip address")
@@ -50,6 +60,9 @@ func init() {
 	f.redis_password = cmdFiler.Flag.String("redis.password", "", "password in clear text")
 	f.redis_database = cmdFiler.Flag.Int("redis.database", 0, "the database on the redis server")
 	f.secretKey = cmdFiler.Flag.String("secure.secret", "", "secret to encrypt Json Web Token(JWT)")
+	filerWhiteListOption = cmdFiler.Flag.String("whiteList", "", "comma separated Ip addresses, CIDR ranges or host names allowed to presign download urls. No limit if empty.")
+	filerTrustedProxies = cmdFiler.Flag.String("trustedProxies", "", "comma separated Ip addresses or CIDR ranges of reverse proxies, whose X-Forwarded-For header is trusted to check -whiteList")
+	f.readJwt = cmdFiler.Flag.Bool("jwt.read", false, "require a JWT to read files, bound to the path or a path prefix, with an expiration. Needs -secure.secret, which should also be a key of the volume servers.")
 
 }
 
@@ -76,16 +89,24 @@ var cmdFiler = &Command{
 }
 
 func runFiler(cmd *Command, args []string) bool {
+	filerTLS.setup()
+	filerAudit.setup()
+	if *filerWhiteListOption != "" {
+		f.whiteList = strings.Split(*filerWhiteListOption, ",")
+	}
+	if *filerTrustedProxies != "" {
+		f.trustedProxies = strings.Split(*filerTrustedProxies, ",")
+	}
 
 	if err := util.TestFolderWritable(*f.dir); err != nil {
 		glog.Fatalf("Check Meta Folder (-dir) Writable %s : %s", *f.dir, err)
 	}
 
 	r := http.NewServeMux()
-	_, nfs_err := weed_server.NewFilerServer(r, *f.ip, *f.port, *f.master, *f.dir, *f.collection,
+	fs, nfs_err := weed_server.NewFilerServer(r, *f.ip, *f.port, *f.master, *f.dir, *f.collection,
 		*f.defaultReplicaPlacement, *f.redirectOnRead, *f.disableDirListing,
 		*f.maxMB,
-		*f.secretKey,
+		*f.secretKey, f.whiteList, f.trustedProxies, *f.readJwt,
 		*f.cassandra_server, *f.cassandra_keyspace,
 		*f.redis_server, *f.redis_password, *f.redis_database,
 	)
@@ -100,7 +121,8 @@ func runFiler(cmd *Command, args []string) bool {
 	if e != nil {
 		glog.Fatalf("Filer listener error: %v", e)
 	}
-	if e := http.Serve(filerListener, r); e != nil {
+	filerListener = util.NewTLSListener(filerListener)
+	if e := http.Serve(filerListener, fs.Instrument(r)); e != nil {
 		glog.Fatalf("Filer Fail to serve: %v", e)
 	}
 
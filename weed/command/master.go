@@ -11,6 +11,7 @@ import (
 
 	"github.com/chrislusf/seaweedfs/weed/glog"
 	"github.com/chrislusf/seaweedfs/weed/server"
+	"github.com/chrislusf/seaweedfs/weed/topology"
 	"github.com/chrislusf/seaweedfs/weed/util"
 	"github.com/gorilla/mux"
 )
@@ -18,6 +19,8 @@ import (
 //init函数初始化run方法,启动时调用的方法
 func init() {
 	cmdMaster.Run = runMaster // break init cycle
+	masterTLS.addFlags(&cmdMaster.Flag)
+	masterAudit.addFlags(&cmdMaster.Flag)
 }
 
 //master命令的定义
@@ -48,7 +51,7 @@ var (
 	//心跳间隔
 	mpulse = cmdMaster.Flag.Int("pulseSeconds", 5, "number of seconds between heartbeats")
 	//配置文件
-	confFile = cmdMaster.Flag.String("conf", "/etc/weedfs/weedfs.conf", "Deprecating! xml configuration file")
+	confFile = cmdMaster.Flag.String("conf", "/etc/weedfs/weedfs.conf", "rack map file in json, yaml or the deprecated xml format, reloaded on SIGHUP or /topology/reload")
 	//默认的复制级别
 	defaultReplicaPlacement = cmdMaster.Flag.String("defaultReplication", "000", "Default replication type if not specified.")
 	//连接idle的时间
@@ -57,18 +60,38 @@ var (
 	mMaxCpu = cmdMaster.Flag.Int("maxCpu", 0, "maximum number of CPUs. 0 means all available CPUs")
 	//垃圾回收的阈值
 	garbageThreshold = cmdMaster.Flag.String("garbageThreshold", "0.3", "threshold to vacuum and reclaim spaces")
+	//按collection设置的垃圾回收阈值
+	vacuumCollectionThresholds = cmdMaster.Flag.String("vacuum.collectionThresholds", "", "per collection garbage threshold, e.g., pictures:0.2,logs:0.5")
+	//允许自动垃圾回收的时间窗口
+	vacuumWindows = cmdMaster.Flag.String("vacuum.window", "", "comma separated time windows allowed for automatic vacuum, e.g., 01:00-05:00. Empty means any time.")
+	//每个数据节点同时回收的卷数
+	vacuumConcurrency = cmdMaster.Flag.Int("vacuum.concurrency", 1, "maximum number of volumes vacuumed at the same time on one volume server")
+	//自动垃圾回收的时间间隔
+	vacuumIntervalMinutes = cmdMaster.Flag.Int("vacuum.intervalMinutes", 15, "minutes between automatic vacuum checks")
+	//副本一致性检查
+	replicaCheckIntervalMinutes = cmdMaster.Flag.Int("replicaCheck.intervalMinutes", 0, "minutes between comparing volume replicas. 0 disables the background check.")
+	replicaCheckCopyMissing     = cmdMaster.Flag.Bool("replicaCheck.copyMissing", false, "copy needles missing on a replica from another replica")
+	replicaCheckApplyTombstones = cmdMaster.Flag.Bool("replicaCheck.applyTombstones", false, "delete needles already deleted on another replica")
 	//ip白名单
-	masterWhiteListOption = cmdMaster.Flag.String("whiteList", "", "comma separated Ip addresses having write permission. No limit if empty.")
+	masterWhiteListOption = cmdMaster.Flag.String("whiteList", "", "comma separated Ip addresses, CIDR ranges or host names having write permission. No limit if empty.")
+	//受信任的反向代理
+	masterTrustedProxiesOption = cmdMaster.Flag.String("trustedProxies", "", "comma separated Ip addresses or CIDR ranges of reverse proxies, whose X-Forwarded-For header is trusted to check -whiteList")
 	//加密私钥
 	masterSecureKey = cmdMaster.Flag.String("secure.secret", "", "secret to encrypt Json Web Token(JWT)")
 	//记录cpuprofile的文件
 	masterCpuProfile = cmdMaster.Flag.String("cpuprofile", "", "cpu profile output file")
 
-	masterWhiteList []string
+	masterTLS   TLSOptions
+	masterAudit AuditOptions
+
+	masterWhiteList      []string
+	masterTrustedProxies []string
 )
 
 //master 命令的run函数
 func runMaster(cmd *Command, args []string) bool {
+	masterTLS.setup()
+	masterAudit.setup()
 	//如果设置的最大cpu数小于1，直接取cpu数量
 	if *mMaxCpu < 1 {
 		*mMaxCpu = runtime.NumCPU()
@@ -99,14 +122,35 @@ func runMaster(cmd *Command, args []string) bool {
 		//切割并赋值
 		masterWhiteList = strings.Split(*masterWhiteListOption, ",")
 	}
+	if *masterTrustedProxiesOption != "" {
+		masterTrustedProxies = strings.Split(*masterTrustedProxiesOption, ",")
+	}
+
+	//垃圾回收策略
+	vacuumPolicy, err := topology.NewVacuumPolicy(*garbageThreshold, *vacuumCollectionThresholds, *vacuumWindows,
+		*vacuumConcurrency, time.Duration(*vacuumIntervalMinutes)*time.Minute)
+	if err != nil {
+		glog.Fatalf("Invalid vacuum options: %v", err)
+	}
 
 	//创建router
 	r := mux.NewRouter()
 	//创建master server
 	ms := weed_server.NewMasterServer(r, *mport, *metaFolder,
-		*volumeSizeLimitMB, *mpulse, *confFile, *defaultReplicaPlacement, *garbageThreshold,
-		masterWhiteList, *masterSecureKey,
+		*volumeSizeLimitMB, *mpulse, *confFile, *defaultReplicaPlacement, vacuumPolicy,
+		masterWhiteList, masterTrustedProxies, *masterSecureKey,
 	)
+	if *replicaCheckIntervalMinutes > 0 {
+		ms.Topo.StartReplicaCheck(time.Duration(*replicaCheckIntervalMinutes)*time.Minute, &topology.VolumeCheckOption{
+			CopyMissing:     *replicaCheckCopyMissing,
+			ApplyTombstones: *replicaCheckApplyTombstones,
+			MaxSamples:      10,
+		})
+	}
+	//收到SIGHUP时重新加载机架映射
+	OnReload(func() {
+		ms.ReloadConfiguration()
+	})
 	//拼接监听的地址+端口
 	listeningAddress := *masterBindIp + ":" + strconv.Itoa(*mport)
 
@@ -117,6 +161,7 @@ func runMaster(cmd *Command, args []string) bool {
 	if e != nil {
 		glog.Fatalf("Master startup error: %v", e)
 	}
+	listener = util.NewTLSListener(listener)
 
 	go func() {
 		time.Sleep(100 * time.Millisecond)
@@ -12,6 +12,7 @@ import (
 
 	"github.com/chrislusf/seaweedfs/weed/glog"
 	"github.com/chrislusf/seaweedfs/weed/server"
+	"github.com/chrislusf/seaweedfs/weed/topology"
 	"github.com/chrislusf/seaweedfs/weed/storage"
 	"github.com/chrislusf/seaweedfs/weed/util"
 	"github.com/gorilla/mux"
@@ -23,11 +24,15 @@ type ServerOptions struct {
 
 var (
 	serverOptions ServerOptions
+	serverTLS     TLSOptions
+	serverAudit   AuditOptions
 	filerOptions  FilerOptions
 )
 
 func init() {
 	cmdServer.Run = runServer // break init cycle
+	serverTLS.addFlags(&cmdServer.Flag)
+	serverAudit.addFlags(&cmdServer.Flag)
 }
 
 var cmdServer = &Command{
@@ -54,14 +59,22 @@ var (
 	serverTimeout                 = cmdServer.Flag.Int("idleTimeout", 10, "connection idle seconds")
 	serverDataCenter              = cmdServer.Flag.String("dataCenter", "", "current volume server's data center name")
 	serverRack                    = cmdServer.Flag.String("rack", "", "current volume server's rack name")
-	serverWhiteListOption         = cmdServer.Flag.String("whiteList", "", "comma separated Ip addresses having write permission. No limit if empty.")
+	serverWhiteListOption         = cmdServer.Flag.String("whiteList", "", "comma separated Ip addresses, CIDR ranges or host names having write permission. No limit if empty.")
+	serverTrustedProxiesOption    = cmdServer.Flag.String("trustedProxies", "", "comma separated Ip addresses or CIDR ranges of reverse proxies, whose X-Forwarded-For header is trusted to check -whiteList")
 	serverPeers                   = cmdServer.Flag.String("master.peers", "", "other master nodes in comma separated ip:masterPort list")
 	serverSecureKey               = cmdServer.Flag.String("secure.secret", "", "secret to encrypt Json Web Token(JWT)")
 	serverGarbageThreshold        = cmdServer.Flag.String("garbageThreshold", "0.3", "threshold to vacuum and reclaim spaces")
+	masterVacuumThresholds        = cmdServer.Flag.String("master.vacuum.collectionThresholds", "", "per collection garbage threshold, e.g., pictures:0.2,logs:0.5")
+	masterVacuumWindows           = cmdServer.Flag.String("master.vacuum.window", "", "comma separated time windows allowed for automatic vacuum, e.g., 01:00-05:00. Empty means any time.")
+	masterVacuumConcurrency       = cmdServer.Flag.Int("master.vacuum.concurrency", 1, "maximum number of volumes vacuumed at the same time on one volume server")
+	masterVacuumIntervalMinutes   = cmdServer.Flag.Int("master.vacuum.intervalMinutes", 15, "minutes between automatic vacuum checks")
+	masterReplicaCheckInterval    = cmdServer.Flag.Int("master.replicaCheck.intervalMinutes", 0, "minutes between comparing volume replicas. 0 disables the background check.")
+	masterReplicaCheckCopy        = cmdServer.Flag.Bool("master.replicaCheck.copyMissing", false, "copy needles missing on a replica from another replica")
+	masterReplicaCheckTombstones  = cmdServer.Flag.Bool("master.replicaCheck.applyTombstones", false, "delete needles already deleted on another replica")
 	masterPort                    = cmdServer.Flag.Int("master.port", 9333, "master server http listen port")
 	masterMetaFolder              = cmdServer.Flag.String("master.dir", "", "data directory to store meta data, default to same as -dir specified")
 	masterVolumeSizeLimitMB       = cmdServer.Flag.Uint("master.volumeSizeLimitMB", 30*1000, "Master stops directing writes to oversized volumes.")
-	masterConfFile                = cmdServer.Flag.String("master.conf", "/etc/weedfs/weedfs.conf", "xml configuration file")
+	masterConfFile                = cmdServer.Flag.String("master.conf", "/etc/weedfs/weedfs.conf", "rack map file in json, yaml or the deprecated xml format, reloaded on SIGHUP or /topology/reload")
 	masterDefaultReplicaPlacement = cmdServer.Flag.String("master.defaultReplicaPlacement", "000", "Default replication type if not specified.")
 	volumePort                    = cmdServer.Flag.Int("volume.port", 8080, "volume server http listen port")
 	volumePublicPort              = cmdServer.Flag.Int("volume.port.public", 0, "volume server public port")
@@ -72,9 +85,28 @@ var (
 	volumeFixJpgOrientation       = cmdServer.Flag.Bool("volume.images.fix.orientation", true, "Adjust jpg orientation when uploading.")
 	volumeReadRedirect            = cmdServer.Flag.Bool("volume.read.redirect", true, "Redirect moved or non-local volumes.")
 	volumeServerPublicUrl         = cmdServer.Flag.String("volume.publicUrl", "", "publicly accessible address")
+	volumeWriteQuorum             = cmdServer.Flag.Int("volume.write.quorum", 0, "number of copies, including the local one, a write must reach to succeed. 0 means all copies.")
+	volumeCollectionWriteQuorums  = cmdServer.Flag.String("volume.write.collectionQuorums", "", "per collection write quorum, e.g., pictures:2,logs:1")
+	volumeHintsDir                = cmdServer.Flag.String("volume.hints.dir", "", "directory to keep writes failed on replicas until they are back, default to <first dir>/hints")
+	volumeDigest                  = cmdServer.Flag.String("volume.digest", "", "[md5|sha256] content digest stored with each uploaded file and used as ETag. A client supplied Content-MD5 is always verified.")
+	volumeCacheSizeMB             = cmdServer.Flag.Int("volume.cache.sizeMB", storage.DefaultNeedleCacheSizeMB, "memory in MB to cache hot files read from disk. 0 disables the cache.")
+	volumeCacheMaxItemKB          = cmdServer.Flag.Int("volume.cache.maxItemKB", storage.DefaultNeedleCacheMaxItemKB, "files larger than this are read without caching")
+	volumeCacheCollections        = cmdServer.Flag.String("volume.cache.collections", "", "per collection cache in MB, separated from the shared one. 0 bypasses the cache, e.g., pictures:256,logs:0")
+	volumeCacheSsdDir             = cmdServer.Flag.String("volume.cache.ssd.dir", "", "directory on a local SSD to keep files evicted from the memory cache, for volumes on HDD")
+	volumeCacheSsdSizeMB          = cmdServer.Flag.Int("volume.cache.ssd.sizeMB", 1024, "disk space in MB used in -volume.cache.ssd.dir")
+	volumeMaxDiskIOErrors         = cmdServer.Flag.Int("volume.disk.maxIOErrors", storage.DefaultDiskMaxIOErrors, "number of I/O errors on a dir within a minute to mark its volumes read only and stop reporting them. 0 disables it.")
+	volumeDiskReserveMB           = cmdServer.Flag.Int("volume.disk.reserveMB", storage.DefaultDiskReserveMB, "free space in MB to keep on each dir, e.g., 1024. Below it the dir gets no new volumes or writes, and its volumes are reported read only, but deletes and compaction still work. 0, the default, disables it.")
+	volumeDurability              = cmdServer.Flag.String("volume.durability", "none", "[none|fsync|group] when writes are synced to disk before being acknowledged. group syncs concurrent writes together every -volume.durability.groupCommitMs.")
+	volumeCollectionDurabilities  = cmdServer.Flag.String("volume.durability.collections", "", "per collection durability, e.g., billing:fsync,logs:none")
+	volumeGroupCommitMs           = cmdServer.Flag.Int("volume.durability.groupCommitMs", storage.DefaultGroupCommitMs, "milliseconds to batch writes before one sync in group durability")
+	volumeShutdownTimeoutSeconds  = cmdServer.Flag.Int("volume.shutdown.timeoutSeconds", 30, "seconds to wait for in-flight requests when shutting down, after telling the master this server is leaving")
+	volumeCompactionMBPerSecond   = cmdServer.Flag.Int("volume.compactionMBps", 0, "limit background compaction or copying speed in mega bytes per second. 0 means no limit.")
+	volumePublicTLS               = cmdServer.Flag.Bool("volume.tls.public", true, "serve -volume.port.public in https too. Set it false to keep the public read port in plain http, with -volume.publicUrl starting with http://")
+	volumeReadJwt                 = cmdServer.Flag.Bool("volume.jwt.read", false, "require a JWT to read files from volume servers, bound to the file id, its collection or a file id prefix, with an expiration. Requests from -whiteList are allowed.")
 	isStartingFiler               = cmdServer.Flag.Bool("filer", false, "whether to start filer")
 
-	serverWhiteList []string
+	serverWhiteList      []string
+	serverTrustedProxies []string
 )
 
 func init() {
@@ -92,9 +124,12 @@ func init() {
 	filerOptions.redis_server = cmdServer.Flag.String("filer.redis.server", "", "host:port of the redis server, e.g., 127.0.0.1:6379")
 	filerOptions.redis_password = cmdServer.Flag.String("filer.redis.password", "", "redis password in clear text")
 	filerOptions.redis_database = cmdServer.Flag.Int("filer.redis.database", 0, "the database on the redis server")
+	filerOptions.readJwt = cmdServer.Flag.Bool("filer.jwt.read", false, "require a JWT to read files from the filer, bound to the path or a path prefix, with an expiration")
 }
 
 func runServer(cmd *Command, args []string) bool {
+	serverTLS.setup()
+	serverAudit.setup()
 	filerOptions.secretKey = serverSecureKey
 	if *serverOptions.cpuprofile != "" {
 		f, err := os.Create(*serverOptions.cpuprofile)
@@ -162,16 +197,19 @@ func runServer(cmd *Command, args []string) bool {
 	if *serverWhiteListOption != "" {
 		serverWhiteList = strings.Split(*serverWhiteListOption, ",")
 	}
+	if *serverTrustedProxiesOption != "" {
+		serverTrustedProxies = strings.Split(*serverTrustedProxiesOption, ",")
+	}
 
 	if *isStartingFiler {
 		go func() {
 			time.Sleep(1 * time.Second)
 			r := http.NewServeMux()
-			_, nfs_err := weed_server.NewFilerServer(r, *serverBindIp, *filerOptions.port, *filerOptions.master, *filerOptions.dir, *filerOptions.collection,
+			fs, nfs_err := weed_server.NewFilerServer(r, *serverBindIp, *filerOptions.port, *filerOptions.master, *filerOptions.dir, *filerOptions.collection,
 				*filerOptions.defaultReplicaPlacement,
 				*filerOptions.redirectOnRead, *filerOptions.disableDirListing,
 				*filerOptions.maxMB,
-				*filerOptions.secretKey,
+				*filerOptions.secretKey, serverWhiteList, serverTrustedProxies, *filerOptions.readJwt,
 				*filerOptions.cassandra_server, *filerOptions.cassandra_keyspace,
 				*filerOptions.redis_server, *filerOptions.redis_password, *filerOptions.redis_database,
 			)
@@ -186,12 +224,19 @@ func runServer(cmd *Command, args []string) bool {
 			if e != nil {
 				glog.Fatalf("Filer listener error: %v", e)
 			}
-			if e := http.Serve(filerListener, r); e != nil {
+			filerListener = util.NewTLSListener(filerListener)
+			if e := http.Serve(filerListener, fs.Instrument(r)); e != nil {
 				glog.Fatalf("Filer Fail to serve: %v", e)
 			}
 		}()
 	}
 
+	vacuumPolicy, err := topology.NewVacuumPolicy(*serverGarbageThreshold, *masterVacuumThresholds, *masterVacuumWindows,
+		*masterVacuumConcurrency, time.Duration(*masterVacuumIntervalMinutes)*time.Minute)
+	if err != nil {
+		glog.Fatalf("Invalid vacuum options: %v", err)
+	}
+
 	var raftWaitForMaster sync.WaitGroup
 	var volumeWait sync.WaitGroup
 
@@ -201,15 +246,26 @@ func runServer(cmd *Command, args []string) bool {
 	go func() {
 		r := mux.NewRouter()
 		ms := weed_server.NewMasterServer(r, *masterPort, *masterMetaFolder,
-			*masterVolumeSizeLimitMB, *volumePulse, *masterConfFile, *masterDefaultReplicaPlacement, *serverGarbageThreshold,
-			serverWhiteList, *serverSecureKey,
+			*masterVolumeSizeLimitMB, *volumePulse, *masterConfFile, *masterDefaultReplicaPlacement, vacuumPolicy,
+			serverWhiteList, serverTrustedProxies, *serverSecureKey,
 		)
+		if *masterReplicaCheckInterval > 0 {
+			ms.Topo.StartReplicaCheck(time.Duration(*masterReplicaCheckInterval)*time.Minute, &topology.VolumeCheckOption{
+				CopyMissing:     *masterReplicaCheckCopy,
+				ApplyTombstones: *masterReplicaCheckTombstones,
+				MaxSamples:      10,
+			})
+		}
+		OnReload(func() {
+			ms.ReloadConfiguration()
+		})
 
 		glog.V(0).Infoln("Start Seaweed Master", util.VERSION, "at", *serverIp+":"+strconv.Itoa(*masterPort))
 		masterListener, e := util.NewListener(*serverBindIp+":"+strconv.Itoa(*masterPort), time.Duration(*serverTimeout)*time.Second)
 		if e != nil {
 			glog.Fatalf("Master startup error: %v", e)
 		}
+		masterListener = util.NewTLSListener(masterListener)
 
 		go func() {
 			raftWaitForMaster.Wait()
@@ -256,7 +312,19 @@ func runServer(cmd *Command, args []string) bool {
 		folders, maxCounts,
 		volumeNeedleMapKind,
 		*serverIp+":"+strconv.Itoa(*masterPort), *volumePulse, *serverDataCenter, *serverRack,
-		serverWhiteList, *volumeFixJpgOrientation, *volumeReadRedirect,
+		serverWhiteList, serverTrustedProxies, *serverSecureKey, *volumeReadJwt, *volumeFixJpgOrientation, *volumeReadRedirect,
+		*volumeCompactionMBPerSecond,
+		*volumeWriteQuorum, *volumeCollectionWriteQuorums, *volumeHintsDir,
+		*volumeDigest,
+		storage.NeedleCacheOption{
+			SizeMB:      *volumeCacheSizeMB,
+			MaxItemKB:   *volumeCacheMaxItemKB,
+			Collections: *volumeCacheCollections,
+			SsdDir:      *volumeCacheSsdDir,
+			SsdSizeMB:   *volumeCacheSsdSizeMB,
+		},
+		*volumeMaxDiskIOErrors, *volumeDiskReserveMB,
+		*volumeDurability, *volumeCollectionDurabilities, *volumeGroupCommitMs,
 	)
 
 	glog.V(0).Infoln("Start Seaweed volume server", util.VERSION, "at", *serverIp+":"+strconv.Itoa(*volumePort))
@@ -267,6 +335,9 @@ func runServer(cmd *Command, args []string) bool {
 	if eListen != nil {
 		glog.Fatalf("Volume server listener error: %v", eListen)
 	}
+	volumeListener = util.NewTLSListener(volumeListener)
+	volumeHttpServer := &http.Server{Addr: *serverBindIp + ":" + strconv.Itoa(*volumePort), Handler: volumeServer.Instrument(volumeMux)}
+	volumeServers := []*http.Server{volumeHttpServer}
 	if isSeperatedPublicPort {
 		publicListeningAddress := *serverIp + ":" + strconv.Itoa(*volumePublicPort)
 		glog.V(0).Infoln("Start Seaweed volume server", util.VERSION, "public at", publicListeningAddress)
@@ -274,21 +345,26 @@ func runServer(cmd *Command, args []string) bool {
 		if e != nil {
 			glog.Fatalf("Volume server listener error:%v", e)
 		}
+		if *volumePublicTLS {
+			publicListener = util.NewPublicTLSListener(publicListener)
+		}
+		publicVolumeServer := &http.Server{Addr: publicListeningAddress, Handler: volumeServer.Instrument(publicVolumeMux)}
+		volumeServers = append(volumeServers, publicVolumeServer)
 		go func() {
-			if e := http.Serve(publicListener, publicVolumeMux); e != nil {
+			if e := publicVolumeServer.Serve(publicListener); e != nil && e != http.ErrServerClosed {
 				glog.Fatalf("Volume server fail to serve public: %v", e)
 			}
 		}()
 	}
 
 	OnInterrupt(func() {
-		volumeServer.Shutdown()
+		volumeServer.Shutdown(time.Duration(*volumeShutdownTimeoutSeconds)*time.Second, volumeServers...)
 		pprof.StopCPUProfile()
 	})
 
-	if e := http.Serve(volumeListener, volumeMux); e != nil {
+	if e := volumeHttpServer.Serve(volumeListener); e != nil && e != http.ErrServerClosed {
 		glog.Fatalf("Volume server fail to serve:%v", e)
 	}
-
-	return true
+	//等待Shutdown处理完进行中的请求后退出进程
+	select {}
 }
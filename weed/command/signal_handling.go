@@ -5,14 +5,24 @@ package command
 import (
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 )
 
+var (
+	reloadHooks     []func()
+	reloadHooksLock sync.Mutex
+)
+
 func OnInterrupt(fn func()) {
 	// deal with control+c,etc
 	signalChan := make(chan os.Signal, 1)
 	// controlling terminal close, daemon not exit
-	signal.Ignore(syscall.SIGHUP)
+	reloadHooksLock.Lock()
+	if len(reloadHooks) == 0 {
+		signal.Ignore(syscall.SIGHUP)
+	}
+	reloadHooksLock.Unlock()
 	signal.Notify(signalChan,
 		os.Interrupt,
 		os.Kill,
@@ -29,3 +39,25 @@ func OnInterrupt(fn func()) {
 		}
 	}()
 }
+
+// OnReload runs fn each time the process receives SIGHUP.
+func OnReload(fn func()) {
+	reloadHooksLock.Lock()
+	defer reloadHooksLock.Unlock()
+	reloadHooks = append(reloadHooks, fn)
+	if len(reloadHooks) > 1 {
+		return
+	}
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, syscall.SIGHUP)
+	go func() {
+		for _ = range signalChan {
+			reloadHooksLock.Lock()
+			hooks := reloadHooks
+			reloadHooksLock.Unlock()
+			for _, hook := range hooks {
+				hook()
+			}
+		}
+	}()
+}
@@ -4,3 +4,6 @@ package command
 
 func OnInterrupt(fn func()) {
 }
+
+func OnReload(fn func()) {
+}
@@ -0,0 +1,35 @@
+package command
+
+import (
+	"flag"
+
+	"github.com/chrislusf/seaweedfs/weed/glog"
+	"github.com/chrislusf/seaweedfs/weed/util"
+)
+
+// TLSOptions holds the TLS flags shared by the master, volume and filer commands.
+type TLSOptions struct {
+	cert   *string
+	key    *string
+	ca     *string
+	mutual *bool
+}
+
+func (o *TLSOptions) addFlags(flags *flag.FlagSet) {
+	o.cert = flags.String("tls.cert", "", "certificate file in PEM to serve https and to talk to the other servers in https. Plain http if empty.")
+	o.key = flags.String("tls.key", "", "private key file in PEM of -tls.cert")
+	o.ca = flags.String("tls.ca", "", "CA certificate file in PEM to verify the other servers, and the clients with -tls.mutual. System CAs if empty.")
+	o.mutual = flags.Bool("tls.mutual", false, "require a client certificate signed by -tls.ca, which must be set, on the cluster ports, and present -tls.cert to the other servers")
+}
+
+//启动监听前调用，失败时退出
+func (o *TLSOptions) setup() {
+	if err := util.SetupTLS(util.TLSOption{
+		CertFile: *o.cert,
+		KeyFile:  *o.key,
+		CAFile:   *o.ca,
+		Mutual:   *o.mutual,
+	}); err != nil {
+		glog.Fatalf("TLS setup error: %v", err)
+	}
+}
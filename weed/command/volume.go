@@ -19,23 +19,45 @@ var (
 )
 
 type VolumeServerOptions struct {
-	port                  *int
-	publicPort            *int
-	folders               []string
-	folderMaxLimits       []int
-	ip                    *string
-	publicUrl             *string
-	bindIp                *string
-	master                *string
-	pulseSeconds          *int
-	idleConnectionTimeout *int
-	maxCpu                *int
-	dataCenter            *string
-	rack                  *string
-	whiteList             []string
-	indexType             *string
-	fixJpgOrientation     *bool
-	readRedirect          *bool
+	port                   *int
+	publicPort             *int
+	folders                []string
+	folderMaxLimits        []int
+	ip                     *string
+	publicUrl              *string
+	bindIp                 *string
+	master                 *string
+	pulseSeconds           *int
+	idleConnectionTimeout  *int
+	maxCpu                 *int
+	dataCenter             *string
+	rack                   *string
+	whiteList              []string
+	trustedProxies         []string
+	indexType              *string
+	fixJpgOrientation      *bool
+	readRedirect           *bool
+	compactionMBPerSecond  *int
+	writeQuorum            *int
+	collectionWriteQuorums *string
+	hintsDir               *string
+	digest                 *string
+	cacheSizeMB            *int
+	cacheMaxItemKB         *int
+	cacheCollections       *string
+	cacheSsdDir            *string
+	cacheSsdSizeMB         *int
+	maxDiskIOErrors        *int
+	diskReserveMB          *int
+	durability             *string
+	collectionDurabilities *string
+	groupCommitMs          *int
+	shutdownTimeoutSeconds *int
+	tls                    TLSOptions
+	audit                  AuditOptions
+	publicTLS              *bool
+	secureKey              *string
+	readJwt                *bool
 }
 
 func init() {
@@ -54,6 +76,27 @@ func init() {
 	v.indexType = cmdVolume.Flag.String("index", "memory", "Choose [memory|leveldb|boltdb] mode for memory~performance balance.")
 	v.fixJpgOrientation = cmdVolume.Flag.Bool("images.fix.orientation", true, "Adjust jpg orientation when uploading.")
 	v.readRedirect = cmdVolume.Flag.Bool("read.redirect", true, "Redirect moved or non-local volumes.")
+	v.writeQuorum = cmdVolume.Flag.Int("write.quorum", 0, "number of copies, including the local one, a write must reach to succeed. 0 means all copies.")
+	v.collectionWriteQuorums = cmdVolume.Flag.String("write.collectionQuorums", "", "per collection write quorum, e.g., pictures:2,logs:1")
+	v.hintsDir = cmdVolume.Flag.String("hints.dir", "", "directory to keep writes failed on replicas until they are back, default to <first dir>/hints")
+	v.digest = cmdVolume.Flag.String("digest", "", "[md5|sha256] content digest stored with each uploaded file and used as ETag. A client supplied Content-MD5 is always verified.")
+	v.cacheSizeMB = cmdVolume.Flag.Int("cache.sizeMB", storage.DefaultNeedleCacheSizeMB, "memory in MB to cache hot files read from disk. 0 disables the cache.")
+	v.cacheMaxItemKB = cmdVolume.Flag.Int("cache.maxItemKB", storage.DefaultNeedleCacheMaxItemKB, "files larger than this are read without caching")
+	v.cacheCollections = cmdVolume.Flag.String("cache.collections", "", "per collection cache in MB, separated from the shared one. 0 bypasses the cache, e.g., pictures:256,logs:0")
+	v.cacheSsdDir = cmdVolume.Flag.String("cache.ssd.dir", "", "directory on a local SSD to keep files evicted from the memory cache, for volumes on HDD")
+	v.cacheSsdSizeMB = cmdVolume.Flag.Int("cache.ssd.sizeMB", 1024, "disk space in MB used in -cache.ssd.dir")
+	v.maxDiskIOErrors = cmdVolume.Flag.Int("disk.maxIOErrors", storage.DefaultDiskMaxIOErrors, "number of I/O errors on a dir within a minute to mark its volumes read only and stop reporting them. 0 disables it.")
+	v.diskReserveMB = cmdVolume.Flag.Int("disk.reserveMB", storage.DefaultDiskReserveMB, "free space in MB to keep on each dir, e.g., 1024. Below it the dir gets no new volumes or writes, and its volumes are reported read only, but deletes and compaction still work. 0, the default, disables it.")
+	v.durability = cmdVolume.Flag.String("durability", "none", "[none|fsync|group] when writes are synced to disk before being acknowledged. group syncs concurrent writes together every -durability.groupCommitMs.")
+	v.collectionDurabilities = cmdVolume.Flag.String("durability.collections", "", "per collection durability, e.g., billing:fsync,logs:none")
+	v.groupCommitMs = cmdVolume.Flag.Int("durability.groupCommitMs", storage.DefaultGroupCommitMs, "milliseconds to batch writes before one sync in group durability")
+	v.shutdownTimeoutSeconds = cmdVolume.Flag.Int("shutdown.timeoutSeconds", 30, "seconds to wait for in-flight requests when shutting down, after telling the master this server is leaving")
+	v.tls.addFlags(&cmdVolume.Flag)
+	v.audit.addFlags(&cmdVolume.Flag)
+	v.readJwt = cmdVolume.Flag.Bool("jwt.read", false, "require a JWT to read files, bound to the file id, its collection or a file id prefix, with an expiration. Requests from -whiteList are allowed.")
+	v.secureKey = cmdVolume.Flag.String("secure.secret", "", "secret to verify Json Web Token(JWT). Not needed with TLS, where the master sends its keys.")
+	v.publicTLS = cmdVolume.Flag.Bool("tls.public", true, "serve -port.public in https too. Set it false to keep the public read port in plain http, with -publicUrl starting with http://")
+	v.compactionMBPerSecond = cmdVolume.Flag.Int("compactionMBps", 0, "limit background compaction or copying speed in mega bytes per second. 0 means no limit.")
 }
 
 var cmdVolume = &Command{
@@ -67,10 +110,13 @@ var cmdVolume = &Command{
 var (
 	volumeFolders         = cmdVolume.Flag.String("dir", os.TempDir(), "directories to store data files. dir[,dir]...")
 	maxVolumeCounts       = cmdVolume.Flag.String("max", "7", "maximum numbers of volumes, count[,count]...")
-	volumeWhiteListOption = cmdVolume.Flag.String("whiteList", "", "comma separated Ip addresses having write permission. No limit if empty.")
+	volumeWhiteListOption = cmdVolume.Flag.String("whiteList", "", "comma separated Ip addresses, CIDR ranges or host names having write permission. No limit if empty.")
+	volumeTrustedProxies  = cmdVolume.Flag.String("trustedProxies", "", "comma separated Ip addresses or CIDR ranges of reverse proxies, whose X-Forwarded-For header is trusted to check -whiteList")
 )
 
 func runVolume(cmd *Command, args []string) bool {
+	v.tls.setup()
+	v.audit.setup()
 	if *v.maxCpu < 1 {
 		*v.maxCpu = runtime.NumCPU()
 	}
@@ -99,6 +145,9 @@ func runVolume(cmd *Command, args []string) bool {
 	if *volumeWhiteListOption != "" {
 		v.whiteList = strings.Split(*volumeWhiteListOption, ",")
 	}
+	if *volumeTrustedProxies != "" {
+		v.trustedProxies = strings.Split(*volumeTrustedProxies, ",")
+	}
 
 	if *v.ip == "" {
 		*v.ip = "127.0.0.1"
@@ -130,8 +179,20 @@ func runVolume(cmd *Command, args []string) bool {
 		v.folders, v.folderMaxLimits,
 		volumeNeedleMapKind,
 		*v.master, *v.pulseSeconds, *v.dataCenter, *v.rack,
-		v.whiteList,
+		v.whiteList, v.trustedProxies, *v.secureKey, *v.readJwt,
 		*v.fixJpgOrientation, *v.readRedirect,
+		*v.compactionMBPerSecond,
+		*v.writeQuorum, *v.collectionWriteQuorums, *v.hintsDir,
+		*v.digest,
+		storage.NeedleCacheOption{
+			SizeMB:      *v.cacheSizeMB,
+			MaxItemKB:   *v.cacheMaxItemKB,
+			Collections: *v.cacheCollections,
+			SsdDir:      *v.cacheSsdDir,
+			SsdSizeMB:   *v.cacheSsdSizeMB,
+		},
+		*v.maxDiskIOErrors, *v.diskReserveMB,
+		*v.durability, *v.collectionDurabilities, *v.groupCommitMs,
 	)
 
 	listeningAddress := *v.bindIp + ":" + strconv.Itoa(*v.port)
@@ -140,6 +201,9 @@ func runVolume(cmd *Command, args []string) bool {
 	if e != nil {
 		glog.Fatalf("Volume server listener error:%v", e)
 	}
+	listener = util.NewTLSListener(listener)
+	server := &http.Server{Addr: listeningAddress, Handler: volumeServer.Instrument(volumeMux)}
+	servers := []*http.Server{server}
 	if isSeperatedPublicPort {
 		publicListeningAddress := *v.bindIp + ":" + strconv.Itoa(*v.publicPort)
 		glog.V(0).Infoln("Start Seaweed volume server", util.VERSION, "public at", publicListeningAddress)
@@ -147,19 +211,25 @@ func runVolume(cmd *Command, args []string) bool {
 		if e != nil {
 			glog.Fatalf("Volume server listener error:%v", e)
 		}
+		if *v.publicTLS {
+			publicListener = util.NewPublicTLSListener(publicListener)
+		}
+		publicServer := &http.Server{Addr: publicListeningAddress, Handler: volumeServer.Instrument(publicVolumeMux)}
+		servers = append(servers, publicServer)
 		go func() {
-			if e := http.Serve(publicListener, publicVolumeMux); e != nil {
+			if e := publicServer.Serve(publicListener); e != nil && e != http.ErrServerClosed {
 				glog.Fatalf("Volume server fail to serve public: %v", e)
 			}
 		}()
 	}
 
 	OnInterrupt(func() {
-		volumeServer.Shutdown()
+		volumeServer.Shutdown(time.Duration(*v.shutdownTimeoutSeconds)*time.Second, servers...)
 	})
 
-	if e := http.Serve(listener, volumeMux); e != nil {
+	if e := server.Serve(listener); e != nil && e != http.ErrServerClosed {
 		glog.Fatalf("Volume server fail to serve: %v", e)
 	}
-	return true
+	//等待Shutdown处理完进行中的请求后退出进程
+	select {}
 }
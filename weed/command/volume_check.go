@@ -0,0 +1,85 @@
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/chrislusf/seaweedfs/weed/operation"
+	"github.com/chrislusf/seaweedfs/weed/storage"
+	"github.com/chrislusf/seaweedfs/weed/topology"
+)
+
+var (
+	vc VolumeCheckOptions
+)
+
+type VolumeCheckOptions struct {
+	master          *string
+	volumeIds       *string
+	copyMissing     *bool
+	applyTombstones *bool
+	samples         *int
+}
+
+func init() {
+	cmdVolumeCheck.Run = runVolumeCheck // break init cycle
+	vc.master = cmdVolumeCheck.Flag.String("server", "localhost:9333", "SeaweedFS master location")
+	vc.volumeIds = cmdVolumeCheck.Flag.String("volumeId", "", "comma separated volume ids to check")
+	vc.copyMissing = cmdVolumeCheck.Flag.Bool("copyMissing", false, "copy needles missing on a replica from another replica")
+	vc.applyTombstones = cmdVolumeCheck.Flag.Bool("applyTombstones", false, "delete needles already deleted on another replica")
+	vc.samples = cmdVolumeCheck.Flag.Int("samples", 10, "number of needle keys listed for each kind of difference")
+}
+
+var cmdVolumeCheck = &Command{
+	UsageLine: "volume.check -server=localhost:9333 -volumeId=234,235",
+	Short:     "compare and repair the replicas of volumes",
+	Long: `Compare the needle index of all replicas of each volume.
+
+  For each replica, it reports the needles missing on it, the extra needles,
+  the needles still alive but deleted on another replica, and the needles with different sizes.
+
+  With -copyMissing, missing needles are copied from another replica.
+  With -applyTombstones, needles deleted on another replica are deleted.
+
+  The listed needles are needle keys in hex, not file ids, since the index has no cookies.
+
+  A needle deleted before a compaction is unknown to the compacted replicas,
+  so -copyMissing can bring it back if another replica missed the deletion.
+  Missing needles are not copied while the replicas have different compaction revisions.
+  `,
+}
+
+func runVolumeCheck(cmd *Command, args []string) bool {
+	if *vc.volumeIds == "" {
+		return false
+	}
+	option := &topology.VolumeCheckOption{
+		CopyMissing:     *vc.copyMissing,
+		ApplyTombstones: *vc.applyTombstones,
+		MaxSamples:      *vc.samples,
+	}
+	for _, vidString := range strings.Split(*vc.volumeIds, ",") {
+		vid, err := storage.NewVolumeId(strings.TrimSpace(vidString))
+		if err != nil {
+			fmt.Printf("Invalid volume id %s: %v\n", vidString, err)
+			continue
+		}
+		lookup, err := operation.Lookup(*vc.master, vid.String())
+		if err != nil {
+			fmt.Printf("Error looking up volume %d: %v\n", vid, err)
+			continue
+		}
+		var urls []string
+		for _, location := range lookup.Locations {
+			urls = append(urls, location.Url)
+		}
+		result := topology.CheckVolumeReplicas(vid, urls, option)
+		if b, err := json.MarshalIndent(result, "", "  "); err == nil {
+			os.Stdout.Write(b)
+			fmt.Println()
+		}
+	}
+	return true
+}
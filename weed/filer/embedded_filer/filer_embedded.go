@@ -8,12 +8,14 @@ import (
 
 	"github.com/chrislusf/seaweedfs/weed/filer"
 	"github.com/chrislusf/seaweedfs/weed/operation"
+	"github.com/chrislusf/seaweedfs/weed/security"
 )
 
 type FilerEmbedded struct {
 	master      string
 	directories *DirectoryManagerInMap
 	files       *FileListInLevelDb
+	secret      security.Secret //删除目录时签名批量删除请求
 }
 
 func NewFilerEmbedded(master string, dir string) (filer *FilerEmbedded, err error) {
@@ -33,6 +35,11 @@ func NewFilerEmbedded(master string, dir string) (filer *FilerEmbedded, err erro
 	return
 }
 
+// SetSecret sets the secret to sign the deletes of the files in deleted directories.
+func (filer *FilerEmbedded) SetSecret(secret security.Secret) {
+	filer.secret = secret
+}
+
 func (filer *FilerEmbedded) CreateFile(filePath string, fid string) (err error) {
 	dir, file := filepath.Split(filePath)
 	dirId, e := filer.directories.MakeDirectory(dir)
@@ -91,7 +98,7 @@ func (filer *FilerEmbedded) DeleteDirectory(dirPath string, recursive bool) (err
 		for _, fileEntry := range list {
 			fids = append(fids, string(fileEntry.Id))
 		}
-		if result_list, delete_file_err := operation.DeleteFiles(filer.master, fids); delete_file_err != nil {
+		if result_list, delete_file_err := operation.DeleteFiles(filer.master, fids, security.GenClusterJwt(filer.secret)); delete_file_err != nil {
 			return delete_file_err
 		} else {
 			if len(result_list.Errors) > 0 {
@@ -8,6 +8,7 @@ import (
 	"strconv"
 
 	"github.com/chrislusf/seaweedfs/weed/glog"
+	"github.com/chrislusf/seaweedfs/weed/security"
 	"github.com/chrislusf/seaweedfs/weed/util"
 )
 
@@ -19,6 +20,10 @@ type VolumeAssignRequest struct {
 	DataCenter  string
 	Rack        string
 	DataNode    string
+	// Preferred falls back to other locations if DataCenter, Rack or DataNode has no space
+	Preferred bool
+	// Jwt is needed once the master has access keys
+	Jwt security.EncodedJwt
 }
 
 type AssignResult struct {
@@ -27,6 +32,8 @@ type AssignResult struct {
 	PublicUrl string `json:"publicUrl,omitempty"`
 	Count     uint64 `json:"count,omitempty"`
 	Error     string `json:"error,omitempty"`
+	// PreferenceHonored tells if the file id is assigned in the requested location
+	PreferenceHonored bool `json:"preferenceHonored"`
 }
 
 func Assign(server string, r *VolumeAssignRequest) (*AssignResult, error) {
@@ -50,8 +57,11 @@ func Assign(server string, r *VolumeAssignRequest) (*AssignResult, error) {
 	if r.DataNode != "" {
 		values.Add("dataNode", r.DataNode)
 	}
+	if r.Preferred {
+		values.Add("preferred", "true")
+	}
 
-	jsonBlob, err := util.Post("http://"+server+"/dir/assign", values)
+	jsonBlob, err := util.PostWithJwt(util.SchemePrefix+server+"/dir/assign", values, r.Jwt)
 	glog.V(2).Info("assign result :", string(jsonBlob))
 	if err != nil {
 		return nil, err
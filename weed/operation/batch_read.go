@@ -0,0 +1,57 @@
+package operation
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/chrislusf/seaweedfs/weed/util"
+)
+
+// BatchRead reads many files from one volume server in one request.
+// fn is called for each file in the order the server read them, with the part holding the content,
+// which is gzipped if the part has a "Content-Encoding: gzip" header.
+// The status is http.StatusOK for found files, and the part holds the error message otherwise.
+func BatchRead(server string, fids []string, fn func(fid string, status int, part *multipart.Part) error) error {
+	values := make(url.Values)
+	for _, fid := range fids {
+		values.Add("fid", fid)
+	}
+	resp, err := client.PostForm(util.SchemePrefix+server+"/batch/read", values)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("batch read from %s: %s", server, resp.Status)
+	}
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		return fmt.Errorf("batch read from %s: unexpected content type %s", server, mediaType)
+	}
+	reader := multipart.NewReader(resp.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		status, err := strconv.Atoi(part.Header.Get("Status"))
+		if err != nil {
+			return fmt.Errorf("batch read from %s: invalid status %q", server, part.Header.Get("Status"))
+		}
+		if err = fn(part.Header.Get("Fid"), status, part); err != nil {
+			return err
+		}
+	}
+}
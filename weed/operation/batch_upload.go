@@ -0,0 +1,102 @@
+package operation
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"path/filepath"
+	"strings"
+
+	"github.com/chrislusf/seaweedfs/weed/security"
+	"github.com/chrislusf/seaweedfs/weed/util"
+)
+
+// BatchFile is one file of a batch upload, written to the needle of Fid.
+type BatchFile struct {
+	Fid          string
+	Name         string
+	MimeType     string
+	Data         []byte
+	IsGzipped    bool
+	Ttl          string
+	LastModified uint64
+}
+
+type BatchWriteResult struct {
+	Fid    string `json:"fid"`
+	Name   string `json:"name,omitempty"`
+	Size   uint32 `json:"size,omitempty"`
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// WriteBatchFiles writes the multipart body of a batch upload, one part per file.
+// The form name of each part is the file id, with the ttl and modified time in the part headers.
+func WriteBatchFiles(w *multipart.Writer, files []BatchFile) error {
+	for _, f := range files {
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`,
+			fileNameEscaper.Replace(f.Fid), fileNameEscaper.Replace(f.Name)))
+		mtype := f.MimeType
+		if mtype == "" {
+			mtype = mime.TypeByExtension(strings.ToLower(filepath.Ext(f.Name)))
+		}
+		if mtype != "" {
+			h.Set("Content-Type", mtype)
+		}
+		if f.IsGzipped {
+			h.Set("Content-Encoding", "gzip")
+		}
+		if f.Ttl != "" {
+			h.Set("Ttl", f.Ttl)
+		}
+		if f.LastModified > 0 {
+			h.Set("Ts", fmt.Sprint(f.LastModified))
+		}
+		part, err := w.CreatePart(h)
+		if err != nil {
+			return err
+		}
+		if _, err = part.Write(f.Data); err != nil {
+			return err
+		}
+	}
+	return w.Close()
+}
+
+// BatchUpload writes many files to one volume server in one request.
+// The files can be in different volumes of the server, and each one gets its own result.
+func BatchUpload(server string, files []BatchFile, jwt security.EncodedJwt) ([]BatchWriteResult, error) {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	if err := WriteBatchFiles(w, files); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", util.SchemePrefix+server+"/batch/write", &buf)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	if jwt != "" {
+		req.Header.Set("Authorization", "BEARER "+string(jwt))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var ret []BatchWriteResult
+	if err = json.Unmarshal(respBody, &ret); err != nil {
+		return nil, fmt.Errorf("batch upload to %s: %s %s", server, resp.Status, string(respBody))
+	}
+	return ret, nil
+}
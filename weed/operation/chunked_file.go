@@ -11,6 +11,7 @@ import (
 	"sync"
 
 	"github.com/chrislusf/seaweedfs/weed/glog"
+	"github.com/chrislusf/seaweedfs/weed/security"
 	"github.com/chrislusf/seaweedfs/weed/util"
 )
 
@@ -69,10 +70,10 @@ func (cm *ChunkManifest) Marshal() ([]byte, error) {
 	return json.Marshal(cm)
 }
 
-func (cm *ChunkManifest) DeleteChunks(master string) error {
+func (cm *ChunkManifest) DeleteChunks(master string, jwt security.EncodedJwt) error {
 	deleteError := 0
 	for _, ci := range cm.Chunks {
-		if e := DeleteFile(master, ci.Fid, ""); e != nil {
+		if e := DeleteFile(master, ci.Fid, jwt); e != nil {
 			deleteError++
 			glog.V(0).Infof("Delete %s error: %v, master: %s", ci.Fid, e, master)
 		}
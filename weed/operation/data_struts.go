@@ -1,7 +1,15 @@
 package operation
 
+import (
+	"github.com/chrislusf/seaweedfs/weed/security"
+)
+
 type JoinResult struct {
 	VolumeSizeLimit uint64 `json:"VolumeSizeLimit,omitempty"`
-	SecretKey       string `json:"secretKey,omitempty"`
-	Error           string `json:"error,omitempty"`
+	//只在TLS连接上发送JWT密钥
+	JwtKeys         []security.SigningKey `json:"jwtKeys,omitempty"`
+	JwtSigningKeyId string                `json:"jwtSigningKeyId,omitempty"`
+	//没有访问密钥时发送空列表，不发送时为null
+	AccessKeys []security.AccessKey `json:"accessKeys"`
+	Error      string               `json:"error,omitempty"`
 }
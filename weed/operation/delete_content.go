@@ -46,7 +46,7 @@ type DeleteFilesResult struct {
 	Results []DeleteResult
 }
 
-func DeleteFiles(master string, fileIds []string) (*DeleteFilesResult, error) {
+func DeleteFiles(master string, fileIds []string, jwt security.EncodedJwt) (*DeleteFilesResult, error) {
 	vid_to_fileIds := make(map[string][]string)
 	ret := &DeleteFilesResult{}
 	var vids []string
@@ -97,7 +97,7 @@ func DeleteFiles(master string, fileIds []string) (*DeleteFilesResult, error) {
 			for _, fid := range fidList {
 				values.Add("fid", fid)
 			}
-			jsonBlob, err := util.Post("http://"+server+"/delete", values)
+			jsonBlob, err := util.PostWithJwt(util.SchemePrefix+server+"/delete", values, jwt)
 			if err != nil {
 				ret.Errors = append(ret.Errors, err.Error()+" "+string(jsonBlob))
 				return
@@ -23,7 +23,7 @@ func RegisterFile(filer string, path string, fileId string, secret security.Secr
 	values := make(url.Values)
 	values.Add("path", path)
 	values.Add("fileId", fileId)
-	_, err := util.Post("http://"+filer+"/admin/register", values)
+	_, err := util.Post(util.SchemePrefix+filer+"/admin/register", values)
 	if err != nil {
 		return fmt.Errorf("Failed to register path:%s on filer:%s to file id:%s", path, filer, fileId)
 	}
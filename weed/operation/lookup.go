@@ -45,7 +45,7 @@ func Lookup(server string, vid string) (ret *LookupResult, err error) {
 func do_lookup(server string, vid string) (*LookupResult, error) {
 	values := make(url.Values)
 	values.Add("volumeId", vid)
-	jsonBlob, err := util.Post("http://"+server+"/dir/lookup", values)
+	jsonBlob, err := util.Post(util.SchemePrefix+server+"/dir/lookup", values)
 	if err != nil {
 		return nil, err
 	}
@@ -72,7 +72,7 @@ func LookupFileId(server string, fileId string) (fullUrl string, err error) {
 	if len(lookup.Locations) == 0 {
 		return "", errors.New("File Not Found")
 	}
-	return "http://" + lookup.Locations[rand.Intn(len(lookup.Locations))].Url + "/" + fileId, nil
+	return util.SchemePrefix + lookup.Locations[rand.Intn(len(lookup.Locations))].Url + "/" + fileId, nil
 }
 
 // LookupVolumeIds find volume locations by cache and actual lookup
@@ -99,7 +99,7 @@ func LookupVolumeIds(server string, vids []string) (map[string]LookupResult, err
 	for _, vid := range unknown_vids {
 		values.Add("volumeId", vid)
 	}
-	jsonBlob, err := util.Post("http://"+server+"/vol/lookup", values)
+	jsonBlob, err := util.Post(util.SchemePrefix+server+"/vol/lookup", values)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,55 @@
+package operation
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/chrislusf/seaweedfs/weed/security"
+	"github.com/chrislusf/seaweedfs/weed/util"
+)
+
+// ErrNeedleReplicationNotSupported is returned when the replica has no needle replication endpoint.
+var ErrNeedleReplicationNotSupported = errors.New("needle replication is not supported")
+
+// ReplicateNeedle sends one or more needles serialized with the given version, concatenated in blob,
+// to a replica, which appends the bytes to its volume as-is.
+func ReplicateNeedle(server string, volumeId string, version uint8, blob []byte, jwt security.EncodedJwt) (*UploadResult, error) {
+	values := make(url.Values)
+	values.Add("volume", volumeId)
+	values.Add("version", strconv.Itoa(int(version)))
+	req, err := http.NewRequest("POST", util.SchemePrefix+server+"/admin/replicate_needle?"+values.Encode(), bytes.NewReader(blob))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if jwt != "" {
+		req.Header.Set("Authorization", "BEARER "+string(jwt))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	// older servers route the unknown path to the file handler, which fails to parse the volume id
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusBadRequest {
+		return nil, ErrNeedleReplicationNotSupported
+	}
+	var ret UploadResult
+	if err = json.Unmarshal(respBody, &ret); err != nil {
+		return nil, &util.StatusError{StatusCode: resp.StatusCode, Message: fmt.Sprintf("replicate needle to %s: %s", server, resp.Status)}
+	}
+	if ret.Error != "" {
+		return nil, &util.StatusError{StatusCode: resp.StatusCode, Message: ret.Error}
+	}
+	return &ret, nil
+}
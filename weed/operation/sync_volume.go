@@ -3,9 +3,13 @@ package operation
 import (
 	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/url"
+	"strconv"
 
 	"github.com/chrislusf/seaweedfs/weed/glog"
+	"github.com/chrislusf/seaweedfs/weed/security"
 	"github.com/chrislusf/seaweedfs/weed/util"
 )
 
@@ -15,13 +19,14 @@ type SyncVolumeResponse struct {
 	TailOffset      uint64 `json:"TailOffset,omitempty"`
 	CompactRevision uint16 `json:"CompactRevision,omitempty"`
 	IdxFileSize     uint64 `json:"IdxFileSize,omitempty"`
+	Version         uint8  `json:"Version,omitempty"`
 	Error           string `json:"error,omitempty"`
 }
 
-func GetVolumeSyncStatus(server string, vid string) (*SyncVolumeResponse, error) {
+func GetVolumeSyncStatus(server string, vid string, jwt security.EncodedJwt) (*SyncVolumeResponse, error) {
 	values := make(url.Values)
 	values.Add("volume", vid)
-	jsonBlob, err := util.Post("http://"+server+"/admin/sync/status", values)
+	jsonBlob, err := util.PostWithJwt(util.SchemePrefix+server+"/admin/sync/status", values, jwt)
 	glog.V(2).Info("sync volume result :", string(jsonBlob))
 	if err != nil {
 		return nil, err
@@ -37,11 +42,11 @@ func GetVolumeSyncStatus(server string, vid string) (*SyncVolumeResponse, error)
 	return &ret, nil
 }
 
-func GetVolumeIdxEntries(server string, vid string, eachEntryFn func(key uint64, offset, size uint32)) error {
+func GetVolumeIdxEntries(server string, vid string, jwt security.EncodedJwt, eachEntryFn func(key uint64, offset, size uint32)) error {
 	values := make(url.Values)
 	values.Add("volume", vid)
 	line := make([]byte, 16)
-	err := util.GetBufferStream("http://"+server+"/admin/sync/index", values, line, func(bytes []byte) {
+	err := util.GetBufferStreamWithJwt(util.SchemePrefix+server+"/admin/sync/index", values, jwt, line, func(bytes []byte) {
 		key := util.BytesToUint64(bytes[:8])
 		offset := util.BytesToUint32(bytes[8:12])
 		size := util.BytesToUint32(bytes[12:16])
@@ -52,3 +57,18 @@ func GetVolumeIdxEntries(server string, vid string, eachEntryFn func(key uint64,
 	}
 	return nil
 }
+
+// GetVolumeNeedleBlob reads one serialized needle, located by an index entry, from the volume server.
+func GetVolumeNeedleBlob(server string, vid string, key uint64, offset, size uint32, compactRevision uint16, jwt security.EncodedJwt) (blob []byte, err error) {
+	values := make(url.Values)
+	values.Add("revision", strconv.Itoa(int(compactRevision)))
+	values.Add("volume", vid)
+	values.Add("id", strconv.FormatUint(key, 10))
+	values.Add("offset", strconv.FormatUint(uint64(offset), 10))
+	values.Add("size", strconv.FormatUint(uint64(size), 10))
+	err = util.GetUrlStreamWithJwt(util.SchemePrefix+server+"/admin/sync/data", values, jwt, func(r io.Reader) error {
+		blob, err = ioutil.ReadAll(r)
+		return err
+	})
+	return
+}
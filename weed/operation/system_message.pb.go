@@ -122,6 +122,8 @@ type JoinMessage struct {
 	Rack             *string                     `protobuf:"bytes,8,opt,name=rack" json:"rack,omitempty"`
 	Volumes          []*VolumeInformationMessage `protobuf:"bytes,9,rep,name=volumes" json:"volumes,omitempty"`
 	AdminPort        *uint32                     `protobuf:"varint,10,opt,name=admin_port" json:"admin_port,omitempty"`
+	FailedDirs       []string                    `protobuf:"bytes,11,rep,name=failed_dirs" json:"failed_dirs,omitempty"`
+	IsLeaving        *bool                       `protobuf:"varint,12,opt,name=is_leaving" json:"is_leaving,omitempty"`
 	XXX_unrecognized []byte                      `json:"-"`
 }
 
@@ -199,5 +201,19 @@ func (m *JoinMessage) GetAdminPort() uint32 {
 	return 0
 }
 
+func (m *JoinMessage) GetFailedDirs() []string {
+	if m != nil {
+		return m.FailedDirs
+	}
+	return nil
+}
+
+func (m *JoinMessage) GetIsLeaving() bool {
+	if m != nil && m.IsLeaving != nil {
+		return *m.IsLeaving
+	}
+	return false
+}
+
 func init() {
 }
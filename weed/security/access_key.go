@@ -0,0 +1,205 @@
+package security
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+type Action string
+
+const (
+	ActionRead   Action = "read"
+	ActionWrite  Action = "write"
+	ActionDelete Action = "delete"
+	ActionAdmin  Action = "admin" //管理集群，同时拥有其他所有权限
+)
+
+var errNotAccessKey = errors.New("not signed by an access key")
+
+// AccessKey is a named secret allowed to do some actions on some collections,
+// or on some filer paths. A key without collections and prefixes is allowed everywhere.
+//
+// Clients sign their tokens with the secret, put the key id in the "kid" header,
+// and must set "exp", see NewAccessToken.
+type AccessKey struct {
+	Id          string   `json:"id"`
+	Secret      Secret   `json:"secret,omitempty"`
+	Actions     []Action `json:"actions"`
+	Collections []string `json:"collections,omitempty"` //"*"表示所有collection
+	Prefixes    []string `json:"prefixes,omitempty"`    //filer路径前缀，在"/"处匹配，"/teamA"不包括"/teamAB"
+}
+
+func (k *AccessKey) validate() error {
+	if k.Id == "" || k.Secret == "" {
+		return errors.New("access key id and secret are required")
+	}
+	if len(k.Actions) == 0 {
+		return fmt.Errorf("access key %s has no actions", k.Id)
+	}
+	for _, a := range k.Actions {
+		switch a {
+		case ActionRead, ActionWrite, ActionDelete, ActionAdmin:
+		default:
+			return fmt.Errorf("unknown action %s", a)
+		}
+	}
+	return nil
+}
+
+// Allows checks the action on the collection, or on the filer path if it is not empty.
+func (k *AccessKey) Allows(action Action, collection, path string) bool {
+	permitted := false
+	for _, a := range k.Actions {
+		if a == action || a == ActionAdmin {
+			permitted = true
+		}
+	}
+	if !permitted {
+		return false
+	}
+	if len(k.Collections) == 0 && len(k.Prefixes) == 0 {
+		return true
+	}
+	if path != "" {
+		for _, p := range k.Prefixes {
+			if hasPathPrefix(path, p) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, c := range k.Collections {
+		if c == "*" || c == collection {
+			return true
+		}
+	}
+	return false
+}
+
+// NewAccessToken signs a token for the access key, valid for ttl.
+// It is sent like other tokens, in the "Authorization" header or the jwt parameter.
+func NewAccessToken(id string, secret Secret, ttl time.Duration) EncodedJwt {
+	t := jwt.NewWithClaims(jwt.SigningMethodHS256, &jwt.StandardClaims{
+		ExpiresAt: time.Now().Add(ttl).Unix(),
+	})
+	t.Header["kid"] = id
+	encoded, err := t.SignedString([]byte(secret))
+	if err != nil {
+		return ""
+	}
+	return EncodedJwt(encoded)
+}
+
+// AccessKeys holds the access keys by id.
+type AccessKeys struct {
+	sync.RWMutex
+	keys map[string]AccessKey
+}
+
+func NewAccessKeys() *AccessKeys {
+	return &AccessKeys{keys: make(map[string]AccessKey)}
+}
+
+func (ak *AccessKeys) IsEmpty() bool {
+	ak.RLock()
+	defer ak.RUnlock()
+	return len(ak.keys) == 0
+}
+
+func (ak *AccessKeys) Get(id string) (AccessKey, bool) {
+	ak.RLock()
+	defer ak.RUnlock()
+	k, ok := ak.keys[id]
+	return k, ok
+}
+
+// List returns the keys sorted by id.
+func (ak *AccessKeys) List() []AccessKey {
+	ak.RLock()
+	defer ak.RUnlock()
+	ids := make([]string, 0, len(ak.keys))
+	for id := range ak.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	keys := make([]AccessKey, 0, len(ids))
+	for _, id := range ids {
+		keys = append(keys, ak.keys[id])
+	}
+	return keys
+}
+
+// Set replaces all keys, e.g., with the keys received from the master.
+func (ak *AccessKeys) Set(keys []AccessKey) error {
+	m := make(map[string]AccessKey, len(keys))
+	for _, k := range keys {
+		if err := k.validate(); err != nil {
+			return err
+		}
+		m[k.Id] = k
+	}
+	ak.Lock()
+	defer ak.Unlock()
+	ak.keys = m
+	return nil
+}
+
+func (ak *AccessKeys) Clone() *AccessKeys {
+	c := NewAccessKeys()
+	c.Set(ak.List())
+	return c
+}
+
+// Put adds the key, or replaces the key with the same id.
+func (ak *AccessKeys) Put(key AccessKey) error {
+	if err := key.validate(); err != nil {
+		return err
+	}
+	ak.Lock()
+	defer ak.Unlock()
+	ak.keys[key.Id] = key
+	return nil
+}
+
+func (ak *AccessKeys) Delete(id string) error {
+	ak.Lock()
+	defer ak.Unlock()
+	if _, ok := ak.keys[id]; !ok {
+		return fmt.Errorf("access key %s not found", id)
+	}
+	delete(ak.keys, id)
+	return nil
+}
+
+// Decode verifies a token signed by the access key named by its "kid".
+// It returns errNotAccessKey if the token is not signed by any access key.
+func (ak *AccessKeys) Decode(tokenString EncodedJwt) (*AccessKey, error) {
+	var key AccessKey
+	found := false
+	claims := &jwt.StandardClaims{}
+	_, err := jwt.ParseWithClaims(string(tokenString), claims, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
+		}
+		kid, _ := t.Header["kid"].(string)
+		if key, found = ak.Get(kid); !found {
+			return nil, errNotAccessKey
+		}
+		return []byte(key.Secret), nil
+	})
+	if !found {
+		return nil, errNotAccessKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	if claims.ExpiresAt == 0 {
+		return nil, ErrNoExpiration
+	}
+	return &key, nil
+}
@@ -0,0 +1,106 @@
+package security
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAuthorize(t *testing.T) {
+	g, err := NewGuard(nil, "cluster secret", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := func(token EncodedJwt) *tokenRequest {
+		return &tokenRequest{g: g, token: token}
+	}
+
+	if err := request("").authorize(ActionDelete, "teamB", ""); err != nil {
+		t.Fatalf("everything should be allowed before access keys are added: %v", err)
+	}
+
+	if err := g.AccessKeys.Put(AccessKey{Id: "teamA", Secret: "a secret", Actions: []Action{ActionWrite, ActionDelete},
+		Collections: []string{"teamA"}, Prefixes: []string{"/teamA/"}}); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	if err := g.AccessKeys.Put(AccessKey{Id: "reader", Secret: "r secret", Actions: []Action{"list"}}); err == nil {
+		t.Fatalf("an unknown action should be rejected")
+	}
+	if err := g.AccessKeys.Put(AccessKey{Id: "admin", Secret: "admin secret", Actions: []Action{ActionAdmin}}); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+
+	if err := request("").authorize(ActionWrite, "teamA", ""); err != ErrUnauthorized {
+		t.Fatalf("a request without a token should be rejected: %v", err)
+	}
+	teamA := request(NewAccessToken("teamA", "a secret", time.Minute))
+	if teamA.authorize(ActionWrite, "teamA", "") != nil || teamA.authorize(ActionDelete, "", "/teamA/a.jpg") != nil {
+		t.Fatalf("teamA should write and delete its own data")
+	}
+	if err := teamA.authorize(ActionDelete, "teamB", ""); err != ErrForbidden {
+		t.Fatalf("teamA should not delete the data of teamB: %v", err)
+	}
+	if err := teamA.authorize(ActionDelete, "", "/teamB/a.jpg"); err != ErrForbidden {
+		t.Fatalf("teamA should not delete the files of teamB: %v", err)
+	}
+	if err := teamA.authorize(ActionAdmin, "teamA", ""); err != ErrForbidden {
+		t.Fatalf("teamA should not be an admin: %v", err)
+	}
+	if err := request(NewAccessToken("teamA", "wrong secret", time.Minute)).authorize(ActionWrite, "teamA", ""); err != ErrUnauthorized {
+		t.Fatalf("a token signed by a wrong secret should be rejected: %v", err)
+	}
+	if err := request(NewAccessToken("teamA", "a secret", -time.Minute)).authorize(ActionWrite, "teamA", ""); err != ErrUnauthorized {
+		t.Fatalf("an expired token should be rejected: %v", err)
+	}
+	if err := request(NewAccessToken("admin", "admin secret", time.Minute)).authorize(ActionDelete, "teamB", ""); err != nil {
+		t.Fatalf("an admin should be allowed everything: %v", err)
+	}
+
+	if err := teamA.authorize(ActionDelete, "", "/teamAB/a.jpg"); err != ErrForbidden {
+		t.Fatalf("teamA should not delete the files of a sibling sharing its prefix: %v", err)
+	}
+
+	//集群成员用JWT密钥签名，但读取令牌不能用于写入
+	if err := request(g.Keys.SignCluster()).authorize(ActionDelete, "teamB", ""); err != nil {
+		t.Fatalf("a cluster member should be allowed: %v", err)
+	}
+	//为一个文件签发的令牌只能用于这个文件
+	fileToken := g.Keys.Sign("3,01637037d6")
+	if err := request(fileToken).authorize(ActionDelete, "teamB", ""); err != ErrUnauthorized {
+		t.Fatalf("a file token should not be a cluster member: %v", err)
+	}
+	if err := g.AuthorizeFile(request(fileToken).request(), ActionDelete, "teamB", "", "3,01637037d6"); err != nil {
+		t.Fatalf("a file token should delete its own file: %v", err)
+	}
+	if err := g.AuthorizeFile(request(fileToken).request(), ActionDelete, "teamB", "", "3,01637037d7"); err != ErrUnauthorized {
+		t.Fatalf("a file token should not delete another file: %v", err)
+	}
+	if err := request(g.Keys.Encode(NewReadClaims("3,01637037d6", "", "", time.Minute))).authorize(ActionDelete, "teamB", ""); err != ErrUnauthorized {
+		t.Fatalf("a read token should not delete: %v", err)
+	}
+
+	if err := g.CheckRead(httptest.NewRequest("GET", "/teamA/a.jpg?jwt="+string(teamA.token), nil), "/teamA/a.jpg", ""); err != ErrForbidden {
+		t.Fatalf("teamA is not allowed to read: %v", err)
+	}
+	if err := g.CheckRead(httptest.NewRequest("GET", "/3,01637037d6?jwt="+string(NewAccessToken("admin", "admin secret", time.Minute)), nil), "3,01637037d6", "teamB"); err != nil {
+		t.Fatalf("an admin should read: %v", err)
+	}
+}
+
+type tokenRequest struct {
+	g     *Guard
+	token EncodedJwt
+}
+
+func (r *tokenRequest) request() *http.Request {
+	req := httptest.NewRequest("POST", "/", nil)
+	if r.token != "" {
+		req.Header.Set("Authorization", "BEARER "+string(r.token))
+	}
+	return req
+}
+
+func (r *tokenRequest) authorize(action Action, collection, path string) error {
+	return r.g.Authorize(r.request(), action, collection, path)
+}
@@ -0,0 +1,288 @@
+package security
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chrislusf/seaweedfs/weed/glog"
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// AuditRecord is one line of the audit log, in JSON.
+type AuditRecord struct {
+	Time      time.Time `json:"time"`
+	Server    string    `json:"server"` //请求发往的地址，区分master、volume和filer
+	ClientIP  string    `json:"clientIp"`
+	Subject   string    `json:"subject,omitempty"` //JWT的"sub"
+	KeyId     string    `json:"keyId,omitempty"`   //JWT的"kid"，访问密钥的id
+	Operation string    `json:"operation"`
+	Target    string    `json:"target,omitempty"` //文件id、路径或者collection
+	Status    int       `json:"status"`
+}
+
+// AuditOption configures where the audit records go. Without File and Webhook nothing is audited.
+type AuditOption struct {
+	File      string
+	MaxMB     int //文件超过此大小后轮转
+	Backups   int //保留的轮转文件数量
+	Webhook   string
+	Transport http.RoundTripper //发送到webhook，传入util.Transport以使用集群的TLS配置
+}
+
+type auditSink interface {
+	write(line []byte) error
+}
+
+var (
+	auditLock  sync.RWMutex
+	auditSinks []auditSink
+)
+
+// SetupAudit opens the audit sinks. The handlers wrapped by Audited check the sinks on each request,
+// so it may also be called after the servers are created.
+func SetupAudit(option AuditOption) error {
+	var sinks []auditSink
+	if option.File != "" {
+		if option.MaxMB <= 0 {
+			return errors.New("audit log size should be positive")
+		}
+		f := &auditFile{path: option.File, maxSize: int64(option.MaxMB) * 1024 * 1024, backups: option.Backups}
+		if err := f.open(); err != nil {
+			return err
+		}
+		sinks = append(sinks, f)
+	}
+	if option.Webhook != "" {
+		if !strings.HasPrefix(option.Webhook, "http://") && !strings.HasPrefix(option.Webhook, "https://") {
+			return fmt.Errorf("invalid audit webhook %s", option.Webhook)
+		}
+		sinks = append(sinks, newAuditWebhook(option.Webhook, option.Transport))
+	}
+	auditLock.Lock()
+	auditSinks = sinks
+	auditLock.Unlock()
+	return nil
+}
+
+func AuditEnabled() bool {
+	return len(currentAuditSinks()) > 0
+}
+
+func currentAuditSinks() []auditSink {
+	auditLock.RLock()
+	defer auditLock.RUnlock()
+	return auditSinks
+}
+
+// AuditTarget tells the target of a request, e.g., the file id or the path.
+type AuditTarget func(r *http.Request) string
+
+// AuditPath takes the url path as the target.
+func AuditPath(r *http.Request) string {
+	return r.URL.Path
+}
+
+// AuditForm takes the form values as the target, without reading multipart bodies.
+func AuditForm(names ...string) AuditTarget {
+	return func(r *http.Request) string {
+		r.ParseForm()
+		var parts []string
+		for _, name := range names {
+			if values := r.Form[name]; len(values) > 0 {
+				parts = append(parts, name+"="+strings.Join(values, ","))
+			}
+		}
+		return strings.Join(parts, " ")
+	}
+}
+
+// Audited records the operation on the target and the response status in the audit log.
+func (g *Guard) Audited(operation string, target AuditTarget, f func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !AuditEnabled() {
+			f(w, r)
+			return
+		}
+		record := &AuditRecord{
+			Server:    r.Host,
+			Operation: operation,
+			Target:    target(r),
+		}
+		if ip := g.ClientIP(r); ip != nil {
+			record.ClientIP = ip.String()
+		}
+		//只是记录，令牌由处理函数校验
+		if tokenStr := GetJwt(r); tokenStr != "" {
+			claims := &jwt.StandardClaims{}
+			if token, _, err := new(jwt.Parser).ParseUnverified(string(tokenStr), claims); err == nil {
+				record.Subject = claims.Subject
+				record.KeyId, _ = token.Header["kid"].(string)
+			}
+		}
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		f(recorder, r)
+		record.Time = time.Now()
+		record.Status = recorder.status
+		Audit(record)
+	}
+}
+
+// Audit writes the record to all audit sinks.
+func Audit(record *AuditRecord) {
+	line, err := json.Marshal(record)
+	if err != nil {
+		glog.V(0).Infof("Failed to marshal audit record %+v: %v", record, err)
+		return
+	}
+	line = append(line, '\n')
+	for _, sink := range currentAuditSinks() {
+		if err := sink.write(line); err != nil {
+			glog.Errorf("Failed to write audit record %s: %v", line, err)
+		}
+	}
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// auditFile appends the records to a local file, and rotates it to file.1, file.2, ... when it is too large.
+type auditFile struct {
+	sync.Mutex
+	path    string
+	maxSize int64
+	backups int
+	file    *os.File
+	size    int64
+}
+
+func (f *auditFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size = file, stat.Size()
+	return nil
+}
+
+func (f *auditFile) write(line []byte) error {
+	f.Lock()
+	defer f.Unlock()
+	if f.size > 0 && f.size+int64(len(line)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := f.file.Write(line)
+	f.size += int64(n)
+	return err
+}
+
+func (f *auditFile) rotate() error {
+	f.file.Close()
+	if f.backups <= 0 {
+		os.Remove(f.path)
+	} else {
+		for i := f.backups - 1; i >= 1; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", f.path, i), fmt.Sprintf("%s.%d", f.path, i+1))
+		}
+		if err := os.Rename(f.path, f.path+".1"); err != nil {
+			return err
+		}
+	}
+	return f.open()
+}
+
+const (
+	auditWebhookQueueSize = 10000
+	auditWebhookBatchSize = 100
+	auditWebhookRetries   = 3
+)
+
+// auditWebhook posts the records in batches, as JSON lines, without blocking the requests.
+type auditWebhook struct {
+	url    string
+	queue  chan []byte
+	client *http.Client
+}
+
+func newAuditWebhook(url string, transport http.RoundTripper) *auditWebhook {
+	h := &auditWebhook{
+		url:    url,
+		queue:  make(chan []byte, auditWebhookQueueSize),
+		client: &http.Client{Transport: transport, Timeout: 10 * time.Second},
+	}
+	go h.loop()
+	return h
+}
+
+func (h *auditWebhook) write(line []byte) error {
+	select {
+	case h.queue <- line:
+		return nil
+	default:
+		return errors.New("audit webhook queue is full")
+	}
+}
+
+//攒够一批或者每秒发送一次
+func (h *auditWebhook) loop() {
+	var batch bytes.Buffer
+	count := 0
+	ticker := time.NewTicker(time.Second)
+	for {
+		select {
+		case line := <-h.queue:
+			batch.Write(line)
+			count++
+			if count < auditWebhookBatchSize {
+				continue
+			}
+		case <-ticker.C:
+			if count == 0 {
+				continue
+			}
+		}
+		if err := h.post(batch.Bytes()); err != nil {
+			glog.Errorf("Failed to post %d audit records to %s: %v", count, h.url, err)
+		}
+		batch.Reset()
+		count = 0
+	}
+}
+
+func (h *auditWebhook) post(body []byte) (err error) {
+	for i := 0; i < auditWebhookRetries; i++ {
+		if i > 0 {
+			time.Sleep(time.Duration(i) * time.Second)
+		}
+		var resp *http.Response
+		if resp, err = h.client.Post(h.url, "application/x-ndjson", bytes.NewReader(body)); err != nil {
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 300 {
+			return nil
+		}
+		err = fmt.Errorf("%s: %s", h.url, resp.Status)
+	}
+	return err
+}
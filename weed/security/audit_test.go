@@ -0,0 +1,111 @@
+package security
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAuditLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "audit.log")
+	g, err := NewGuard(nil, "", []string{"198.51.100.7"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	//处理函数可以在设置审计日志之前包装
+	deleted := g.Audited("delete", AuditForm("fid"), func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	})
+	if err := SetupAudit(AuditOption{File: file, MaxMB: 1, Backups: 2}); err != nil {
+		t.Fatalf("setup: %v", err)
+	}
+	defer SetupAudit(AuditOption{})
+	r := httptest.NewRequest("POST", "/delete?fid=3,01637037d6&fid=3,01637037d7", nil)
+	r.RemoteAddr = "198.51.100.7:8080"
+	r.Header.Set("X-Forwarded-For", "192.0.2.1")
+	r.Header.Set("Authorization", "BEARER "+string(NewAccessToken("teamA", "a secret", time.Minute)))
+	deleted(httptest.NewRecorder(), r)
+
+	records := readAuditRecords(t, file)
+	if len(records) != 1 {
+		t.Fatalf("expected 1 record, got %d", len(records))
+	}
+	record := records[0]
+	if record.Operation != "delete" || record.Target != "fid=3,01637037d6,3,01637037d7" || record.Status != http.StatusAccepted {
+		t.Fatalf("unexpected record %+v", record)
+	}
+	if record.ClientIP != "192.0.2.1" || record.KeyId != "teamA" {
+		t.Fatalf("the client should be recorded: %+v", record)
+	}
+
+	//写满1MB后轮转，只保留2个旧文件
+	long := &AuditRecord{Operation: "write", Target: string(make([]byte, 100*1024))}
+	for i := 0; i < 40; i++ {
+		Audit(long)
+	}
+	for _, name := range []string{file, file + ".1", file + ".2"} {
+		stat, err := os.Stat(name)
+		if err != nil {
+			t.Fatalf("%s should exist: %v", name, err)
+		}
+		if stat.Size() > 1024*1024 {
+			t.Fatalf("%s should be rotated at 1MB: %d bytes", name, stat.Size())
+		}
+	}
+	if _, err := os.Stat(file + ".3"); !os.IsNotExist(err) {
+		t.Fatalf("only 2 backups should be kept: %v", err)
+	}
+}
+
+func TestAuditWebhook(t *testing.T) {
+	posted := make(chan []byte, 1)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		posted <- body
+	}))
+	defer server.Close()
+	//webhook使用传入的Transport，信任测试服务器的证书
+	if err := SetupAudit(AuditOption{Webhook: server.URL, Transport: server.Client().Transport}); err != nil {
+		t.Fatalf("setup: %v", err)
+	}
+	defer SetupAudit(AuditOption{})
+
+	Audit(&AuditRecord{Operation: "delete", Target: "3,01637037d6"})
+	select {
+	case body := <-posted:
+		var record AuditRecord
+		if err := json.Unmarshal(body, &record); err != nil || record.Operation != "delete" {
+			t.Fatalf("unexpected post %s: %v", body, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the audit record was not posted over TLS")
+	}
+}
+
+func readAuditRecords(t *testing.T, file string) (records []AuditRecord) {
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var record AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("invalid record %s: %v", scanner.Text(), err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
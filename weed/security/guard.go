@@ -6,19 +6,24 @@ import (
 	"net"
 	"net/http"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/chrislusf/seaweedfs/weed/glog"
+	jwt "github.com/dgrijalva/jwt-go"
 )
 
 var (
 	ErrUnauthorized = errors.New("unauthorized token")
+	ErrForbidden    = errors.New("access key not allowed")
 )
 
 /*
 Guard is to ensure data access security.
 There are 2 ways to check access:
-1. white list. It's checking request ip address.
-2. JSON Web Token(JWT) generated from secretKey.
+1. white list. It's checking request ip address, against addresses, CIDR ranges and host names.
+  The "X-Forwarded-For" header is only used if the request comes from a trusted proxy.
+2. JSON Web Token(JWT) signed by one of the active keys, see KeySet.
   The jwt can come from:
   1. url parameter jwt=...
   2. request header "Authorization"
@@ -27,6 +32,11 @@ There are 2 ways to check access:
 The white list is checked first because it is easy.
 Then the JWT is checked.
 
+Once access keys are added, see AccessKey, the handlers also check the permissions with Authorize.
+Cluster members are trusted if they are in the white list, or sign their requests with a JWT key
+and the cluster audience, so the JWT keys should not be given to clients any more.
+A token signed for a file id, without the audience, only authorizes that file.
+
 The Guard will also check these claims if provided:
 1. "exp" Expiration Time
 2. "nbf" Not Before
@@ -41,16 +51,58 @@ https://github.com/pkieltyka/jwtauth/blob/master/jwtauth.go
 
 */
 type Guard struct {
-	whiteList []string
-	SecretKey Secret
+	whiteList      *ipList
+	trustedProxies *ipList
+	Keys           *KeySet
+	AccessKeys     *AccessKeys
+
+	isActive    bool
+	resolveOnce sync.Once
+}
+
+// NewGuard parses the white list, which may have IPv4 or IPv6 addresses, CIDR ranges and host names,
+// and the trusted proxies, which may have addresses and CIDR ranges.
+func NewGuard(whiteList []string, secretKey string, trustedProxies []string) (*Guard, error) {
+	g := &Guard{
+		whiteList:      newIPList(),
+		trustedProxies: newIPList(),
+		Keys:           NewKeySet(secretKey),
+		AccessKeys:     NewAccessKeys(),
+	}
+	if err := g.whiteList.add(whiteList, true); err != nil {
+		return nil, fmt.Errorf("white list: %v", err)
+	}
+	if err := g.trustedProxies.add(trustedProxies, false); err != nil {
+		return nil, fmt.Errorf("trusted proxies: %v", err)
+	}
+	g.isActive = !g.whiteList.isEmpty() || !g.Keys.IsEmpty()
+	g.startResolving()
+	return g, nil
+}
 
-	isActive bool
+// AddTrustedProxies trusts more proxies, which may also be host names,
+// e.g., the other masters which proxy requests to the leader.
+func (g *Guard) AddTrustedProxies(proxies []string) error {
+	if err := g.trustedProxies.add(proxies, true); err != nil {
+		return err
+	}
+	g.startResolving()
+	return nil
 }
 
-func NewGuard(whiteList []string, secretKey string) *Guard {
-	g := &Guard{whiteList: whiteList, SecretKey: Secret(secretKey)}
-	g.isActive = len(g.whiteList) != 0 || len(g.SecretKey) != 0
-	return g
+//有主机名时定期重新解析
+func (g *Guard) startResolving() {
+	if !g.whiteList.hasHosts() && !g.trustedProxies.hasHosts() {
+		return
+	}
+	g.resolveOnce.Do(func() {
+		go func() {
+			for _ = range time.Tick(hostResolveInterval) {
+				g.whiteList.resolve()
+				g.trustedProxies.resolve()
+			}
+		}()
+	})
 }
 
 func (g *Guard) WhiteList(f func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
@@ -68,10 +120,7 @@ func (g *Guard) WhiteList(f func(w http.ResponseWriter, r *http.Request)) func(w
 }
 
 func (g *Guard) Secure(f func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
-	if !g.isActive {
-		//if no security needed, just skip all checkings
-		return f
-	}
+	//密钥可能在启动后才从master获得，所以每次请求时检查
 	return func(w http.ResponseWriter, r *http.Request) {
 		if err := g.checkJwt(w, r); err != nil {
 			w.WriteHeader(http.StatusUnauthorized)
@@ -81,54 +130,168 @@ func (g *Guard) Secure(f func(w http.ResponseWriter, r *http.Request)) func(w ht
 	}
 }
 
-func GetActualRemoteHost(r *http.Request) (host string, err error) {
-	host = r.Header.Get("HTTP_X_FORWARDED_FOR")
-	if host == "" {
-		host = r.Header.Get("X-FORWARDED-FOR")
-	}
-	if strings.Contains(host, ",") {
-		host = host[0:strings.Index(host, ",")]
+// Authorized is WhiteList which also lets in the requests with a valid token once access keys are added.
+// The handler should check the permissions with Authorize.
+func (g *Guard) Authorized(f func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+	whiteListed := g.WhiteList(f)
+	return func(w http.ResponseWriter, r *http.Request) {
+		//访问密钥可能在启动后才从master获得，所以每次请求时检查
+		if g.AccessKeys.IsEmpty() || g.checkWhiteList(w, r) == nil {
+			whiteListed(w, r)
+			return
+		}
+		if _, err := g.authenticate(r, ""); err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		f(w, r)
 	}
-	if host == "" {
-		host, _, err = net.SplitHostPort(r.RemoteAddr)
+}
+
+// Privileged lets in only the requests from the white list, or with an access key allowed to administer all collections.
+// Unlike Authorized, it refuses everyone while neither a white list nor access keys are configured.
+func (g *Guard) Privileged(f func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if g.IsWhiteListed(r) {
+			f(w, r)
+			return
+		}
+		if !g.AccessKeys.IsEmpty() {
+			if key, err := g.authenticate(r, ""); err == nil && key != nil && key.Allows(ActionAdmin, "", "") {
+				f(w, r)
+				return
+			}
+		}
+		glog.V(0).Infof("Privileged request from %s is refused", r.RemoteAddr)
+		w.WriteHeader(http.StatusUnauthorized)
 	}
-	return
 }
 
-func (g *Guard) checkWhiteList(w http.ResponseWriter, r *http.Request) error {
-	if len(g.whiteList) == 0 {
+// Restricted tells whether the callers are identified, by the white list or by access keys.
+func (g *Guard) Restricted() bool {
+	return !g.whiteList.isEmpty() || !g.AccessKeys.IsEmpty()
+}
+
+// IsWhiteListed checks whether the request comes from the white list, which is not empty.
+func (g *Guard) IsWhiteListed(r *http.Request) bool {
+	return !g.whiteList.isEmpty() && g.whiteList.contains(g.ClientIP(r))
+}
+
+// Authorize checks whether the request may do the action on the collection,
+// or on the filer path if it is not empty. It allows everything until access keys are added.
+// It returns ErrUnauthorized without a valid token, and ErrForbidden if the access key is not allowed.
+func (g *Guard) Authorize(r *http.Request, action Action, collection, path string) error {
+	return g.AuthorizeFile(r, action, collection, path, "")
+}
+
+// AuthorizeFile is Authorize, which also accepts a token signed for the file id, see KeySet.Sign.
+func (g *Guard) AuthorizeFile(r *http.Request, action Action, collection, path, fileId string) error {
+	if g.AccessKeys.IsEmpty() {
 		return nil
 	}
+	key, err := g.authenticate(r, fileId)
+	if err != nil {
+		return err
+	}
+	if key != nil && !key.Allows(action, collection, path) {
+		glog.V(1).Infof("Access key %s from %s is not allowed to %s %s%s", key.Id, r.RemoteAddr, action, collection, path)
+		return ErrForbidden
+	}
+	return nil
+}
 
-	host, err := GetActualRemoteHost(r)
-	if err == nil {
-		for _, ip := range g.whiteList {
-
-			// If the whitelist entry contains a "/" it
-			// is a CIDR range, and we should check the
-			// remote host is within it
-			if strings.Contains(ip, "/") {
-				_, cidrnet, err := net.ParseCIDR(ip)
-				if err != nil {
-					panic(err)
-				}
-				remote := net.ParseIP(host)
-				if cidrnet.Contains(remote) {
-					return nil
-				}
-			}
+// AuthorizedFiles is Authorized for the handlers which check each file with AuthorizeFile,
+// so that the tokens signed for one file id reach them.
+func (g *Guard) AuthorizedFiles(f func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+	whiteListed := g.WhiteList(f)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if g.AccessKeys.IsEmpty() {
+			whiteListed(w, r)
+			return
+		}
+		f(w, r)
+	}
+}
 
-			//
-			// Otherwise we're looking for a literal match.
-			//
-			if ip == host {
-				return nil
+// IsClusterMember checks whether the request has a valid token of a cluster member, see KeySet.SignCluster.
+func (g *Guard) IsClusterMember(r *http.Request) bool {
+	tokenStr := GetJwt(r)
+	if tokenStr == "" {
+		return false
+	}
+	claims := &jwt.StandardClaims{}
+	_, err := g.Keys.Decode(tokenStr, claims)
+	return err == nil && claims.Audience == ClusterAudience
+}
+
+//返回请求的访问密钥，白名单和集群成员返回nil
+//集群成员的令牌有专门的audience，为一个文件签发的令牌只能用于fileId
+func (g *Guard) authenticate(r *http.Request, fileId string) (*AccessKey, error) {
+	if g.IsWhiteListed(r) {
+		return nil, nil
+	}
+	tokenStr := GetJwt(r)
+	if tokenStr == "" {
+		return nil, ErrUnauthorized
+	}
+	key, err := g.AccessKeys.Decode(tokenStr)
+	if err == errNotAccessKey {
+		claims := &jwt.StandardClaims{}
+		if _, err = g.Keys.Decode(tokenStr, claims); err == nil {
+			switch {
+			case claims.Audience == ClusterAudience:
+			case claims.Audience == "" && claims.Subject != "" && claims.Subject == fileId:
+			default:
+				err = fmt.Errorf("token of audience %q for %q is not allowed here", claims.Audience, claims.Subject)
 			}
 		}
 	}
+	if err != nil {
+		glog.V(1).Infof("Token verification error from %s: %v", r.RemoteAddr, err)
+		return nil, ErrUnauthorized
+	}
+	return key, nil
+}
+
+// ClientIP returns the address of the client, or nil if it is unknown.
+// The "X-Forwarded-For" header is only used if the request comes from a trusted proxy,
+// and is read from the right, skipping the trusted proxies, so the client cannot forge it.
+func (g *Guard) ClientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !g.trustedProxies.contains(ip) {
+		return ip
+	}
+	hops := strings.Split(strings.Join(r.Header["X-Forwarded-For"], ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		if ip = net.ParseIP(hop); ip == nil {
+			return nil
+		}
+		if !g.trustedProxies.contains(ip) {
+			return ip
+		}
+	}
+	return ip
+}
+
+func (g *Guard) checkWhiteList(w http.ResponseWriter, r *http.Request) error {
+	if g.whiteList.isEmpty() {
+		return nil
+	}
+
+	if g.whiteList.contains(g.ClientIP(r)) {
+		return nil
+	}
 
-	glog.V(0).Infof("Not in whitelist: %s", r.RemoteAddr)
-	return fmt.Errorf("Not in whitelis: %s", r.RemoteAddr)
+	glog.V(0).Infof("Not in whitelist: %s %v", r.RemoteAddr, r.Header["X-Forwarded-For"])
+	return fmt.Errorf("Not in whitelist: %s", r.RemoteAddr)
 }
 
 func (g *Guard) checkJwt(w http.ResponseWriter, r *http.Request) error {
@@ -136,7 +299,7 @@ func (g *Guard) checkJwt(w http.ResponseWriter, r *http.Request) error {
 		return nil
 	}
 
-	if len(g.SecretKey) == 0 {
+	if g.Keys.IsEmpty() {
 		return nil
 	}
 
@@ -147,7 +310,7 @@ func (g *Guard) checkJwt(w http.ResponseWriter, r *http.Request) error {
 	}
 
 	// Verify the token
-	token, err := DecodeJwt(g.SecretKey, tokenStr)
+	token, err := g.Keys.Decode(tokenStr, &jwt.StandardClaims{})
 	if err != nil {
 		glog.V(1).Infof("Token verification error from %s: %v", r.RemoteAddr, err)
 		return ErrUnauthorized
@@ -157,6 +320,5 @@ func (g *Guard) checkJwt(w http.ResponseWriter, r *http.Request) error {
 		return ErrUnauthorized
 	}
 
-	glog.V(1).Infof("No permission from %s", r.RemoteAddr)
-	return fmt.Errorf("No write permisson from %s", r.RemoteAddr)
+	return nil
 }
@@ -0,0 +1,95 @@
+package security
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWhiteListBehindProxies(t *testing.T) {
+	if _, err := NewGuard([]string{"10.0.0.0/33"}, "", nil); err == nil {
+		t.Fatalf("a malformed CIDR should be rejected at start")
+	}
+	if _, err := NewGuard(nil, "", []string{"proxy.example.com"}); err == nil {
+		t.Fatalf("a trusted proxy should not be a host name")
+	}
+	g, err := NewGuard([]string{"192.0.2.1", "10.1.0.0/16", "2001:db8::/32", "localhost"}, "", []string{"198.51.100.0/24", "2001:db8:ffff::1"})
+	if err != nil {
+		t.Fatalf("new guard: %v", err)
+	}
+	allowed := func(remoteAddr string, forwardedFor ...string) bool {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = remoteAddr
+		for _, f := range forwardedFor {
+			r.Header.Add("X-Forwarded-For", f)
+		}
+		return g.checkWhiteList(nil, r) == nil
+	}
+
+	if !allowed("192.0.2.1:8080") || !allowed("10.1.2.3:8080") || !allowed("[2001:db8::5]:8080") {
+		t.Fatalf("addresses in the white list should be allowed")
+	}
+	if !allowed("127.0.0.1:8080") {
+		t.Fatalf("a resolved host name should be allowed")
+	}
+	if allowed("203.0.113.9:8080") || allowed("[2001:db9::5]:8080") {
+		t.Fatalf("addresses out of the white list should be rejected")
+	}
+	if allowed("203.0.113.9:8080", "192.0.2.1") {
+		t.Fatalf("X-Forwarded-For from an untrusted client should be ignored")
+	}
+	if !allowed("198.51.100.7:8080", "192.0.2.1") {
+		t.Fatalf("X-Forwarded-For from a trusted proxy should be honoured")
+	}
+	//客户端自己伪造的地址在最左边，可信代理追加的才是真实地址
+	if allowed("198.51.100.7:8080", "192.0.2.1, 203.0.113.9") {
+		t.Fatalf("a forged address before the real client should be ignored")
+	}
+	if !allowed("[2001:db8:ffff::1]:8080", "203.0.113.9, 10.1.2.3", "198.51.100.8") {
+		t.Fatalf("the chain of trusted proxies should be skipped")
+	}
+	if allowed("198.51.100.7:8080", "not an ip") {
+		t.Fatalf("a malformed X-Forwarded-For should be rejected")
+	}
+}
+
+func TestPrivileged(t *testing.T) {
+	privileged := func(g *Guard, remoteAddr string, token EncodedJwt) bool {
+		r := httptest.NewRequest("POST", "/jwt/keys/add", nil)
+		r.RemoteAddr = remoteAddr
+		if token != "" {
+			r.Header.Set("Authorization", "BEARER "+string(token))
+		}
+		w := httptest.NewRecorder()
+		g.Privileged(func(w http.ResponseWriter, r *http.Request) {})(w, r)
+		return w.Code == http.StatusOK
+	}
+
+	g, err := NewGuard(nil, "cluster secret", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if privileged(g, "192.0.2.1:8080", "") {
+		t.Fatalf("nobody should be privileged without a white list and access keys")
+	}
+	g.AccessKeys.Put(AccessKey{Id: "teamA", Secret: "a secret", Actions: []Action{ActionAdmin}, Collections: []string{"teamA"}})
+	g.AccessKeys.Put(AccessKey{Id: "admin", Secret: "admin secret", Actions: []Action{ActionAdmin}})
+	if privileged(g, "192.0.2.1:8080", g.Keys.SignCluster()) {
+		t.Fatalf("a cluster member should not be privileged")
+	}
+	if privileged(g, "192.0.2.1:8080", NewAccessToken("teamA", "a secret", time.Minute)) {
+		t.Fatalf("an admin of one collection should not be privileged")
+	}
+	if !privileged(g, "192.0.2.1:8080", NewAccessToken("admin", "admin secret", time.Minute)) {
+		t.Fatalf("an admin of all collections should be privileged")
+	}
+
+	g, err = NewGuard([]string{"192.0.2.1"}, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !privileged(g, "192.0.2.1:8080", "") || privileged(g, "192.0.2.2:8080", "") {
+		t.Fatalf("only the white list should be privileged")
+	}
+}
@@ -0,0 +1,128 @@
+package security
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chrislusf/seaweedfs/weed/glog"
+)
+
+const hostResolveInterval = time.Minute
+
+// ipList matches IPv4 and IPv6 addresses against single addresses, CIDR ranges,
+// and host names, which are resolved again periodically.
+type ipList struct {
+	sync.RWMutex
+	nets     []*net.IPNet
+	hosts    []string
+	resolved map[string][]net.IP
+}
+
+func newIPList() *ipList {
+	return &ipList{resolved: make(map[string][]net.IP)}
+}
+
+// add parses the entries. Host names are only accepted if allowHosts is set.
+func (l *ipList) add(entries []string, allowHosts bool) error {
+	var nets []*net.IPNet
+	var hosts []string
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if strings.Contains(entry, "/") {
+			_, n, err := net.ParseCIDR(entry)
+			if err != nil {
+				return fmt.Errorf("invalid CIDR %s: %v", entry, err)
+			}
+			nets = append(nets, n)
+		} else if ip := net.ParseIP(entry); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+		} else if allowHosts && isHostName(entry) {
+			hosts = append(hosts, entry)
+		} else {
+			return fmt.Errorf("invalid address %s", entry)
+		}
+	}
+	l.Lock()
+	l.nets = append(l.nets, nets...)
+	l.hosts = append(l.hosts, hosts...)
+	l.Unlock()
+	if len(hosts) > 0 {
+		l.resolve()
+	}
+	return nil
+}
+
+//主机名只包含字母、数字、"-"和"."，并且至少有一个字母
+func isHostName(s string) bool {
+	hasLetter := false
+	for _, c := range s {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+			hasLetter = true
+		case c >= '0' && c <= '9', c == '-', c == '.':
+		default:
+			return false
+		}
+	}
+	return hasLetter
+}
+
+func (l *ipList) isEmpty() bool {
+	l.RLock()
+	defer l.RUnlock()
+	return len(l.nets) == 0 && len(l.hosts) == 0
+}
+
+func (l *ipList) hasHosts() bool {
+	l.RLock()
+	defer l.RUnlock()
+	return len(l.hosts) > 0
+}
+
+func (l *ipList) contains(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	l.RLock()
+	defer l.RUnlock()
+	for _, n := range l.nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	for _, ips := range l.resolved {
+		for _, resolved := range ips {
+			if resolved.Equal(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// resolve looks up the host names. A host name failing to resolve keeps its last addresses.
+func (l *ipList) resolve() {
+	l.RLock()
+	hosts := append([]string(nil), l.hosts...)
+	l.RUnlock()
+	for _, host := range hosts {
+		ips, err := net.LookupIP(host)
+		if err != nil {
+			glog.V(0).Infof("Failed to resolve %s: %v", host, err)
+			continue
+		}
+		l.Lock()
+		l.resolved[host] = ips
+		l.Unlock()
+	}
+}
@@ -23,7 +23,7 @@ func GenJwt(secret Secret, fileId string) EncodedJwt {
 		ExpiresAt: time.Now().Add(time.Second * 10).Unix(),
 		Subject:   fileId,
 	}
-	encoded, e := t.SignedString(secret)
+	encoded, e := t.SignedString([]byte(secret))
 	if e != nil {
 		glog.V(0).Infof("Failed to sign claims: %v", t.Claims)
 		return ""
@@ -31,6 +31,22 @@ func GenJwt(secret Secret, fileId string) EncodedJwt {
 	return EncodedJwt(encoded)
 }
 
+// GenClusterJwt generates a token of a cluster member with the secret, see KeySet.SignCluster.
+func GenClusterJwt(secret Secret) EncodedJwt {
+	if secret == "" {
+		return ""
+	}
+	encoded, e := EncodeJwt(secret, &jwt.StandardClaims{
+		Audience:  ClusterAudience,
+		ExpiresAt: time.Now().Add(time.Second * 10).Unix(),
+	})
+	if e != nil {
+		glog.V(0).Infof("Failed to sign cluster claims: %v", e)
+		return ""
+	}
+	return encoded
+}
+
 func GetJwt(r *http.Request) EncodedJwt {
 
 	// Get token from query params
@@ -62,13 +78,13 @@ func EncodeJwt(secret Secret, claims *jwt.StandardClaims) (EncodedJwt, error) {
 
 	t := jwt.New(jwt.GetSigningMethod("HS256"))
 	t.Claims = claims
-	encoded, e := t.SignedString(secret)
+	encoded, e := t.SignedString([]byte(secret))
 	return EncodedJwt(encoded), e
 }
 
 func DecodeJwt(secret Secret, tokenString EncodedJwt) (token *jwt.Token, err error) {
 	// check exp, nbf
 	return jwt.Parse(string(tokenString), func(token *jwt.Token) (interface{}, error) {
-		return secret, nil
+		return []byte(secret), nil
 	})
 }
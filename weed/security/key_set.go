@@ -0,0 +1,222 @@
+package security
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// DefaultKeyId names the key given by -secure.secret.
+const DefaultKeyId = "default"
+
+// SigningKey is a JWT secret with its key id, sent in the "kid" header of the tokens it signs.
+type SigningKey struct {
+	Id     string `json:"kid"`
+	Secret Secret `json:"secret"`
+}
+
+// KeySet holds the active JWT keys. Tokens are signed with the signing key,
+// and verified with the key named by their "kid", or with any active key if they have none.
+//
+// To rotate keys without downtime: add the new key, wait for it to reach all servers,
+// switch signing to it, and retire the old key after the tokens signed with it expire.
+type KeySet struct {
+	sync.RWMutex
+	keys      []SigningKey
+	signingId string
+}
+
+func NewKeySet(secret string) *KeySet {
+	ks := &KeySet{}
+	if secret != "" {
+		ks.keys = []SigningKey{{Id: DefaultKeyId, Secret: Secret(secret)}}
+		ks.signingId = DefaultKeyId
+	}
+	return ks
+}
+
+func GenerateSecret() Secret {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return Secret(hex.EncodeToString(b))
+}
+
+func (ks *KeySet) IsEmpty() bool {
+	ks.RLock()
+	defer ks.RUnlock()
+	return len(ks.keys) == 0
+}
+
+// Keys returns a copy of the keys and the id of the signing key.
+func (ks *KeySet) Keys() ([]SigningKey, string) {
+	ks.RLock()
+	defer ks.RUnlock()
+	return append([]SigningKey(nil), ks.keys...), ks.signingId
+}
+
+func (ks *KeySet) KeyIds() (ids []string, signingId string) {
+	ks.RLock()
+	defer ks.RUnlock()
+	for _, k := range ks.keys {
+		ids = append(ids, k.Id)
+	}
+	return ids, ks.signingId
+}
+
+// Set replaces all keys, e.g., with the keys received from the master.
+func (ks *KeySet) Set(keys []SigningKey, signingId string) error {
+	if err := validateKeys(keys, signingId); err != nil {
+		return err
+	}
+	ks.Lock()
+	defer ks.Unlock()
+	ks.keys = append([]SigningKey(nil), keys...)
+	ks.signingId = signingId
+	return nil
+}
+
+func (ks *KeySet) Clone() *KeySet {
+	keys, signingId := ks.Keys()
+	return &KeySet{keys: keys, signingId: signingId}
+}
+
+// Add adds a new key. The first key becomes the signing key.
+func (ks *KeySet) Add(key SigningKey) error {
+	if key.Id == "" || key.Secret == "" {
+		return errors.New("key id and secret are required")
+	}
+	ks.Lock()
+	defer ks.Unlock()
+	for _, k := range ks.keys {
+		if k.Id == key.Id {
+			return fmt.Errorf("key %s already exists", key.Id)
+		}
+	}
+	ks.keys = append(ks.keys, key)
+	if ks.signingId == "" {
+		ks.signingId = key.Id
+	}
+	return nil
+}
+
+// Use switches signing to an existing key.
+func (ks *KeySet) Use(id string) error {
+	ks.Lock()
+	defer ks.Unlock()
+	if ks.find(id) < 0 {
+		return fmt.Errorf("key %s not found", id)
+	}
+	ks.signingId = id
+	return nil
+}
+
+// Retire removes a key which is no longer used for signing.
+func (ks *KeySet) Retire(id string) error {
+	ks.Lock()
+	defer ks.Unlock()
+	i := ks.find(id)
+	if i < 0 {
+		return fmt.Errorf("key %s not found", id)
+	}
+	if id == ks.signingId {
+		return fmt.Errorf("key %s is used for signing, switch to another key first", id)
+	}
+	ks.keys = append(ks.keys[:i:i], ks.keys[i+1:]...)
+	return nil
+}
+
+// Sign generates a token for the file id, valid for 10 seconds, which only authorizes that file.
+func (ks *KeySet) Sign(fileId string) EncodedJwt {
+	return ks.Encode(&jwt.StandardClaims{
+		ExpiresAt: time.Now().Add(time.Second * 10).Unix(),
+		Subject:   fileId,
+	})
+}
+
+// SignCluster generates a token of a cluster member, valid for 10 seconds.
+func (ks *KeySet) SignCluster() EncodedJwt {
+	return ks.Encode(&jwt.StandardClaims{
+		Audience:  ClusterAudience,
+		ExpiresAt: time.Now().Add(time.Second * 10).Unix(),
+	})
+}
+
+// Encode signs the claims with the signing key. It returns "" without keys.
+func (ks *KeySet) Encode(claims jwt.Claims) EncodedJwt {
+	ks.RLock()
+	i := ks.find(ks.signingId)
+	var key SigningKey
+	if i >= 0 {
+		key = ks.keys[i]
+	}
+	ks.RUnlock()
+	if i < 0 {
+		return ""
+	}
+	t := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	t.Header["kid"] = key.Id
+	encoded, err := t.SignedString([]byte(key.Secret))
+	if err != nil {
+		return ""
+	}
+	return EncodedJwt(encoded)
+}
+
+// Decode verifies the token with the key named by its "kid",
+// or with each active key if it has no "kid", as signed by GenJwt.
+func (ks *KeySet) Decode(tokenString EncodedJwt, claims jwt.Claims) (token *jwt.Token, err error) {
+	keys, _ := ks.Keys()
+	if len(keys) == 0 {
+		return nil, errors.New("no key to verify the token")
+	}
+	for _, key := range keys {
+		secret := []byte(key.Secret)
+		token, err = jwt.ParseWithClaims(string(tokenString), claims, func(t *jwt.Token) (interface{}, error) {
+			if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+				return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
+			}
+			if kid, ok := t.Header["kid"].(string); ok && kid != key.Id {
+				return nil, errKeyIdMismatch
+			}
+			return secret, nil
+		})
+		if err == nil {
+			return token, nil
+		}
+		//kid不匹配或签名不对时尝试下一个密钥
+		if ve, ok := err.(*jwt.ValidationError); ok && (ve.Inner == errKeyIdMismatch || ve.Errors&jwt.ValidationErrorSignatureInvalid != 0) {
+			continue
+		}
+		return token, err
+	}
+	return nil, err
+}
+
+var errKeyIdMismatch = errors.New("key id mismatch")
+
+func (ks *KeySet) find(id string) int {
+	for i, k := range ks.keys {
+		if k.Id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func validateKeys(keys []SigningKey, signingId string) error {
+	found := signingId == "" && len(keys) == 0
+	for _, k := range keys {
+		if k.Id == signingId {
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("signing key %s not found", signingId)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package security
+
+import (
+	"testing"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+func TestKeyRotation(t *testing.T) {
+	ks := NewKeySet("old secret")
+	oldToken := ks.Sign("3,01637037d6")
+	if oldToken == "" {
+		t.Fatalf("no token signed")
+	}
+
+	if err := ks.Add(SigningKey{Id: "k2", Secret: "new secret"}); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if err := ks.Add(SigningKey{Id: "k2", Secret: "another secret"}); err == nil {
+		t.Fatalf("a duplicated key id should be rejected")
+	}
+	if err := ks.Use("k2"); err != nil {
+		t.Fatalf("use: %v", err)
+	}
+	newToken := ks.Sign("3,01637037d6")
+	token, err := ks.Decode(newToken, &jwt.StandardClaims{})
+	if err != nil || token.Header["kid"] != "k2" {
+		t.Fatalf("decode the new token: %v %v", token, err)
+	}
+	if _, err := ks.Decode(oldToken, &jwt.StandardClaims{}); err != nil {
+		t.Fatalf("the old key should still verify: %v", err)
+	}
+	//不带kid的令牌用所有密钥尝试
+	if _, err := ks.Decode(GenJwt("new secret", "3,01637037d6"), &jwt.StandardClaims{}); err != nil {
+		t.Fatalf("a token without kid should be verified: %v", err)
+	}
+
+	if err := ks.Retire("k2"); err == nil {
+		t.Fatalf("the signing key should not be retired")
+	}
+	if err := ks.Retire(DefaultKeyId); err != nil {
+		t.Fatalf("retire: %v", err)
+	}
+	if _, err := ks.Decode(oldToken, &jwt.StandardClaims{}); err == nil {
+		t.Fatalf("a retired key should not verify")
+	}
+	if _, err := ks.Decode(GenJwt("old secret", "3,01637037d6"), &jwt.StandardClaims{}); err == nil {
+		t.Fatalf("a token without kid signed by a retired key should not verify")
+	}
+
+	copied := NewKeySet("")
+	keys, signingId := ks.Keys()
+	if err := copied.Set(keys, "missing"); err == nil {
+		t.Fatalf("a signing key not in the keys should be rejected")
+	}
+	if err := copied.Set(keys, signingId); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	if _, err := copied.Decode(newToken, &jwt.StandardClaims{}); err != nil {
+		t.Fatalf("the copied keys should verify: %v", err)
+	}
+}
@@ -0,0 +1,112 @@
+package security
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/chrislusf/seaweedfs/weed/glog"
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+var ErrNoExpiration = errors.New("token without expiration")
+
+const (
+	// ReadAudience marks the read tokens, so that they are never taken as tokens of cluster members.
+	ReadAudience = "read"
+	// ClusterAudience marks the tokens of cluster members, which are allowed everything.
+	ClusterAudience = "cluster"
+)
+
+// ReadClaims grant reading one file, given as the subject, which is the file id on volume servers
+// and the path on filers, or all files in a collection, or all files with a prefix, which ends at a '/' or ','.
+type ReadClaims struct {
+	jwt.StandardClaims
+	Collection string `json:"collection,omitempty"`
+	Prefix     string `json:"prefix,omitempty"`
+}
+
+// NewReadClaims creates claims expiring after ttl. Only one of object, collection and prefix is needed.
+func NewReadClaims(object, collection, prefix string, ttl time.Duration) *ReadClaims {
+	return &ReadClaims{
+		StandardClaims: jwt.StandardClaims{
+			Audience:  ReadAudience,
+			ExpiresAt: time.Now().Add(ttl).Unix(),
+			Subject:   object,
+		},
+		Collection: collection,
+		Prefix:     prefix,
+	}
+}
+
+func (c *ReadClaims) Valid() error {
+	if c.ExpiresAt == 0 {
+		return ErrNoExpiration
+	}
+	return c.StandardClaims.Valid()
+}
+
+// Allows checks whether the claims cover the object in the collection.
+// The subject, the collection and the prefix all have to match if they are set.
+func (c *ReadClaims) Allows(object, collection string) bool {
+	if c.Subject == "" && c.Collection == "" && c.Prefix == "" {
+		return false
+	}
+	return (c.Subject == "" || c.Subject == object) &&
+		(c.Collection == "" || c.Collection == collection) &&
+		(c.Prefix == "" || hasPathPrefix(object, c.Prefix))
+}
+
+//前缀必须在分隔符处结束，"/home/chris"不能匹配"/home/chrisx/a.jpg"，"3"不能匹配"30,01637037d6"
+func hasPathPrefix(object, prefix string) bool {
+	if !strings.HasPrefix(object, prefix) {
+		return false
+	}
+	if len(object) == len(prefix) || strings.HasSuffix(prefix, "/") || strings.HasSuffix(prefix, ",") {
+		return true
+	}
+	next := object[len(prefix)]
+	return next == '/' || next == ','
+}
+
+// CheckRead requires a read token, or an access key allowed to read, for the object in the collection,
+// unless the request comes from the white list.
+func (g *Guard) CheckRead(r *http.Request, object, collection string) error {
+	if !g.whiteList.isEmpty() && g.checkWhiteList(nil, r) == nil {
+		return nil
+	}
+	tokenStr := GetJwt(r)
+	if tokenStr == "" {
+		return ErrUnauthorized
+	}
+	if key, err := g.AccessKeys.Decode(tokenStr); err != errNotAccessKey {
+		if err != nil {
+			glog.V(1).Infof("Access token verification error from %s: %v", r.RemoteAddr, err)
+			return ErrUnauthorized
+		}
+		//filer上的对象是以"/"开头的路径，卷服务器上的是文件id
+		path := ""
+		if strings.HasPrefix(object, "/") {
+			path = object
+		}
+		if !key.Allows(ActionRead, collection, path) {
+			glog.V(1).Infof("Access key %s from %s is not allowed to read %s", key.Id, r.RemoteAddr, object)
+			return ErrForbidden
+		}
+		return nil
+	}
+	claims := &ReadClaims{}
+	if _, err := g.Keys.Decode(tokenStr, claims); err != nil {
+		glog.V(1).Infof("Read token verification error from %s: %v", r.RemoteAddr, err)
+		return ErrUnauthorized
+	}
+	if claims.Audience == ClusterAudience {
+		return nil
+	}
+	if !claims.Allows(object, collection) {
+		glog.V(1).Infof("Read token from %s does not allow %s", r.RemoteAddr, object)
+		return ErrUnauthorized
+	}
+	return nil
+}
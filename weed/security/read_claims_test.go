@@ -0,0 +1,64 @@
+package security
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+func TestCheckRead(t *testing.T) {
+	g, err := NewGuard(nil, "secret", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := func(token EncodedJwt, object, collection string) error {
+		r := httptest.NewRequest("GET", "/"+object+"?jwt="+string(token), nil)
+		return g.CheckRead(r, object, collection)
+	}
+
+	if err := check("", "3,01637037d6", ""); err != ErrUnauthorized {
+		t.Fatalf("a read without a token should be rejected: %v", err)
+	}
+	fileToken := g.Keys.Encode(NewReadClaims("3,01637037d6", "", "", time.Minute))
+	if err := check(fileToken, "3,01637037d6", ""); err != nil {
+		t.Fatalf("read the presigned file: %v", err)
+	}
+	if err := check(fileToken, "3,01637037d7", ""); err == nil {
+		t.Fatalf("a file token should not read another file")
+	}
+	collectionToken := g.Keys.Encode(NewReadClaims("", "pictures", "", time.Minute))
+	if check(collectionToken, "3,01637037d6", "pictures") != nil || check(collectionToken, "3,01637037d6", "logs") == nil {
+		t.Fatalf("a collection token should only read files in the collection")
+	}
+	prefixToken := g.Keys.Encode(NewReadClaims("", "", "/home/chris/", time.Minute))
+	if check(prefixToken, "/home/chris/a.jpg", "") != nil || check(prefixToken, "/home/other/a.jpg", "") == nil {
+		t.Fatalf("a prefix token should only read files with the prefix")
+	}
+	//前缀只在分隔符处匹配
+	if check(g.Keys.Encode(NewReadClaims("", "", "/home/chris", time.Minute)), "/home/chrisx/a.jpg", "") == nil {
+		t.Fatalf("a prefix token should not read a sibling sharing the prefix")
+	}
+	volumeToken := g.Keys.Encode(NewReadClaims("", "pictures", "3", time.Minute))
+	if check(volumeToken, "3,01637037d6", "pictures") != nil || check(volumeToken, "30,01637037d6", "pictures") == nil {
+		t.Fatalf("a file id prefix should end at the comma")
+	}
+	if check(volumeToken, "3,01637037d6", "logs") == nil {
+		t.Fatalf("a prefix token with a collection should only read files in the collection")
+	}
+
+	expiredToken := g.Keys.Encode(NewReadClaims("3,01637037d6", "", "", -time.Minute))
+	if err := check(expiredToken, "3,01637037d6", ""); err == nil {
+		t.Fatalf("an expired token should be rejected")
+	}
+	noExpirationToken := g.Keys.Encode(&ReadClaims{StandardClaims: jwt.StandardClaims{Subject: "3,01637037d6"}})
+	if err := check(noExpirationToken, "3,01637037d6", ""); err == nil {
+		t.Fatalf("a token without expiration should be rejected")
+	}
+
+	whiteListed, _ := NewGuard([]string{"192.0.2.1"}, "secret", nil)
+	if err := whiteListed.CheckRead(httptest.NewRequest("GET", "/3,01637037d6", nil), "3,01637037d6", ""); err != nil {
+		t.Fatalf("a read from the white list should be allowed: %v", err)
+	}
+}
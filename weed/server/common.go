@@ -73,6 +73,18 @@ func writeJsonError(w http.ResponseWriter, r *http.Request, httpStatus int, err
 	writeJsonQuiet(w, r, httpStatus, m)
 }
 
+//访问密钥没有权限时返回403，其他认证失败返回401
+func authErrorStatus(err error) int {
+	if err == security.ErrForbidden {
+		return http.StatusForbidden
+	}
+	return http.StatusUnauthorized
+}
+
+func writeAuthError(w http.ResponseWriter, r *http.Request, err error) {
+	writeJsonError(w, r, authErrorStatus(err), err)
+}
+
 func debug(params ...interface{}) {
 	glog.V(4).Infoln(params)
 }
@@ -86,7 +98,7 @@ func submitForClientHandler(w http.ResponseWriter, r *http.Request, masterUrl st
 	}
 
 	debug("parsing upload file...")
-	fname, data, mimeType, isGzipped, lastModified, _, _, pe := storage.ParseUpload(r)
+	fname, data, mimeType, pairMap, _, isGzipped, lastModified, _, _, pe := storage.ParseUpload(r, storage.DigestNone)
 	if pe != nil {
 		writeJsonError(w, r, http.StatusBadRequest, pe)
 		return
@@ -99,6 +111,7 @@ func submitForClientHandler(w http.ResponseWriter, r *http.Request, masterUrl st
 		Replication: r.FormValue("replication"),
 		Collection:  r.FormValue("collection"),
 		Ttl:         r.FormValue("ttl"),
+		Jwt:         jwt,
 	}
 	assignResult, ae := operation.Assign(masterUrl, ar)
 	if ae != nil {
@@ -106,13 +119,13 @@ func submitForClientHandler(w http.ResponseWriter, r *http.Request, masterUrl st
 		return
 	}
 
-	url := "http://" + assignResult.Url + "/" + assignResult.Fid
+	url := util.SchemePrefix + assignResult.Url + "/" + assignResult.Fid
 	if lastModified != 0 {
 		url = url + "?ts=" + strconv.FormatUint(lastModified, 10)
 	}
 
 	debug("upload file to store", url)
-	uploadResult, err := operation.Upload(url, fname, bytes.NewReader(data), isGzipped, mimeType, jwt)
+	uploadResult, err := operation.Upload(url, fname, bytes.NewReader(data), isGzipped, mimeType, pairMap, jwt)
 	if err != nil {
 		writeJsonError(w, r, http.StatusInternalServerError, err)
 		return
@@ -129,7 +142,7 @@ func submitForClientHandler(w http.ResponseWriter, r *http.Request, masterUrl st
 func deleteForClientHandler(w http.ResponseWriter, r *http.Request, masterUrl string) {
 	r.ParseForm()
 	fids := r.Form["fid"]
-	ret, err := operation.DeleteFiles(masterUrl, fids)
+	ret, err := operation.DeleteFiles(masterUrl, fids, security.GetJwt(r))
 	if err != nil {
 		writeJsonError(w, r, http.StatusInternalServerError, err)
 		return
@@ -183,3 +196,29 @@ func statsMemoryHandler(w http.ResponseWriter, r *http.Request) {
 	m["Memory"] = stats.MemStat()
 	writeJsonQuiet(w, r, http.StatusOK, m)
 }
+
+// presign signs a read token for the object, the collection or the prefix,
+// expiring after the "ttl" form value, e.g., 15m, or an hour by default.
+// The caller should be authorized to read them.
+func presign(guard *security.Guard, r *http.Request, object, collection, prefix string) (map[string]interface{}, error) {
+	//不能识别调用者时，任何人都能生成读取令牌
+	if !guard.Restricted() {
+		return nil, errors.New("presigning needs a white list or access keys")
+	}
+	ttl := time.Hour
+	if r.FormValue("ttl") != "" {
+		var err error
+		if ttl, err = time.ParseDuration(r.FormValue("ttl")); err != nil || ttl <= 0 {
+			return nil, fmt.Errorf("invalid ttl %s", r.FormValue("ttl"))
+		}
+	}
+	if object == "" && collection == "" && prefix == "" {
+		return nil, errors.New("nothing to presign")
+	}
+	claims := security.NewReadClaims(object, collection, prefix, ttl)
+	jwt := guard.Keys.Encode(claims)
+	if jwt == "" {
+		return nil, errors.New("no key to sign, please set -secure.secret")
+	}
+	return map[string]interface{}{"jwt": jwt, "expires": claims.ExpiresAt}, nil
+}
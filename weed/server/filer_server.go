@@ -1,6 +1,8 @@
 package weed_server
 
 import (
+	"encoding/json"
+	"fmt"
 	"math/rand"
 	"net/http"
 	"strconv"
@@ -14,6 +16,7 @@ import (
 	"github.com/chrislusf/seaweedfs/weed/filer/redis_store"
 	"github.com/chrislusf/seaweedfs/weed/glog"
 	"github.com/chrislusf/seaweedfs/weed/security"
+	"github.com/chrislusf/seaweedfs/weed/stats"
 	"github.com/chrislusf/seaweedfs/weed/storage"
 	"github.com/chrislusf/seaweedfs/weed/util"
 )
@@ -27,15 +30,56 @@ type FilerServer struct {
 	redirectOnRead     bool
 	disableDirListing  bool
 	secret             security.Secret
+	guard              *security.Guard
+	readJwt            bool //读取也需要JWT
 	filer              filer.Filer
 	maxMB		   int
 	masterNodes        *storage.MasterNodes
+	metrics            *stats.Registry
+	httpMetrics        *stats.HTTPMetrics
+	accessKeysWarning  sync.Once
+	pathLocks          pathLocks
+}
+
+//按路径加锁，使同一路径的条件检查和写入依次进行
+type pathLocks struct {
+	sync.Mutex
+	locks map[string]*pathLock
+}
+
+type pathLock struct {
+	sync.Mutex
+	waiters int
+}
+
+// lock locks the path, and returns the function to unlock it.
+func (pl *pathLocks) lock(path string) (unlock func()) {
+	pl.Lock()
+	if pl.locks == nil {
+		pl.locks = make(map[string]*pathLock)
+	}
+	l, ok := pl.locks[path]
+	if !ok {
+		l = &pathLock{}
+		pl.locks[path] = l
+	}
+	l.waiters++
+	pl.Unlock()
+	l.Lock()
+	return func() {
+		l.Unlock()
+		pl.Lock()
+		if l.waiters--; l.waiters == 0 {
+			delete(pl.locks, path)
+		}
+		pl.Unlock()
+	}
 }
 
 func NewFilerServer(r *http.ServeMux, ip string, port int, master string, dir string, collection string,
 	replication string, redirectOnRead bool, disableDirListing bool,
 	maxMB int,
-	secret string,
+	secret string, whiteList []string, trustedProxies []string, readJwt bool,
 	cassandra_server string, cassandra_keyspace string,
 	redis_server string, redis_password string, redis_database int,
 ) (fs *FilerServer, err error) {
@@ -47,6 +91,11 @@ func NewFilerServer(r *http.ServeMux, ip string, port int, master string, dir st
 		disableDirListing:  disableDirListing,
 		maxMB:		    maxMB,
 		port:               ip + ":" + strconv.Itoa(port),
+		secret:             security.Secret(secret),
+		readJwt:            readJwt,
+	}
+	if fs.guard, err = security.NewGuard(whiteList, secret, trustedProxies); err != nil {
+		return nil, err
 	}
 
 	if cassandra_server != "" {
@@ -59,15 +108,21 @@ func NewFilerServer(r *http.ServeMux, ip string, port int, master string, dir st
 		redis_store := redis_store.NewRedisStore(redis_server, redis_password, redis_database)
 		fs.filer = flat_namespace.NewFlatNamespaceFiler(master, redis_store)
 	} else {
-		if fs.filer, err = embedded_filer.NewFilerEmbedded(master, dir); err != nil {
+		embeddedFiler, err := embedded_filer.NewFilerEmbedded(master, dir)
+		if err != nil {
 			glog.Fatalf("Can not start filer in dir %s : %v", dir, err)
-			return
+			return nil, err
 		}
+		embeddedFiler.SetSecret(fs.secret)
+		fs.filer = embeddedFiler
 
-		r.HandleFunc("/admin/mv", fs.moveHandler)
-		r.HandleFunc("/admin/register", fs.registerHandler)
+		r.HandleFunc("/admin/mv", fs.guard.Audited("move", security.AuditForm("from", "to"), fs.moveHandler))
+		r.HandleFunc("/admin/register", fs.guard.Audited("register", security.AuditForm("path", "fileId"), fs.registerHandler))
 	}
 
+	fs.registerMetrics()
+	r.HandleFunc("/admin/presign", fs.guard.Authorized(fs.presignHandler))
+	r.HandleFunc("/metrics", fs.guard.WhiteList(fs.metrics.ServeHTTP))
 	r.HandleFunc("/", fs.filerHandler)
 
 	go func() {
@@ -98,6 +153,9 @@ func NewFilerServer(r *http.ServeMux, ip string, port int, master string, dir st
 					}
 					glog.V(0).Infoln("Filer Server Connected with master at", master)
 				}
+				if err = fs.syncAccessKeys(master); err != nil {
+					glog.V(1).Infof("Filer Server Failed to sync access keys from master %s: %v", master, err)
+				}
 			} else {
 				glog.V(1).Infof("Filer Server Failed to talk with master %s: %v", fs.getMasterNode(), err)
 				if connected {
@@ -115,10 +173,63 @@ func NewFilerServer(r *http.ServeMux, ip string, port int, master string, dir st
 	return fs, nil
 }
 
+//只能用于这个文件的令牌
 func (fs *FilerServer) jwt(fileId string) security.EncodedJwt {
 	return security.GenJwt(fs.secret, fileId)
 }
 
+//集群成员的令牌，用于分配文件id和同步访问密钥
+func (fs *FilerServer) clusterJwt() security.EncodedJwt {
+	return security.GenClusterJwt(fs.secret)
+}
+
+//访问密钥只通过TLS从master获取
+func (fs *FilerServer) syncAccessKeys(master string) error {
+	if !util.TLSEnabled() {
+		return fs.checkAccessKeysWithoutTLS(master)
+	}
+	var keys []security.AccessKey
+	if err := fs.getFromMaster(master, "/access/keys/sync", &keys); err != nil {
+		return err
+	}
+	return fs.guard.AccessKeys.Set(keys)
+}
+
+//没有TLS时无法获得访问密钥，filer会放行所有请求，master上有访问密钥时报错
+func (fs *FilerServer) checkAccessKeysWithoutTLS(master string) error {
+	var ret struct {
+		Keys []security.AccessKey
+	}
+	if err := fs.getFromMaster(master, "/access/keys", &ret); err != nil {
+		return err
+	}
+	if len(ret.Keys) > 0 {
+		fs.accessKeysWarning.Do(func() {
+			glog.Errorf("Master %s has %d access keys, which are only synced to the filer over TLS. Without TLS the filer does not check them.", master, len(ret.Keys))
+		})
+	}
+	return nil
+}
+
+func (fs *FilerServer) getFromMaster(master, path string, ret interface{}) error {
+	req, err := http.NewRequest("GET", util.SchemePrefix+master+path, nil)
+	if err != nil {
+		return err
+	}
+	if jwt := fs.clusterJwt(); jwt != "" {
+		req.Header.Set("Authorization", "BEARER "+string(jwt))
+	}
+	resp, err := util.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", req.URL, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(ret)
+}
+
 func (fs *FilerServer) getMasterNode() string {
 	fs.mnLock.RLock()
 	defer fs.mnLock.RUnlock()
@@ -151,7 +262,7 @@ func (fs *FilerServer) detectHealthyMaster(masterNode string) (master string, e
 }
 
 func checkMaster(masterNode string) error {
-	statUrl := "http://" + masterNode + "/stats"
+	statUrl := util.SchemePrefix + masterNode + "/stats"
 	glog.V(4).Infof("Connecting to %s ...", statUrl)
 	_, e := util.Get(statUrl)
 	return e
@@ -2,6 +2,8 @@ package weed_server
 
 import (
 	"net/http"
+
+	"github.com/chrislusf/seaweedfs/weed/security"
 )
 
 func (fs *FilerServer) filerHandler(w http.ResponseWriter, r *http.Request) {
@@ -11,10 +13,10 @@ func (fs *FilerServer) filerHandler(w http.ResponseWriter, r *http.Request) {
 	case "HEAD":
 		fs.GetOrHeadHandler(w, r, false)
 	case "DELETE":
-		fs.DeleteHandler(w, r)
+		fs.guard.Audited("delete", security.AuditPath, fs.DeleteHandler)(w, r)
 	case "PUT":
-		fs.PostHandler(w, r)
+		fs.guard.Audited("write", security.AuditPath, fs.PostHandler)(w, r)
 	case "POST":
-		fs.PostHandler(w, r)
+		fs.guard.Audited("write", security.AuditPath, fs.PostHandler)(w, r)
 	}
 }
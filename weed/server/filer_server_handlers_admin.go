@@ -4,6 +4,8 @@ import (
 	"net/http"
 
 	"github.com/chrislusf/seaweedfs/weed/glog"
+	"github.com/chrislusf/seaweedfs/weed/security"
+	"github.com/chrislusf/seaweedfs/weed/util"
 )
 
 /*
@@ -19,6 +21,24 @@ Wildcard is not supported.
 func (fs *FilerServer) moveHandler(w http.ResponseWriter, r *http.Request) {
 	from := r.FormValue("from")
 	to := r.FormValue("to")
+	//移走相当于删除原路径
+	if err := fs.guard.Authorize(r, security.ActionDelete, "", from); err != nil {
+		writeAuthError(w, r, err)
+		return
+	}
+	if err := fs.guard.Authorize(r, security.ActionWrite, "", to); err != nil {
+		writeAuthError(w, r, err)
+		return
+	}
+	//按顺序给两个路径加锁，避免死锁
+	first, second := from, to
+	if second < first {
+		first, second = second, first
+	}
+	defer fs.pathLocks.lock(first)()
+	if second != first {
+		defer fs.pathLocks.lock(second)()
+	}
 	err := fs.filer.Move(from, to)
 	if err != nil {
 		glog.V(4).Infoln("moving", from, "->", to, err.Error())
@@ -31,6 +51,11 @@ func (fs *FilerServer) moveHandler(w http.ResponseWriter, r *http.Request) {
 func (fs *FilerServer) registerHandler(w http.ResponseWriter, r *http.Request) {
 	path := r.FormValue("path")
 	fileId := r.FormValue("fileId")
+	if err := fs.guard.Authorize(r, security.ActionWrite, "", path); err != nil {
+		writeAuthError(w, r, err)
+		return
+	}
+	defer fs.pathLocks.lock(path)()
 	err := fs.filer.CreateFile(path, fileId)
 	if err != nil {
 		glog.V(4).Infof("register %s to %s error: %v", fileId, path, err)
@@ -39,3 +64,27 @@ func (fs *FilerServer) registerHandler(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 	}
 }
+
+//生成带过期时间的文件下载链接，也可以用prefix生成一个目录的读取令牌
+func (fs *FilerServer) presignHandler(w http.ResponseWriter, r *http.Request) {
+	path, prefix := r.FormValue("path"), r.FormValue("prefix")
+	//路径和前缀都要有读取权限
+	for _, p := range []string{path, prefix} {
+		if p == "" {
+			continue
+		}
+		if err := fs.guard.Authorize(r, security.ActionRead, "", p); err != nil {
+			writeAuthError(w, r, err)
+			return
+		}
+	}
+	ret, err := presign(fs.guard, r, path, "", prefix)
+	if err != nil {
+		writeJsonError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if path != "" {
+		ret["url"] = util.SchemePrefix + r.Host + path + "?jwt=" + string(ret["jwt"].(security.EncodedJwt))
+	}
+	writeJsonQuiet(w, r, http.StatusOK, ret)
+}
@@ -77,6 +77,12 @@ func (fs *FilerServer) listDirectoryHandler(w http.ResponseWriter, r *http.Reque
 }
 
 func (fs *FilerServer) GetOrHeadHandler(w http.ResponseWriter, r *http.Request, isGetMethod bool) {
+	if fs.readJwt {
+		if err := fs.guard.CheckRead(r, r.URL.Path, ""); err != nil {
+			w.WriteHeader(authErrorStatus(err))
+			return
+		}
+	}
 	if strings.HasSuffix(r.URL.Path, "/") {
 		if fs.disableDirListing {
 			w.WriteHeader(http.StatusMethodNotAllowed)
@@ -100,17 +106,28 @@ func (fs *FilerServer) GetOrHeadHandler(w http.ResponseWriter, r *http.Request,
 		return
 	}
 	urlString := urlLocation
+	//访问文件路径的令牌换成访问文件id的令牌
+	jwt := fs.jwt(fileId)
 	if fs.redirectOnRead {
+		if jwt != "" {
+			urlString += "?jwt=" + string(jwt)
+		}
 		http.Redirect(w, r, urlString, http.StatusFound)
 		return
 	}
 	u, _ := url.Parse(urlString)
 	q := u.Query()
 	for key, values := range r.URL.Query() {
+		if key == "jwt" {
+			continue
+		}
 		for _, value := range values {
 			q.Add(key, value)
 		}
 	}
+	if jwt != "" {
+		q.Set("jwt", string(jwt))
+	}
 	u.RawQuery = q.Encode()
 	request := &http.Request{
 		Method:        r.Method,
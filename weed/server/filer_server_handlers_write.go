@@ -17,6 +17,7 @@ import (
 
 	"github.com/chrislusf/seaweedfs/weed/glog"
 	"github.com/chrislusf/seaweedfs/weed/operation"
+	"github.com/chrislusf/seaweedfs/weed/security"
 	"github.com/chrislusf/seaweedfs/weed/storage"
 	"github.com/chrislusf/seaweedfs/weed/util"
 	"github.com/syndtr/goleveldb/leveldb"
@@ -94,6 +95,7 @@ func (fs *FilerServer) assignNewFileInfo(w http.ResponseWriter, r *http.Request,
 		Replication: replication,
 		Collection:  collection,
 		Ttl:         r.URL.Query().Get("ttl"),
+		Jwt:         fs.clusterJwt(),
 	}
 	assignResult, ae := operation.Assign(fs.getMasterNode(), ar)
 	if ae != nil {
@@ -103,7 +105,7 @@ func (fs *FilerServer) assignNewFileInfo(w http.ResponseWriter, r *http.Request,
 		return
 	}
 	fileId = assignResult.Fid
-	urlLocation = "http://" + assignResult.Url + "/" + assignResult.Fid
+	urlLocation = util.SchemePrefix + assignResult.Url + "/" + assignResult.Fid
 	return
 }
 
@@ -112,7 +114,7 @@ func (fs *FilerServer) multipartUploadAnalyzer(w http.ResponseWriter, r *http.Re
 	if r.Method == "PUT" {
 		buf, _ := ioutil.ReadAll(r.Body)
 		r.Body = ioutil.NopCloser(bytes.NewBuffer(buf))
-		fileName, _, _, _, _, _, _, pe := storage.ParseUpload(r)
+		fileName, _, _, _, _, _, _, _, _, pe := storage.ParseUpload(r, storage.DigestNone)
 		if pe != nil {
 			glog.V(0).Infoln("failing to parse post body", pe.Error())
 			writeJsonError(w, r, http.StatusInternalServerError, pe)
@@ -218,7 +220,58 @@ func (fs *FilerServer) monolithicUploadAnalyzer(w http.ResponseWriter, r *http.R
 	return
 }
 
+//检查If-Match和If-None-Match条件，文件的ETag是对应needle的ETag，不存在的文件ETag为空
+//调用者需要持有路径锁直到写入完成，这样检查和写入才是原子的
+//目录的POST在上传后才知道文件名，此时path以"/"结尾，不做检查
+func (fs *FilerServer) checkPrecondition(w http.ResponseWriter, r *http.Request, path string) bool {
+	condition := storage.NewNeedleCondition(r.Header)
+	if condition == nil {
+		return true
+	}
+	if strings.HasSuffix(path, "/") {
+		return true
+	}
+	etag := ""
+	fileId, err := fs.filer.FindFile(path)
+	if err != nil && err != leveldb.ErrNotFound {
+		glog.V(0).Infoln("failing to find path in filer store", path, err.Error())
+		writeJsonError(w, r, http.StatusInternalServerError, err)
+		return false
+	}
+	if err == nil && fileId != "" {
+		fileUrl, err := operation.LookupFileId(fs.getMasterNode(), fileId)
+		if err == nil {
+			var header http.Header
+			if header, err = util.Head(fileUrl); err == nil {
+				etag = header.Get("Etag")
+			}
+		}
+		if err != nil {
+			glog.V(0).Infoln("failing to read etag of", path, err.Error())
+			writeJsonError(w, r, http.StatusInternalServerError, err)
+			return false
+		}
+	}
+	if !condition.Matches(etag) {
+		writeJsonError(w, r, http.StatusPreconditionFailed, storage.ErrPreconditionFailed)
+		return false
+	}
+	return true
+}
+
 func (fs *FilerServer) PostHandler(w http.ResponseWriter, r *http.Request) {
+	if err := fs.guard.Authorize(r, security.ActionWrite, "", r.URL.Path); err != nil {
+		writeAuthError(w, r, err)
+		return
+	}
+
+	//同一路径的写入依次进行，目录的POST在知道文件名之后再加锁
+	if !strings.HasSuffix(r.URL.Path, "/") {
+		defer fs.pathLocks.lock(r.URL.Path)()
+	}
+	if !fs.checkPrecondition(w, r, r.URL.Path) {
+		return
+	}
 
 	query := r.URL.Query()
 	replication := query.Get("replication")
@@ -261,13 +314,20 @@ func (fs *FilerServer) PostHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	glog.V(4).Infoln("post to", u)
 
+	//条件已经在路径锁内检查过，新分配的fid上还没有needle，不能再让卷服务器检查
+	header := make(http.Header)
+	for k, v := range r.Header {
+		header[k] = v
+	}
+	header.Del("If-Match")
+	header.Del("If-None-Match")
 	request := &http.Request{
 		Method:        r.Method,
 		URL:           u,
 		Proto:         r.Proto,
 		ProtoMajor:    r.ProtoMajor,
 		ProtoMinor:    r.ProtoMinor,
-		Header:        r.Header,
+		Header:        header,
 		Body:          r.Body,
 		Host:          r.Host,
 		ContentLength: r.ContentLength,
@@ -293,6 +353,10 @@ func (fs *FilerServer) PostHandler(w http.ResponseWriter, r *http.Request) {
 		writeJsonError(w, r, http.StatusInternalServerError, unmarshal_err)
 		return
 	}
+	if resp.StatusCode == http.StatusPreconditionFailed {
+		writeJsonError(w, r, http.StatusPreconditionFailed, storage.ErrPreconditionFailed)
+		return
+	}
 	if ret.Error != "" {
 		glog.V(0).Infoln("failing to post to volume server", r.RequestURI, ret.Error)
 		writeJsonError(w, r, http.StatusInternalServerError, errors.New(ret.Error))
@@ -309,6 +373,11 @@ func (fs *FilerServer) PostHandler(w http.ResponseWriter, r *http.Request) {
 				errors.New("Can not to write to folder "+path+" without a file name"))
 			return
 		}
+		defer fs.pathLocks.lock(path)()
+		if !fs.checkPrecondition(w, r, path) {
+			operation.DeleteFile(fs.getMasterNode(), fileId, fs.jwt(fileId)) //clean up
+			return
+		}
 	}
 
 	// also delete the old fid unless PUT operation
@@ -517,7 +586,7 @@ func (fs *FilerServer) doUpload(urlLocation string, w http.ResponseWriter, r *ht
 	err = nil
 
 	ioReader := ioutil.NopCloser(bytes.NewBuffer(chunkBuf))
-	uploadResult, uploadError := operation.Upload(urlLocation, fileName, ioReader, false, contentType, fs.jwt(fileId))
+	uploadResult, uploadError := operation.Upload(urlLocation, fileName, ioReader, false, contentType, nil, fs.jwt(fileId))
 	if uploadResult != nil {
 		glog.V(0).Infoln("Chunk upload result. Name:", uploadResult.Name, "Fid:", fileId, "Size:", uploadResult.Size)
 	}
@@ -530,12 +599,20 @@ func (fs *FilerServer) doUpload(urlLocation string, w http.ResponseWriter, r *ht
 // curl -X DELETE http://localhost:8888/path/to
 // curl -X DELETE http://localhost:8888/path/to?recursive=true
 func (fs *FilerServer) DeleteHandler(w http.ResponseWriter, r *http.Request) {
+	if err := fs.guard.Authorize(r, security.ActionDelete, "", r.URL.Path); err != nil {
+		writeAuthError(w, r, err)
+		return
+	}
 	var err error
 	var fid string
 	if strings.HasSuffix(r.URL.Path, "/") {
 		isRecursive := r.FormValue("recursive") == "true"
 		err = fs.filer.DeleteDirectory(r.URL.Path, isRecursive)
 	} else {
+		defer fs.pathLocks.lock(r.URL.Path)()
+		if !fs.checkPrecondition(w, r, r.URL.Path) {
+			return
+		}
 		fid, err = fs.filer.DeleteFile(r.URL.Path)
 		if err == nil && fid != "" {
 			err = operation.DeleteFile(fs.getMasterNode(), fid, fs.jwt(fid))
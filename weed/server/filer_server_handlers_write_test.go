@@ -0,0 +1,178 @@
+package weed_server
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chrislusf/seaweedfs/weed/filer/embedded_filer"
+	"github.com/chrislusf/seaweedfs/weed/security"
+	"github.com/chrislusf/seaweedfs/weed/storage"
+)
+
+// fakeCluster is a master and a volume server, which checks the conditional headers like the real one
+type fakeCluster struct {
+	sync.Mutex
+	server  *httptest.Server
+	files   map[string][]byte
+	nextKey int
+}
+
+func newFakeCluster() *fakeCluster {
+	c := &fakeCluster{files: make(map[string][]byte)}
+	c.server = httptest.NewServer(http.HandlerFunc(c.serve))
+	return c
+}
+
+func (c *fakeCluster) host() string {
+	return strings.TrimPrefix(c.server.URL, "http://")
+}
+
+func (c *fakeCluster) etag(fid string) string {
+	c.Lock()
+	defer c.Unlock()
+	if data, ok := c.files[fid]; ok {
+		return fmt.Sprintf(`"%x"`, md5.Sum(data))
+	}
+	return ""
+}
+
+func (c *fakeCluster) serve(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/dir/assign":
+		c.Lock()
+		c.nextKey++
+		fid := fmt.Sprintf("9,%x01020304", c.nextKey)
+		c.Unlock()
+		json.NewEncoder(w).Encode(map[string]interface{}{"fid": fid, "url": c.host(), "publicUrl": c.host(), "count": 1})
+		return
+	case "/dir/lookup":
+		json.NewEncoder(w).Encode(map[string]interface{}{"volumeId": "9", "locations": []map[string]string{{"url": c.host(), "publicUrl": c.host()}}})
+		return
+	}
+	fid := strings.TrimPrefix(r.URL.Path, "/")
+	etag := c.etag(fid)
+	switch r.Method {
+	case "HEAD", "GET":
+		if etag == "" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Etag", etag)
+	case "POST", "PUT":
+		if !storage.NewNeedleCondition(r.Header).Matches(etag) {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			w.Write([]byte(`{"error":"precondition failed"}`))
+			return
+		}
+		data, _ := ioutil.ReadAll(r.Body)
+		//放大检查和写入之间的时间窗口
+		time.Sleep(10 * time.Millisecond)
+		c.Lock()
+		c.files[fid] = data
+		c.Unlock()
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"name":"file","size":%d}`, len(data))
+	case "DELETE":
+		c.Lock()
+		delete(c.files, fid)
+		c.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+func postToFiler(fs *FilerServer, path, content string, header map[string]string) int {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, _ := writer.CreateFormFile("file", "file")
+	part.Write([]byte(content))
+	writer.Close()
+	r := httptest.NewRequest("POST", path, &body)
+	r.Header.Set("Content-Type", writer.FormDataContentType())
+	for k, v := range header {
+		r.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	fs.PostHandler(w, r)
+	return w.Code
+}
+
+func TestFilerConditionalWrite(t *testing.T) {
+	cluster := newFakeCluster()
+	defer cluster.server.Close()
+	dir, err := ioutil.TempDir("", "filer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ef, err := embedded_filer.NewFilerEmbedded(cluster.host(), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	guard, err := security.NewGuard(nil, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := &FilerServer{master: cluster.host(), filer: ef, guard: guard}
+	etagOf := func(path string) string {
+		fid, err := ef.FindFile(path)
+		if err != nil {
+			t.Fatalf("find %s: %v", path, err)
+		}
+		return cluster.etag(fid)
+	}
+
+	if code := postToFiler(fs, "/docs/a.txt", "v1", nil); code != http.StatusCreated {
+		t.Fatalf("unexpected status %d", code)
+	}
+	etag := etagOf("/docs/a.txt")
+	//新分配的fid上没有needle，If-Match只能由filer检查
+	if code := postToFiler(fs, "/docs/a.txt", "v2", map[string]string{"If-Match": etag}); code != http.StatusCreated {
+		t.Fatalf("expected the conditional overwrite to succeed, got %d", code)
+	}
+	if code := postToFiler(fs, "/docs/a.txt", "v3", map[string]string{"If-Match": etag}); code != http.StatusPreconditionFailed {
+		t.Fatalf("expected a stale If-Match to fail, got %d", code)
+	}
+
+	if code := postToFiler(fs, "/docs/b.txt", "v1", map[string]string{"If-None-Match": "*"}); code != http.StatusCreated {
+		t.Fatalf("expected to create b.txt, got %d", code)
+	}
+	if code := postToFiler(fs, "/docs/b.txt", "v2", map[string]string{"If-None-Match": "*"}); code != http.StatusPreconditionFailed {
+		t.Fatalf("expected b.txt to exist, got %d", code)
+	}
+
+	//同一个If-Match的并发写入只能有一个成功
+	etag = etagOf("/docs/a.txt")
+	var wg sync.WaitGroup
+	codes := make(chan int, 5)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			codes <- postToFiler(fs, "/docs/a.txt", fmt.Sprintf("writer %d", i), map[string]string{"If-Match": etag})
+		}(i)
+	}
+	wg.Wait()
+	close(codes)
+	created := 0
+	for code := range codes {
+		if code == http.StatusCreated {
+			created++
+		} else if code != http.StatusPreconditionFailed {
+			t.Errorf("unexpected status %d", code)
+		}
+	}
+	if created != 1 {
+		t.Fatalf("expected exactly one concurrent conditional write to succeed, got %d", created)
+	}
+}
@@ -0,0 +1,76 @@
+package weed_server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/chrislusf/seaweedfs/weed/filer"
+	"github.com/chrislusf/seaweedfs/weed/stats"
+)
+
+func (fs *FilerServer) registerMetrics() {
+	fs.metrics = stats.NewRegistry()
+	fs.httpMetrics = stats.NewHTTPMetrics(fs.metrics, "seaweedfs_filer")
+	fs.filer = &metricsFiler{
+		Filer:   fs.filer,
+		latency: fs.metrics.NewHistogram("seaweedfs_filer_store_duration_seconds", "Latency of the filer store operations.", stats.DefaultBuckets, "operation"),
+	}
+}
+
+// Instrument records the requests served by the mux in the metrics, by the pattern of the handler.
+func (fs *FilerServer) Instrument(mux *http.ServeMux) http.Handler {
+	return fs.httpMetrics.Instrument(mux, func(r *http.Request) string {
+		_, pattern := mux.Handler(r)
+		return pattern
+	})
+}
+
+// metricsFiler times the operations of the filer store.
+type metricsFiler struct {
+	filer.Filer
+	latency *stats.Histogram
+}
+
+func (f *metricsFiler) observe(operation string, start time.Time) {
+	f.latency.Observe(time.Since(start).Seconds(), operation)
+}
+
+func (f *metricsFiler) CreateFile(fullFileName string, fid string) (err error) {
+	defer f.observe("create_file", time.Now())
+	return f.Filer.CreateFile(fullFileName, fid)
+}
+
+func (f *metricsFiler) FindFile(fullFileName string) (fid string, err error) {
+	defer f.observe("find_file", time.Now())
+	return f.Filer.FindFile(fullFileName)
+}
+
+func (f *metricsFiler) DeleteFile(fullFileName string) (fid string, err error) {
+	defer f.observe("delete_file", time.Now())
+	return f.Filer.DeleteFile(fullFileName)
+}
+
+func (f *metricsFiler) FindDirectory(dirPath string) (dirId filer.DirectoryId, err error) {
+	defer f.observe("find_directory", time.Now())
+	return f.Filer.FindDirectory(dirPath)
+}
+
+func (f *metricsFiler) ListDirectories(dirPath string) (dirs []filer.DirectoryEntry, err error) {
+	defer f.observe("list_directories", time.Now())
+	return f.Filer.ListDirectories(dirPath)
+}
+
+func (f *metricsFiler) ListFiles(dirPath string, lastFileName string, limit int) (files []filer.FileEntry, err error) {
+	defer f.observe("list_files", time.Now())
+	return f.Filer.ListFiles(dirPath, lastFileName, limit)
+}
+
+func (f *metricsFiler) DeleteDirectory(dirPath string, recursive bool) (err error) {
+	defer f.observe("delete_directory", time.Now())
+	return f.Filer.DeleteDirectory(dirPath, recursive)
+}
+
+func (f *metricsFiler) Move(fromPath string, toPath string) (err error) {
+	defer f.observe("move", time.Now())
+	return f.Filer.Move(fromPath, toPath)
+}
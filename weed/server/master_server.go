@@ -2,6 +2,7 @@ package weed_server
 
 import (
 	"fmt"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
@@ -11,6 +12,7 @@ import (
 	"github.com/chrislusf/seaweedfs/weed/glog"
 	"github.com/chrislusf/seaweedfs/weed/security"
 	"github.com/chrislusf/seaweedfs/weed/sequence"
+	"github.com/chrislusf/seaweedfs/weed/stats"
 	"github.com/chrislusf/seaweedfs/weed/topology"
 	"github.com/chrislusf/seaweedfs/weed/util"
 	"github.com/gorilla/mux"
@@ -28,15 +30,18 @@ type MasterServer struct {
 	pulseSeconds int
 	//默认的复制级别
 	defaultReplicaPlacement string
-	//垃圾回收的阈值
-	garbageThreshold string
-	guard            *security.Guard
+	//垃圾回收策略
+	vacuumPolicy *topology.VacuumPolicy
+	guard        *security.Guard
 
 	Topo   *topology.Topology
 	vg     *topology.VolumeGrowth
 	vgLock sync.Mutex
 
 	bounedLeaderChan chan int
+
+	metrics       *stats.Registry
+	leaderChanges *stats.Counter
 }
 
 //master server构造函数
@@ -45,8 +50,9 @@ func NewMasterServer(r *mux.Router, port int, metaFolder string,
 	pulseSeconds int,
 	confFile string,
 	defaultReplicaPlacement string,
-	garbageThreshold string,
+	vacuumPolicy *topology.VacuumPolicy,
 	whiteList []string,
+	trustedProxies []string,
 	secureKey string,
 ) *MasterServer {
 	ms := &MasterServer{
@@ -54,7 +60,7 @@ func NewMasterServer(r *mux.Router, port int, metaFolder string,
 		volumeSizeLimitMB:       volumeSizeLimitMB,
 		pulseSeconds:            pulseSeconds,
 		defaultReplicaPlacement: defaultReplicaPlacement,
-		garbageThreshold:        garbageThreshold,
+		vacuumPolicy:            vacuumPolicy,
 	}
 	ms.bounedLeaderChan = make(chan int, 16)
 	//发号器
@@ -67,33 +73,71 @@ func NewMasterServer(r *mux.Router, port int, metaFolder string,
 	ms.vg = topology.NewDefaultVolumeGrowth()
 	glog.V(0).Infoln("Volume Size Limit is", volumeSizeLimitMB, "MB")
 
-	ms.guard = security.NewGuard(whiteList, secureKey)
+	if ms.guard, e = security.NewGuard(whiteList, secureKey, trustedProxies); e != nil {
+		glog.Fatalf("invalid security options: %v", e)
+	}
+	//master之间通过raft同步密钥，守卫直接使用拓扑中的密钥
+	ms.Topo.JwtKeys = ms.guard.Keys
+	ms.Topo.AccessKeys = ms.guard.AccessKeys
+	if secureKey != "" && !util.TLSEnabled() {
+		glog.Warningf("JWT keys are only sent to volume servers over TLS, with a verified client certificate or from the white list. Set -secure.secret on the volume servers, or enable TLS.")
+	}
 
+	ms.registerMetrics(r)
 	r.HandleFunc("/", ms.uiStatusHandler)
 	r.HandleFunc("/ui/index.html", ms.uiStatusHandler)
-	r.HandleFunc("/dir/assign", ms.proxyToLeader(ms.guard.WhiteList(ms.dirAssignHandler)))
-	r.HandleFunc("/dir/lookup", ms.proxyToLeader(ms.guard.WhiteList(ms.dirLookupHandler)))
+	r.HandleFunc("/dir/assign", ms.proxyToLeader(ms.authorize(security.ActionWrite, ms.dirAssignHandler)))
+	r.HandleFunc("/dir/lookup", ms.proxyToLeader(ms.guard.Authorized(ms.dirLookupHandler)))
 	r.HandleFunc("/dir/join", ms.proxyToLeader(ms.guard.WhiteList(ms.dirJoinHandler)))
 	r.HandleFunc("/dir/status", ms.proxyToLeader(ms.guard.WhiteList(ms.dirStatusHandler)))
-	r.HandleFunc("/col/delete", ms.proxyToLeader(ms.guard.WhiteList(ms.collectionDeleteHandler)))
-	r.HandleFunc("/vol/lookup", ms.proxyToLeader(ms.guard.WhiteList(ms.volumeLookupHandler)))
-	r.HandleFunc("/vol/grow", ms.proxyToLeader(ms.guard.WhiteList(ms.volumeGrowHandler)))
+	r.HandleFunc("/dir/presign", ms.proxyToLeader(ms.authorize(security.ActionRead, ms.dirPresignHandler)))
+	r.HandleFunc("/col/delete", ms.proxyToLeader(ms.guard.Audited("delete_collection", security.AuditForm("collection"), ms.authorize(security.ActionAdmin, ms.collectionDeleteHandler))))
+	r.HandleFunc("/vol/lookup", ms.proxyToLeader(ms.guard.Authorized(ms.volumeLookupHandler)))
+	r.HandleFunc("/vol/grow", ms.proxyToLeader(ms.guard.Audited("grow_volume", security.AuditForm("collection", "replication", "ttl", "count"), ms.authorize(security.ActionAdmin, ms.volumeGrowHandler))))
 	r.HandleFunc("/vol/status", ms.proxyToLeader(ms.guard.WhiteList(ms.volumeStatusHandler)))
-	r.HandleFunc("/vol/vacuum", ms.proxyToLeader(ms.guard.WhiteList(ms.volumeVacuumHandler)))
-	r.HandleFunc("/submit", ms.guard.WhiteList(ms.submitFromMasterServerHandler))
-	r.HandleFunc("/delete", ms.guard.WhiteList(ms.deleteFromMasterServerHandler))
+	r.HandleFunc("/vol/vacuum", ms.proxyToLeader(ms.guard.Audited("vacuum", security.AuditForm("garbageThreshold"), ms.admin(ms.volumeVacuumHandler))))
+	r.HandleFunc("/topology/reload", ms.guard.WhiteList(ms.topologyReloadHandler))
+	r.HandleFunc("/topology/conflicts", ms.proxyToLeader(ms.guard.WhiteList(ms.topologyConflictsHandler)))
+	r.HandleFunc("/vol/vacuum/status", ms.proxyToLeader(ms.guard.WhiteList(ms.volumeVacuumStatusHandler)))
+	r.HandleFunc("/vol/mark_readonly", ms.proxyToLeader(ms.guard.Audited("mark_volume_readonly", security.AuditForm("volumeId", "collection"), ms.authorize(security.ActionAdmin, ms.volumeMarkReadonlyHandler))))
+	r.HandleFunc("/vol/mark_writable", ms.proxyToLeader(ms.guard.Audited("mark_volume_writable", security.AuditForm("volumeId", "collection"), ms.authorize(security.ActionAdmin, ms.volumeMarkWritableHandler))))
+	r.HandleFunc("/vol/check", ms.proxyToLeader(ms.admin(ms.volumeCheckHandler)))
+	r.HandleFunc("/vol/check/status", ms.proxyToLeader(ms.guard.WhiteList(ms.volumeCheckStatusHandler)))
+	r.HandleFunc("/vol/delete", ms.proxyToLeader(ms.guard.Audited("delete_volume", security.AuditForm("volumeId", "collection"), ms.authorize(security.ActionAdmin, ms.volumeDeleteHandler))))
+	r.HandleFunc("/jwt/keys", ms.proxyToLeader(ms.admin(ms.jwtKeysHandler)))
+	r.HandleFunc("/jwt/keys/add", ms.proxyToLeader(ms.guard.Audited("add_jwt_key", security.AuditForm("kid"), ms.guard.Privileged(ms.jwtKeyAddHandler))))
+	r.HandleFunc("/jwt/keys/use", ms.proxyToLeader(ms.guard.Audited("use_jwt_key", security.AuditForm("kid"), ms.guard.Privileged(ms.jwtKeyUseHandler))))
+	r.HandleFunc("/jwt/keys/retire", ms.proxyToLeader(ms.guard.Audited("retire_jwt_key", security.AuditForm("kid"), ms.guard.Privileged(ms.jwtKeyRetireHandler))))
+	r.HandleFunc("/access/keys", ms.proxyToLeader(ms.admin(ms.accessKeysHandler)))
+	r.HandleFunc("/access/keys/put", ms.proxyToLeader(ms.guard.Audited("put_access_key", security.AuditForm("id", "actions", "collections", "prefixes"), ms.admin(ms.accessKeyPutHandler))))
+	r.HandleFunc("/access/keys/delete", ms.proxyToLeader(ms.guard.Audited("delete_access_key", security.AuditForm("id"), ms.admin(ms.accessKeyDeleteHandler))))
+	r.HandleFunc("/access/keys/sync", ms.proxyToLeader(ms.admin(ms.accessKeysSyncHandler)))
+	r.HandleFunc("/submit", ms.guard.Authorized(ms.submitFromMasterServerHandler))
+	r.HandleFunc("/delete", ms.guard.Audited("delete", security.AuditForm("fid"), ms.guard.Authorized(ms.deleteFromMasterServerHandler)))
+	r.HandleFunc("/metrics", ms.guard.WhiteList(ms.metrics.ServeHTTP))
 	r.HandleFunc("/{fileId}", ms.proxyToLeader(ms.redirectHandler))
 	r.HandleFunc("/stats/counter", ms.guard.WhiteList(statsCounterHandler))
 	r.HandleFunc("/stats/memory", ms.guard.WhiteList(statsMemoryHandler))
 
-	ms.Topo.StartRefreshWritableVolumes(garbageThreshold)
+	ms.Topo.StartRefreshWritableVolumes(vacuumPolicy)
 
 	return ms
 }
 
 func (ms *MasterServer) SetRaftServer(raftServer *RaftServer) {
 	ms.Topo.RaftServer = raftServer.raftServer
+	//其他master会把请求代理到leader
+	var peerHosts []string
+	for _, peer := range raftServer.peers {
+		if host, _, err := net.SplitHostPort(peer); err == nil {
+			peerHosts = append(peerHosts, host)
+		}
+	}
+	if err := ms.guard.AddTrustedProxies(peerHosts); err != nil {
+		glog.V(0).Infof("Failed to trust the peers %v as proxies: %v", raftServer.peers, err)
+	}
 	ms.Topo.RaftServer.AddEventListener(raft.LeaderChangeEventType, func(e raft.Event) {
+		ms.leaderChanges.Inc()
 		if ms.Topo.RaftServer.Leader() != "" {
 			glog.V(0).Infoln("[", ms.Topo.RaftServer.Name(), "]", ms.Topo.RaftServer.Leader(), "becomes leader.")
 		}
@@ -107,6 +151,28 @@ func (ms *MasterServer) SetRaftServer(raftServer *RaftServer) {
 	}
 }
 
+// authorize checks the action on the collection given in the request, once access keys are added.
+func (ms *MasterServer) authorize(action security.Action, f func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+	return ms.guard.Authorized(func(w http.ResponseWriter, r *http.Request) {
+		if err := ms.guard.Authorize(r, action, r.FormValue("collection"), ""); err != nil {
+			writeAuthError(w, r, err)
+			return
+		}
+		f(w, r)
+	})
+}
+
+// admin requires an admin access key of all collections, once access keys are added.
+func (ms *MasterServer) admin(f func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+	return ms.guard.Authorized(func(w http.ResponseWriter, r *http.Request) {
+		if err := ms.guard.Authorize(r, security.ActionAdmin, "", ""); err != nil {
+			writeAuthError(w, r, err)
+			return
+		}
+		f(w, r)
+	})
+}
+
 func (ms *MasterServer) proxyToLeader(f func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if ms.Topo.IsLeader() {
@@ -114,19 +180,21 @@ func (ms *MasterServer) proxyToLeader(f func(w http.ResponseWriter, r *http.Requ
 		} else if ms.Topo.RaftServer != nil && ms.Topo.RaftServer.Leader() != "" {
 			ms.bounedLeaderChan <- 1
 			defer func() { <-ms.bounedLeaderChan }()
-			targetUrl, err := url.Parse("http://" + ms.Topo.RaftServer.Leader())
+			targetUrl, err := url.Parse(util.SchemePrefix + ms.Topo.RaftServer.Leader())
 			if err != nil {
 				writeJsonError(w, r, http.StatusInternalServerError,
-					fmt.Errorf("Leader URL http://%s Parse Error: %v", ms.Topo.RaftServer.Leader(), err))
+					fmt.Errorf("Leader URL %s Parse Error: %v", ms.Topo.RaftServer.Leader(), err))
 				return
 			}
 			glog.V(4).Infoln("proxying to leader", ms.Topo.RaftServer.Leader())
 			proxy := httputil.NewSingleHostReverseProxy(targetUrl)
 			director := proxy.Director
 			proxy.Director = func(req *http.Request) {
-				actualHost, err := security.GetActualRemoteHost(req)
-				if err == nil {
-					req.Header.Set("HTTP_X_FORWARDED_FOR", actualHost)
+				//leader信任其他master转发的客户端地址，ReverseProxy随后还会追加本机看到的地址
+				if clientIP := ms.guard.ClientIP(req); clientIP != nil {
+					req.Header.Set("X-Forwarded-For", clientIP.String())
+				} else {
+					req.Header.Set("X-Forwarded-For", "unknown")
 				}
 				director(req)
 			}
@@ -138,3 +206,17 @@ func (ms *MasterServer) proxyToLeader(f func(w http.ResponseWriter, r *http.Requ
 		}
 	}
 }
+
+// ReloadConfiguration reloads the rack map of this master, and reports nodes conflicting with it.
+func (ms *MasterServer) ReloadConfiguration() ([]topology.ConfigurationConflict, error) {
+	conflicts, err := ms.Topo.ReloadConfiguration()
+	if err != nil {
+		glog.V(0).Infoln("Failed to reload topology configuration:", err)
+		return nil, err
+	}
+	for _, c := range conflicts {
+		glog.V(0).Infof("Data node %s reports %s/%s but is mapped to %s/%s",
+			c.Url, c.ReportedDataCenter, c.ReportedRack, c.DataCenter, c.Rack)
+	}
+	return conflicts, nil
+}
@@ -1,14 +1,17 @@
 package weed_server
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
 
+	"github.com/chrislusf/seaweedfs/weed/glog"
 	"github.com/chrislusf/seaweedfs/weed/operation"
 	"github.com/chrislusf/seaweedfs/weed/stats"
 	"github.com/chrislusf/seaweedfs/weed/storage"
+	"github.com/chrislusf/seaweedfs/weed/topology"
 )
 
 func (ms *MasterServer) lookupVolumeId(vids []string, collection string) (volumeLocations map[string]operation.LookupResult) {
@@ -80,25 +83,43 @@ func (ms *MasterServer) dirAssignHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	options := []*topology.VolumeGrowOption{option}
+	if option.Preferred {
+		options = option.RelaxedOptions()
+	}
+	var status int
+	for i, o := range options {
+		var fid string
+		var count uint64
+		var dn *topology.DataNode
+		if fid, count, dn, status, err = ms.assignFileId(requestedCount, o); err == nil {
+			writeJsonQuiet(w, r, http.StatusOK, operation.AssignResult{Fid: fid, Url: dn.Url(), PublicUrl: dn.PublicUrl, Count: count,
+				PreferenceHonored: i == 0})
+			return
+		}
+		if i < len(options)-1 {
+			glog.V(1).Infof("Fall back from preferred location %s: %v", o, err)
+		}
+	}
+	writeJsonQuiet(w, r, status, operation.AssignResult{Error: err.Error()})
+}
+
+//在满足option的卷上分配文件id，没有可写卷时先增长卷
+func (ms *MasterServer) assignFileId(requestedCount uint64, option *topology.VolumeGrowOption) (fid string, count uint64, dn *topology.DataNode, status int, err error) {
 	if !ms.Topo.HasWritableVolume(option) {
 		if ms.Topo.FreeSpace() <= 0 {
-			writeJsonQuiet(w, r, http.StatusNotFound, operation.AssignResult{Error: "No free volumes left!"})
-			return
+			return "", 0, nil, http.StatusNotFound, errors.New("No free volumes left!")
 		}
 		ms.vgLock.Lock()
 		defer ms.vgLock.Unlock()
 		if !ms.Topo.HasWritableVolume(option) {
 			if _, err = ms.vg.AutomaticGrowByType(option, ms.Topo); err != nil {
-				writeJsonError(w, r, http.StatusInternalServerError,
-					fmt.Errorf("Cannot grow volume group! %v", err))
-				return
+				return "", 0, nil, http.StatusInternalServerError, fmt.Errorf("Cannot grow volume group! %v", err)
 			}
 		}
 	}
-	fid, count, dn, err := ms.Topo.PickForWrite(requestedCount, option)
-	if err == nil {
-		writeJsonQuiet(w, r, http.StatusOK, operation.AssignResult{Fid: fid, Url: dn.Url(), PublicUrl: dn.PublicUrl, Count: count})
-	} else {
-		writeJsonQuiet(w, r, http.StatusNotAcceptable, operation.AssignResult{Error: err.Error()})
+	if fid, count, dn, err = ms.Topo.PickForWrite(requestedCount, option); err != nil {
+		return "", 0, nil, http.StatusNotAcceptable, err
 	}
+	return fid, count, dn, http.StatusOK, nil
 }
@@ -7,11 +7,13 @@ import (
 	"io/ioutil"
 	"math/rand"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 
 	"github.com/chrislusf/seaweedfs/weed/glog"
 	"github.com/chrislusf/seaweedfs/weed/operation"
+	"github.com/chrislusf/seaweedfs/weed/security"
 	"github.com/chrislusf/seaweedfs/weed/storage"
 	"github.com/chrislusf/seaweedfs/weed/topology"
 	"github.com/chrislusf/seaweedfs/weed/util"
@@ -25,7 +27,8 @@ func (ms *MasterServer) collectionDeleteHandler(w http.ResponseWriter, r *http.R
 		return
 	}
 	for _, server := range collection.ListVolumeServers() {
-		_, err := util.Get("http://" + server.Ip + ":" + strconv.Itoa(server.Port) + "/admin/delete_collection?collection=" + r.FormValue("collection"))
+		_, err := util.PostWithJwt(util.SchemePrefix+server.Ip+":"+strconv.Itoa(server.Port)+"/admin/delete_collection",
+			url.Values{"collection": {r.FormValue("collection")}}, ms.guard.Keys.SignCluster())
 		if err != nil {
 			writeJsonError(w, r, http.StatusInternalServerError, err)
 			return
@@ -59,10 +62,19 @@ func (ms *MasterServer) dirJoinHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	ms.Topo.ProcessJoinMessage(joinMessage)
-	writeJsonQuiet(w, r, http.StatusOK, operation.JoinResult{
+	ret := operation.JoinResult{
 		VolumeSizeLimit: uint64(ms.volumeSizeLimitMB) * 1024 * 1024,
-		SecretKey:       string(ms.guard.SecretKey),
-	})
+	}
+	if ms.mayReceiveSecrets(r) {
+		ret.JwtKeys, ret.JwtSigningKeyId = ms.guard.Keys.Keys()
+		ret.AccessKeys = ms.guard.AccessKeys.List()
+	}
+	writeJsonQuiet(w, r, http.StatusOK, ret)
+}
+
+//密钥不以明文发送，并且只发给校验过客户端证书或者在白名单中的集群成员
+func (ms *MasterServer) mayReceiveSecrets(r *http.Request) bool {
+	return r.TLS != nil && (len(r.TLS.VerifiedChains) > 0 || ms.guard.IsWhiteListed(r))
 }
 
 func (ms *MasterServer) dirStatusHandler(w http.ResponseWriter, r *http.Request) {
@@ -73,15 +85,43 @@ func (ms *MasterServer) dirStatusHandler(w http.ResponseWriter, r *http.Request)
 }
 
 func (ms *MasterServer) volumeVacuumHandler(w http.ResponseWriter, r *http.Request) {
-	gcThreshold := r.FormValue("garbageThreshold")
-	if gcThreshold == "" {
-		gcThreshold = ms.garbageThreshold
+	policy := ms.vacuumPolicy
+	if gcThreshold := r.FormValue("garbageThreshold"); gcThreshold != "" {
+		policy = policy.WithGarbageThreshold(gcThreshold)
+	}
+	glog.Infoln("garbageThreshold =", policy.GarbageThreshold)
+	if err := ms.Topo.Vacuum(policy, false); err != nil {
+		writeJsonError(w, r, http.StatusConflict, err)
+		return
 	}
-	glog.Infoln("garbageThreshold =", gcThreshold)
-	ms.Topo.Vacuum(gcThreshold)
 	ms.dirStatusHandler(w, r)
 }
 
+func (ms *MasterServer) volumeVacuumStatusHandler(w http.ResponseWriter, r *http.Request) {
+	m := make(map[string]interface{})
+	m["Version"] = util.VERSION
+	m["Policy"] = ms.vacuumPolicy.ToMap()
+	m["Status"] = ms.Topo.VacuumStatus()
+	writeJsonQuiet(w, r, http.StatusOK, m)
+}
+
+func (ms *MasterServer) topologyReloadHandler(w http.ResponseWriter, r *http.Request) {
+	conflicts, err := ms.ReloadConfiguration()
+	if err != nil {
+		writeJsonError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	m := make(map[string]interface{})
+	m["Conflicts"] = conflicts
+	writeJsonQuiet(w, r, http.StatusOK, m)
+}
+
+func (ms *MasterServer) topologyConflictsHandler(w http.ResponseWriter, r *http.Request) {
+	m := make(map[string]interface{})
+	m["Conflicts"] = ms.Topo.ConfigurationConflicts()
+	writeJsonQuiet(w, r, http.StatusOK, m)
+}
+
 func (ms *MasterServer) volumeGrowHandler(w http.ResponseWriter, r *http.Request) {
 	count := 0
 	option, err := ms.getVolumeGrowOption(r)
@@ -107,6 +147,67 @@ func (ms *MasterServer) volumeGrowHandler(w http.ResponseWriter, r *http.Request
 	}
 }
 
+func (ms *MasterServer) volumeMarkReadonlyHandler(w http.ResponseWriter, r *http.Request) {
+	ms.markVolumeReadOnly(w, r, true)
+}
+
+func (ms *MasterServer) volumeMarkWritableHandler(w http.ResponseWriter, r *http.Request) {
+	ms.markVolumeReadOnly(w, r, false)
+}
+
+func (ms *MasterServer) markVolumeReadOnly(w http.ResponseWriter, r *http.Request, readOnly bool) {
+	volumeId, err := storage.NewVolumeId(r.FormValue("volumeId"))
+	if err != nil {
+		writeJsonError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if err = ms.Topo.MarkVolumeReadOnly(r.FormValue("collection"), volumeId, readOnly); err != nil {
+		writeJsonError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	writeJsonQuiet(w, r, http.StatusOK, map[string]interface{}{"volumeId": volumeId, "readOnly": readOnly})
+}
+
+func (ms *MasterServer) volumeDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	volumeId, err := storage.NewVolumeId(r.FormValue("volumeId"))
+	if err != nil {
+		writeJsonError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if err = ms.Topo.DeleteVolume(r.FormValue("collection"), volumeId); err != nil {
+		writeJsonError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	writeJsonQuiet(w, r, http.StatusAccepted, map[string]interface{}{"volumeId": volumeId})
+}
+
+func (ms *MasterServer) volumeCheckHandler(w http.ResponseWriter, r *http.Request) {
+	option := &topology.VolumeCheckOption{
+		CopyMissing:     r.FormValue("copyMissing") == "true",
+		ApplyTombstones: r.FormValue("applyTombstones") == "true",
+		MaxSamples:      util.ParseInt(r.FormValue("samples"), 10),
+	}
+	if r.FormValue("volumeId") == "" {
+		writeJsonQuiet(w, r, http.StatusOK, map[string]interface{}{"Volumes": ms.Topo.CheckReplicas(option)})
+		return
+	}
+	volumeId, err := storage.NewVolumeId(r.FormValue("volumeId"))
+	if err != nil {
+		writeJsonError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	result, err := ms.Topo.CheckVolume(r.FormValue("collection"), volumeId, option)
+	if err != nil {
+		writeJsonError(w, r, http.StatusNotFound, err)
+		return
+	}
+	writeJsonQuiet(w, r, http.StatusOK, result)
+}
+
+func (ms *MasterServer) volumeCheckStatusHandler(w http.ResponseWriter, r *http.Request) {
+	writeJsonQuiet(w, r, http.StatusOK, map[string]interface{}{"Volumes": ms.Topo.LastReplicaCheck()})
+}
+
 func (ms *MasterServer) volumeStatusHandler(w http.ResponseWriter, r *http.Request) {
 	m := make(map[string]interface{})
 	m["Version"] = util.VERSION
@@ -143,6 +244,11 @@ func (ms *MasterServer) selfUrl(r *http.Request) string {
 	return "localhost:" + strconv.Itoa(ms.port)
 }
 func (ms *MasterServer) submitFromMasterServerHandler(w http.ResponseWriter, r *http.Request) {
+	//上传的collection从url参数中读取，不能解析multipart表单
+	if err := ms.guard.Authorize(r, security.ActionWrite, r.URL.Query().Get("collection"), ""); err != nil {
+		writeAuthError(w, r, err)
+		return
+	}
 	if ms.Topo.IsLeader() {
 		submitForClientHandler(w, r, ms.selfUrl(r))
 	} else {
@@ -188,6 +294,168 @@ func (ms *MasterServer) getVolumeGrowOption(r *http.Request) (*topology.VolumeGr
 		DataCenter:       r.FormValue("dataCenter"),
 		Rack:             r.FormValue("rack"),
 		DataNode:         r.FormValue("dataNode"),
+		Preferred:        r.FormValue("preferred") == "true",
 	}
 	return volumeGrowOption, nil
 }
+
+func (ms *MasterServer) jwtKeysHandler(w http.ResponseWriter, r *http.Request) {
+	ids, signingKeyId := ms.guard.Keys.KeyIds()
+	writeJsonQuiet(w, r, http.StatusOK, map[string]interface{}{"Keys": ids, "SigningKey": signingKeyId})
+}
+
+//添加密钥，未指定secret时随机生成，并在结果中返回给管理员
+func (ms *MasterServer) jwtKeyAddHandler(w http.ResponseWriter, r *http.Request) {
+	key := security.SigningKey{Id: r.FormValue("kid"), Secret: security.Secret(r.FormValue("secret"))}
+	if key.Secret == "" {
+		key.Secret = security.GenerateSecret()
+	}
+	if _, found := ms.guard.AccessKeys.Get(key.Id); found {
+		writeJsonError(w, r, http.StatusBadRequest, fmt.Errorf("key id %s is used by an access key", key.Id))
+		return
+	}
+	if err := ms.Topo.UpdateJwtKeys(func(keys *security.KeySet) error {
+		return keys.Add(key)
+	}); err != nil {
+		writeJsonError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	writeJsonQuiet(w, r, http.StatusOK, key)
+}
+
+func (ms *MasterServer) jwtKeyUseHandler(w http.ResponseWriter, r *http.Request) {
+	kid := r.FormValue("kid")
+	if err := ms.Topo.UpdateJwtKeys(func(keys *security.KeySet) error {
+		return keys.Use(kid)
+	}); err != nil {
+		writeJsonError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	ms.jwtKeysHandler(w, r)
+}
+
+func (ms *MasterServer) jwtKeyRetireHandler(w http.ResponseWriter, r *http.Request) {
+	kid := r.FormValue("kid")
+	if err := ms.Topo.UpdateJwtKeys(func(keys *security.KeySet) error {
+		return keys.Retire(kid)
+	}); err != nil {
+		writeJsonError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	ms.jwtKeysHandler(w, r)
+}
+
+//列出访问密钥，不返回secret
+func (ms *MasterServer) accessKeysHandler(w http.ResponseWriter, r *http.Request) {
+	keys := ms.guard.AccessKeys.List()
+	for i := range keys {
+		keys[i].Secret = ""
+	}
+	writeJsonQuiet(w, r, http.StatusOK, map[string]interface{}{"Keys": keys})
+}
+
+//添加或修改访问密钥，新密钥未指定secret时随机生成，并在结果中返回给管理员
+func (ms *MasterServer) accessKeyPutHandler(w http.ResponseWriter, r *http.Request) {
+	if ms.guard.Keys.IsEmpty() {
+		writeJsonError(w, r, http.StatusBadRequest, errors.New("cluster members sign their requests with JWT keys once access keys are added, add one with /jwt/keys/add first"))
+		return
+	}
+	key := security.AccessKey{
+		Id:          r.FormValue("id"),
+		Secret:      security.Secret(r.FormValue("secret")),
+		Collections: splitList(r.FormValue("collections")),
+		Prefixes:    splitList(r.FormValue("prefixes")),
+	}
+	for _, action := range splitList(r.FormValue("actions")) {
+		key.Actions = append(key.Actions, security.Action(action))
+	}
+	jwtKeyIds, _ := ms.guard.Keys.KeyIds()
+	for _, id := range jwtKeyIds {
+		if id == key.Id {
+			writeJsonError(w, r, http.StatusBadRequest, fmt.Errorf("key id %s is used by a JWT key", key.Id))
+			return
+		}
+	}
+	if err := ms.Topo.UpdateAccessKeys(func(keys *security.AccessKeys) error {
+		if key.Secret == "" {
+			if old, found := keys.Get(key.Id); found {
+				key.Secret = old.Secret
+			} else {
+				key.Secret = security.GenerateSecret()
+			}
+		}
+		return keys.Put(key)
+	}); err != nil {
+		writeJsonError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	writeJsonQuiet(w, r, http.StatusOK, key)
+}
+
+func (ms *MasterServer) accessKeyDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.FormValue("id")
+	if err := ms.Topo.UpdateAccessKeys(func(keys *security.AccessKeys) error {
+		return keys.Delete(id)
+	}); err != nil {
+		writeJsonError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	ms.accessKeysHandler(w, r)
+}
+
+//filer通过TLS同步访问密钥，和心跳一样需要校验过的客户端证书或者白名单
+func (ms *MasterServer) accessKeysSyncHandler(w http.ResponseWriter, r *http.Request) {
+	if !ms.mayReceiveSecrets(r) {
+		writeJsonError(w, r, http.StatusForbidden, errors.New("access keys are only sent over TLS, with a verified client certificate or from the white list"))
+		return
+	}
+	writeJsonQuiet(w, r, http.StatusOK, ms.guard.AccessKeys.List())
+}
+
+func splitList(s string) (list []string) {
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return
+}
+
+//生成带过期时间的文件下载链接，也可以按collection或者文件id前缀生成读取令牌
+func (ms *MasterServer) dirPresignHandler(w http.ResponseWriter, r *http.Request) {
+	fileId := r.FormValue("fileId")
+	var volumeId storage.VolumeId
+	if fileId != "" {
+		vid, keyCookie, err := operation.ParseFileId(fileId)
+		if err == nil {
+			volumeId, err = storage.NewVolumeId(vid)
+		}
+		if err != nil {
+			writeJsonError(w, r, http.StatusBadRequest, fmt.Errorf("invalid fileId %s: %v", fileId, err))
+			return
+		}
+		//去掉扩展名，和卷服务器校验的文件id一致
+		if dotIndex := strings.LastIndex(keyCookie, "."); dotIndex > 0 {
+			keyCookie = keyCookie[:dotIndex]
+		}
+		fileId = vid + "," + keyCookie
+	}
+	collection := r.FormValue("collection")
+	//权限按collection检查，文件所在的卷必须属于这个collection
+	var machines []*topology.DataNode
+	if fileId != "" {
+		if machines = ms.Topo.Lookup(collection, volumeId); len(machines) == 0 {
+			writeJsonError(w, r, http.StatusNotFound, fmt.Errorf("volume id %d not found", volumeId))
+			return
+		}
+	}
+	ret, err := presign(ms.guard, r, fileId, collection, r.FormValue("prefix"))
+	if err != nil {
+		writeJsonError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if fileId != "" {
+		ret["url"] = util.NormalizeUrl(machines[rand.Intn(len(machines))].PublicUrl) + "/" + fileId + "?jwt=" + string(ret["jwt"].(security.EncodedJwt))
+	}
+	writeJsonQuiet(w, r, http.StatusOK, ret)
+}
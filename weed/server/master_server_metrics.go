@@ -0,0 +1,66 @@
+package weed_server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/chrislusf/seaweedfs/weed/stats"
+	"github.com/gorilla/mux"
+)
+
+//所有请求按路由模板统计，避免文件id成为标签
+func (ms *MasterServer) registerMetrics(r *mux.Router) {
+	ms.metrics = stats.NewRegistry()
+	httpMetrics := stats.NewHTTPMetrics(ms.metrics, "seaweedfs_master")
+	r.Use(func(h http.Handler) http.Handler {
+		return httpMetrics.Instrument(h, routeTemplate)
+	})
+	ms.leaderChanges = ms.metrics.NewCounter("seaweedfs_master_raft_leader_changes_total", "Number of raft leader changes seen by this master.")
+	ms.metrics.Collect(ms.collectMetrics)
+}
+
+func routeTemplate(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if template, err := route.GetPathTemplate(); err == nil {
+			return template
+		}
+	}
+	return "other"
+}
+
+func (ms *MasterServer) collectMetrics(w *stats.MetricWriter) {
+	isLeader := 0.0
+	if ms.Topo.IsLeader() {
+		isLeader = 1
+	}
+	w.Gauge("seaweedfs_master_raft_is_leader", "Whether this master is the raft leader.", isLeader)
+	if ms.Topo.RaftServer != nil {
+		w.Gauge("seaweedfs_master_raft_term", "Current raft term.", float64(ms.Topo.RaftServer.Term()))
+	}
+
+	//只有leader收到心跳
+	now := time.Now().Unix()
+	w.Header("seaweedfs_master_heartbeat_age_seconds", "Seconds since the last heartbeat of the volume server.", "gauge")
+	for _, dn := range ms.Topo.DataNodes() {
+		w.Sample("seaweedfs_master_heartbeat_age_seconds", stats.Labels(
+			"node", dn.Url(),
+			"data_center", string(dn.GetDataCenter().Id()),
+			"rack", string(dn.GetRack().Id()),
+		), float64(now-dn.LastSeen))
+	}
+
+	vacuum := ms.Topo.VacuumCounters()
+	running := 0.0
+	if vacuum.IsRunning {
+		running = 1
+	}
+	w.Gauge("seaweedfs_master_vacuum_running", "Whether a vacuum is running.", running)
+	w.Counter("seaweedfs_master_vacuum_runs_total", "Number of vacuum runs.", float64(vacuum.Runs))
+	w.Header("seaweedfs_master_vacuum_volumes_total", "Number of volumes checked, compacted, and failed to compact by vacuum.", "counter")
+	w.Sample("seaweedfs_master_vacuum_volumes_total", stats.Labels("result", "checked"), float64(vacuum.Checked))
+	w.Sample("seaweedfs_master_vacuum_volumes_total", stats.Labels("result", "compacted"), float64(vacuum.Compacted))
+	w.Sample("seaweedfs_master_vacuum_volumes_total", stats.Labels("result", "failed"), float64(vacuum.Failed))
+	if !vacuum.FinishTime.IsZero() {
+		w.Gauge("seaweedfs_master_vacuum_last_finish_timestamp_seconds", "Unix time when the last vacuum finished.", float64(vacuum.FinishTime.Unix()))
+	}
+}
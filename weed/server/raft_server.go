@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"math/rand"
 	"net/http"
@@ -19,6 +20,7 @@ import (
 	"github.com/chrislusf/raft"
 	"github.com/chrislusf/seaweedfs/weed/glog"
 	"github.com/chrislusf/seaweedfs/weed/topology"
+	"github.com/chrislusf/seaweedfs/weed/util"
 	"github.com/gorilla/mux"
 )
 
@@ -45,10 +47,13 @@ func NewRaftServer(r *mux.Router, peers []string, httpAddr string, dataDir strin
 	}
 
 	raft.RegisterCommand(&topology.MaxVolumeIdCommand{})
+	raft.RegisterCommand(&topology.JwtKeysCommand{})
+	raft.RegisterCommand(&topology.AccessKeysCommand{})
 
 	var err error
 	transporter := raft.NewHTTPTransporter("/cluster", 0)
 	transporter.Transport.MaxIdleConnsPerHost = 1024
+	transporter.Transport.TLSClientConfig = util.Transport.TLSClientConfig
 	glog.V(1).Infof("Starting RaftServer with IP:%v:", httpAddr)
 
 	// Clear old cluster configurations if peers are changed
@@ -82,7 +87,7 @@ func NewRaftServer(r *mux.Router, peers []string, httpAddr string, dataDir strin
 				glog.V(0).Infoln("No existing server found. Starting as leader in the new cluster.")
 				_, err := s.raftServer.Do(&raft.DefaultJoinCommand{
 					Name:             s.raftServer.Name(),
-					ConnectionString: "http://" + s.httpAddr,
+					ConnectionString: util.SchemePrefix + s.httpAddr,
 				})
 				if err != nil {
 					glog.V(0).Infoln(err)
@@ -99,7 +104,7 @@ func NewRaftServer(r *mux.Router, peers []string, httpAddr string, dataDir strin
 
 		_, err := s.raftServer.Do(&raft.DefaultJoinCommand{
 			Name:             s.raftServer.Name(),
-			ConnectionString: "http://" + s.httpAddr,
+			ConnectionString: util.SchemePrefix + s.httpAddr,
 		})
 
 		if err != nil {
@@ -118,7 +123,7 @@ func (s *RaftServer) Peers() (members []string) {
 	peers := s.raftServer.Peers()
 
 	for _, p := range peers {
-		members = append(members, strings.TrimPrefix(p.ConnectionString, "http://"))
+		members = append(members, util.TrimScheme(p.ConnectionString))
 	}
 
 	return
@@ -137,7 +142,7 @@ func isPeersChanged(dir string, self string, peers []string) (oldPeers []string,
 	}
 
 	for _, p := range conf.Peers {
-		oldPeers = append(oldPeers, strings.TrimPrefix(p.ConnectionString, "http://"))
+		oldPeers = append(oldPeers, util.TrimScheme(p.ConnectionString))
 	}
 	oldPeers = append(oldPeers, self)
 
@@ -152,7 +157,7 @@ func isPeersChanged(dir string, self string, peers []string) (oldPeers []string,
 func (s *RaftServer) Join(peers []string) error {
 	command := &raft.DefaultJoinCommand{
 		Name:             s.raftServer.Name(),
-		ConnectionString: "http://" + s.httpAddr,
+		ConnectionString: util.SchemePrefix + s.httpAddr,
 	}
 
 	var err error
@@ -162,7 +167,7 @@ func (s *RaftServer) Join(peers []string) error {
 		if m == s.httpAddr {
 			continue
 		}
-		target := fmt.Sprintf("http://%s/cluster/join", strings.TrimSpace(m))
+		target := util.SchemePrefix + strings.TrimSpace(m) + "/cluster/join"
 		glog.V(0).Infoln("Attempting to connect to:", target)
 
 		err = postFollowingOneRedirect(target, "application/json", &b)
@@ -184,7 +189,7 @@ func (s *RaftServer) Join(peers []string) error {
 // a workaround because http POST following redirection misses request body
 func postFollowingOneRedirect(target string, contentType string, b *bytes.Buffer) error {
 	backupReader := bytes.NewReader(b.Bytes())
-	resp, err := http.Post(target, contentType, b)
+	resp, err := post(target, contentType, b)
 	if err != nil {
 		return err
 	}
@@ -199,7 +204,7 @@ func postFollowingOneRedirect(target string, contentType string, b *bytes.Buffer
 		}
 
 		glog.V(0).Infoln("Post redirected to ", urlStr)
-		resp2, err2 := http.Post(urlStr, contentType, backupReader)
+		resp2, err2 := post(urlStr, contentType, backupReader)
 		if err2 != nil {
 			return err2
 		}
@@ -215,3 +220,13 @@ func postFollowingOneRedirect(target string, contentType string, b *bytes.Buffer
 
 	return nil
 }
+
+//使用util的客户端发送，启用TLS后同样生效
+func post(target string, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest("POST", target, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	return util.Do(req)
+}
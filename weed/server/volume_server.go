@@ -1,14 +1,21 @@
 package weed_server
 
 import (
+	"context"
+	"fmt"
 	"math/rand"
 	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/chrislusf/seaweedfs/weed/glog"
 	"github.com/chrislusf/seaweedfs/weed/security"
+	"github.com/chrislusf/seaweedfs/weed/stats"
 	"github.com/chrislusf/seaweedfs/weed/storage"
+	"github.com/chrislusf/seaweedfs/weed/topology"
 )
 
 type VolumeServer struct {
@@ -23,6 +30,20 @@ type VolumeServer struct {
 	needleMapKind     storage.NeedleMapType
 	FixJpgOrientation bool
 	ReadRedirect      bool
+
+	compactionBytePerSecond int64
+
+	writeQuorum            int
+	collectionWriteQuorums map[string]int
+	hints                  *storage.HintQueue
+	replicas               *topology.KnownReplicas
+
+	digestType storage.DigestType
+
+	readJwt bool //读取也需要JWT
+
+	metrics     *stats.Registry
+	httpMetrics *stats.HTTPMetrics
 }
 
 func NewVolumeServer(adminMux, publicMux *http.ServeMux, ip string,
@@ -31,9 +52,16 @@ func NewVolumeServer(adminMux, publicMux *http.ServeMux, ip string,
 	needleMapKind storage.NeedleMapType,
 	masterNode string, pulseSeconds int,
 	dataCenter string, rack string,
-	whiteList []string,
+	whiteList []string, trustedProxies []string, secureKey string, readJwt bool,
 	fixJpgOrientation bool,
-	readRedirect bool) *VolumeServer {
+	readRedirect bool,
+	compactionMBPerSecond int,
+	writeQuorum int, collectionWriteQuorums string,
+	hintsDir string,
+	digest string,
+	cacheOption storage.NeedleCacheOption,
+	maxDiskIOErrors int, diskReserveMB int,
+	durability string, collectionDurabilities string, groupCommitMs int) *VolumeServer {
 	vs := &VolumeServer{
 		pulseSeconds:      pulseSeconds,
 		dataCenter:        dataCenter,
@@ -41,33 +69,88 @@ func NewVolumeServer(adminMux, publicMux *http.ServeMux, ip string,
 		needleMapKind:     needleMapKind,
 		FixJpgOrientation: fixJpgOrientation,
 		ReadRedirect:      readRedirect,
+
+		compactionBytePerSecond: int64(compactionMBPerSecond) * 1024 * 1024,
 	}
 	vs.SetMasterNode(masterNode)
 	vs.store = storage.NewStore(port, ip, publicUrl, folders, maxCounts, vs.needleMapKind)
+	vs.store.SetMaxDiskIOErrors(maxDiskIOErrors)
+	vs.store.SetDiskReserveMB(diskReserveMB)
+	durabilityPolicy, err := storage.NewDurabilityPolicy(durability, collectionDurabilities, groupCommitMs)
+	if err != nil {
+		glog.Fatalf("invalid durability: %v", err)
+	}
+	vs.store.SetDurability(durabilityPolicy)
+
+	vs.writeQuorum = writeQuorum
+	if vs.collectionWriteQuorums, err = parseCollectionWriteQuorums(collectionWriteQuorums); err != nil {
+		glog.Fatalf("invalid collection write quorums %s: %v", collectionWriteQuorums, err)
+	}
+	if hintsDir == "" {
+		hintsDir = filepath.Join(folders[0], "hints")
+	}
+	if vs.hints, err = storage.NewHintQueue(hintsDir); err != nil {
+		glog.Fatalf("cannot create hint queue: %v", err)
+	}
+	vs.replicas = topology.NewKnownReplicas()
+	if vs.digestType, err = storage.ParseDigestType(digest); err != nil {
+		glog.Fatalf("invalid digest: %v", err)
+	}
 
-	vs.guard = security.NewGuard(whiteList, "")
+	cache, err := storage.NewNeedleCache(cacheOption)
+	if err != nil {
+		glog.Fatalf("invalid read cache option: %v", err)
+	}
+	storage.SetNeedleCache(cache)
 
+	if vs.guard, err = security.NewGuard(whiteList, secureKey, trustedProxies); err != nil {
+		glog.Fatalf("invalid security options: %v", err)
+	}
+	vs.readJwt = readJwt
+	vs.store.SetJwtKeys(vs.guard.Keys)
+	vs.store.SetAccessKeys(vs.guard.AccessKeys)
+
+	vs.registerMetrics()
 	adminMux.HandleFunc("/ui/index.html", vs.uiStatusHandler)
 	adminMux.HandleFunc("/status", vs.guard.WhiteList(vs.statusHandler))
-	adminMux.HandleFunc("/admin/assign_volume", vs.guard.WhiteList(vs.assignVolumeHandler))
-	adminMux.HandleFunc("/admin/vacuum/check", vs.guard.WhiteList(vs.vacuumVolumeCheckHandler))
-	adminMux.HandleFunc("/admin/vacuum/compact", vs.guard.WhiteList(vs.vacuumVolumeCompactHandler))
-	adminMux.HandleFunc("/admin/vacuum/commit", vs.guard.WhiteList(vs.vacuumVolumeCommitHandler))
-	adminMux.HandleFunc("/admin/delete_collection", vs.guard.WhiteList(vs.deleteCollectionHandler))
-	adminMux.HandleFunc("/admin/sync/status", vs.guard.WhiteList(vs.getVolumeSyncStatusHandler))
-	adminMux.HandleFunc("/admin/sync/index", vs.guard.WhiteList(vs.getVolumeIndexContentHandler))
-	adminMux.HandleFunc("/admin/sync/data", vs.guard.WhiteList(vs.getVolumeDataContentHandler))
+	adminMux.HandleFunc("/admin/assign_volume", vs.guard.Authorized(vs.assignVolumeHandler))
+	adminMux.HandleFunc("/admin/vacuum/check", vs.guard.Authorized(vs.vacuumVolumeCheckHandler))
+	adminMux.HandleFunc("/admin/vacuum/compact", vs.guard.Authorized(vs.vacuumVolumeCompactHandler))
+	adminMux.HandleFunc("/admin/vacuum/commit", vs.guard.Authorized(vs.vacuumVolumeCommitHandler))
+	adminMux.HandleFunc("/admin/delete_collection", vs.audited("delete_collection", security.AuditForm("collection"), vs.guard.Authorized(vs.deleteCollectionHandler)))
+	adminMux.HandleFunc("/admin/volume/readonly", vs.guard.Authorized(vs.markVolumeReadonlyHandler))
+	adminMux.HandleFunc("/admin/volume/writable", vs.guard.Authorized(vs.markVolumeWritableHandler))
+	adminMux.HandleFunc("/admin/volume/delete", vs.audited("delete_volume", security.AuditForm("volume"), vs.guard.Authorized(vs.deleteVolumeHandler)))
+	adminMux.HandleFunc("/admin/dir/add", vs.guard.Authorized(vs.addDirHandler))
+	adminMux.HandleFunc("/admin/dir/remove", vs.guard.Authorized(vs.removeDirHandler))
+	adminMux.HandleFunc("/admin/replicate_needle", vs.guard.Authorized(vs.replicateNeedleHandler))
+	adminMux.HandleFunc("/admin/scrub", vs.guard.Authorized(vs.scrubVolumeHandler))
+	adminMux.HandleFunc("/admin/sync/status", vs.guard.Authorized(vs.getVolumeSyncStatusHandler))
+	adminMux.HandleFunc("/admin/sync/index", vs.guard.Authorized(vs.getVolumeIndexContentHandler))
+	adminMux.HandleFunc("/admin/sync/data", vs.guard.Authorized(vs.getVolumeDataContentHandler))
 	adminMux.HandleFunc("/stats/counter", vs.guard.WhiteList(statsCounterHandler))
 	adminMux.HandleFunc("/stats/memory", vs.guard.WhiteList(statsMemoryHandler))
 	adminMux.HandleFunc("/stats/disk", vs.guard.WhiteList(vs.statsDiskHandler))
-	adminMux.HandleFunc("/delete", vs.guard.WhiteList(vs.batchDeleteHandler))
+	adminMux.HandleFunc("/stats/cache", vs.guard.WhiteList(vs.statsCacheHandler))
+	adminMux.HandleFunc("/metrics", vs.guard.WhiteList(vs.metrics.ServeHTTP))
+	adminMux.HandleFunc("/delete", vs.audited("batch_delete", security.AuditForm("fid"), vs.guard.AuthorizedFiles(vs.batchDeleteHandler)))
+	adminMux.HandleFunc("/batch/write", vs.audited("batch_write", security.AuditPath, vs.guard.AuthorizedFiles(vs.batchWriteHandler)))
+	adminMux.HandleFunc("/batch/read", vs.batchReadHandler)
 	adminMux.HandleFunc("/", vs.privateStoreHandler)
 	if publicMux != adminMux {
 		// separated admin and public port
 		publicMux.HandleFunc("/favicon.ico", vs.faviconHandler)
+		publicMux.HandleFunc("/batch/read", vs.batchReadHandler)
 		publicMux.HandleFunc("/", vs.publicReadOnlyHandler)
 	}
 
+	go func() {
+		for {
+			time.Sleep(time.Duration(vs.pulseSeconds) * time.Second)
+			topology.ReplayHints(vs.hints, vs.guard.Keys)
+		}
+	}()
+
 	go func() {
 		connected := true
 
@@ -77,12 +160,11 @@ func NewVolumeServer(adminMux, publicMux *http.ServeMux, ip string,
 		vs.store.SetRack(vs.rack)
 		for {
 			glog.V(4).Infof("Volume server sending to master %s", vs.GetMasterNode())
-			master, secretKey, err := vs.store.SendHeartbeatToMaster()
+			master, err := vs.store.SendHeartbeatToMaster()
 			if err == nil {
 				if !connected {
 					connected = true
 					vs.SetMasterNode(master)
-					vs.guard.SecretKey = secretKey
 					glog.V(0).Infoln("Volume Server Connected with master at", master)
 				}
 			} else {
@@ -91,10 +173,16 @@ func NewVolumeServer(adminMux, publicMux *http.ServeMux, ip string,
 					connected = false
 				}
 			}
+			var interval time.Duration
 			if connected {
-				time.Sleep(time.Duration(float32(vs.pulseSeconds*1e3)*(1+rand.Float32())) * time.Millisecond)
+				interval = time.Duration(float32(vs.pulseSeconds*1e3)*(1+rand.Float32())) * time.Millisecond
 			} else {
-				time.Sleep(time.Duration(float32(vs.pulseSeconds*1e3)*0.25) * time.Millisecond)
+				interval = time.Duration(float32(vs.pulseSeconds*1e3)*0.25) * time.Millisecond
+			}
+			//磁盘故障时立即发送心跳，不在出错的请求中发送
+			select {
+			case <-time.After(interval):
+			case <-vs.store.DiskFailed():
 			}
 		}
 	}()
@@ -114,12 +202,73 @@ func (vs *VolumeServer) SetMasterNode(masterNode string) {
 	vs.masterNode = masterNode
 }
 
-func (vs *VolumeServer) Shutdown() {
+// Shutdown tells the master this server is leaving, stops accepting new requests,
+// waits up to the timeout for the in-flight ones, and then flushes and closes the volumes.
+func (vs *VolumeServer) Shutdown(timeout time.Duration, servers ...*http.Server) {
 	glog.V(0).Infoln("Shutting down volume server...")
+	//先通知master，不再向本机分配写入
+	if err := vs.store.SendLeavingToMaster(); err != nil {
+		glog.V(0).Infof("Failed to tell master %s about leaving: %v", vs.GetMasterNode(), err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, server := range servers {
+		wg.Add(1)
+		go func(server *http.Server) {
+			defer wg.Done()
+			if err := server.Shutdown(ctx); err != nil {
+				glog.V(0).Infof("Stop serving %s: %v", server.Addr, err)
+			}
+		}(server)
+	}
+	wg.Wait()
 	vs.store.Close()
 	glog.V(0).Infoln("Shut down successfully!")
 }
 
-func (vs *VolumeServer) jwt(fileId string) security.EncodedJwt {
-	return security.GenJwt(vs.guard.SecretKey, fileId)
+// checkRead requires a read token for the file id in -jwt.read mode.
+func (vs *VolumeServer) checkRead(r *http.Request, volumeId storage.VolumeId, fileId string) error {
+	if !vs.readJwt {
+		return nil
+	}
+	var collection string
+	if v := vs.store.GetVolume(volumeId); v != nil {
+		collection = v.Collection
+	}
+	return vs.guard.CheckRead(r, fileId, collection)
+}
+
+// audited records the request in the audit log, except the copies sent to the other replicas,
+// which are signed as a cluster member.
+func (vs *VolumeServer) audited(operation string, target security.AuditTarget, f func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+	audited := vs.guard.Audited(operation, target, f)
+	return func(w http.ResponseWriter, r *http.Request) {
+		//只看url参数，不能读取上传的文件内容；type参数由客户端控制，还要校验令牌
+		if r.URL.Query().Get("type") == "replicate" && vs.guard.IsClusterMember(r) {
+			f(w, r)
+			return
+		}
+		audited(w, r)
+	}
+}
+
+//解析 collection:quorum 的列表，例如 pictures:2,logs:1
+func parseCollectionWriteQuorums(s string) (map[string]int, error) {
+	quorums := make(map[string]int)
+	if s == "" {
+		return quorums, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		parts := strings.Split(pair, ":")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("expecting collection:quorum, but got %s", pair)
+		}
+		quorum, err := strconv.Atoi(parts[1])
+		if err != nil || quorum < 0 {
+			return nil, fmt.Errorf("invalid quorum %s for collection %s", parts[1], parts[0])
+		}
+		quorums[parts[0]] = quorum
+	}
+	return quorums, nil
 }
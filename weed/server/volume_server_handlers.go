@@ -3,6 +3,7 @@ package weed_server
 import (
 	"net/http"
 
+	"github.com/chrislusf/seaweedfs/weed/security"
 	"github.com/chrislusf/seaweedfs/weed/stats"
 )
 
@@ -31,13 +32,13 @@ func (vs *VolumeServer) privateStoreHandler(w http.ResponseWriter, r *http.Reque
 		vs.GetOrHeadHandler(w, r)
 	case "DELETE":
 		stats.DeleteRequest()
-		vs.guard.WhiteList(vs.DeleteHandler)(w, r)
+		vs.audited("delete", security.AuditPath, vs.guard.AuthorizedFiles(vs.DeleteHandler))(w, r)
 	case "PUT":
 		stats.WriteRequest()
-		vs.guard.WhiteList(vs.PostHandler)(w, r)
+		vs.audited("write", security.AuditPath, vs.guard.AuthorizedFiles(vs.PostHandler))(w, r)
 	case "POST":
 		stats.WriteRequest()
-		vs.guard.WhiteList(vs.PostHandler)(w, r)
+		vs.audited("write", security.AuditPath, vs.guard.AuthorizedFiles(vs.PostHandler))(w, r)
 	}
 }
 
@@ -1,11 +1,15 @@
 package weed_server
 
 import (
+	"fmt"
 	"net/http"
 	"path/filepath"
+	"strconv"
 
 	"github.com/chrislusf/seaweedfs/weed/glog"
+	"github.com/chrislusf/seaweedfs/weed/security"
 	"github.com/chrislusf/seaweedfs/weed/stats"
+	"github.com/chrislusf/seaweedfs/weed/storage"
 	"github.com/chrislusf/seaweedfs/weed/util"
 )
 
@@ -13,10 +17,15 @@ func (vs *VolumeServer) statusHandler(w http.ResponseWriter, r *http.Request) {
 	m := make(map[string]interface{})
 	m["Version"] = util.VERSION
 	m["Volumes"] = vs.store.Status()
+	m["Hints"] = vs.hints.Pending()
 	writeJsonQuiet(w, r, http.StatusOK, m)
 }
 
 func (vs *VolumeServer) assignVolumeHandler(w http.ResponseWriter, r *http.Request) {
+	if err := vs.guard.Authorize(r, security.ActionAdmin, r.FormValue("collection"), ""); err != nil {
+		writeAuthError(w, r, err)
+		return
+	}
 	err := vs.store.AddVolume(r.FormValue("volume"), r.FormValue("collection"), vs.needleMapKind, r.FormValue("replication"), r.FormValue("ttl"))
 	if err == nil {
 		writeJsonQuiet(w, r, http.StatusAccepted, map[string]string{"error": ""})
@@ -27,6 +36,10 @@ func (vs *VolumeServer) assignVolumeHandler(w http.ResponseWriter, r *http.Reque
 }
 
 func (vs *VolumeServer) deleteCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	if err := vs.guard.Authorize(r, security.ActionAdmin, r.FormValue("collection"), ""); err != nil {
+		writeAuthError(w, r, err)
+		return
+	}
 	err := vs.store.DeleteCollection(r.FormValue("collection"))
 	if err == nil {
 		writeJsonQuiet(w, r, http.StatusOK, map[string]string{"error": ""})
@@ -36,15 +49,134 @@ func (vs *VolumeServer) deleteCollectionHandler(w http.ResponseWriter, r *http.R
 	glog.V(2).Infoln("deleting collection =", r.FormValue("collection"), ", error =", err)
 }
 
+func (vs *VolumeServer) markVolumeReadonlyHandler(w http.ResponseWriter, r *http.Request) {
+	vs.markVolumeReadOnly(w, r, true)
+}
+
+func (vs *VolumeServer) markVolumeWritableHandler(w http.ResponseWriter, r *http.Request) {
+	vs.markVolumeReadOnly(w, r, false)
+}
+
+func (vs *VolumeServer) markVolumeReadOnly(w http.ResponseWriter, r *http.Request, readOnly bool) {
+	if !vs.authorizeVolumeAdmin(w, r) {
+		return
+	}
+	vid, err := storage.NewVolumeId(r.FormValue("volume"))
+	if err == nil {
+		err = vs.store.MarkVolumeReadOnly(vid, readOnly)
+	}
+	if err == nil {
+		writeJsonQuiet(w, r, http.StatusOK, map[string]string{"error": ""})
+	} else {
+		writeJsonError(w, r, http.StatusInternalServerError, err)
+	}
+	glog.V(2).Infoln("mark volume =", r.FormValue("volume"), ", readonly =", readOnly, ", error =", err)
+}
+
+func (vs *VolumeServer) deleteVolumeHandler(w http.ResponseWriter, r *http.Request) {
+	vid, err := storage.NewVolumeId(r.FormValue("volume"))
+	if err != nil {
+		writeJsonError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if err = vs.authorize(r, security.ActionAdmin, vid, ""); err != nil {
+		writeAuthError(w, r, err)
+		return
+	}
+	err = vs.store.DeleteVolume(vid)
+	if err == nil {
+		writeJsonQuiet(w, r, http.StatusOK, map[string]string{"error": ""})
+	} else {
+		writeJsonError(w, r, http.StatusInternalServerError, err)
+	}
+	glog.V(2).Infoln("deleting volume =", r.FormValue("volume"), ", error =", err)
+}
+
+func (vs *VolumeServer) addDirHandler(w http.ResponseWriter, r *http.Request) {
+	if err := vs.guard.Authorize(r, security.ActionAdmin, "", ""); err != nil {
+		writeAuthError(w, r, err)
+		return
+	}
+	max, err := strconv.Atoi(r.FormValue("max"))
+	if err != nil || max < 0 {
+		writeJsonError(w, r, http.StatusBadRequest, fmt.Errorf("invalid max volume count %q", r.FormValue("max")))
+		return
+	}
+	if err = vs.store.AddLocation(r.FormValue("dir"), max, vs.needleMapKind); err == nil {
+		writeJsonQuiet(w, r, http.StatusOK, map[string]string{"error": ""})
+	} else {
+		writeJsonError(w, r, http.StatusNotAcceptable, err)
+	}
+	glog.V(0).Infoln("adding dir =", r.FormValue("dir"), ", max =", max, ", error =", err)
+}
+
+func (vs *VolumeServer) removeDirHandler(w http.ResponseWriter, r *http.Request) {
+	if err := vs.guard.Authorize(r, security.ActionAdmin, "", ""); err != nil {
+		writeAuthError(w, r, err)
+		return
+	}
+	err := vs.store.RemoveLocation(r.FormValue("dir"))
+	if err == nil {
+		writeJsonQuiet(w, r, http.StatusOK, map[string]string{"error": ""})
+	} else {
+		writeJsonError(w, r, http.StatusNotAcceptable, err)
+	}
+	glog.V(0).Infoln("removing dir =", r.FormValue("dir"), ", error =", err)
+}
+
 func (vs *VolumeServer) statsDiskHandler(w http.ResponseWriter, r *http.Request) {
 	m := make(map[string]interface{})
 	m["Version"] = util.VERSION
 	var ds []*stats.DiskStatus
-	for _, loc := range vs.store.Locations {
+	for _, loc := range vs.store.Locations() {
 		if dir, e := filepath.Abs(loc.Directory); e == nil {
 			ds = append(ds, stats.NewDiskStatus(dir))
 		}
 	}
 	m["DiskStatuses"] = ds
+	m["DiskHealths"] = vs.store.DiskHealths()
+	writeJsonQuiet(w, r, http.StatusOK, m)
+}
+
+func (vs *VolumeServer) statsCacheHandler(w http.ResponseWriter, r *http.Request) {
+	m := make(map[string]interface{})
+	m["Version"] = util.VERSION
+	m["Cache"] = storage.NeedleCacheStatistics()
 	writeJsonQuiet(w, r, http.StatusOK, m)
 }
+
+func (vs *VolumeServer) scrubVolumeHandler(w http.ResponseWriter, r *http.Request) {
+	vid, err := storage.NewVolumeId(r.FormValue("volume"))
+	if err != nil {
+		writeJsonError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if err = vs.authorize(r, security.ActionAdmin, vid, ""); err != nil {
+		writeAuthError(w, r, err)
+		return
+	}
+	v := vs.store.GetVolume(vid)
+	if v == nil {
+		writeJsonError(w, r, http.StatusNotFound, fmt.Errorf("volume %d not found", vid))
+		return
+	}
+	ret, err := v.Scrub()
+	if err != nil {
+		writeJsonError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	if len(ret.Corrupted) > 0 {
+		glog.Warningf("scrub volume %d found %d corrupted needles out of %d", vid, len(ret.Corrupted), ret.Checked)
+	}
+	writeJsonQuiet(w, r, http.StatusOK, ret)
+}
+
+//卷的管理操作需要集群成员的令牌，或者能管理卷所在collection的访问密钥
+func (vs *VolumeServer) authorizeVolumeAdmin(w http.ResponseWriter, r *http.Request) bool {
+	vid, _ := storage.NewVolumeId(r.FormValue("volume"))
+	if err := vs.authorize(r, security.ActionAdmin, vid, ""); err != nil {
+		writeAuthError(w, r, err)
+		return false
+	}
+	return true
+}
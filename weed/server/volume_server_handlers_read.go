@@ -6,6 +6,7 @@ import (
 	"mime"
 	"mime/multipart"
 	"net/http"
+	"net/textproto"
 	"net/url"
 	"path"
 	"strconv"
@@ -15,6 +16,7 @@ import (
 	"github.com/chrislusf/seaweedfs/weed/glog"
 	"github.com/chrislusf/seaweedfs/weed/images"
 	"github.com/chrislusf/seaweedfs/weed/operation"
+	"github.com/chrislusf/seaweedfs/weed/stats"
 	"github.com/chrislusf/seaweedfs/weed/storage"
 	"github.com/chrislusf/seaweedfs/weed/util"
 )
@@ -53,6 +55,9 @@ func (vs *VolumeServer) GetOrHeadHandler(w http.ResponseWriter, r *http.Request)
 			if c := r.FormValue("collection"); c != "" {
 				arg.Set("collection", c)
 			}
+			if j := r.FormValue("jwt"); j != "" {
+				arg.Set("jwt", j)
+			}
 			u.RawQuery = arg.Encode()
 			http.Redirect(w, r, u.String(), http.StatusMovedPermanently)
 
@@ -62,6 +67,10 @@ func (vs *VolumeServer) GetOrHeadHandler(w http.ResponseWriter, r *http.Request)
 		}
 		return
 	}
+	if err := vs.checkRead(r, volumeId, vid+","+fid); err != nil {
+		w.WriteHeader(authErrorStatus(err))
+		return
+	}
 	cookie := n.Cookie
 	count, e := vs.store.ReadVolumeNeedle(volumeId, n)
 	glog.V(4).Infoln("read bytes", count, "error", e)
@@ -93,6 +102,13 @@ func (vs *VolumeServer) GetOrHeadHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	w.Header().Set("Etag", etag)
+	for k, v := range n.PairMap() {
+		w.Header().Set(k, v)
+	}
+	//只有原样返回内容时，Content-MD5才和返回的内容一致
+	if contentMD5 := n.ContentMD5(); contentMD5 != "" && !n.IsGzipped() && r.FormValue("width") == "" && r.FormValue("height") == "" {
+		w.Header().Set("Content-MD5", contentMD5)
+	}
 
 	if vs.tryHandleChunkedFile(n, filename, w, r) {
 		return
@@ -298,3 +314,139 @@ func writeResponseContent(filename, mimeType string, rs io.ReadSeeker, w http.Re
 	_, e = io.CopyN(w, sendContent, sendSize)
 	return e
 }
+
+//批量读取，fid参数可以有多个，都需要在本机上，结果以multipart/mixed返回
+//每个part的Fid和Status header表示对应的文件和状态，同一个卷的needle按照在.dat文件中的偏移排序读取
+func (vs *VolumeServer) batchReadHandler(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	acceptGzip := strings.Contains(r.Header.Get("Accept-Encoding"), "gzip")
+	mw := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", "multipart/mixed; boundary="+mw.Boundary())
+	w.WriteHeader(http.StatusOK)
+
+	var volumeIds []storage.VolumeId
+	volumeNeedles := make(map[storage.VolumeId][]*storage.Needle)
+	volumeFids := make(map[storage.VolumeId][]string)
+	for _, fid := range r.Form["fid"] {
+		vid, idCookie, err := operation.ParseFileId(fid)
+		if err != nil {
+			writeBatchReadError(mw, fid, http.StatusBadRequest, err.Error())
+			continue
+		}
+		volumeId, err := storage.NewVolumeId(vid)
+		if err != nil {
+			writeBatchReadError(mw, fid, http.StatusBadRequest, err.Error())
+			continue
+		}
+		if dotSep := strings.LastIndex(idCookie, "."); dotSep > 0 {
+			idCookie = idCookie[:dotSep]
+		}
+		n := new(storage.Needle)
+		if err = n.ParsePath(idCookie); err != nil {
+			writeBatchReadError(mw, fid, http.StatusBadRequest, err.Error())
+			continue
+		}
+		if err = vs.checkRead(r, volumeId, vid+","+idCookie); err != nil {
+			writeBatchReadError(mw, fid, authErrorStatus(err), err.Error())
+			continue
+		}
+		if _, found := volumeNeedles[volumeId]; !found {
+			volumeIds = append(volumeIds, volumeId)
+		}
+		volumeNeedles[volumeId] = append(volumeNeedles[volumeId], n)
+		volumeFids[volumeId] = append(volumeFids[volumeId], fid)
+	}
+
+	for _, volumeId := range volumeIds {
+		ns, fids := volumeNeedles[volumeId], volumeFids[volumeId]
+		cookies := make([]uint32, len(ns))
+		for i, n := range ns {
+			cookies[i] = n.Cookie
+		}
+		stats.ReadRequest()
+		vs.store.ReadVolumeNeedles(volumeId, ns, func(i int, count int, err error) {
+			n, fid := ns[i], fids[i]
+			if err != nil || count <= 0 {
+				glog.V(4).Infoln("batch read", fid, "error:", err)
+				writeBatchReadError(mw, fid, http.StatusNotFound, "Not Found")
+				return
+			}
+			defer n.ReleaseMemory()
+			if n.Cookie != cookies[i] {
+				glog.V(0).Infoln("batch read", fid, "with unmaching cookie from", r.RemoteAddr, "agent", r.UserAgent())
+				writeBatchReadError(mw, fid, http.StatusNotFound, "Not Found")
+				return
+			}
+			if n.IsChunkedManifest() {
+				writeBatchReadError(mw, fid, http.StatusNotAcceptable, "ChunkManifest: not allowed in batch read mode.")
+				return
+			}
+			if e := writeBatchReadNeedle(mw, fid, n, acceptGzip); e != nil {
+				glog.V(2).Infoln("batch read response write error:", e)
+			}
+		})
+	}
+	if e := mw.Close(); e != nil {
+		glog.V(2).Infoln("batch read response write error:", e)
+	}
+}
+
+func writeBatchReadError(mw *multipart.Writer, fid string, status int, message string) {
+	h := make(textproto.MIMEHeader)
+	h.Set("Fid", fid)
+	h.Set("Status", strconv.Itoa(status))
+	h.Set("Content-Type", "text/plain; charset=utf-8")
+	part, err := mw.CreatePart(h)
+	if err == nil {
+		_, err = io.WriteString(part, message)
+	}
+	if err != nil {
+		glog.V(2).Infoln("batch read response write error:", err)
+	}
+}
+
+func writeBatchReadNeedle(mw *multipart.Writer, fid string, n *storage.Needle, acceptGzip bool) (err error) {
+	h := make(textproto.MIMEHeader)
+	h.Set("Fid", fid)
+	h.Set("Status", strconv.Itoa(http.StatusOK))
+	filename, mtype := "", ""
+	if n.NameSize > 0 {
+		filename = string(n.Name)
+		h.Set("Content-Disposition", `inline; filename="`+fileNameEscaper.Replace(filename)+`"`)
+	}
+	if n.MimeSize > 0 && !strings.HasPrefix(string(n.Mime), "application/octet-stream") {
+		mtype = string(n.Mime)
+	} else if ext := path.Ext(filename); ext != "" {
+		mtype = mime.TypeByExtension(ext)
+	}
+	if mtype != "" {
+		h.Set("Content-Type", mtype)
+	}
+	if n.LastModified != 0 {
+		h.Set("Last-Modified", time.Unix(int64(n.LastModified), 0).UTC().Format(http.TimeFormat))
+	}
+	h.Set("Etag", n.Etag())
+	for k, v := range n.PairMap() {
+		h.Set(k, v)
+	}
+	if contentMD5 := n.ContentMD5(); contentMD5 != "" && !n.IsGzipped() {
+		h.Set("Content-MD5", contentMD5)
+	}
+	data := n.Data
+	if n.IsGzipped() {
+		if acceptGzip {
+			h.Set("Content-Encoding", "gzip")
+		} else if data, err = operation.UnGzipData(data); err != nil {
+			glog.V(0).Infoln("ungzip error:", err, fid)
+			data = n.Data
+			h.Set("Content-Encoding", "gzip")
+		}
+	}
+	h.Set("Content-Length", strconv.Itoa(len(data)))
+	part, err := mw.CreatePart(h)
+	if err != nil {
+		return err
+	}
+	_, err = part.Write(data)
+	return err
+}
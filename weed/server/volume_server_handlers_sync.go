@@ -10,6 +10,9 @@ import (
 )
 
 func (vs *VolumeServer) getVolumeSyncStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if !vs.authorizeVolumeAdmin(w, r) {
+		return
+	}
 	v, err := vs.getVolume("volume", r)
 	if v == nil {
 		writeJsonError(w, r, http.StatusBadRequest, err)
@@ -25,6 +28,9 @@ func (vs *VolumeServer) getVolumeSyncStatusHandler(w http.ResponseWriter, r *htt
 }
 
 func (vs *VolumeServer) getVolumeIndexContentHandler(w http.ResponseWriter, r *http.Request) {
+	if !vs.authorizeVolumeAdmin(w, r) {
+		return
+	}
 	v, err := vs.getVolume("volume", r)
 	if v == nil {
 		writeJsonError(w, r, http.StatusBadRequest, err)
@@ -39,6 +45,9 @@ func (vs *VolumeServer) getVolumeIndexContentHandler(w http.ResponseWriter, r *h
 }
 
 func (vs *VolumeServer) getVolumeDataContentHandler(w http.ResponseWriter, r *http.Request) {
+	if !vs.authorizeVolumeAdmin(w, r) {
+		return
+	}
 	v, err := vs.getVolume("volume", r)
 	if v == nil {
 		writeJsonError(w, r, http.StatusBadRequest, fmt.Errorf("Not Found volume: %v", err))
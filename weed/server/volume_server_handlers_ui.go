@@ -14,7 +14,7 @@ func (vs *VolumeServer) uiStatusHandler(w http.ResponseWriter, r *http.Request)
 	infos := make(map[string]interface{})
 	infos["Up Time"] = time.Now().Sub(startTime).String()
 	var ds []*stats.DiskStatus
-	for _, loc := range vs.store.Locations {
+	for _, loc := range vs.store.Locations() {
 		if dir, e := filepath.Abs(loc.Directory); e == nil {
 			ds = append(ds, stats.NewDiskStatus(dir))
 		}
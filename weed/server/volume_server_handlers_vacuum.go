@@ -7,6 +7,9 @@ import (
 )
 
 func (vs *VolumeServer) vacuumVolumeCheckHandler(w http.ResponseWriter, r *http.Request) {
+	if !vs.authorizeVolumeAdmin(w, r) {
+		return
+	}
 	err, ret := vs.store.CheckCompactVolume(r.FormValue("volume"), r.FormValue("garbageThreshold"))
 	if err == nil {
 		writeJsonQuiet(w, r, http.StatusOK, map[string]interface{}{"error": "", "result": ret})
@@ -16,7 +19,10 @@ func (vs *VolumeServer) vacuumVolumeCheckHandler(w http.ResponseWriter, r *http.
 	glog.V(2).Infoln("checked compacting volume =", r.FormValue("volume"), "garbageThreshold =", r.FormValue("garbageThreshold"), "vacuum =", ret)
 }
 func (vs *VolumeServer) vacuumVolumeCompactHandler(w http.ResponseWriter, r *http.Request) {
-	err := vs.store.CompactVolume(r.FormValue("volume"))
+	if !vs.authorizeVolumeAdmin(w, r) {
+		return
+	}
+	err := vs.store.CompactVolume(r.FormValue("volume"), vs.compactionBytePerSecond)
 	if err == nil {
 		writeJsonQuiet(w, r, http.StatusOK, map[string]string{"error": ""})
 	} else {
@@ -25,6 +31,9 @@ func (vs *VolumeServer) vacuumVolumeCompactHandler(w http.ResponseWriter, r *htt
 	glog.V(2).Infoln("compacted volume =", r.FormValue("volume"), ", error =", err)
 }
 func (vs *VolumeServer) vacuumVolumeCommitHandler(w http.ResponseWriter, r *http.Request) {
+	if !vs.authorizeVolumeAdmin(w, r) {
+		return
+	}
 	err := vs.store.CommitCompactVolume(r.FormValue("volume"))
 	if err == nil {
 		writeJsonQuiet(w, r, http.StatusOK, map[string]string{"error": ""})
@@ -3,10 +3,14 @@ package weed_server
 import (
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"strconv"
 
 	"github.com/chrislusf/seaweedfs/weed/glog"
 	"github.com/chrislusf/seaweedfs/weed/operation"
+	"github.com/chrislusf/seaweedfs/weed/security"
 	"github.com/chrislusf/seaweedfs/weed/storage"
 	"github.com/chrislusf/seaweedfs/weed/topology"
 )
@@ -17,26 +21,33 @@ func (vs *VolumeServer) PostHandler(w http.ResponseWriter, r *http.Request) {
 		writeJsonError(w, r, http.StatusBadRequest, e)
 		return
 	}
-	vid, _, _, _, _ := parseURLPath(r.URL.Path)
+	vid, fid, _, _, _ := parseURLPath(r.URL.Path)
 	volumeId, ve := storage.NewVolumeId(vid)
 	if ve != nil {
 		glog.V(0).Infoln("NewVolumeId error:", ve)
 		writeJsonError(w, r, http.StatusBadRequest, ve)
 		return
 	}
-	needle, ne := storage.NewNeedle(r, vs.FixJpgOrientation)
+	if err := vs.authorize(r, security.ActionWrite, volumeId, vid+","+fid); err != nil {
+		writeAuthError(w, r, err)
+		return
+	}
+	needle, ne := storage.NewNeedle(r, vs.FixJpgOrientation, vs.digestType)
 	if ne != nil {
 		writeJsonError(w, r, http.StatusBadRequest, ne)
 		return
 	}
 
 	ret := operation.UploadResult{}
-	size, errorStatus := topology.ReplicatedWrite(vs.GetMasterNode(),
-		vs.store, volumeId, needle, r)
+	size, err := topology.ReplicatedWrite(vs.GetMasterNode(),
+		vs.store, volumeId, needle, r, vs.replicationOption(r, volumeId))
 	httpStatus := http.StatusCreated
-	if errorStatus != "" {
+	if err == storage.ErrPreconditionFailed {
+		httpStatus = http.StatusPreconditionFailed
+		ret.Error = err.Error()
+	} else if err != nil {
 		httpStatus = http.StatusInternalServerError
-		ret.Error = errorStatus
+		ret.Error = err.Error()
 	}
 	if needle.HasName() {
 		ret.Name = string(needle.Name)
@@ -47,11 +58,50 @@ func (vs *VolumeServer) PostHandler(w http.ResponseWriter, r *http.Request) {
 	writeJsonQuiet(w, r, httpStatus, ret)
 }
 
+//复制节点直接追加主节点序列化好的一个或多个needle
+func (vs *VolumeServer) replicateNeedleHandler(w http.ResponseWriter, r *http.Request) {
+	volumeId, err := storage.NewVolumeId(r.FormValue("volume"))
+	if err != nil {
+		writeJsonError(w, r, http.StatusNotAcceptable, err)
+		return
+	}
+	version, err := strconv.ParseUint(r.FormValue("version"), 10, 8)
+	if err != nil {
+		writeJsonError(w, r, http.StatusNotAcceptable, fmt.Errorf("invalid version %s", r.FormValue("version")))
+		return
+	}
+	//needle可以是卷中的任何文件，需要集群成员或者对collection的写权限
+	if err = vs.authorize(r, security.ActionWrite, volumeId, ""); err != nil {
+		writeAuthError(w, r, err)
+		return
+	}
+	//404已经表示不支持复制needle的旧版本，卷不在本机时用410，发送方不会再重试
+	if !vs.store.HasVolume(volumeId) {
+		writeJsonError(w, r, http.StatusGone, fmt.Errorf("volume %d not found", volumeId))
+		return
+	}
+	blob, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeJsonError(w, r, http.StatusNotAcceptable, err)
+		return
+	}
+	size, err := vs.store.WriteNeedleBlobs(volumeId, blob, storage.Version(version))
+	if err != nil {
+		writeJsonError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	writeJsonQuiet(w, r, http.StatusCreated, operation.UploadResult{Size: size})
+}
+
 func (vs *VolumeServer) DeleteHandler(w http.ResponseWriter, r *http.Request) {
 	n := new(storage.Needle)
 	vid, fid, _, _, _ := parseURLPath(r.URL.Path)
 	volumeId, _ := storage.NewVolumeId(vid)
 	n.ParsePath(fid)
+	if err := vs.authorize(r, security.ActionDelete, volumeId, vid+","+fid); err != nil {
+		writeAuthError(w, r, err)
+		return
+	}
 
 	glog.V(2).Infoln("deleting", n)
 
@@ -74,6 +124,12 @@ func (vs *VolumeServer) DeleteHandler(w http.ResponseWriter, r *http.Request) {
 
 	count := int64(n.Size)
 
+	//删除chunk之前先检查条件，真正删除时还会在锁内再检查一次
+	if !storage.NewNeedleCondition(r.Header).Matches(n.Etag()) {
+		writeJsonError(w, r, http.StatusPreconditionFailed, storage.ErrPreconditionFailed)
+		return
+	}
+
 	if n.IsChunkedManifest() {
 		chunkManifest, e := operation.LoadChunkManifest(n.Data, n.IsGzipped())
 		if e != nil {
@@ -81,19 +137,21 @@ func (vs *VolumeServer) DeleteHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		// make sure all chunks had deleted before delete manifest
-		if e := chunkManifest.DeleteChunks(vs.GetMasterNode()); e != nil {
+		if e := chunkManifest.DeleteChunks(vs.GetMasterNode(), security.GetJwt(r)); e != nil {
 			writeJsonError(w, r, http.StatusInternalServerError, fmt.Errorf("Delete chunks error: %v", e))
 			return
 		}
 		count = chunkManifest.Size
 	}
 
-	_, err := topology.ReplicatedDelete(vs.GetMasterNode(), vs.store, volumeId, n, r)
+	_, err := topology.ReplicatedDelete(vs.GetMasterNode(), vs.store, volumeId, n, r, vs.replicationOption(r, volumeId))
 
 	if err == nil {
 		m := make(map[string]int64)
 		m["size"] = count
 		writeJsonQuiet(w, r, http.StatusAccepted, m)
+	} else if err == storage.ErrPreconditionFailed {
+		writeJsonError(w, r, http.StatusPreconditionFailed, err)
 	} else {
 		writeJsonError(w, r, http.StatusInternalServerError, fmt.Errorf("Deletion Failed: %v", err))
 	}
@@ -116,6 +174,14 @@ func (vs *VolumeServer) batchDeleteHandler(w http.ResponseWriter, r *http.Reques
 		n := new(storage.Needle)
 		volumeId, _ := storage.NewVolumeId(vid)
 		n.ParsePath(id_cookie)
+		if err := vs.authorize(r, security.ActionDelete, volumeId, fid); err != nil {
+			ret = append(ret, operation.DeleteResult{
+				Fid:    fid,
+				Status: authErrorStatus(err),
+				Error:  err.Error(),
+			})
+			continue
+		}
 		glog.V(4).Infoln("batch deleting", n)
 		cookie := n.Cookie
 		if _, err := vs.store.ReadVolumeNeedle(volumeId, n); err != nil {
@@ -165,3 +231,98 @@ func (vs *VolumeServer) batchDeleteHandler(w http.ResponseWriter, r *http.Reques
 
 	writeJsonQuiet(w, r, http.StatusAccepted, ret)
 }
+
+//批量写入，multipart的每个part是一个文件，表单名称是fid，ttl和ts放在part的header中
+//同一个卷的needle只加一次锁写入，并作为一个请求复制，每个文件返回各自的结果
+func (vs *VolumeServer) batchWriteHandler(w http.ResponseWriter, r *http.Request) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		writeJsonError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	var ret []operation.BatchWriteResult
+	var volumeIds []storage.VolumeId
+	volumeNeedles := make(map[storage.VolumeId][]*storage.Needle)
+	volumeResults := make(map[storage.VolumeId][]int)
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			writeJsonError(w, r, http.StatusBadRequest, err)
+			return
+		}
+		fid := part.FormName()
+		vid, n, err := storage.NewNeedleFromPart(part, vs.FixJpgOrientation, vs.digestType)
+		part.Close()
+		if err != nil {
+			ret = append(ret, operation.BatchWriteResult{
+				Fid:    fid,
+				Status: http.StatusBadRequest,
+				Error:  err.Error(),
+			})
+			continue
+		}
+		volumeId, err := storage.NewVolumeId(vid)
+		if err != nil {
+			ret = append(ret, operation.BatchWriteResult{
+				Fid:    fid,
+				Status: http.StatusBadRequest,
+				Error:  err.Error(),
+			})
+			continue
+		}
+		if err = vs.authorize(r, security.ActionWrite, volumeId, fid); err != nil {
+			ret = append(ret, operation.BatchWriteResult{
+				Fid:    fid,
+				Status: authErrorStatus(err),
+				Error:  err.Error(),
+			})
+			continue
+		}
+		if _, found := volumeNeedles[volumeId]; !found {
+			volumeIds = append(volumeIds, volumeId)
+		}
+		volumeNeedles[volumeId] = append(volumeNeedles[volumeId], n)
+		volumeResults[volumeId] = append(volumeResults[volumeId], len(ret))
+		ret = append(ret, operation.BatchWriteResult{Fid: fid, Name: string(n.Name)})
+	}
+	for _, volumeId := range volumeIds {
+		glog.V(4).Infoln("batch writing", len(volumeNeedles[volumeId]), "needles to volume", volumeId)
+		sizes, errs := topology.ReplicatedBatchWrite(vs.GetMasterNode(),
+			vs.store, volumeId, volumeNeedles[volumeId], r, vs.replicationOption(r, volumeId))
+		for i, index := range volumeResults[volumeId] {
+			if errs[i] != nil {
+				ret[index].Status = http.StatusInternalServerError
+				ret[index].Error = errs[i].Error()
+				continue
+			}
+			ret[index].Status = http.StatusCreated
+			ret[index].Size = sizes[i]
+		}
+	}
+	writeJsonQuiet(w, r, http.StatusAccepted, ret)
+}
+
+//有访问密钥时检查请求对卷所在collection的权限，fileId不为空时也接受为这个文件签发的令牌
+func (vs *VolumeServer) authorize(r *http.Request, action security.Action, volumeId storage.VolumeId, fileId string) error {
+	collection := ""
+	if v := vs.store.GetVolume(volumeId); v != nil {
+		collection = v.Collection
+	}
+	return vs.guard.AuthorizeFile(r, action, collection, "", fileId)
+}
+
+//写入的quorum依次取请求参数，collection的设置和默认值
+func (vs *VolumeServer) replicationOption(r *http.Request, volumeId storage.VolumeId) *topology.ReplicationOption {
+	option := &topology.ReplicationOption{WriteQuorum: vs.writeQuorum, Hints: vs.hints, Keys: vs.guard.Keys, Replicas: vs.replicas}
+	if quorum, err := strconv.Atoi(r.FormValue("writeQuorum")); err == nil {
+		option.WriteQuorum = quorum
+	} else if v := vs.store.GetVolume(volumeId); v != nil {
+		if quorum, ok := vs.collectionWriteQuorums[v.Collection]; ok {
+			option.WriteQuorum = quorum
+		}
+	}
+	return option
+}
@@ -0,0 +1,45 @@
+package weed_server
+
+import (
+	"net/http"
+
+	"github.com/chrislusf/seaweedfs/weed/stats"
+)
+
+func (vs *VolumeServer) registerMetrics() {
+	vs.metrics = stats.NewRegistry()
+	vs.httpMetrics = stats.NewHTTPMetrics(vs.metrics, "seaweedfs_volume")
+	vs.metrics.Collect(vs.collectMetrics)
+}
+
+// Instrument records the requests served by the mux in the metrics, by the pattern of the handler.
+func (vs *VolumeServer) Instrument(mux *http.ServeMux) http.Handler {
+	return vs.httpMetrics.Instrument(mux, func(r *http.Request) string {
+		_, pattern := mux.Handler(r)
+		return pattern
+	})
+}
+
+func (vs *VolumeServer) collectMetrics(w *stats.MetricWriter) {
+	volumes := vs.store.Status()
+	w.Header("seaweedfs_volume_size_bytes", "Size of the volume data.", "gauge")
+	for _, v := range volumes {
+		w.Sample("seaweedfs_volume_size_bytes", stats.Labels("volume", v.Id.String(), "collection", v.Collection), float64(v.Size))
+	}
+	w.Header("seaweedfs_volume_files", "Number of files in the volume, including the deleted ones.", "gauge")
+	for _, v := range volumes {
+		w.Sample("seaweedfs_volume_files", stats.Labels("volume", v.Id.String(), "collection", v.Collection), float64(v.FileCount))
+	}
+	w.Header("seaweedfs_volume_deleted_files", "Number of deleted files in the volume, until it is vacuumed.", "gauge")
+	for _, v := range volumes {
+		w.Sample("seaweedfs_volume_deleted_files", stats.Labels("volume", v.Id.String(), "collection", v.Collection), float64(v.DeleteCount))
+	}
+	w.Header("seaweedfs_volume_garbage_ratio", "Ratio of deleted bytes in the volume, compared with -garbageThreshold of vacuum.", "gauge")
+	for _, v := range volumes {
+		ratio := 0.0
+		if v.Size > 0 {
+			ratio = float64(v.DeletedByteCount) / float64(v.Size)
+		}
+		w.Sample("seaweedfs_volume_garbage_ratio", stats.Labels("volume", v.Id.String(), "collection", v.Collection), ratio)
+	}
+}
@@ -0,0 +1,84 @@
+package stats
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// HTTPMetrics counts the requests, their latencies and bytes per handler.
+type HTTPMetrics struct {
+	requests *Counter
+	latency  *Histogram
+	bytesIn  *Counter
+	bytesOut *Counter
+}
+
+// NewHTTPMetrics registers the metrics named with the namespace, e.g., "seaweedfs_volume".
+func NewHTTPMetrics(reg *Registry, namespace string) *HTTPMetrics {
+	return &HTTPMetrics{
+		requests: reg.NewCounter(namespace+"_http_requests_total", "Number of http requests.", "handler", "method", "status"),
+		latency:  reg.NewHistogram(namespace+"_http_request_duration_seconds", "Latency of http requests.", DefaultBuckets, "handler", "method", "status"),
+		bytesIn:  reg.NewCounter(namespace+"_http_received_bytes_total", "Bytes of http request bodies.", "handler"),
+		bytesOut: reg.NewCounter(namespace+"_http_sent_bytes_total", "Bytes of http response bodies.", "handler"),
+	}
+}
+
+// Instrument records the requests served by h. route names the handler of a request,
+// e.g., its route pattern, so that the labels stay few.
+func (m *HTTPMetrics) Instrument(h http.Handler, route func(r *http.Request) string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		handler := route(r)
+		var body *countingReader
+		if r.Body != nil {
+			body = &countingReader{ReadCloser: r.Body}
+			r.Body = body
+		}
+		recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(recorder, r)
+		status := strconv.Itoa(recorder.status)
+		m.requests.Inc(handler, r.Method, status)
+		m.latency.Observe(time.Since(start).Seconds(), handler, r.Method, status)
+		if body != nil {
+			m.bytesIn.Add(float64(body.n), handler)
+		}
+		m.bytesOut.Add(float64(recorder.n), handler)
+	})
+}
+
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	n      int64
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(p []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(p)
+	r.n += int64(n)
+	return n, err
+}
+
+//分块传输时需要及时刷出
+func (r *responseRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
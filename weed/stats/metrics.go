@@ -0,0 +1,263 @@
+package stats
+
+import (
+	"bytes"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the latency buckets in seconds, the same as the Prometheus clients.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Registry holds the metrics of one server, and writes them in the Prometheus text format.
+type Registry struct {
+	sync.Mutex
+	families   []family
+	collectors []func(w *MetricWriter)
+}
+
+type family interface {
+	write(w *MetricWriter)
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (reg *Registry) NewCounter(name, help string, labelNames ...string) *Counter {
+	c := &Counter{name: name, help: help, labelNames: labelNames, values: make(map[string]*counterValue)}
+	reg.Lock()
+	reg.families = append(reg.families, c)
+	reg.Unlock()
+	return c
+}
+
+func (reg *Registry) NewHistogram(name, help string, buckets []float64, labelNames ...string) *Histogram {
+	h := &Histogram{name: name, help: help, buckets: buckets, labelNames: labelNames, values: make(map[string]*histogramValue)}
+	reg.Lock()
+	reg.families = append(reg.families, h)
+	reg.Unlock()
+	return h
+}
+
+// Collect adds a function to write the metrics read at scraping time, e.g., the volume sizes.
+func (reg *Registry) Collect(f func(w *MetricWriter)) {
+	reg.Lock()
+	reg.collectors = append(reg.collectors, f)
+	reg.Unlock()
+}
+
+func (reg *Registry) Write(w *MetricWriter) {
+	reg.Lock()
+	families := append([]family(nil), reg.families...)
+	collectors := append([](func(w *MetricWriter))(nil), reg.collectors...)
+	reg.Unlock()
+	for _, f := range families {
+		f.write(w)
+	}
+	for _, collect := range collectors {
+		collect(w)
+	}
+}
+
+// ServeHTTP serves the metrics to the Prometheus scraper.
+func (reg *Registry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	mw := &MetricWriter{}
+	reg.Write(mw)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(mw.Bytes())
+}
+
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Labels pairs up the names and values, e.g., Labels("volume", "3", "collection", "pictures").
+func Labels(nameValues ...string) []Label {
+	labels := make([]Label, 0, len(nameValues)/2)
+	for i := 0; i+1 < len(nameValues); i += 2 {
+		labels = append(labels, Label{nameValues[i], nameValues[i+1]})
+	}
+	return labels
+}
+
+// MetricWriter writes metrics in the Prometheus text format.
+type MetricWriter struct {
+	bytes.Buffer
+}
+
+// Header starts a metric family. The type is "counter", "gauge" or "histogram".
+func (w *MetricWriter) Header(name, help, typ string) {
+	w.WriteString("# HELP " + name + " " + strings.Replace(strings.Replace(help, `\`, `\\`, -1), "\n", `\n`, -1) + "\n")
+	w.WriteString("# TYPE " + name + " " + typ + "\n")
+}
+
+func (w *MetricWriter) Sample(name string, labels []Label, value float64) {
+	w.WriteString(name)
+	if len(labels) > 0 {
+		w.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(l.Name + `="` + escapeLabelValue(l.Value) + `"`)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteString(" " + formatFloat(value) + "\n")
+}
+
+// Gauge writes a metric family with a single sample without labels.
+func (w *MetricWriter) Gauge(name, help string, value float64) {
+	w.Header(name, help, "gauge")
+	w.Sample(name, nil, value)
+}
+
+// Counter writes a metric family with a single cumulative sample without labels.
+func (w *MetricWriter) Counter(name, help string, value float64) {
+	w.Header(name, help, "counter")
+	w.Sample(name, nil, value)
+}
+
+func escapeLabelValue(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, `"`, `\"`, -1)
+	return strings.Replace(s, "\n", `\n`, -1)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func labelsOf(names, values []string) []Label {
+	labels := make([]Label, len(names))
+	for i, name := range names {
+		if i < len(values) {
+			labels[i] = Label{name, values[i]}
+		} else {
+			labels[i] = Label{Name: name}
+		}
+	}
+	return labels
+}
+
+//标签值之间用不会出现在值中的字符连接
+func labelKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+// Counter is a cumulative value per label values.
+type Counter struct {
+	sync.Mutex
+	name       string
+	help       string
+	labelNames []string
+	values     map[string]*counterValue
+}
+
+type counterValue struct {
+	labels []string
+	value  float64
+}
+
+func (c *Counter) Add(value float64, labelValues ...string) {
+	key := labelKey(labelValues)
+	c.Lock()
+	defer c.Unlock()
+	v, ok := c.values[key]
+	if !ok {
+		v = &counterValue{labels: labelValues}
+		c.values[key] = v
+	}
+	v.value += value
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *Counter) write(w *MetricWriter) {
+	c.Lock()
+	defer c.Unlock()
+	w.Header(c.name, c.help, "counter")
+	keys := make([]string, 0, len(c.values))
+	for key := range c.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		v := c.values[key]
+		w.Sample(c.name, labelsOf(c.labelNames, v.labels), v.value)
+	}
+}
+
+// Histogram counts the observed values in buckets per label values.
+type Histogram struct {
+	sync.Mutex
+	name       string
+	help       string
+	buckets    []float64 //升序的上界，不含+Inf
+	labelNames []string
+	values     map[string]*histogramValue
+}
+
+type histogramValue struct {
+	labels []string
+	counts []uint64 //每个桶内的数量，最后一个是+Inf
+	sum    float64
+	count  uint64
+}
+
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	key := labelKey(labelValues)
+	h.Lock()
+	defer h.Unlock()
+	v, ok := h.values[key]
+	if !ok {
+		v = &histogramValue{labels: labelValues, counts: make([]uint64, len(h.buckets)+1)}
+		h.values[key] = v
+	}
+	i := sort.SearchFloat64s(h.buckets, value)
+	v.counts[i]++
+	v.sum += value
+	v.count++
+}
+
+func (h *Histogram) write(w *MetricWriter) {
+	h.Lock()
+	defer h.Unlock()
+	w.Header(h.name, h.help, "histogram")
+	keys := make([]string, 0, len(h.values))
+	for key := range h.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		v := h.values[key]
+		labels := labelsOf(h.labelNames, v.labels)
+		var cumulative uint64
+		for b, count := range v.counts {
+			cumulative += count
+			le := math.Inf(1)
+			if b < len(h.buckets) {
+				le = h.buckets[b]
+			}
+			w.Sample(h.name+"_bucket", append(labels, Label{"le", formatFloat(le)}), float64(cumulative))
+		}
+		w.Sample(h.name+"_sum", labels, v.sum)
+		w.Sample(h.name+"_count", labels, float64(v.count))
+	}
+}
@@ -0,0 +1,68 @@
+package stats
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetricsText(t *testing.T) {
+	reg := NewRegistry()
+	m := NewHTTPMetrics(reg, "test")
+	handler := m.Instrument(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+		if r.Method == "DELETE" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+		w.Write([]byte("hello"))
+	}), func(r *http.Request) string { return "/" })
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/3,01637037d6", strings.NewReader("0123456789")))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("DELETE", "/3,01637037d6", nil))
+	reg.Collect(func(w *MetricWriter) {
+		w.Header("test_volume_size_bytes", "Size of the volume.", "gauge")
+		w.Sample("test_volume_size_bytes", Labels("volume", "3", "collection", `a"b`), 1024)
+	})
+
+	w := httptest.NewRecorder()
+	reg.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	text := w.Body.String()
+	for _, line := range []string{
+		"# TYPE test_http_requests_total counter",
+		`test_http_requests_total{handler="/",method="DELETE",status="404"} 1`,
+		`test_http_requests_total{handler="/",method="POST",status="200"} 1`,
+		"# TYPE test_http_request_duration_seconds histogram",
+		`test_http_request_duration_seconds_bucket{handler="/",method="POST",status="200",le="+Inf"} 1`,
+		`test_http_request_duration_seconds_count{handler="/",method="POST",status="200"} 1`,
+		`test_http_received_bytes_total{handler="/"} 10`,
+		`test_http_sent_bytes_total{handler="/"} 10`,
+		`test_volume_size_bytes{volume="3",collection="a\"b"} 1024`,
+	} {
+		if !strings.Contains(text, line+"\n") {
+			t.Fatalf("missing %q in:\n%s", line, text)
+		}
+	}
+}
+
+func TestHistogramBuckets(t *testing.T) {
+	reg := NewRegistry()
+	h := reg.NewHistogram("test_seconds", "Test.", []float64{0.1, 1}, "op")
+	h.Observe(0.05, "find")
+	h.Observe(0.1, "find")
+	h.Observe(0.5, "find")
+	h.Observe(3, "find")
+	w := &MetricWriter{}
+	reg.Write(w)
+	for _, line := range []string{
+		`test_seconds_bucket{op="find",le="0.1"} 2`,
+		`test_seconds_bucket{op="find",le="1"} 3`,
+		`test_seconds_bucket{op="find",le="+Inf"} 4`,
+		`test_seconds_sum{op="find"} 3.65`,
+		`test_seconds_count{op="find"} 4`,
+	} {
+		if !strings.Contains(w.String(), line+"\n") {
+			t.Fatalf("missing %q in:\n%s", line, w.String())
+		}
+	}
+}
@@ -23,7 +23,11 @@ func (c CRC) Value() uint32 {
 	return uint32(c>>15|c<<17) + 0xa282ead8
 }
 
+// Etag is the hex of the content digest if the needle has one, otherwise of the CRC.
 func (n *Needle) Etag() string {
+	if n.HasDigest() {
+		return fmt.Sprintf("\"%x\"", n.Digest.Sum)
+	}
 	bits := make([]byte, 4)
 	util.Uint32toBytes(bits, uint32(n.Checksum))
 	return fmt.Sprintf("\"%x\"", bits)
@@ -15,6 +15,8 @@ type DiskLocation struct {
 	MaxVolumeCount int
 	//卷列表
 	volumes map[VolumeId]*Volume
+
+	health diskHealth
 }
 
 //DiskLocation构造函数
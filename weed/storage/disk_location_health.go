@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/chrislusf/seaweedfs/weed/glog"
+)
+
+const (
+	DefaultDiskMaxIOErrors = 10
+	diskErrorWindow        = time.Minute
+)
+
+// DiskHealth is the I/O error record of a DiskLocation.
+// A location is failed once it has MaxIOErrors errors within a minute,
+// and all its volumes are then read only and no longer reported to the master.
+// It also records the free space, checked against the reserve of the store.
+type DiskHealth struct {
+	Directory      string
+	Failed         bool
+	FailedAt       time.Time
+	IOErrors       uint64
+	RecentIOErrors int
+	LastError      string `json:",omitempty"`
+	LastErrorAt    time.Time
+	FreeBytes      uint64
+	LowDiskSpace   bool
+}
+
+type diskHealth struct {
+	sync.Mutex
+	ioErrors     uint64
+	windowStart  time.Time
+	windowErrors int
+	lastError    string
+	lastErrorAt  time.Time
+	failed       bool
+	failedAt     time.Time
+
+	freeBytes      uint64
+	lowDiskSpace   bool
+	spaceCheckedAt time.Time
+}
+
+//判断是否是磁盘故障导致的错误，文件不存在、已关闭等不算
+func isDiskIOError(err error) bool {
+	var errno error
+	switch e := err.(type) {
+	case *os.PathError:
+		errno = e.Err
+	case *os.SyscallError:
+		errno = e.Err
+	case syscall.Errno:
+		errno = e
+	default:
+		return false
+	}
+	switch errno {
+	case syscall.EIO, syscall.ENXIO, syscall.ENODEV, syscall.EROFS:
+		return true
+	}
+	return false
+}
+
+// recordIOError counts the error if it is a disk I/O error,
+// and returns true if the location just turned failed.
+func (l *DiskLocation) recordIOError(err error, maxErrors int) bool {
+	if err == nil || !isDiskIOError(err) {
+		return false
+	}
+	h := &l.health
+	h.Lock()
+	defer h.Unlock()
+	now := time.Now()
+	h.ioErrors++
+	if now.Sub(h.windowStart) > diskErrorWindow {
+		h.windowStart, h.windowErrors = now, 0
+	}
+	h.windowErrors++
+	h.lastError, h.lastErrorAt = err.Error(), now
+	glog.V(0).Infof("disk %s I/O error %d in the last minute: %v", l.Directory, h.windowErrors, err)
+	if h.failed || maxErrors <= 0 || h.windowErrors < maxErrors {
+		return false
+	}
+	h.failed, h.failedAt = true, now
+	return true
+}
+
+func (l *DiskLocation) IsFailed() bool {
+	l.health.Lock()
+	defer l.health.Unlock()
+	return l.health.failed
+}
+
+//磁盘故障后，所有卷设为只读
+func (l *DiskLocation) quarantine() {
+	glog.Errorf("disk %s has failed, marking its %d volumes read only", l.Directory, len(l.volumes))
+	for _, v := range l.volumes {
+		v.setReadOnly(true)
+	}
+}
+
+func (l *DiskLocation) Health() DiskHealth {
+	h := &l.health
+	h.Lock()
+	defer h.Unlock()
+	ret := DiskHealth{
+		Directory:    l.Directory,
+		Failed:       h.failed,
+		FailedAt:     h.failedAt,
+		IOErrors:     h.ioErrors,
+		LastError:    h.lastError,
+		LastErrorAt:  h.lastErrorAt,
+		FreeBytes:    h.freeBytes,
+		LowDiskSpace: h.lowDiskSpace,
+	}
+	if time.Since(h.windowStart) <= diskErrorWindow {
+		ret.RecentIOErrors = h.windowErrors
+	}
+	return ret
+}
@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestDiskLocationQuarantine(t *testing.T) {
+	dir, err := ioutil.TempDir("", "disk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := NewStore(8080, "localhost", "localhost:8080", []string{dir}, []int{3}, NeedleMapInMemory)
+	s.SetMaxDiskIOErrors(3)
+	if err := s.AddVolume("1", "", NeedleMapInMemory, "000", ""); err != nil {
+		t.Fatalf("add volume: %v", err)
+	}
+	defer s.Close()
+
+	ioError := &os.PathError{Op: "read", Path: dir + "/1.dat", Err: syscall.EIO}
+	s.checkDiskError(1, errors.New("Not Found"))
+	s.checkDiskError(1, ioError)
+	s.checkDiskError(1, ioError)
+	if s.Locations()[0].IsFailed() || s.GetVolume(1).isReadOnly() {
+		t.Fatalf("disk should not fail before reaching the error threshold")
+	}
+	s.checkDiskError(1, ioError)
+	health := s.DiskHealths()[0]
+	if !health.Failed || health.IOErrors != 3 || health.RecentIOErrors != 3 {
+		t.Fatalf("unexpected disk health %+v", health)
+	}
+	if !s.GetVolume(1).isReadOnly() {
+		t.Fatalf("volumes on a failed disk should be read only")
+	}
+	select {
+	case <-s.DiskFailed():
+	default:
+		t.Fatalf("expected the heartbeat to be signaled")
+	}
+	if s.findFreeLocation() != nil {
+		t.Fatalf("failed disk should not get new volumes")
+	}
+}
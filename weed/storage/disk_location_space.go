@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"path/filepath"
+	"time"
+
+	"github.com/chrislusf/seaweedfs/weed/glog"
+	"github.com/chrislusf/seaweedfs/weed/stats"
+)
+
+const (
+	DefaultDiskReserveMB   = 0 //默认不保留，升级后的行为不变
+	diskSpaceCheckInterval = time.Second
+)
+
+// checkDiskSpace refreshes the free space of the location, at most once a second unless forced,
+// and returns true if it is below the reserve. The space is unknown, and never low,
+// on platforms without disk stats.
+func (l *DiskLocation) checkDiskSpace(reserveBytes uint64, force bool) bool {
+	h := &l.health
+	h.Lock()
+	defer h.Unlock()
+	now := time.Now()
+	if !force && now.Sub(h.spaceCheckedAt) < diskSpaceCheckInterval {
+		return h.lowDiskSpace
+	}
+	h.spaceCheckedAt = now
+	dir, err := filepath.Abs(l.Directory)
+	if err != nil {
+		return h.lowDiskSpace
+	}
+	disk := stats.NewDiskStatus(dir)
+	if disk.All == 0 {
+		return h.lowDiskSpace
+	}
+	h.freeBytes = disk.Free
+	low := reserveBytes > 0 && disk.Free < reserveBytes
+	if low != h.lowDiskSpace {
+		if low {
+			glog.Warningf("disk %s has %d bytes free, below the reserve of %d bytes, stop writing to it", l.Directory, disk.Free, reserveBytes)
+		} else {
+			glog.V(0).Infof("disk %s has %d bytes free, writable again", l.Directory, disk.Free)
+		}
+		h.lowDiskSpace = low
+	}
+	return low
+}
+
+func (l *DiskLocation) IsLowDiskSpace() bool {
+	l.health.Lock()
+	defer l.health.Unlock()
+	return l.health.lowDiskSpace
+}
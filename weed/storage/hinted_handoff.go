@@ -0,0 +1,326 @@
+package storage
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/chrislusf/seaweedfs/weed/glog"
+	"github.com/chrislusf/seaweedfs/weed/util"
+)
+
+const (
+	hintFileExt      = ".hints"
+	hintCorruptedExt = ".corrupted" //无法解析或者被副本拒绝的hint另存到这个后缀的文件
+	hintHeaderSize   = 10
+	HintTypeWrite    = byte(1)
+	HintTypeDelete   = byte(2)
+)
+
+/*
+A Hint is a write or delete that failed on one replica.
+The hints are kept in a directory per replica, with a file per volume,
+so that a volume the replica cannot catch up does not hold back the others.
+Each hint file is a list of records:
+  type 1 byte, volume id 4 bytes, version 1 byte, payload length 4 bytes, payload
+For writes the payload is the needle serialized by Needle.Append,
+for deletes it is the url path of the deleted file.
+*/
+type Hint struct {
+	Type     byte
+	VolumeId VolumeId
+	Version  Version
+	Payload  []byte
+}
+
+// HintRejected wraps the error of a hint that the replica will never accept,
+// e.g., because the volume is no longer there. Such hints are set aside instead of retried.
+type HintRejected struct {
+	Err error
+}
+
+func (e HintRejected) Error() string {
+	return e.Err.Error()
+}
+
+// HintQueue durably keeps hints for replicas that missed writes, until they are replayed.
+type HintQueue struct {
+	dir        string
+	lock       sync.Mutex //保护hint文件的追加和截断
+	replayLock sync.Mutex //同一时间只有一个重放
+}
+
+func NewHintQueue(dir string) (*HintQueue, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("create hint directory %s: %v", dir, err)
+	}
+	return &HintQueue{dir: dir}, nil
+}
+
+func (q *HintQueue) replicaDir(replica string) string {
+	return filepath.Join(q.dir, url.QueryEscape(replica))
+}
+
+func (q *HintQueue) fileName(replica string, volumeId VolumeId) string {
+	return filepath.Join(q.replicaDir(replica), volumeId.String()+hintFileExt)
+}
+
+// Add appends the hint to the replica's hint file of the volume, and syncs it to disk.
+func (q *HintQueue) Add(replica string, h *Hint) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if err := os.MkdirAll(q.replicaDir(replica), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(q.fileName(replica, h.VolumeId), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	header := make([]byte, hintHeaderSize)
+	header[0] = h.Type
+	util.Uint32toBytes(header[1:5], uint32(h.VolumeId))
+	header[5] = byte(h.Version)
+	util.Uint32toBytes(header[6:10], uint32(len(h.Payload)))
+	if _, err = f.Write(append(header, h.Payload...)); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// HasHints tells if the replica has hints of the volume waiting to be replayed.
+func (q *HintQueue) HasHints(replica string, volumeId VolumeId) bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	fi, err := os.Stat(q.fileName(replica, volumeId))
+	return err == nil && fi.Size() > 0
+}
+
+// Pending returns the bytes of hints waiting for each replica.
+func (q *HintQueue) Pending() map[string]int64 {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	ret := make(map[string]int64)
+	dirInfos, err := ioutil.ReadDir(q.dir)
+	if err != nil {
+		return ret
+	}
+	for _, di := range dirInfos {
+		if !di.IsDir() {
+			continue
+		}
+		replica, err := url.QueryUnescape(di.Name())
+		if err != nil {
+			continue
+		}
+		var total int64
+		for _, size := range q.volumeFiles(replica) {
+			total += size
+		}
+		if total > 0 {
+			ret[replica] = total
+		}
+	}
+	return ret
+}
+
+// volumeFiles returns the size of the replica's hint file of each volume.
+func (q *HintQueue) volumeFiles(replica string) map[VolumeId]int64 {
+	ret := make(map[VolumeId]int64)
+	fileInfos, err := ioutil.ReadDir(q.replicaDir(replica))
+	if err != nil {
+		return ret
+	}
+	for _, fi := range fileInfos {
+		if !strings.HasSuffix(fi.Name(), hintFileExt) {
+			continue
+		}
+		if volumeId, err := NewVolumeId(strings.TrimSuffix(fi.Name(), hintFileExt)); err == nil {
+			ret[volumeId] = fi.Size()
+		}
+	}
+	return ret
+}
+
+// Replay calls fn on the replica's hints, in order for each volume, and removes the replayed ones.
+// A hint failed with HintRejected is set aside. Other errors stop the replay of that volume,
+// keeping its remaining hints for the next replay, and the other volumes go on.
+// The hints are replayed without holding the lock, so that a slow replica does not block Add.
+func (q *HintQueue) Replay(replica string, fn func(h *Hint) error) (replayed int, err error) {
+	q.replayLock.Lock()
+	defer q.replayLock.Unlock()
+	q.lock.Lock()
+	volumes := q.volumeFiles(replica)
+	q.lock.Unlock()
+	for volumeId := range volumes {
+		n, e := q.replayVolume(replica, volumeId, fn)
+		replayed += n
+		if e != nil {
+			err = e
+		}
+	}
+	q.lock.Lock()
+	//没有hint之后删除副本的目录，还有文件时会失败
+	os.Remove(q.replicaDir(replica))
+	q.lock.Unlock()
+	return replayed, err
+}
+
+func (q *HintQueue) replayVolume(replica string, volumeId VolumeId, fn func(h *Hint) error) (replayed int, err error) {
+	fileName := q.fileName(replica, volumeId)
+	//只重放此刻已有的hint，之后追加的留到下次
+	q.lock.Lock()
+	fi, err := os.Stat(fileName)
+	q.lock.Unlock()
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	snapshotSize := fi.Size()
+	f, err := os.Open(fileName)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	var offset int64
+	corrupted := false
+	r := bufio.NewReader(io.LimitReader(f, snapshotSize))
+	for offset < snapshotSize {
+		h, size, readErr := readHint(r, snapshotSize-offset)
+		if readErr != nil {
+			err = fmt.Errorf("read hint of %s volume %d at %d: %v", replica, volumeId, offset, readErr)
+			corrupted = true
+			break
+		}
+		if err = fn(h); err != nil {
+			if _, ok := err.(HintRejected); !ok {
+				break
+			}
+			//副本永远不会接受的hint另存，供人工检查，然后继续重放
+			q.lock.Lock()
+			e := q.setAside(fileName, offset, offset+size)
+			q.lock.Unlock()
+			if e != nil {
+				err = e
+				break
+			}
+			glog.Errorf("Set aside hint of volume %d rejected by %s to %s: %v", volumeId, replica, fileName+hintCorruptedExt, err)
+			err = nil
+		} else {
+			replayed++
+		}
+		offset += size
+	}
+	f.Close()
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if corrupted {
+		//无法解析的部分另存，供人工检查，之后追加的hint照常重放
+		if e := q.setAside(fileName, offset, snapshotSize); e != nil {
+			return replayed, e
+		}
+		glog.Errorf("Set aside %d bytes of corrupted hints of %s to %s: %v", snapshotSize-offset, replica, fileName+hintCorruptedExt, err)
+		offset = snapshotSize
+	}
+	if e := q.removeReplayed(fileName, offset); e != nil {
+		return replayed, e
+	}
+	return replayed, err
+}
+
+// readHint reads one hint, which should not be larger than maxSize.
+func readHint(r io.Reader, maxSize int64) (h *Hint, size int64, err error) {
+	header := make([]byte, hintHeaderSize)
+	if _, err = io.ReadFull(r, header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = fmt.Errorf("truncated hint header")
+		}
+		return
+	}
+	h = &Hint{
+		Type:     header[0],
+		VolumeId: VolumeId(util.BytesToUint32(header[1:5])),
+		Version:  Version(header[5]),
+	}
+	payloadSize := util.BytesToUint32(header[6:10])
+	if int64(hintHeaderSize)+int64(payloadSize) > maxSize {
+		return nil, 0, fmt.Errorf("truncated hint payload of %d bytes", payloadSize)
+	}
+	h.Payload = make([]byte, payloadSize)
+	if _, err = io.ReadFull(r, h.Payload); err != nil {
+		return nil, 0, fmt.Errorf("truncated hint payload: %v", err)
+	}
+	return h, int64(hintHeaderSize + payloadSize), nil
+}
+
+// removeReplayed removes the hints before offset, keeping the ones after it,
+// including those added during the replay. The lock should be held.
+func (q *HintQueue) removeReplayed(fileName string, offset int64) error {
+	if offset == 0 {
+		return nil
+	}
+	f, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if offset >= fi.Size() {
+		f.Close()
+		return os.Remove(fileName)
+	}
+	if _, err := f.Seek(offset, 0); err != nil {
+		return err
+	}
+	tmpName := fileName + ".tmp"
+	dst, err := os.OpenFile(tmpName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(dst, f); err == nil {
+		err = dst.Sync()
+	}
+	if e := dst.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	return os.Rename(tmpName, fileName)
+}
+
+// setAside appends the bytes from offset to end of the hint file to its corrupted file.
+func (q *HintQueue) setAside(fileName string, offset, end int64) error {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err = f.Seek(offset, 0); err != nil {
+		return err
+	}
+	dst, err := os.OpenFile(fileName+hintCorruptedExt, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = io.CopyN(dst, f, end-offset); err == nil {
+		err = dst.Sync()
+	}
+	if e := dst.Close(); err == nil {
+		err = e
+	}
+	return err
+}
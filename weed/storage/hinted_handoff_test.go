@@ -0,0 +1,155 @@
+package storage
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestHintQueueReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hints")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	q, err := NewHintQueue(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replica := "127.0.0.1:8080"
+	for i := 1; i <= 3; i++ {
+		//卷1有两条hint，卷2有一条
+		h := &Hint{Type: HintTypeWrite, VolumeId: VolumeId(1 + i/3), Version: Version2, Payload: []byte{byte(i)}}
+		if err := q.Add(replica, h); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !q.HasHints(replica, 1) || !q.HasHints(replica, 2) || q.Pending()[replica] == 0 {
+		t.Fatalf("expected pending hints for %s", replica)
+	}
+	if q.HasHints(replica, 3) {
+		t.Fatalf("unexpected hints for volume 3")
+	}
+
+	//卷1的失败不影响卷2
+	var replayedPayloads []byte
+	replayed, err := q.Replay(replica, func(h *Hint) error {
+		if h.Payload[0] == 1 {
+			return errors.New("replica is down")
+		}
+		replayedPayloads = append(replayedPayloads, h.Payload[0])
+		return nil
+	})
+	if err == nil || replayed != 1 || len(replayedPayloads) != 1 || replayedPayloads[0] != 3 {
+		t.Fatalf("expected the hint of volume 2 replayed and an error, got %d, %v, %v", replayed, replayedPayloads, err)
+	}
+	if !q.HasHints(replica, 1) || q.HasHints(replica, 2) {
+		t.Fatalf("expected hints only for volume 1")
+	}
+
+	replayedPayloads = nil
+	replayed, err = q.Replay(replica, func(h *Hint) error {
+		if h.VolumeId != 1 {
+			t.Errorf("unexpected hint of volume %d", h.VolumeId)
+		}
+		replayedPayloads = append(replayedPayloads, h.Payload[0])
+		return nil
+	})
+	if err != nil || replayed != 2 {
+		t.Fatalf("expected 2 replayed hints, got %d, %v", replayed, err)
+	}
+	if len(replayedPayloads) != 2 || replayedPayloads[0] != 1 || replayedPayloads[1] != 2 {
+		t.Fatalf("unexpected replay order %v", replayedPayloads)
+	}
+	if q.HasHints(replica, 1) || len(q.Pending()) != 0 {
+		t.Fatalf("expected no more hints for %s", replica)
+	}
+}
+
+func TestHintQueueReplayRejected(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hints")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	q, err := NewHintQueue(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replica := "127.0.0.1:8080"
+	for i := 1; i <= 2; i++ {
+		if err := q.Add(replica, &Hint{Type: HintTypeWrite, VolumeId: 1, Version: Version2, Payload: []byte{byte(i)}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var replayedPayloads []byte
+	replayed, err := q.Replay(replica, func(h *Hint) error {
+		if h.Payload[0] == 1 {
+			return HintRejected{errors.New("volume 1 not found")}
+		}
+		replayedPayloads = append(replayedPayloads, h.Payload[0])
+		return nil
+	})
+	if err != nil || replayed != 1 || replayedPayloads[0] != 2 {
+		t.Fatalf("expected the hint after the rejected one replayed, got %d, %v, %v", replayed, replayedPayloads, err)
+	}
+	if fi, err := os.Stat(q.fileName(replica, 1) + hintCorruptedExt); err != nil || fi.Size() != hintHeaderSize+1 {
+		t.Fatalf("expected the rejected hint to be set aside: %v", err)
+	}
+	if q.HasHints(replica, 1) {
+		t.Fatalf("expected no more hints for %s", replica)
+	}
+}
+
+func TestHintQueueReplayCorrupted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hints")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	q, err := NewHintQueue(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replica := "127.0.0.1:8080"
+	if err := q.Add(replica, &Hint{Type: HintTypeWrite, VolumeId: 1, Version: Version2, Payload: []byte{1}}); err != nil {
+		t.Fatal(err)
+	}
+	//一条长度超出文件的记录
+	f, err := os.OpenFile(q.fileName(replica, 1), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{HintTypeWrite, 0, 0, 0, 2, byte(Version2), 0, 0, 1, 0, 2})
+	f.Close()
+
+	//重放时追加的hint要保留
+	replayed, err := q.Replay(replica, func(h *Hint) error {
+		if err := q.Add(replica, &Hint{Type: HintTypeDelete, VolumeId: 1, Version: Version2, Payload: []byte("/1,01")}); err != nil {
+			t.Fatal(err)
+		}
+		return nil
+	})
+	if err == nil || replayed != 1 {
+		t.Fatalf("expected 1 replayed hint and an error, got %d, %v", replayed, err)
+	}
+	if fi, err := os.Stat(q.fileName(replica, 1) + hintCorruptedExt); err != nil || fi.Size() != 11 {
+		t.Fatalf("expected the corrupted hint to be set aside: %v", err)
+	}
+
+	var replayedTypes []byte
+	replayed, err = q.Replay(replica, func(h *Hint) error {
+		replayedTypes = append(replayedTypes, h.Type)
+		return nil
+	})
+	if err != nil || replayed != 1 || replayedTypes[0] != HintTypeDelete {
+		t.Fatalf("expected the hint added during the replay, got %d, %v, %v", replayed, replayedTypes, err)
+	}
+	if q.HasHints(replica, 1) {
+		t.Fatalf("expected no more hints for %s", replica)
+	}
+}
@@ -1,10 +1,13 @@
 package storage
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"mime"
+	"mime/multipart"
 	"net/http"
+	"net/textproto"
 	"path"
 	"strconv"
 	"strings"
@@ -20,6 +23,8 @@ const (
 	NeedlePaddingSize     = 8                          //针的宽大小
 	NeedleChecksumSize    = 4                          //针的checksum的大小
 	MaxPossibleVolumeSize = 4 * 1024 * 1024 * 1024 * 8 //最大可能的卷大小
+	PairNamePrefix        = "Seaweed-"                 //用户元数据header的前缀
+	MaxPairsSize          = 64*1024 - 1                //用户元数据序列化后的最大长度
 )
 
 /*
@@ -34,15 +39,18 @@ type Needle struct {
 	Id     uint64 `comment:"needle id"`                                        //针文件的id
 	Size   uint32 `comment:"sum of DataSize,Data,NameSize,Name,MimeSize,Mime"` //文件的大小，包括(DataSize,Data,NameSize,Name,MimeSize,Mime)
 
-	DataSize     uint32 `comment:"Data size"`            //version2 //数据的大小
-	Data         []byte `comment:"The actual file data"` //数据
-	Flags        byte   `comment:"boolean flags"`        //version2
-	NameSize     uint8  //version2 	//名字的大小
-	Name         []byte `comment:"maximum 256 characters"` //version2 //名字
-	MimeSize     uint8  //version2 //mime的大小
-	Mime         []byte `comment:"maximum 256 characters"` //version2 //mime
-	LastModified uint64 //only store LastModifiedBytesLength bytes, which is 5 bytes to disk //最后被修改的时间
-	Ttl          *TTL   //过期时间
+	DataSize     uint32       `comment:"Data size"`            //version2 //数据的大小
+	Data         []byte       `comment:"The actual file data"` //数据
+	Flags        byte         `comment:"boolean flags"`        //version2
+	NameSize     uint8        //version2 	//名字的大小
+	Name         []byte       `comment:"maximum 256 characters"` //version2 //名字
+	MimeSize     uint8        //version2 //mime的大小
+	Mime         []byte       `comment:"maximum 256 characters"` //version2 //mime
+	LastModified uint64       //only store LastModifiedBytesLength bytes, which is 5 bytes to disk //最后被修改的时间
+	Ttl          *TTL         //过期时间
+	PairsSize    uint16       //version2 //用户元数据的大小
+	Pairs        []byte       `comment:"user metadata in json"` //version2 //用户元数据
+	Digest       NeedleDigest //version2 //内容的md5或者sha256摘要
 
 	Checksum CRC    `comment:"CRC32 to check integrity"` //一致性校验码
 	Padding  []byte `comment:"Aligned to 8 bytes"`       //补充
@@ -57,9 +65,11 @@ func (n *Needle) String() (str string) {
 }
 
 //解析上传的文件
-func ParseUpload(r *http.Request) (
-	fileName string, data []byte, mimeType string, isGzipped bool,
+func ParseUpload(r *http.Request, digestType DigestType) (
+	fileName string, data []byte, mimeType string, pairMap map[string]string, digest NeedleDigest, isGzipped bool,
 	modifiedTime uint64, ttl *TTL, isChunkedFile bool, e error) {
+	//解析Seaweed-开头的header作为用户元数据
+	pairMap = parsePairs(r.Header)
 	//解析MultipartReader头
 	form, fe := r.MultipartReader()
 	//如果报错,打log返回
@@ -122,6 +132,31 @@ func ParseUpload(r *http.Request) (
 			break
 		}
 	}
+	//计算摘要，part中没有Content-MD5时使用请求的Content-MD5
+	contentMD5 := part.Header.Get("Content-MD5")
+	if contentMD5 == "" {
+		contentMD5 = r.Header.Get("Content-MD5")
+	}
+	if digest, e = computeDigest(data, part.Header.Get("Content-Encoding") == "gzip", contentMD5, digestType); e != nil {
+		return
+	}
+	fileName, data, mimeType, isGzipped, e = parseUploadPart(fileName, data, part.Header)
+	if e != nil {
+		return
+	}
+	//解析ts
+	modifiedTime, _ = strconv.ParseUint(r.FormValue("ts"), 10, 64)
+	//解析过期时间
+	ttl, _ = ReadTTL(r.FormValue("ttl"))
+	//解析cm
+	isChunkedFile, _ = strconv.ParseBool(r.FormValue("cm"))
+	return
+}
+
+//根据文件名和part的header解析mime类型，并判断是否压缩，可压缩的内容直接压缩
+func parseUploadPart(name string, content []byte, header textproto.MIMEHeader) (
+	fileName string, data []byte, mimeType string, isGzipped bool, e error) {
+	fileName, data = name, content
 	//对文件名进行解析
 	dotIndex := strings.LastIndex(fileName, ".")
 	ext, mtype := "", ""
@@ -131,13 +166,13 @@ func ParseUpload(r *http.Request) (
 		mtype = mime.TypeByExtension(ext)
 	}
 	//解析header头里面的content-type
-	contentType := part.Header.Get("Content-Type")
+	contentType := header.Get("Content-Type")
 	if contentType != "" && mtype != contentType {
 		mimeType = contentType //only return mime type if not deductable
 		mtype = contentType
 	}
 	//解析header头，Content-Encoding，看是否被压缩
-	if part.Header.Get("Content-Encoding") == "gzip" {
+	if header.Get("Content-Encoding") == "gzip" {
 		isGzipped = true
 	} else if operation.IsGzippable(ext, mtype) {
 		if data, e = operation.GzipData(data); e != nil {
@@ -154,26 +189,42 @@ func ParseUpload(r *http.Request) (
 		!strings.HasSuffix(fileName, ".tar.gz") {
 		fileName = fileName[:len(fileName)-3]
 	}
-	//解析ts
-	modifiedTime, _ = strconv.ParseUint(r.FormValue("ts"), 10, 64)
-	//解析过期时间
-	ttl, _ = ReadTTL(r.FormValue("ttl"))
-	//解析cm
-	isChunkedFile, _ = strconv.ParseBool(r.FormValue("cm"))
 	return
 }
 
 //针文件的构造函数
-func NewNeedle(r *http.Request, fixJpgOrientation bool) (n *Needle, e error) {
+func NewNeedle(r *http.Request, fixJpgOrientation bool, digestType DigestType) (n *Needle, e error) {
 	//声明变量
 	fname, mimeType, isGzipped, isChunkedFile := "", "", false, false
+	var pairMap map[string]string
+	var digest NeedleDigest
 	//申请内存
 	n = new(Needle)
 	//解析上传的文件
-	fname, n.Data, mimeType, isGzipped, n.LastModified, n.Ttl, isChunkedFile, e = ParseUpload(r)
+	fname, n.Data, mimeType, pairMap, digest, isGzipped, n.LastModified, n.Ttl, isChunkedFile, e = ParseUpload(r, digestType)
 	if e != nil {
 		return
 	}
+	n.SetDigest(digest)
+	if e = n.SetPairs(pairMap); e != nil {
+		return
+	}
+	n.setUploadFlags(fname, mimeType, isGzipped, isChunkedFile, fixJpgOrientation)
+	//文件名的解析
+	commaSep := strings.LastIndex(r.URL.Path, ",")
+	dotSep := strings.LastIndex(r.URL.Path, ".")
+	fid := r.URL.Path[commaSep+1:]
+	if dotSep > 0 {
+		fid = r.URL.Path[commaSep+1 : dotSep]
+	}
+
+	e = n.ParsePath(fid)
+
+	return
+}
+
+//根据上传的信息设置针文件的名称，mime和标志位，并计算校验码
+func (n *Needle) setUploadFlags(fname string, mimeType string, isGzipped bool, isChunkedFile bool, fixJpgOrientation bool) {
 	//如果文件名称小于256
 	if len(fname) < 256 {
 		n.Name = []byte(fname)
@@ -208,22 +259,98 @@ func NewNeedle(r *http.Request, fixJpgOrientation bool) (n *Needle, e error) {
 			n.Data = images.FixJpgOrientation(n.Data)
 		}
 	}
-
 	//校验码
 	n.Checksum = NewCRC(n.Data)
-	//文件名的解析
-	commaSep := strings.LastIndex(r.URL.Path, ",")
-	dotSep := strings.LastIndex(r.URL.Path, ".")
-	fid := r.URL.Path[commaSep+1:]
-	if dotSep > 0 {
-		fid = r.URL.Path[commaSep+1 : dotSep]
-	}
+}
 
-	e = n.ParsePath(fid)
+//从批量上传的一个part构造针文件，part的表单名称是fid，ts，ttl和用户元数据放在part的header中
+func NewNeedleFromPart(part *multipart.Part, fixJpgOrientation bool, digestType DigestType) (vid string, n *Needle, e error) {
+	vid, keyCookie, e := operation.ParseFileId(part.FormName())
+	if e != nil {
+		return
+	}
+	if dotSep := strings.LastIndex(keyCookie, "."); dotSep > 0 {
+		keyCookie = keyCookie[:dotSep]
+	}
+	n = new(Needle)
+	if e = n.ParsePath(keyCookie); e != nil {
+		return
+	}
+	fname := part.FileName()
+	if fname != "" {
+		fname = path.Base(fname)
+	}
+	data, e := ioutil.ReadAll(part)
+	if e != nil {
+		return
+	}
+	digest, e := computeDigest(data, part.Header.Get("Content-Encoding") == "gzip", part.Header.Get("Content-MD5"), digestType)
+	if e != nil {
+		return
+	}
+	n.SetDigest(digest)
+	mimeType, isGzipped := "", false
+	if fname, n.Data, mimeType, isGzipped, e = parseUploadPart(fname, data, part.Header); e != nil {
+		return
+	}
+	if ts := part.Header.Get("Ts"); ts != "" {
+		if n.LastModified, e = strconv.ParseUint(ts, 10, 64); e != nil {
+			return
+		}
+	}
+	if n.Ttl, e = ReadTTL(part.Header.Get("Ttl")); e != nil {
+		return
+	}
+	if e = n.SetPairs(parsePairs(part.Header)); e != nil {
+		return
+	}
+	n.setUploadFlags(fname, mimeType, isGzipped, false, fixJpgOrientation)
+	return
+}
 
+//从header中取出Seaweed-开头的用户元数据，保留完整的header名称
+func parsePairs(header map[string][]string) (pairMap map[string]string) {
+	for k, v := range header {
+		if len(v) > 0 && strings.HasPrefix(k, PairNamePrefix) {
+			if pairMap == nil {
+				pairMap = make(map[string]string)
+			}
+			pairMap[k] = v[0]
+		}
+	}
 	return
 }
 
+// SetPairs stores the user metadata in the needle as json.
+func (n *Needle) SetPairs(pairMap map[string]string) error {
+	if len(pairMap) == 0 {
+		return nil
+	}
+	pairs, err := json.Marshal(pairMap)
+	if err != nil {
+		return err
+	}
+	if len(pairs) > MaxPairsSize {
+		return fmt.Errorf("user metadata of %d bytes exceeds %d bytes", len(pairs), MaxPairsSize)
+	}
+	n.Pairs = pairs
+	n.SetHasPairs()
+	return nil
+}
+
+// PairMap returns the user metadata stored in the needle, keyed by the header names.
+func (n *Needle) PairMap() map[string]string {
+	if !n.HasPairs() || len(n.Pairs) == 0 {
+		return nil
+	}
+	pairMap := make(map[string]string)
+	if err := json.Unmarshal(n.Pairs, &pairMap); err != nil {
+		glog.V(0).Infof("failed to parse user metadata of needle %d: %v", n.Id, err)
+		return nil
+	}
+	return pairMap
+}
+
 //解析路径
 func (n *Needle) ParsePath(fid string) (err error) {
 	//先获取fid的长度
@@ -1,18 +1,33 @@
 package storage
 
 import (
+	"container/list"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
 
-	"github.com/hashicorp/golang-lru"
-
+	"github.com/chrislusf/seaweedfs/weed/glog"
 	"github.com/chrislusf/seaweedfs/weed/util"
 )
 
+const (
+	DefaultNeedleCacheSizeMB    = 64
+	DefaultNeedleCacheMaxItemKB = 1024
+	ssdCacheFileExt             = ".ncache"
+)
+
 var (
-	bytesCache *lru.Cache
-	bytesPool  *util.BytesPool
+	needleCache *NeedleCache
+	bytesPool   *util.BytesPool
+
+	//每次加载卷文件分配一个新的序号，作为缓存key的一部分，
+	//压缩或者重新加载后旧的缓存自然失效
+	volumeLoadSequence uint64
 )
 
 /*
@@ -20,12 +35,15 @@ There are one level of caching, and one level of pooling.
 
 In pooling, all []byte are fetched and returned to the pool bytesPool.
 
-In caching, the string~[]byte mapping is cached
+In caching, the needle blobs read from the volume data files are kept in
+memory within a byte budget, optionally partitioned by collection,
+and those evicted from memory can be kept in a directory on a local SSD.
 */
 func init() {
 	bytesPool = util.NewBytesPool()
-	bytesCache, _ = lru.NewWithEvict(512, func(key interface{}, value interface{}) {
-		value.(*Block).decreaseReference()
+	needleCache, _ = NewNeedleCache(NeedleCacheOption{
+		SizeMB:    DefaultNeedleCacheSizeMB,
+		MaxItemKB: DefaultNeedleCacheMaxItemKB,
 	})
 }
 
@@ -43,26 +61,15 @@ func (block *Block) increaseReference() {
 	atomic.AddInt32(&block.refCount, 1)
 }
 
-// get bytes from the LRU cache of []byte first, then from the bytes pool
-// when []byte in LRU cache is evicted, it will be put back to the bytes pool
+// get the []byte from the bytes pool and read the file block into it, without caching
 func getBytesForFileBlock(r *os.File, offset int64, readSize int) (dataSlice []byte, block *Block, err error) {
-	// check cache, return if found
-	cacheKey := fmt.Sprintf("%d:%d:%d", r.Fd(), offset>>3, readSize)
-	if obj, found := bytesCache.Get(cacheKey); found {
-		block = obj.(*Block)
-		block.increaseReference()
-		dataSlice = block.Bytes[0:readSize]
-		return dataSlice, block, nil
-	}
-
-	// get the []byte from pool
-	b := bytesPool.Get(readSize)
-	// refCount = 2, one by the bytesCache, one by the actual needle object
-	block = &Block{Bytes: b, refCount: 2}
+	block = &Block{Bytes: bytesPool.Get(readSize), refCount: 1}
 	dataSlice = block.Bytes[0:readSize]
-	_, err = r.ReadAt(dataSlice, offset)
-	bytesCache.Add(cacheKey, block)
-	return dataSlice, block, err
+	if _, err = r.ReadAt(dataSlice, offset); err != nil {
+		block.decreaseReference()
+		return nil, nil, err
+	}
+	return dataSlice, block, nil
 }
 
 func (n *Needle) ReleaseMemory() {
@@ -73,3 +80,350 @@ func (n *Needle) ReleaseMemory() {
 func ReleaseBytes(b []byte) {
 	bytesPool.Put(b)
 }
+
+// NeedleCacheOption configures the cache of needles read from volume data files.
+type NeedleCacheOption struct {
+	SizeMB      int    // memory shared by collections without their own partition, 0 to disable
+	MaxItemKB   int    // larger needles are read without caching
+	Collections string // per collection partitions, collection:sizeMB,..., a size of 0 bypasses the cache
+	SsdDir      string // directory to keep needles evicted from memory, usually on a local SSD
+	SsdSizeMB   int
+}
+
+// NeedleCache is a byte sized LRU cache of needle blobs,
+// with an optional second tier in a local directory.
+type NeedleCache struct {
+	maxItemSize      int
+	defaultPartition *cachePartition
+	partitions       map[string]*cachePartition //nil表示该集合不使用缓存
+	ssd              *ssdCache
+}
+
+type CacheStats struct {
+	Capacity  int64
+	Bytes     int64
+	Items     int
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	Rejected  uint64 `json:",omitempty"`
+}
+
+type NeedleCacheStats struct {
+	MaxItemSize int
+	Default     *CacheStats           `json:",omitempty"`
+	Collections map[string]CacheStats `json:",omitempty"`
+	Bypassed    []string              `json:",omitempty"`
+	Ssd         *CacheStats           `json:",omitempty"`
+}
+
+func NewNeedleCache(option NeedleCacheOption) (*NeedleCache, error) {
+	c := &NeedleCache{
+		maxItemSize: option.MaxItemKB * 1024,
+		partitions:  make(map[string]*cachePartition),
+	}
+	if option.SsdDir != "" && option.SsdSizeMB > 0 {
+		ssd, err := newSsdCache(option.SsdDir, int64(option.SsdSizeMB)*1024*1024)
+		if err != nil {
+			return nil, err
+		}
+		c.ssd = ssd
+	}
+	c.defaultPartition = c.newPartition(option.SizeMB)
+	if option.Collections == "" {
+		return c, nil
+	}
+	for _, pair := range strings.Split(option.Collections, ",") {
+		parts := strings.Split(pair, ":")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("expecting collection:sizeMB, but got %s", pair)
+		}
+		sizeMB, err := strconv.Atoi(parts[1])
+		if err != nil || sizeMB < 0 {
+			return nil, fmt.Errorf("invalid cache size %s for collection %s", parts[1], parts[0])
+		}
+		c.partitions[parts[0]] = c.newPartition(sizeMB)
+	}
+	return c, nil
+}
+
+// SetNeedleCache replaces the needle cache. It should be called before serving any reads.
+func SetNeedleCache(c *NeedleCache) {
+	needleCache = c
+}
+
+func NeedleCacheStatistics() NeedleCacheStats {
+	return needleCache.Stats()
+}
+
+func (c *NeedleCache) newPartition(sizeMB int) *cachePartition {
+	if sizeMB <= 0 {
+		return nil
+	}
+	p := newCachePartition(int64(sizeMB) * 1024 * 1024)
+	if c.ssd != nil {
+		p.onEvict = c.ssd.put
+	}
+	return p
+}
+
+func (c *NeedleCache) partition(collection string) *cachePartition {
+	if p, ok := c.partitions[collection]; ok {
+		return p
+	}
+	return c.defaultPartition
+}
+
+//先查内存，再查ssd，最后读取数据文件，并加入内存缓存
+func (c *NeedleCache) getBytesForFileBlock(collection string, key string, r *os.File, offset int64, readSize int) (dataSlice []byte, block *Block, err error) {
+	p := c.partition(collection)
+	if p == nil {
+		return getBytesForFileBlock(r, offset, readSize)
+	}
+	if readSize > c.maxItemSize {
+		p.reject()
+		return getBytesForFileBlock(r, offset, readSize)
+	}
+	if block = p.get(key); block != nil {
+		return block.Bytes[0:readSize], block, nil
+	}
+	if c.ssd != nil {
+		block = c.ssd.get(key, readSize)
+	}
+	if block == nil {
+		if _, block, err = getBytesForFileBlock(r, offset, readSize); err != nil {
+			return nil, nil, err
+		}
+	}
+	p.add(key, block, readSize)
+	return block.Bytes[0:readSize], block, nil
+}
+
+func (c *NeedleCache) Stats() NeedleCacheStats {
+	s := NeedleCacheStats{MaxItemSize: c.maxItemSize}
+	if c.defaultPartition != nil {
+		stats := c.defaultPartition.stats()
+		s.Default = &stats
+	}
+	for collection, p := range c.partitions {
+		if p == nil {
+			s.Bypassed = append(s.Bypassed, collection)
+			continue
+		}
+		if s.Collections == nil {
+			s.Collections = make(map[string]CacheStats)
+		}
+		s.Collections[collection] = p.stats()
+	}
+	if c.ssd != nil {
+		stats := c.ssd.stats()
+		s.Ssd = &stats
+	}
+	return s
+}
+
+type cacheEntry struct {
+	key   string
+	block *Block
+	size  int64
+}
+
+// cachePartition is an LRU list of blocks within a byte budget.
+// Each cached block holds one reference, released on eviction.
+type cachePartition struct {
+	sync.Mutex
+	capacity int64
+	ll       *list.List
+	items    map[string]*list.Element
+	onEvict  func(key string, block *Block, size int64) //内存淘汰时调用，用于写入ssd
+	s        CacheStats
+}
+
+func newCachePartition(capacity int64) *cachePartition {
+	return &cachePartition{
+		capacity: capacity,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+		s:        CacheStats{Capacity: capacity},
+	}
+}
+
+func (p *cachePartition) get(key string) *Block {
+	p.Lock()
+	defer p.Unlock()
+	if e, ok := p.items[key]; ok {
+		p.ll.MoveToFront(e)
+		p.s.Hits++
+		//持有锁时增加引用，避免同时被淘汰放回池中
+		block := e.Value.(*cacheEntry).block
+		block.increaseReference()
+		return block
+	}
+	p.s.Misses++
+	return nil
+}
+
+func (p *cachePartition) add(key string, block *Block, readSize int) {
+	size := int64(readSize)
+	if size > p.capacity {
+		return
+	}
+	p.Lock()
+	defer p.Unlock()
+	if _, ok := p.items[key]; ok {
+		return
+	}
+	block.increaseReference()
+	p.items[key] = p.ll.PushFront(&cacheEntry{key: key, block: block, size: size})
+	p.s.Bytes += size
+	for p.s.Bytes > p.capacity {
+		e := p.ll.Back()
+		entry := e.Value.(*cacheEntry)
+		p.ll.Remove(e)
+		delete(p.items, entry.key)
+		p.s.Bytes -= entry.size
+		p.s.Evictions++
+		if p.onEvict != nil {
+			p.onEvict(entry.key, entry.block, entry.size)
+		}
+		entry.block.decreaseReference()
+	}
+}
+
+func (p *cachePartition) reject() {
+	p.Lock()
+	p.s.Rejected++
+	p.Unlock()
+}
+
+func (p *cachePartition) stats() CacheStats {
+	p.Lock()
+	defer p.Unlock()
+	s := p.s
+	s.Items = p.ll.Len()
+	return s
+}
+
+// ssdCache keeps the needle blobs evicted from memory as files in a local directory.
+// The files are written in the background, and dropped if the writer falls behind.
+type ssdCache struct {
+	sync.Mutex
+	dir      string
+	capacity int64
+	ll       *list.List
+	items    map[string]*list.Element
+	writes   chan *cacheEntry
+	s        CacheStats
+}
+
+func newSsdCache(dir string, capacity int64) (*ssdCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	//缓存key中的卷加载序号在重启后会重新计数，之前的缓存文件都无效
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if strings.HasSuffix(f.Name(), ssdCacheFileExt) {
+			os.Remove(filepath.Join(dir, f.Name()))
+		}
+	}
+	s := &ssdCache{
+		dir:      dir,
+		capacity: capacity,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+		writes:   make(chan *cacheEntry, 1024),
+		s:        CacheStats{Capacity: capacity},
+	}
+	go s.loop()
+	return s, nil
+}
+
+func (s *ssdCache) fileName(key string) string {
+	return filepath.Join(s.dir, strings.Replace(key, ":", "_", -1)+ssdCacheFileExt)
+}
+
+func (s *ssdCache) put(key string, block *Block, size int64) {
+	if size > s.capacity {
+		return
+	}
+	s.Lock()
+	_, ok := s.items[key]
+	s.Unlock()
+	if ok {
+		return
+	}
+	block.increaseReference()
+	select {
+	case s.writes <- &cacheEntry{key: key, block: block, size: size}:
+	default:
+		block.decreaseReference()
+	}
+}
+
+func (s *ssdCache) loop() {
+	for entry := range s.writes {
+		err := ioutil.WriteFile(s.fileName(entry.key), entry.block.Bytes[0:entry.size], 0644)
+		entry.block.decreaseReference()
+		if err != nil {
+			glog.V(0).Infof("write ssd cache %s: %v", s.fileName(entry.key), err)
+			continue
+		}
+		s.added(entry.key, entry.size)
+	}
+}
+
+func (s *ssdCache) added(key string, size int64) {
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.items[key]; ok {
+		return
+	}
+	s.items[key] = s.ll.PushFront(&cacheEntry{key: key, size: size})
+	s.s.Bytes += size
+	for s.s.Bytes > s.capacity {
+		e := s.ll.Back()
+		entry := e.Value.(*cacheEntry)
+		s.ll.Remove(e)
+		delete(s.items, entry.key)
+		s.s.Bytes -= entry.size
+		s.s.Evictions++
+		os.Remove(s.fileName(entry.key))
+	}
+}
+
+func (s *ssdCache) get(key string, readSize int) *Block {
+	s.Lock()
+	e, ok := s.items[key]
+	if ok {
+		s.ll.MoveToFront(e)
+	}
+	s.Unlock()
+	var block *Block
+	if ok {
+		//文件可能刚被淘汰删除，按未命中处理
+		if f, err := os.Open(s.fileName(key)); err == nil {
+			_, block, _ = getBytesForFileBlock(f, 0, readSize)
+			f.Close()
+		}
+	}
+	s.Lock()
+	if block != nil {
+		s.s.Hits++
+	} else {
+		s.s.Misses++
+	}
+	s.Unlock()
+	return block
+}
+
+func (s *ssdCache) stats() CacheStats {
+	s.Lock()
+	defer s.Unlock()
+	stats := s.s
+	stats.Items = s.ll.Len()
+	return stats
+}
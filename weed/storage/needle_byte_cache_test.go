@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNeedleCachePartitions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	f, err := os.Create(filepath.Join(dir, "data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	data := make([]byte, 3*1024*1024)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	f.Write(data)
+
+	c, err := NewNeedleCache(NeedleCacheOption{
+		SizeMB:      1,
+		MaxItemKB:   512,
+		Collections: "pictures:2,logs:0",
+		SsdDir:      filepath.Join(dir, "ssd"),
+		SsdSizeMB:   4,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := func(collection, key string, offset int64, size int) {
+		b, block, err := c.getBytesForFileBlock(collection, key, f, offset, size)
+		if err != nil {
+			t.Fatalf("read %s: %v", key, err)
+		}
+		if b[0] != data[offset] || b[size-1] != data[offset+int64(size)-1] {
+			t.Fatalf("read %s: wrong data", key)
+		}
+		block.decreaseReference()
+	}
+
+	// the shared partition holds 1MB, so the third 400KB block evicts the first one
+	read("", "a", 0, 400*1024)
+	read("", "a", 0, 400*1024)
+	read("", "b", 400*1024, 400*1024)
+	read("", "c", 800*1024, 400*1024)
+	read("", "big", 0, 600*1024)
+	read("logs", "d", 0, 1024)
+	read("pictures", "e", 0, 1024)
+
+	s := c.Stats()
+	if s.Default.Hits != 1 || s.Default.Misses != 3 || s.Default.Evictions != 1 || s.Default.Rejected != 1 || s.Default.Items != 2 {
+		t.Fatalf("unexpected default partition stats %+v", s.Default)
+	}
+	if s.Default.Bytes > s.Default.Capacity {
+		t.Fatalf("cache holds %d bytes over its capacity %d", s.Default.Bytes, s.Default.Capacity)
+	}
+	if len(s.Bypassed) != 1 || s.Bypassed[0] != "logs" || s.Collections["pictures"].Items != 1 {
+		t.Fatalf("unexpected collection stats %+v", s)
+	}
+
+	// the evicted block is moved to the ssd tier in the background
+	for i := 0; i < 100 && c.Stats().Ssd.Items == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	read("", "a", 0, 400*1024)
+	if s = c.Stats(); s.Ssd.Hits != 1 {
+		t.Fatalf("expected a hit on the ssd tier %+v", s.Ssd)
+	}
+}
@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// ErrPreconditionFailed is returned when a conditional write or delete does not match the current needle.
+var ErrPreconditionFailed = errors.New("Precondition Failed")
+
+// NeedleCondition holds the ETags of the If-Match and If-None-Match headers of a write or delete.
+// "*" matches any existing needle.
+type NeedleCondition struct {
+	IfMatch     []string
+	IfNoneMatch []string
+}
+
+// NewNeedleCondition parses the conditional headers, and returns nil if there is none.
+func NewNeedleCondition(h http.Header) *NeedleCondition {
+	c := &NeedleCondition{
+		IfMatch:     parseEtags(h.Get("If-Match")),
+		IfNoneMatch: parseEtags(h.Get("If-None-Match")),
+	}
+	if len(c.IfMatch) == 0 && len(c.IfNoneMatch) == 0 {
+		return nil
+	}
+	return c
+}
+
+//解析逗号分隔的ETag列表，弱ETag按强ETag比较
+func parseEtags(value string) (etags []string) {
+	for _, etag := range strings.Split(value, ",") {
+		etag = strings.TrimPrefix(strings.TrimSpace(etag), "W/")
+		if etag != "" {
+			etags = append(etags, etag)
+		}
+	}
+	return
+}
+
+// Matches tells if the condition holds for the current ETag, which is empty if the needle does not exist.
+func (c *NeedleCondition) Matches(etag string) bool {
+	if c == nil {
+		return true
+	}
+	if len(c.IfMatch) > 0 && (etag == "" || !containsEtag(c.IfMatch, etag)) {
+		return false
+	}
+	if len(c.IfNoneMatch) > 0 && etag != "" && containsEtag(c.IfNoneMatch, etag) {
+		return false
+	}
+	return true
+}
+
+func containsEtag(etags []string, etag string) bool {
+	for _, e := range etags {
+		if e == "*" || e == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,136 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/chrislusf/seaweedfs/weed/operation"
+)
+
+// DigestType is the hash algorithm of the content digest stored in a needle.
+type DigestType byte
+
+const (
+	DigestNone   DigestType = 0
+	DigestMD5    DigestType = 1
+	DigestSHA256 DigestType = 2
+)
+
+var (
+	ErrContentMD5Mismatch = errors.New("Content-MD5 does not match the uploaded content")
+	ErrDigestMismatch     = errors.New("content digest does not match, needle corrupted")
+)
+
+// NeedleDigest is a strong hash of the needle content, before any gzip compression.
+type NeedleDigest struct {
+	Type DigestType
+	Sum  []byte
+}
+
+func ParseDigestType(s string) (DigestType, error) {
+	switch strings.ToLower(s) {
+	case "", "none":
+		return DigestNone, nil
+	case "md5":
+		return DigestMD5, nil
+	case "sha256", "sha-256":
+		return DigestSHA256, nil
+	}
+	return DigestNone, fmt.Errorf("unknown digest type %s, should be md5 or sha256", s)
+}
+
+func (t DigestType) String() string {
+	switch t {
+	case DigestMD5:
+		return "md5"
+	case DigestSHA256:
+		return "sha256"
+	}
+	return ""
+}
+
+// Size is the number of bytes of the digest, 0 for unknown types.
+func (t DigestType) Size() int {
+	switch t {
+	case DigestMD5:
+		return md5.Size
+	case DigestSHA256:
+		return sha256.Size
+	}
+	return 0
+}
+
+func (t DigestType) Sum(data []byte) []byte {
+	switch t {
+	case DigestMD5:
+		sum := md5.Sum(data)
+		return sum[:]
+	case DigestSHA256:
+		sum := sha256.Sum256(data)
+		return sum[:]
+	}
+	return nil
+}
+
+//计算上传内容的摘要，如果客户端提供了Content-MD5，先校验
+//摘要针对解压后的内容，客户端上传的gzip内容会先解压
+func computeDigest(data []byte, isGzippedByClient bool, contentMD5 string, digestType DigestType) (digest NeedleDigest, e error) {
+	if contentMD5 != "" {
+		sum := md5.Sum(data)
+		if base64.StdEncoding.EncodeToString(sum[:]) != contentMD5 {
+			return digest, ErrContentMD5Mismatch
+		}
+		if digestType == DigestNone {
+			digestType = DigestMD5
+		}
+	}
+	if digestType == DigestNone {
+		return
+	}
+	if isGzippedByClient {
+		if unzipped, err := operation.UnGzipData(data); err == nil {
+			data = unzipped
+		}
+	}
+	return NeedleDigest{Type: digestType, Sum: digestType.Sum(data)}, nil
+}
+
+func (n *Needle) HasDigest() bool {
+	return n.Flags&FlagHasDigest != 0
+}
+
+// SetDigest stores the digest in the needle, ignoring empty or unknown digests.
+func (n *Needle) SetDigest(digest NeedleDigest) {
+	if digest.Type.Size() == 0 || len(digest.Sum) != digest.Type.Size() {
+		return
+	}
+	n.Digest = digest
+	n.Flags = n.Flags | FlagHasDigest
+}
+
+// ContentMD5 returns the base64 encoded MD5 of the content, if the needle has one.
+func (n *Needle) ContentMD5() string {
+	if !n.HasDigest() || n.Digest.Type != DigestMD5 {
+		return ""
+	}
+	return base64.StdEncoding.EncodeToString(n.Digest.Sum)
+}
+
+// VerifyDigest checks the content against the stored digest, if any.
+// Gzipped content matches if either the stored or the uncompressed bytes match.
+func (n *Needle) VerifyDigest() error {
+	if !n.HasDigest() || bytes.Equal(n.Digest.Type.Sum(n.Data), n.Digest.Sum) {
+		return nil
+	}
+	if n.IsGzipped() {
+		if unzipped, err := operation.UnGzipData(n.Data); err == nil && bytes.Equal(n.Digest.Type.Sum(unzipped), n.Digest.Sum) {
+			return nil
+		}
+	}
+	return ErrDigestMismatch
+}
@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestNeedleDigest(t *testing.T) {
+	data := []byte("content with a digest")
+	sum := md5.Sum(data)
+	contentMD5 := base64.StdEncoding.EncodeToString(sum[:])
+
+	if _, err := computeDigest(data, false, base64.StdEncoding.EncodeToString(make([]byte, md5.Size)), DigestNone); err != ErrContentMD5Mismatch {
+		t.Fatalf("expected Content-MD5 mismatch, got %v", err)
+	}
+	digest, err := computeDigest(data, false, contentMD5, DigestNone)
+	if err != nil || digest.Type != DigestMD5 {
+		t.Fatalf("Content-MD5 should default to an md5 digest: %+v %v", digest, err)
+	}
+	if digest, _ = computeDigest(data, false, "", DigestSHA256); digest.Type != DigestSHA256 || len(digest.Sum) != 32 {
+		t.Fatalf("unexpected sha256 digest %+v", digest)
+	}
+
+	n := &Needle{Id: 1, Cookie: 0x1234, Data: data}
+	n.Checksum = NewCRC(n.Data)
+	n.SetDigest(digest)
+	if n.Etag() != `"`+hex.EncodeToString(digest.Sum)+`"` {
+		t.Fatalf("etag %s should be the digest", n.Etag())
+	}
+
+	var buf bytes.Buffer
+	if _, err := n.Append(&buf, CurrentVersion); err != nil {
+		t.Fatalf("append: %v", err)
+	}
+	parsed, err := ParseNeedleBlob(buf.Bytes(), CurrentVersion)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if !parsed.HasDigest() || parsed.Digest.Type != DigestSHA256 || !bytes.Equal(parsed.Digest.Sum, digest.Sum) {
+		t.Fatalf("digest not preserved: %+v", parsed.Digest)
+	}
+	if err := parsed.VerifyDigest(); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	parsed.Data = []byte("content with another digest")
+	if err := parsed.VerifyDigest(); err != ErrDigestMismatch {
+		t.Fatalf("expected digest mismatch, got %v", err)
+	}
+}
+
+func TestScrubVolume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "volume")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	rp, _ := NewReplicaPlacementFromString("000")
+	v, err := NewVolume(dir, "", 1, NeedleMapInMemory, rp, EMPTY_TTL)
+	if err != nil {
+		t.Fatalf("new volume: %v", err)
+	}
+	defer v.Close()
+
+	good := &Needle{Id: 1, Cookie: 0x1234, Data: []byte("scrubbed needle")}
+	good.Checksum = NewCRC(good.Data)
+	good.SetDigest(NeedleDigest{Type: DigestMD5, Sum: DigestMD5.Sum(good.Data)})
+	bad := &Needle{Id: 2, Cookie: 0x1234, Data: []byte("scrubbed needle with a wrong digest")}
+	bad.Checksum = NewCRC(bad.Data)
+	bad.SetDigest(NeedleDigest{Type: DigestMD5, Sum: DigestMD5.Sum(good.Data)})
+	_, errs := v.writeNeedles([]*Needle{good, bad})
+	for i, e := range errs {
+		if e != nil {
+			t.Fatalf("write needle %d: %v", i, e)
+		}
+	}
+
+	ret, err := v.Scrub()
+	if err != nil {
+		t.Fatalf("scrub: %v", err)
+	}
+	if ret.Checked != 2 || len(ret.Corrupted) != 1 || ret.Corrupted["2"] == "" {
+		t.Fatalf("unexpected scrub result %+v", ret)
+	}
+}
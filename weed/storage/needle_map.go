@@ -39,6 +39,7 @@ type NeedleMapper interface {
 	IndexFileSize() uint64
 	IndexFileContent() ([]byte, error)
 	IndexFileName() string
+	Sync() error
 }
 
 //基本针映射结构
@@ -99,6 +100,13 @@ func (nm *baseNeedleMapper) appendToIndexFile(key uint64, offset uint32, size ui
 	return err
 }
 
+//把索引文件刷到磁盘
+func (nm *baseNeedleMapper) Sync() error {
+	nm.indexFileAccessLock.Lock()
+	defer nm.indexFileAccessLock.Unlock()
+	return nm.indexFile.Sync()
+}
+
 //获取索引文件的内容
 func (nm *baseNeedleMapper) IndexFileContent() ([]byte, error) {
 	//加锁
@@ -16,9 +16,13 @@ const (
 	FlagHasMime             = 0x04
 	FlagHasLastModifiedDate = 0x08
 	FlagHasTtl              = 0x10
+	FlagHasPairs            = 0x20
+	FlagHasDigest           = 0x40
 	FlagIsChunkManifest     = 0x80
 	LastModifiedBytesLength = 5
 	TtlBytesLength          = 2
+	PairsSizeBytesLength    = 2
+	DigestTypeBytesLength   = 1
 )
 
 func (n *Needle) DiskSize() int64 {
@@ -63,6 +67,7 @@ func (n *Needle) Append(w io.Writer, version Version) (size uint32, err error) {
 		util.Uint32toBytes(header[0:4], n.Cookie)
 		util.Uint64toBytes(header[4:12], n.Id)
 		n.DataSize, n.NameSize, n.MimeSize = uint32(len(n.Data)), uint8(len(n.Name)), uint8(len(n.Mime))
+		n.PairsSize = uint16(len(n.Pairs))
 		if n.DataSize > 0 {
 			n.Size = 4 + n.DataSize + 1
 			if n.HasName() {
@@ -77,6 +82,12 @@ func (n *Needle) Append(w io.Writer, version Version) (size uint32, err error) {
 			if n.HasTtl() {
 				n.Size = n.Size + TtlBytesLength
 			}
+			if n.HasPairs() {
+				n.Size = n.Size + PairsSizeBytesLength + uint32(n.PairsSize)
+			}
+			if n.HasDigest() {
+				n.Size = n.Size + DigestTypeBytesLength + uint32(len(n.Digest.Sum))
+			}
 		} else {
 			n.Size = 0
 		}
@@ -127,6 +138,24 @@ func (n *Needle) Append(w io.Writer, version Version) (size uint32, err error) {
 					return
 				}
 			}
+			if n.HasPairs() {
+				util.Uint16toBytes(header[0:PairsSizeBytesLength], n.PairsSize)
+				if _, err = w.Write(header[0:PairsSizeBytesLength]); err != nil {
+					return
+				}
+				if _, err = w.Write(n.Pairs); err != nil {
+					return
+				}
+			}
+			if n.HasDigest() {
+				util.Uint8toBytes(header[0:DigestTypeBytesLength], byte(n.Digest.Type))
+				if _, err = w.Write(header[0:DigestTypeBytesLength]); err != nil {
+					return
+				}
+				if _, err = w.Write(n.Digest.Sum); err != nil {
+					return
+				}
+			}
 		}
 		padding := NeedlePaddingSize - ((NeedleHeaderSize + n.Size + NeedleChecksumSize) % NeedlePaddingSize)
 		util.Uint32toBytes(header[0:NeedleChecksumSize], n.Checksum.Value())
@@ -148,6 +177,11 @@ func (n *Needle) ReadData(r *os.File, offset int64, size uint32, version Version
 		return err
 	}
 	n.rawBlock = block
+	return n.parseNeedleData(bytes, size, version)
+}
+
+//解析读取到的针数据，并校验crc
+func (n *Needle) parseNeedleData(bytes []byte, size uint32, version Version) (err error) {
 	n.ParseNeedleHeader(bytes)
 	if n.Size != size {
 		return fmt.Errorf("File Entry Not Found. Needle %d Memory %d", n.Size, size)
@@ -169,6 +203,58 @@ func (n *Needle) ReadData(r *os.File, offset int64, size uint32, version Version
 	n.Checksum = newChecksum
 	return nil
 }
+// ParseNeedleBlob parses a needle serialized by Needle.Append, and verifies its checksum.
+func ParseNeedleBlob(blob []byte, version Version) (n *Needle, err error) {
+	if len(blob) < NeedleHeaderSize {
+		return nil, fmt.Errorf("needle blob of %d bytes is too short", len(blob))
+	}
+	n = new(Needle)
+	n.ParseNeedleHeader(blob)
+	if n.DiskSize() != int64(len(blob)) {
+		return nil, fmt.Errorf("needle blob of %d bytes does not match needle size %d", len(blob), n.Size)
+	}
+	switch version {
+	case Version1:
+		n.Data = blob[NeedleHeaderSize : NeedleHeaderSize+n.Size]
+	case Version2:
+		n.readNeedleDataVersion2(blob[NeedleHeaderSize : NeedleHeaderSize+int(n.Size)])
+	default:
+		return nil, fmt.Errorf("Unsupported Version! (%d)", version)
+	}
+	if n.Size == 0 {
+		return n, nil
+	}
+	checksum := util.BytesToUint32(blob[NeedleHeaderSize+n.Size : NeedleHeaderSize+n.Size+NeedleChecksumSize])
+	n.Checksum = NewCRC(n.Data)
+	if checksum != n.Checksum.Value() {
+		return nil, errors.New("CRC error! Needle blob corrupted")
+	}
+	return n, nil
+}
+
+// SplitNeedleBlobs parses needles serialized by Needle.Append and concatenated in data.
+func SplitNeedleBlobs(data []byte, version Version) (ns []*Needle, blobs [][]byte, err error) {
+	for len(data) > 0 {
+		if len(data) < NeedleHeaderSize {
+			return nil, nil, fmt.Errorf("needle blob of %d bytes is too short", len(data))
+		}
+		header := new(Needle)
+		header.ParseNeedleHeader(data)
+		diskSize := header.DiskSize()
+		if diskSize > int64(len(data)) {
+			return nil, nil, fmt.Errorf("needle blob of %d bytes is shorter than needle size %d", len(data), header.Size)
+		}
+		n, e := ParseNeedleBlob(data[:diskSize], version)
+		if e != nil {
+			return nil, nil, e
+		}
+		ns = append(ns, n)
+		blobs = append(blobs, data[:diskSize])
+		data = data[diskSize:]
+	}
+	return
+}
+
 func (n *Needle) ParseNeedleHeader(bytes []byte) {
 	n.Cookie = util.BytesToUint32(bytes[0:4])
 	n.Id = util.BytesToUint64(bytes[4:12])
@@ -209,6 +295,23 @@ func (n *Needle) readNeedleDataVersion2(bytes []byte) {
 		n.Ttl = LoadTTLFromBytes(bytes[index : index+TtlBytesLength])
 		index = index + TtlBytesLength
 	}
+	if index < lenBytes && n.HasPairs() {
+		n.PairsSize = util.BytesToUint16(bytes[index : index+PairsSizeBytesLength])
+		index = index + PairsSizeBytesLength
+		n.Pairs = bytes[index : index+int(n.PairsSize)]
+		index = index + int(n.PairsSize)
+	}
+	if index < lenBytes && n.HasDigest() {
+		n.Digest.Type = DigestType(bytes[index])
+		index = index + DigestTypeBytesLength
+		digestSize := n.Digest.Type.Size()
+		if digestSize == 0 || index+digestSize > lenBytes {
+			glog.V(0).Infof("needle %d has invalid digest type %d", n.Id, n.Digest.Type)
+			return
+		}
+		n.Digest.Sum = bytes[index : index+digestSize]
+		index = index + digestSize
+	}
 }
 
 func ReadNeedleHeader(r *os.File, version Version, offset int64) (n *Needle, bodyLength uint32, err error) {
@@ -272,6 +375,12 @@ func (n *Needle) HasMime() bool {
 func (n *Needle) SetHasMime() {
 	n.Flags = n.Flags | FlagHasMime
 }
+func (n *Needle) HasPairs() bool {
+	return n.Flags&FlagHasPairs != 0
+}
+func (n *Needle) SetHasPairs() {
+	n.Flags = n.Flags | FlagHasPairs
+}
 func (n *Needle) HasLastModifiedDate() bool {
 	return n.Flags&FlagHasLastModifiedDate > 0
 }
@@ -0,0 +1,154 @@
+package storage
+
+import (
+	"bytes"
+	"mime/multipart"
+	"testing"
+
+	"github.com/chrislusf/seaweedfs/weed/operation"
+)
+
+func TestParseNeedleBlob(t *testing.T) {
+	ttl, _ := ReadTTL("3d")
+	n := &Needle{
+		Cookie:       0x12345678,
+		Id:           0x42,
+		Data:         []byte("hello world"),
+		Name:         []byte("hello.txt"),
+		Mime:         []byte("text/plain"),
+		LastModified: 1500000000,
+		Ttl:          ttl,
+	}
+	n.SetHasName()
+	n.SetHasMime()
+	n.SetHasLastModifiedDate()
+	n.SetHasTtl()
+	n.SetGzipped()
+	n.Checksum = NewCRC(n.Data)
+
+	var buf bytes.Buffer
+	if _, err := n.Append(&buf, Version2); err != nil {
+		t.Fatalf("append needle: %v", err)
+	}
+	blob := buf.Bytes()
+
+	parsed, err := ParseNeedleBlob(blob, Version2)
+	if err != nil {
+		t.Fatalf("parse needle blob: %v", err)
+	}
+	if parsed.Cookie != n.Cookie || parsed.Id != n.Id || parsed.Flags != n.Flags ||
+		parsed.LastModified != n.LastModified || parsed.Ttl.String() != ttl.String() ||
+		!bytes.Equal(parsed.Data, n.Data) || !bytes.Equal(parsed.Name, n.Name) || !bytes.Equal(parsed.Mime, n.Mime) {
+		t.Fatalf("parsed needle %+v differs from %+v", parsed, n)
+	}
+
+	var reserialized bytes.Buffer
+	if _, err := parsed.Append(&reserialized, Version2); err != nil {
+		t.Fatalf("append parsed needle: %v", err)
+	}
+	if !bytes.Equal(reserialized.Bytes(), blob) {
+		t.Fatalf("reserialized needle is not byte identical")
+	}
+
+	blob[NeedleHeaderSize+4] ^= 0xff
+	if _, err := ParseNeedleBlob(blob, Version2); err == nil {
+		t.Fatalf("expected checksum error")
+	}
+	if _, err := ParseNeedleBlob(blob[:len(blob)-1], Version2); err == nil {
+		t.Fatalf("expected size error")
+	}
+}
+
+func TestBatchNeedles(t *testing.T) {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	files := []operation.BatchFile{
+		{Fid: "3,01637037d6", Name: "a.jpg", Data: []byte("first"), Ttl: "1d", LastModified: 1500000000},
+		{Fid: "3,02637037d7.png", Name: "b.png", MimeType: "image/x-custom", Data: []byte("second")},
+		{Fid: "bad", Name: "c.jpg", Data: []byte("third")},
+	}
+	if err := operation.WriteBatchFiles(w, files); err != nil {
+		t.Fatalf("write batch: %v", err)
+	}
+	reader := multipart.NewReader(&body, w.Boundary())
+	var ns []*Needle
+	for i := range files {
+		part, err := reader.NextPart()
+		if err != nil {
+			t.Fatalf("next part: %v", err)
+		}
+		vid, n, err := NewNeedleFromPart(part, false, DigestNone)
+		if i == 2 {
+			if err == nil {
+				t.Fatalf("expected error for fid %s", files[i].Fid)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("parse part %d: %v", i, err)
+		}
+		if vid != "3" || n.Id != uint64(i+1) || n.Cookie != 0x637037d6+uint32(i) || string(n.Data) != string(files[i].Data) {
+			t.Fatalf("part %d parsed as %s %v", i, vid, n)
+		}
+		ns = append(ns, n)
+	}
+	if ns[0].Ttl.String() != "1d" || ns[0].LastModified != 1500000000 || !ns[0].HasTtl() {
+		t.Fatalf("unexpected ttl %s or last modified %d", ns[0].Ttl, ns[0].LastModified)
+	}
+	if string(ns[1].Mime) != "image/x-custom" || string(ns[1].Name) != "b.png" {
+		t.Fatalf("unexpected mime %s or name %s", ns[1].Mime, ns[1].Name)
+	}
+
+	var blob bytes.Buffer
+	for _, n := range ns {
+		if _, err := n.Append(&blob, Version2); err != nil {
+			t.Fatalf("append needle: %v", err)
+		}
+	}
+	split, blobs, err := SplitNeedleBlobs(blob.Bytes(), Version2)
+	if err != nil {
+		t.Fatalf("split needle blobs: %v", err)
+	}
+	if len(split) != 2 || len(blobs) != 2 || split[1].Id != ns[1].Id || string(split[1].Data) != "second" {
+		t.Fatalf("unexpected split result %v", split)
+	}
+	if _, _, err := SplitNeedleBlobs(blob.Bytes()[:blob.Len()-1], Version2); err == nil {
+		t.Fatalf("expected error for truncated blobs")
+	}
+}
+
+func TestNeedlePairs(t *testing.T) {
+	header := make(map[string][]string)
+	header["Seaweed-Owner"] = []string{"42"}
+	header["Seaweed-Content-Disposition"] = []string{`attachment; filename="a.txt"`}
+	header["Content-Type"] = []string{"text/plain"}
+	n := &Needle{Cookie: 1, Id: 2, Data: []byte("with metadata")}
+	if err := n.SetPairs(parsePairs(header)); err != nil {
+		t.Fatalf("set pairs: %v", err)
+	}
+	n.SetHasTtl()
+	n.Ttl, _ = ReadTTL("5m")
+	n.Checksum = NewCRC(n.Data)
+
+	var buf bytes.Buffer
+	if _, err := n.Append(&buf, Version2); err != nil {
+		t.Fatalf("append needle: %v", err)
+	}
+	parsed, err := ParseNeedleBlob(buf.Bytes(), Version2)
+	if err != nil {
+		t.Fatalf("parse needle blob: %v", err)
+	}
+	pairMap := parsed.PairMap()
+	if len(pairMap) != 2 || pairMap["Seaweed-Owner"] != "42" ||
+		pairMap["Seaweed-Content-Disposition"] != `attachment; filename="a.txt"` {
+		t.Fatalf("unexpected pairs %v", pairMap)
+	}
+	if parsed.Ttl.String() != "5m" {
+		t.Fatalf("ttl %s is not kept with pairs", parsed.Ttl)
+	}
+
+	large := map[string]string{"Seaweed-Large": string(make([]byte, MaxPairsSize))}
+	if err := new(Needle).SetPairs(large); err == nil {
+		t.Fatalf("expected error for too large pairs")
+	}
+}
@@ -5,8 +5,11 @@ import (
 	"errors"
 	"fmt"
 	"math/rand"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/chrislusf/seaweedfs/weed/glog"
 	"github.com/chrislusf/seaweedfs/weed/operation"
@@ -72,12 +75,20 @@ type Store struct {
 	Ip              string
 	Port            int
 	PublicUrl       string
-	Locations       []*DiskLocation
+	locations       atomic.Value
 	dataCenter      string //optional informaton, overwriting master setting if exists
 	rack            string //optional information, overwriting master setting if exists
 	connected       bool
 	volumeSizeLimit uint64 //read from the master
 	masterNodes     *MasterNodes
+	maxDiskIOErrors int    //磁盘一分钟内的io错误达到此数量后隔离，0表示不隔离
+	diskReserve     uint64 //磁盘剩余空间低于此值时不再写入，0表示不检查
+	durability      *DurabilityPolicy
+	locationsLock   sync.Mutex //增删磁盘目录时加锁，locations按写时复制整体替换
+	leaving         int32      //已向master宣告退出，不再发送心跳
+	jwtKeys         *security.KeySet
+	accessKeys      *security.AccessKeys
+	diskFailed      chan struct{} //磁盘故障时通知心跳循环立即上报
 }
 
 func (s *Store) String() (str string) {
@@ -86,15 +97,83 @@ func (s *Store) String() (str string) {
 }
 
 func NewStore(port int, ip, publicUrl string, dirnames []string, maxVolumeCounts []int, needleMapKind NeedleMapType) (s *Store) {
-	s = &Store{Port: port, Ip: ip, PublicUrl: publicUrl}
-	s.Locations = make([]*DiskLocation, 0)
+	s = &Store{Port: port, Ip: ip, PublicUrl: publicUrl, maxDiskIOErrors: DefaultDiskMaxIOErrors, diskFailed: make(chan struct{}, 1)}
+	locations := make([]*DiskLocation, 0)
 	for i := 0; i < len(dirnames); i++ {
 		location := NewDiskLocation(dirnames[i], maxVolumeCounts[i])
 		location.loadExistingVolumes(needleMapKind)
-		s.Locations = append(s.Locations, location)
+		locations = append(locations, location)
 	}
+	s.locations.Store(locations)
 	return
 }
+
+// Locations returns the data directories. The returned slice should not be modified.
+func (s *Store) Locations() []*DiskLocation {
+	return s.locations.Load().([]*DiskLocation)
+}
+
+// AddLocation attaches a data directory to the running store, loading the volumes already in it.
+// Volumes whose ids are already served from another directory are skipped.
+func (s *Store) AddLocation(dir string, maxVolumeCount int, needleMapKind NeedleMapType) error {
+	if err := util.TestFolderWritable(dir); err != nil {
+		return fmt.Errorf("data dir %s: %v", dir, err)
+	}
+	s.locationsLock.Lock()
+	defer s.locationsLock.Unlock()
+	if s.findLocation(dir) != nil {
+		return fmt.Errorf("data dir %s is already used", dir)
+	}
+	location := NewDiskLocation(dir, maxVolumeCount)
+	location.loadExistingVolumes(needleMapKind)
+	for vid, v := range location.volumes {
+		if s.findVolume(vid) != nil {
+			glog.V(0).Infof("skip volume %d in %s, which is already loaded from another dir", vid, dir)
+			v.Close()
+			delete(location.volumes, vid)
+			continue
+		}
+		v.setDurability(s.durability)
+	}
+	locations := make([]*DiskLocation, 0, len(s.Locations())+1)
+	s.locations.Store(append(append(locations, s.Locations()...), location))
+	return nil
+}
+
+// RemoveLocation detaches a data directory, closing its volumes once their in-flight requests finish.
+// The volume files are kept on disk.
+func (s *Store) RemoveLocation(dir string) error {
+	s.locationsLock.Lock()
+	defer s.locationsLock.Unlock()
+	location := s.findLocation(dir)
+	if location == nil {
+		return fmt.Errorf("data dir %s is not used", dir)
+	}
+	locations := make([]*DiskLocation, 0, len(s.Locations()))
+	for _, l := range s.Locations() {
+		if l != location {
+			locations = append(locations, l)
+		}
+	}
+	s.locations.Store(locations)
+	//新请求已经找不到这些卷，等进行中的请求结束后再关闭
+	for _, v := range location.volumes {
+		v.detach()
+	}
+	glog.V(0).Infoln("Store removed dir:", dir, "with", len(location.volumes), "volumes")
+	return nil
+}
+
+func (s *Store) findLocation(dir string) *DiskLocation {
+	dir = filepath.Clean(dir)
+	for _, location := range s.Locations() {
+		if filepath.Clean(location.Directory) == dir {
+			return location
+		}
+	}
+	return nil
+}
+
 func (s *Store) AddVolume(volumeListString string, collection string, needleMapKind NeedleMapType, replicaPlacement string, ttlString string) error {
 	rt, e := NewReplicaPlacementFromString(replicaPlacement)
 	if e != nil {
@@ -132,7 +211,7 @@ func (s *Store) AddVolume(volumeListString string, collection string, needleMapK
 	return e
 }
 func (s *Store) DeleteCollection(collection string) (e error) {
-	for _, location := range s.Locations {
+	for _, location := range s.Locations() {
 		e = location.DeleteCollectionFromDiskLocation(collection)
 		if e != nil {
 			return
@@ -141,17 +220,106 @@ func (s *Store) DeleteCollection(collection string) (e error) {
 	return
 }
 
+func (s *Store) MarkVolumeReadOnly(i VolumeId, readOnly bool) error {
+	if v := s.findVolume(i); v != nil {
+		return v.MarkReadOnly(readOnly)
+	}
+	return fmt.Errorf("Volume %d not found!", i)
+}
+
+func (s *Store) DeleteVolume(i VolumeId) error {
+	for _, location := range s.Locations() {
+		if _, found := location.volumes[i]; found {
+			return location.deleteVolumeById(i)
+		}
+	}
+	return fmt.Errorf("Volume %d not found!", i)
+}
+
 func (s *Store) findVolume(vid VolumeId) *Volume {
-	for _, location := range s.Locations {
+	for _, location := range s.Locations() {
 		if v, found := location.volumes[vid]; found {
 			return v
 		}
 	}
 	return nil
 }
+
+// acquireVolume finds the volume and keeps it open until its release.
+func (s *Store) acquireVolume(vid VolumeId) *Volume {
+	if v := s.findVolume(vid); v != nil && v.acquire() {
+		return v
+	}
+	return nil
+}
+func (s *Store) findVolumeLocation(vid VolumeId) *DiskLocation {
+	for _, location := range s.Locations() {
+		if _, found := location.volumes[vid]; found {
+			return location
+		}
+	}
+	return nil
+}
+
+// checkDiskError counts the disk I/O errors of the volume's location,
+// and quarantines the location once it fails.
+func (s *Store) checkDiskError(vid VolumeId, err error) {
+	if err == nil {
+		return
+	}
+	location := s.findVolumeLocation(vid)
+	if location == nil || !location.recordIOError(err, s.maxDiskIOErrors) {
+		return
+	}
+	location.quarantine()
+	//已经有未处理的通知时不必再发
+	select {
+	case s.diskFailed <- struct{}{}:
+	default:
+	}
+}
+
+// DiskFailed is signaled when a disk fails, so that the heartbeat reports it at once.
+func (s *Store) DiskFailed() <-chan struct{} {
+	return s.diskFailed
+}
+
+func (s *Store) SetMaxDiskIOErrors(maxDiskIOErrors int) {
+	s.maxDiskIOErrors = maxDiskIOErrors
+}
+
+// SetDurability sets when the writes to each volume are synced to disk.
+func (s *Store) SetDurability(p *DurabilityPolicy) {
+	s.durability = p
+	for _, location := range s.Locations() {
+		for _, v := range location.volumes {
+			v.setDurability(p)
+		}
+	}
+}
+
+// SetDiskReserveMB sets the free space kept on each disk. Below it the disk gets no new volumes or writes,
+// and its volumes are reported read only, while deletes and compaction still work.
+func (s *Store) SetDiskReserveMB(reserveMB int) {
+	s.diskReserve = uint64(reserveMB) * 1024 * 1024
+	for _, location := range s.Locations() {
+		location.checkDiskSpace(s.diskReserve, true)
+	}
+}
+
+func (s *Store) DiskHealths() (ret []DiskHealth) {
+	for _, location := range s.Locations() {
+		ret = append(ret, location.Health())
+	}
+	return
+}
+
 func (s *Store) findFreeLocation() (ret *DiskLocation) {
 	max := 0
-	for _, location := range s.Locations {
+	for _, location := range s.Locations() {
+		if location.IsFailed() || location.checkDiskSpace(s.diskReserve, false) {
+			continue
+		}
 		currentFreeCount := location.MaxVolumeCount - len(location.volumes)
 		if currentFreeCount > max {
 			max = currentFreeCount
@@ -168,6 +336,7 @@ func (s *Store) addVolume(vid VolumeId, collection string, needleMapKind NeedleM
 		glog.V(0).Infof("In dir %s adds volume:%v collection:%s replicaPlacement:%v ttl:%v",
 			location.Directory, vid, collection, replicaPlacement, ttl)
 		if volume, err := NewVolume(location.Directory, collection, vid, needleMapKind, replicaPlacement, ttl); err == nil {
+			volume.setDurability(s.durability)
 			location.volumes[vid] = volume
 			return nil
 		} else {
@@ -179,7 +348,8 @@ func (s *Store) addVolume(vid VolumeId, collection string, needleMapKind NeedleM
 
 func (s *Store) Status() []*VolumeInfo {
 	var stats []*VolumeInfo
-	for _, location := range s.Locations {
+	for _, location := range s.Locations() {
+		lowDiskSpace := location.IsLowDiskSpace()
 		for k, v := range location.volumes {
 			s := &VolumeInfo{
 				Id:               VolumeId(k),
@@ -190,7 +360,7 @@ func (s *Store) Status() []*VolumeInfo {
 				FileCount:        v.nm.FileCount(),
 				DeleteCount:      v.nm.DeletedCount(),
 				DeletedByteCount: v.nm.DeletedSize(),
-				ReadOnly:         v.readOnly,
+				ReadOnly:         v.isReadOnly() || lowDiskSpace,
 				Ttl:              v.Ttl}
 			stats = append(stats, s)
 		}
@@ -209,7 +379,20 @@ func (s *Store) SetRack(rack string) {
 func (s *Store) SetBootstrapMaster(bootstrapMaster string) {
 	s.masterNodes = NewMasterNodes(bootstrapMaster)
 }
-func (s *Store) SendHeartbeatToMaster() (masterNode string, secretKey security.Secret, e error) {
+// SetJwtKeys sets the keys to update with the JWT keys sent by the master over TLS.
+func (s *Store) SetJwtKeys(keys *security.KeySet) {
+	s.jwtKeys = keys
+}
+
+// SetAccessKeys sets the keys to update with the access keys sent by the master over TLS.
+func (s *Store) SetAccessKeys(keys *security.AccessKeys) {
+	s.accessKeys = keys
+}
+
+func (s *Store) SendHeartbeatToMaster() (masterNode string, e error) {
+	if s.IsLeaving() {
+		return "", errors.New("volume server is leaving")
+	}
 	masterNode, e = s.masterNodes.FindMaster()
 	if e != nil {
 		return
@@ -217,8 +400,20 @@ func (s *Store) SendHeartbeatToMaster() (masterNode string, secretKey security.S
 	var volumeMessages []*operation.VolumeInformationMessage
 	maxVolumeCount := 0
 	var maxFileKey uint64
-	for _, location := range s.Locations {
-		maxVolumeCount = maxVolumeCount + location.MaxVolumeCount
+	var failedDirs []string
+	for _, location := range s.Locations() {
+		//故障磁盘上的卷不再上报，master将不再使用这些卷
+		if location.IsFailed() {
+			failedDirs = append(failedDirs, location.Directory)
+			continue
+		}
+		//空间不足的磁盘不再分配新卷，卷上报为只读
+		lowDiskSpace := location.checkDiskSpace(s.diskReserve, true)
+		if lowDiskSpace {
+			maxVolumeCount = maxVolumeCount + len(location.volumes)
+		} else {
+			maxVolumeCount = maxVolumeCount + location.MaxVolumeCount
+		}
 		for k, v := range location.volumes {
 			if maxFileKey < v.nm.MaxFileKey() {
 				maxFileKey = v.nm.MaxFileKey()
@@ -231,7 +426,7 @@ func (s *Store) SendHeartbeatToMaster() (masterNode string, secretKey security.S
 					FileCount:        proto.Uint64(uint64(v.nm.FileCount())),
 					DeleteCount:      proto.Uint64(uint64(v.nm.DeletedCount())),
 					DeletedByteCount: proto.Uint64(v.nm.DeletedSize()),
-					ReadOnly:         proto.Bool(v.readOnly),
+					ReadOnly:         proto.Bool(v.isReadOnly() || lowDiskSpace),
 					ReplicaPlacement: proto.Uint32(uint32(v.ReplicaPlacement.Byte())),
 					Version:          proto.Uint32(uint32(v.Version())),
 					Ttl:              proto.Uint32(v.Ttl.ToUint32()),
@@ -258,57 +453,169 @@ func (s *Store) SendHeartbeatToMaster() (masterNode string, secretKey security.S
 		DataCenter:     proto.String(s.dataCenter),
 		Rack:           proto.String(s.rack),
 		Volumes:        volumeMessages,
+		FailedDirs:     failedDirs,
+	}
+
+	ret, err := s.join(masterNode, joinMessage)
+	if err != nil {
+		return masterNode, err
 	}
+	s.volumeSizeLimit = ret.VolumeSizeLimit
+	if len(ret.JwtKeys) > 0 && s.jwtKeys != nil {
+		if err = s.jwtKeys.Set(ret.JwtKeys, ret.JwtSigningKeyId); err != nil {
+			glog.V(0).Infof("Invalid jwt keys from master %s: %v", masterNode, err)
+		}
+	}
+	if ret.AccessKeys != nil && s.accessKeys != nil {
+		if err = s.accessKeys.Set(ret.AccessKeys); err != nil {
+			glog.V(0).Infof("Invalid access keys from master %s: %v", masterNode, err)
+		}
+	}
+	s.connected = true
+	return
+}
 
+// SendLeavingToMaster stops the heartbeats and tells the master this server is leaving,
+// so the master unregisters it at once instead of waiting for the heartbeat timeout.
+func (s *Store) SendLeavingToMaster() error {
+	if !atomic.CompareAndSwapInt32(&s.leaving, 0, 1) {
+		return nil
+	}
+	if s.masterNodes == nil {
+		return nil
+	}
+	masterNode, err := s.masterNodes.FindMaster()
+	if err != nil {
+		return err
+	}
+	joinMessage := &operation.JoinMessage{
+		IsInit:         proto.Bool(false),
+		Ip:             proto.String(s.Ip),
+		Port:           proto.Uint32(uint32(s.Port)),
+		PublicUrl:      proto.String(s.PublicUrl),
+		MaxVolumeCount: proto.Uint32(0),
+		MaxFileKey:     proto.Uint64(0),
+		DataCenter:     proto.String(s.dataCenter),
+		Rack:           proto.String(s.rack),
+		IsLeaving:      proto.Bool(true),
+	}
+	_, err = s.join(masterNode, joinMessage)
+	return err
+}
+
+func (s *Store) IsLeaving() bool {
+	return atomic.LoadInt32(&s.leaving) == 1
+}
+
+func (s *Store) join(masterNode string, joinMessage *operation.JoinMessage) (*operation.JoinResult, error) {
 	data, err := proto.Marshal(joinMessage)
 	if err != nil {
-		return "", "", err
+		return nil, err
 	}
 
-	joinUrl := "http://" + masterNode + "/dir/join"
+	joinUrl := util.SchemePrefix + masterNode + "/dir/join"
 	glog.V(4).Infof("Connecting to %s ...", joinUrl)
 
 	jsonBlob, err := util.PostBytes(joinUrl, data)
 	if err != nil {
 		s.masterNodes.Reset()
-		return "", "", err
+		return nil, err
 	}
 	var ret operation.JoinResult
 	if err := json.Unmarshal(jsonBlob, &ret); err != nil {
 		glog.V(0).Infof("Failed to join %s with response: %s", joinUrl, string(jsonBlob))
 		s.masterNodes.Reset()
-		return masterNode, "", err
+		return nil, err
 	}
 	if ret.Error != "" {
 		s.masterNodes.Reset()
-		return masterNode, "", errors.New(ret.Error)
+		return nil, errors.New(ret.Error)
 	}
-	s.volumeSizeLimit = ret.VolumeSizeLimit
-	secretKey = security.Secret(ret.SecretKey)
-	s.connected = true
-	return
+	return &ret, nil
 }
+
+//关闭前先把数据和索引刷到磁盘
 func (s *Store) Close() {
-	for _, location := range s.Locations {
+	for _, location := range s.Locations() {
 		for _, v := range location.volumes {
+			if err := v.sync(); err != nil {
+				glog.V(0).Infof("Failed to sync volume %d: %v", v.Id, err)
+			}
 			v.Close()
 		}
 	}
 }
 func (s *Store) Write(i VolumeId, n *Needle) (size uint32, err error) {
-	if v := s.findVolume(i); v != nil {
-		if v.readOnly {
+	return s.WriteIf(i, n, nil)
+}
+
+// WriteIf writes the needle if the condition holds for the current needle with the same id,
+// checked under the volume's data file lock. Otherwise it returns ErrPreconditionFailed.
+func (s *Store) WriteIf(i VolumeId, n *Needle, condition *NeedleCondition) (size uint32, err error) {
+	return s.writeToVolume(i, func(v *Volume) (uint32, error) {
+		return v.writeNeedleIf(n, condition)
+	})
+}
+
+// WriteNeedles appends the needles to one volume under one lock acquisition,
+// with a size or an error for each needle. err is set if the volume cannot be written at all.
+func (s *Store) WriteNeedles(i VolumeId, ns []*Needle) (sizes []uint32, errs []error, err error) {
+	_, err = s.writeToVolume(i, func(v *Volume) (total uint32, e error) {
+		sizes, errs = v.writeNeedles(ns)
+		for j, size := range sizes {
+			s.checkDiskError(i, errs[j])
+			total += size
+		}
+		return
+	})
+	return
+}
+
+// WriteNeedleBlobs appends one or more needles serialized by Needle.Append with the given version,
+// concatenated in data. The bytes are kept as-is if the local volume has the same version.
+func (s *Store) WriteNeedleBlobs(i VolumeId, data []byte, version Version) (size uint32, err error) {
+	ns, blobs, err := SplitNeedleBlobs(data, version)
+	if err != nil {
+		return 0, err
+	}
+	return s.writeToVolume(i, func(v *Volume) (total uint32, e error) {
+		var sizes []uint32
+		var errs []error
+		if v.Version() != version {
+			sizes, errs = v.writeNeedles(ns)
+		} else {
+			sizes, errs = v.writeNeedleBlobs(ns, blobs)
+		}
+		for j, size := range sizes {
+			if errs[j] != nil {
+				return total, errs[j]
+			}
+			total += size
+		}
+		return
+	})
+}
+
+func (s *Store) writeToVolume(i VolumeId, writeFn func(v *Volume) (uint32, error)) (size uint32, err error) {
+	if v := s.acquireVolume(i); v != nil {
+		defer v.release()
+		if v.isReadOnly() {
 			err = fmt.Errorf("Volume %d is read only", i)
 			return
 		}
+		if location := s.findVolumeLocation(i); location != nil && location.checkDiskSpace(s.diskReserve, false) {
+			err = fmt.Errorf("Volume %d is read only, disk %s is low on free space", i, location.Directory)
+			return
+		}
 		if MaxPossibleVolumeSize >= v.ContentSize()+uint64(size) {
-			size, err = v.writeNeedle(n)
+			size, err = writeFn(v)
+			s.checkDiskError(i, err)
 		} else {
 			err = fmt.Errorf("Volume Size Limit %d Exceeded! Current size is %d", s.volumeSizeLimit, v.ContentSize())
 		}
 		if s.volumeSizeLimit < v.ContentSize()+3*uint64(size) {
 			glog.V(0).Infoln("volume", i, "size", v.ContentSize(), "will exceed limit", s.volumeSizeLimit)
-			if _, _, e := s.SendHeartbeatToMaster(); e != nil {
+			if _, e := s.SendHeartbeatToMaster(); e != nil {
 				glog.V(0).Infoln("error when reporting size:", e)
 			}
 		}
@@ -319,17 +626,48 @@ func (s *Store) Write(i VolumeId, n *Needle) (size uint32, err error) {
 	return
 }
 func (s *Store) Delete(i VolumeId, n *Needle) (uint32, error) {
-	if v := s.findVolume(i); v != nil && !v.readOnly {
-		return v.deleteNeedle(n)
+	return s.DeleteIf(i, n, nil)
+}
+
+// DeleteIf deletes the needle if the condition holds for the current needle.
+// Otherwise it returns ErrPreconditionFailed.
+func (s *Store) DeleteIf(i VolumeId, n *Needle, condition *NeedleCondition) (uint32, error) {
+	if v := s.acquireVolume(i); v != nil {
+		defer v.release()
+		if v.isReadOnly() {
+			return 0, nil
+		}
+		size, err := v.deleteNeedleIf(n, condition)
+		s.checkDiskError(i, err)
+		return size, err
 	}
 	return 0, nil
 }
 func (s *Store) ReadVolumeNeedle(i VolumeId, n *Needle) (int, error) {
-	if v := s.findVolume(i); v != nil {
-		return v.readNeedle(n)
+	if v := s.acquireVolume(i); v != nil {
+		defer v.release()
+		count, err := v.readNeedle(n)
+		s.checkDiskError(i, err)
+		return count, err
 	}
 	return 0, fmt.Errorf("Volume %v not found!", i)
 }
+// ReadVolumeNeedles reads the needles of one volume sorted by their offsets,
+// calling fn after each read with the index of the needle in ns.
+func (s *Store) ReadVolumeNeedles(i VolumeId, ns []*Needle, fn func(i int, count int, err error)) {
+	v := s.acquireVolume(i)
+	if v == nil {
+		for j := range ns {
+			fn(j, 0, fmt.Errorf("Volume %v not found!", i))
+		}
+		return
+	}
+	defer v.release()
+	v.readNeedles(ns, func(j int, count int, err error) {
+		s.checkDiskError(i, err)
+		fn(j, count, err)
+	})
+}
 func (s *Store) GetVolume(i VolumeId) *Volume {
 	return s.findVolume(i)
 }
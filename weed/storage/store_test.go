@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestAddAndRemoveLocation(t *testing.T) {
+	dir1, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir1)
+	dir2, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir2)
+
+	rp, _ := NewReplicaPlacementFromString("000")
+	for _, vid := range []VolumeId{1, 2} {
+		v, err := NewVolume(dir2, "", vid, NeedleMapInMemory, rp, EMPTY_TTL)
+		if err != nil {
+			t.Fatalf("new volume %d: %v", vid, err)
+		}
+		v.Close()
+	}
+
+	s := NewStore(8080, "localhost", "localhost:8080", []string{dir1}, []int{3}, NeedleMapInMemory)
+	defer s.Close()
+	if err := s.AddVolume("1", "", NeedleMapInMemory, "000", ""); err != nil {
+		t.Fatalf("add volume: %v", err)
+	}
+
+	if err := s.AddLocation(dir2, 5, NeedleMapInMemory); err != nil {
+		t.Fatalf("add dir: %v", err)
+	}
+	if err := s.AddLocation(dir2, 5, NeedleMapInMemory); err == nil {
+		t.Fatalf("adding the same dir twice should fail")
+	}
+	if len(s.Locations()) != 2 || !s.HasVolume(2) {
+		t.Fatalf("volume 2 should be loaded from the added dir")
+	}
+	if _, found := s.Locations()[1].volumes[1]; found {
+		t.Fatalf("volume 1 is already loaded from the first dir")
+	}
+
+	//卸载要等正在使用卷的请求结束
+	v := s.acquireVolume(2)
+	removed := make(chan error)
+	go func() {
+		removed <- s.RemoveLocation(dir2)
+	}()
+	select {
+	case <-removed:
+		t.Fatalf("removing the dir should wait for the volume in use")
+	case <-time.After(50 * time.Millisecond):
+	}
+	v.release()
+	if err := <-removed; err != nil {
+		t.Fatalf("remove dir: %v", err)
+	}
+	if v.acquire() {
+		t.Fatalf("the volume of the removed dir should be unavailable")
+	}
+	if len(s.Locations()) != 1 || s.HasVolume(2) || !s.HasVolume(1) {
+		t.Fatalf("only the volumes of the removed dir should be unmounted")
+	}
+	if err := s.RemoveLocation(dir2); err == nil {
+		t.Fatalf("removing an unknown dir should fail")
+	}
+}
+
+func TestDiskReserve(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := NewStore(8080, "localhost", "localhost:8080", []string{dir}, []int{3}, NeedleMapInMemory)
+	defer s.Close()
+	s.volumeSizeLimit = 1 << 30
+	if err := s.AddVolume("1", "", NeedleMapInMemory, "000", ""); err != nil {
+		t.Fatalf("add volume: %v", err)
+	}
+	n := &Needle{Id: 1, Cookie: 0x1234, Data: []byte("written before the disk is full")}
+	n.Checksum = NewCRC(n.Data)
+	if _, err := s.Write(1, n); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	// no disk has a petabyte free
+	s.SetDiskReserveMB(1 << 30)
+	if s.DiskHealths()[0].FreeBytes == 0 {
+		t.Skip("disk stats are not supported on this platform")
+	}
+	if !s.Locations()[0].IsLowDiskSpace() || !s.Status()[0].ReadOnly {
+		t.Fatalf("volumes on a disk below the reserve should be read only")
+	}
+	if err := s.AddVolume("2", "", NeedleMapInMemory, "000", ""); err == nil {
+		t.Fatalf("a disk below the reserve should get no new volumes")
+	}
+	if _, err := s.Write(1, n); err == nil {
+		t.Fatalf("writes to a disk below the reserve should fail")
+	}
+	if size, err := s.Delete(1, &Needle{Id: 1, Cookie: 0x1234}); err != nil || size == 0 {
+		t.Fatalf("deletes should still work: %d %v", size, err)
+	}
+
+	s.SetDiskReserveMB(0)
+	if _, err := s.Write(1, n); err != nil {
+		t.Fatalf("write after freeing space: %v", err)
+	}
+}
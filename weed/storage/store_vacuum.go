@@ -22,13 +22,14 @@ func (s *Store) CheckCompactVolume(volumeIdString string, garbageThresholdString
 	}
 	return fmt.Errorf("volume id %d is not found during check compact", vid), false
 }
-func (s *Store) CompactVolume(volumeIdString string) error {
+func (s *Store) CompactVolume(volumeIdString string, compactionBytePerSecond int64) error {
 	vid, err := NewVolumeId(volumeIdString)
 	if err != nil {
 		return fmt.Errorf("Volume Id %s is not a valid unsigned integer", volumeIdString)
 	}
-	if v := s.findVolume(vid); v != nil {
-		return v.Compact()
+	if v := s.acquireVolume(vid); v != nil {
+		defer v.release()
+		return v.Compact(compactionBytePerSecond)
 	}
 	return fmt.Errorf("volume id %d is not found during compact", vid)
 }
@@ -37,7 +38,8 @@ func (s *Store) CommitCompactVolume(volumeIdString string) error {
 	if err != nil {
 		return fmt.Errorf("Volume Id %s is not a valid unsigned integer", volumeIdString)
 	}
-	if v := s.findVolume(vid); v != nil {
+	if v := s.acquireVolume(vid); v != nil {
+		defer v.release()
 		return v.commitCompact()
 	}
 	return fmt.Errorf("volume id %d is not found during commit compact", vid)
@@ -5,6 +5,7 @@ import (
 	"os"
 	"path"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/chrislusf/seaweedfs/weed/glog"
@@ -18,12 +19,20 @@ type Volume struct {
 	dataFile      *os.File      //数据文件
 	nm            NeedleMapper  //针map
 	needleMapKind NeedleMapType //针map类型
-	readOnly      bool          //是否只读
+	readOnly      int32         //是否只读，写入时不加锁读取，磁盘故障时由其它请求设置，所以用atomic
 
 	SuperBlock //继承超级块
 
 	dataFileAccessLock sync.Mutex // 锁
+	syncLock           sync.Mutex //刷盘时持有，压缩提交和关闭替换文件时也要持有
 	lastModifiedTime   uint64     //unix time in seconds //上一次被修改的时间
+	loadSequence       uint64     //加载序号，用于区分缓存
+
+	durability Durability      //写入后的刷盘方式
+	committer  *groupCommitter //组提交
+
+	useLock  sync.RWMutex //请求使用卷期间持有读锁，卸载时等待
+	detached bool         //所在磁盘目录已卸载，卷不再可用
 }
 
 //卷的构造函数
@@ -37,7 +46,7 @@ func NewVolume(dirname string, collection string, id VolumeId, needleMapKind Nee
 
 //卷的打印函数
 func (v *Volume) String() string {
-	return fmt.Sprintf("Id:%v, dir:%s, Collection:%s, dataFile:%v, nm:%v, readOnly:%v", v.Id, v.dir, v.Collection, v.dataFile, v.nm, v.readOnly)
+	return fmt.Sprintf("Id:%v, dir:%s, Collection:%s, dataFile:%v, nm:%v, readOnly:%v", v.Id, v.dir, v.Collection, v.dataFile, v.nm, v.isReadOnly())
 }
 
 //获取卷的文件名
@@ -78,8 +87,74 @@ func (v *Volume) Size() int64 {
 func (v *Volume) Close() {
 	v.dataFileAccessLock.Lock()
 	defer v.dataFileAccessLock.Unlock()
+	v.syncLock.Lock()
+	defer v.syncLock.Unlock()
+	v.nm.Close()
+	_ = v.dataFile.Close()
+}
+
+// acquire keeps the volume open until release, and returns false if the volume is detached.
+func (v *Volume) acquire() bool {
+	v.useLock.RLock()
+	if v.detached {
+		v.useLock.RUnlock()
+		return false
+	}
+	return true
+}
+
+func (v *Volume) release() {
+	v.useLock.RUnlock()
+}
+
+// detach marks the volume unavailable, waits for the requests using it, and closes it.
+func (v *Volume) detach() {
+	v.useLock.Lock()
+	v.detached = true
+	v.useLock.Unlock()
+	v.Close()
+}
+
+func (v *Volume) isReadOnly() bool {
+	return atomic.LoadInt32(&v.readOnly) != 0
+}
+
+func (v *Volume) setReadOnly(readOnly bool) {
+	var value int32
+	if readOnly {
+		value = 1
+	}
+	atomic.StoreInt32(&v.readOnly, value)
+}
+
+// MarkReadOnly persists the read-only flag in the super block,
+// so the volume stays frozen, or becomes writable again, across restarts.
+func (v *Volume) MarkReadOnly(readOnly bool) error {
+	v.dataFileAccessLock.Lock()
+	defer v.dataFileAccessLock.Unlock()
+	if readOnly == v.IsMarkedReadOnly() {
+		return nil
+	}
+	if readOnly {
+		v.Flags = v.Flags | SuperBlockFlagReadOnly
+	} else {
+		v.Flags = v.Flags &^ SuperBlockFlagReadOnly
+	}
+	if e := v.writeSuperBlock(); e != nil {
+		v.Flags = v.Flags ^ SuperBlockFlagReadOnly
+		return e
+	}
+	if readOnly {
+		v.setReadOnly(true)
+		return nil
+	}
+	// reload to reopen the index file for writing
+	v.syncLock.Lock()
+	defer v.syncLock.Unlock()
 	v.nm.Close()
 	_ = v.dataFile.Close()
+	v.setReadOnly(false)
+	return v.load(true, false, v.needleMapKind)
 }
 
 //判断是否需要复制
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/chrislusf/seaweedfs/weed/glog"
 	"github.com/chrislusf/seaweedfs/weed/util"
 )
 
@@ -74,3 +75,96 @@ func verifyNeedleIntegrity(datFile *os.File, v Version, offset int64, key uint64
 	}
 	return nil
 }
+
+// recoverVolumeTail repairs the end of a volume left by a crash.
+// Index entries pointing to torn needles are dropped, complete needles appended to the data file
+// after the last indexed one get their index entries, and a torn needle at the end of the data file is truncated.
+func recoverVolumeTail(v *Volume, indexFile *os.File) error {
+	indexSize, err := util.GetFileSize(indexFile)
+	if err != nil {
+		return err
+	}
+	//截掉不完整的索引项
+	if indexSize%NeedleIndexSize != 0 {
+		glog.V(0).Infof("truncating the torn index entry at the end of %s", indexFile.Name())
+		indexSize -= indexSize % NeedleIndexSize
+		if err = indexFile.Truncate(indexSize); err != nil {
+			return err
+		}
+	}
+	//找到最后一个完整的针，丢掉指向残缺针的索引项，保留其后的删除项
+	dataEnd := int64(SuperBlockSize)
+	for pos := indexSize - NeedleIndexSize; pos >= 0; pos -= NeedleIndexSize {
+		entry, err := readIndexEntryAtOffset(indexFile, pos)
+		if err != nil {
+			return err
+		}
+		key, offset, size := idxFileEntry(entry)
+		if offset == 0 {
+			continue
+		}
+		if verifyNeedleIntegrity(v.dataFile, v.Version(), int64(offset)*NeedlePaddingSize, key, size) == nil {
+			dataEnd = int64(offset)*NeedlePaddingSize + (&Needle{Size: size}).DiskSize()
+			break
+		}
+		glog.V(0).Infof("dropping the index entry of torn needle %x at offset %d in %s", key, int64(offset)*NeedlePaddingSize, indexFile.Name())
+		tail := make([]byte, indexSize-pos-NeedleIndexSize)
+		if _, err = indexFile.ReadAt(tail, pos+NeedleIndexSize); err != nil {
+			return err
+		}
+		if err = indexFile.Truncate(pos); err != nil {
+			return err
+		}
+		if _, err = indexFile.WriteAt(tail, pos); err != nil {
+			return err
+		}
+		indexSize -= NeedleIndexSize
+	}
+	//扫描最后一个完整针之后的数据
+	dataSize, err := util.GetFileSize(v.dataFile)
+	if err != nil {
+		return err
+	}
+	offset := dataEnd
+	var entries []byte
+	added := make(map[uint64]bool)
+	for offset < dataSize {
+		n, bodyLength, err := ReadNeedleHeader(v.dataFile, v.Version(), offset)
+		if err != nil || n == nil || offset+NeedleHeaderSize+int64(bodyLength) > dataSize {
+			break
+		}
+		//文件系统崩溃后可能留下全零的数据
+		if n.Id == 0 && n.Cookie == 0 {
+			break
+		}
+		if err = n.ReadData(v.dataFile, offset, n.Size, v.Version()); err != nil {
+			break
+		}
+		n.ReleaseMemory()
+		//删除标记的索引项在写删除标记之前已经写入，只有删除的是这里补上的针时才补删除项
+		if n.Size > 0 || added[n.Id] {
+			entry := make([]byte, NeedleIndexSize)
+			util.Uint64toBytes(entry[0:8], n.Id)
+			if n.Size > 0 {
+				util.Uint32toBytes(entry[8:12], uint32(offset/NeedlePaddingSize))
+				util.Uint32toBytes(entry[12:16], n.Size)
+			}
+			entries = append(entries, entry...)
+			added[n.Id] = n.Size > 0
+		}
+		offset += NeedleHeaderSize + int64(bodyLength)
+	}
+	if len(entries) > 0 {
+		glog.V(0).Infof("adding %d index entries of needles found after the last indexed one in %s", len(entries)/NeedleIndexSize, indexFile.Name())
+		if _, err = indexFile.WriteAt(entries, indexSize); err != nil {
+			return err
+		}
+	}
+	if offset < dataSize {
+		glog.V(0).Infof("truncating %d bytes of torn needle at the end of %s", dataSize-offset, v.dataFile.Name())
+		if err = v.dataFile.Truncate(offset); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Durability is when appended needles are synced to disk before the write is acknowledged.
+type Durability int
+
+const (
+	DurabilityNone        Durability = iota //交给操作系统刷盘
+	DurabilityFsync                         //每次写入后fsync
+	DurabilityGroupCommit                   //并发的写入合并起来，每隔一段时间fsync一次
+)
+
+const DefaultGroupCommitMs = 10
+
+func ParseDurability(s string) (Durability, error) {
+	switch strings.ToLower(s) {
+	case "", "none":
+		return DurabilityNone, nil
+	case "fsync":
+		return DurabilityFsync, nil
+	case "group":
+		return DurabilityGroupCommit, nil
+	}
+	return DurabilityNone, fmt.Errorf("unknown durability %s, should be none, fsync or group", s)
+}
+
+func (d Durability) String() string {
+	switch d {
+	case DurabilityFsync:
+		return "fsync"
+	case DurabilityGroupCommit:
+		return "group"
+	}
+	return "none"
+}
+
+// DurabilityPolicy is the durability of the volumes of a store, optionally per collection.
+type DurabilityPolicy struct {
+	Default             Durability
+	Collections         map[string]Durability
+	GroupCommitInterval time.Duration
+}
+
+// NewDurabilityPolicy parses the server durability, and the per collection ones in the form of collection:durability,...
+func NewDurabilityPolicy(durability string, collectionDurabilities string, groupCommitMs int) (*DurabilityPolicy, error) {
+	p := &DurabilityPolicy{
+		Collections:         make(map[string]Durability),
+		GroupCommitInterval: time.Duration(groupCommitMs) * time.Millisecond,
+	}
+	var err error
+	if p.Default, err = ParseDurability(durability); err != nil {
+		return nil, err
+	}
+	if collectionDurabilities == "" {
+		return p, nil
+	}
+	for _, pair := range strings.Split(collectionDurabilities, ",") {
+		parts := strings.Split(pair, ":")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("expecting collection:durability, but got %s", pair)
+		}
+		if p.Collections[parts[0]], err = ParseDurability(parts[1]); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+func (p *DurabilityPolicy) durabilityOf(collection string) Durability {
+	if p == nil {
+		return DurabilityNone
+	}
+	if d, ok := p.Collections[collection]; ok {
+		return d
+	}
+	return p.Default
+}
+
+func (v *Volume) setDurability(p *DurabilityPolicy) {
+	v.durability = p.durabilityOf(v.Collection)
+	if v.durability == DurabilityGroupCommit && v.committer == nil {
+		v.committer = newGroupCommitter(p.GroupCommitInterval, v.sync)
+	}
+}
+
+//把数据文件和索引文件刷到磁盘
+//调用时不持有dataFileAccessLock，由syncLock防止压缩提交同时替换文件
+func (v *Volume) sync() error {
+	v.syncLock.Lock()
+	defer v.syncLock.Unlock()
+	if err := v.dataFile.Sync(); err != nil {
+		return err
+	}
+	return v.nm.Sync()
+}
+
+// syncAppended makes the needles appended so far durable according to the volume's durability.
+// It is called after releasing the data file lock, so other writers can append while syncing.
+func (v *Volume) syncAppended() error {
+	switch v.durability {
+	case DurabilityFsync:
+		return v.sync()
+	case DurabilityGroupCommit:
+		return v.committer.commit()
+	}
+	return nil
+}
+
+// groupCommitter batches the syncs of concurrent writers.
+// Each writer waits for the first sync started after it joined a batch.
+type groupCommitter struct {
+	sync.Mutex
+	interval time.Duration
+	syncFn   func() error
+	batch    *commitBatch
+}
+
+type commitBatch struct {
+	done chan struct{}
+	err  error
+}
+
+func newGroupCommitter(interval time.Duration, syncFn func() error) *groupCommitter {
+	return &groupCommitter{interval: interval, syncFn: syncFn}
+}
+
+func (g *groupCommitter) commit() error {
+	g.Lock()
+	b := g.batch
+	if b == nil {
+		b = &commitBatch{done: make(chan struct{})}
+		g.batch = b
+		time.AfterFunc(g.interval, func() {
+			g.flush(b)
+		})
+	}
+	g.Unlock()
+	<-b.done
+	return b.err
+}
+
+func (g *groupCommitter) flush(b *commitBatch) {
+	//先摘下批次，之后加入的写入等待下一次刷盘
+	g.Lock()
+	if g.batch == b {
+		g.batch = nil
+	}
+	g.Unlock()
+	b.err = g.syncFn()
+	close(b.done)
+}
@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGroupCommit(t *testing.T) {
+	var syncs int32
+	g := newGroupCommitter(20*time.Millisecond, func() error {
+		atomic.AddInt32(&syncs, 1)
+		return nil
+	})
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := g.commit(); err != nil {
+				t.Errorf("commit: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if syncs == 0 || syncs > 2 {
+		t.Fatalf("expected concurrent commits to share one or two syncs, got %d", syncs)
+	}
+}
+
+func TestDurabilityPolicy(t *testing.T) {
+	p, err := NewDurabilityPolicy("group", "billing:fsync,logs:none", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.durabilityOf("billing") != DurabilityFsync || p.durabilityOf("logs") != DurabilityNone || p.durabilityOf("") != DurabilityGroupCommit {
+		t.Fatalf("unexpected durabilities %+v", p)
+	}
+	if _, err = NewDurabilityPolicy("always", "", 5); err == nil {
+		t.Fatalf("unknown durability should fail")
+	}
+}
+
+func TestRecoverVolumeTail(t *testing.T) {
+	dir, err := ioutil.TempDir("", "volume")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	rp, _ := NewReplicaPlacementFromString("000")
+	v, err := NewVolume(dir, "", 1, NeedleMapInMemory, rp, EMPTY_TTL)
+	if err != nil {
+		t.Fatalf("new volume: %v", err)
+	}
+	v.setDurability(&DurabilityPolicy{Default: DurabilityGroupCommit, GroupCommitInterval: time.Millisecond})
+	for i := 1; i <= 3; i++ {
+		n := &Needle{Id: uint64(i), Cookie: 0x1234, Data: []byte("needle written before the crash")}
+		n.Checksum = NewCRC(n.Data)
+		if _, err := v.writeNeedle(n); err != nil {
+			t.Fatalf("write needle %d: %v", i, err)
+		}
+	}
+	dataSize, contentSize := v.Size(), v.ContentSize()
+	fileName := v.FileName()
+	v.Close()
+
+	// the index entry of needle 3 is lost, and a needle is torn at the end of the data file
+	if err := os.Truncate(fileName+".idx", 2*NeedleIndexSize); err != nil {
+		t.Fatal(err)
+	}
+	dat, _ := os.OpenFile(fileName+".dat", os.O_WRONLY|os.O_APPEND, 0644)
+	dat.Write([]byte{0, 0, 0x12, 0x34, 0, 0, 0, 0, 0, 0, 0, 4, 0, 0, 0, 100, 1, 2, 3})
+	dat.Close()
+
+	v, err = NewVolume(dir, "", 1, NeedleMapInMemory, nil, nil)
+	if err != nil {
+		t.Fatalf("reload volume: %v", err)
+	}
+	defer v.Close()
+	if v.isReadOnly() {
+		t.Fatalf("recovered volume should be writable")
+	}
+	n := &Needle{Id: 3}
+	if _, err := v.readNeedle(n); err != nil {
+		t.Fatalf("needle 3 should be indexed again: %v", err)
+	}
+	n.ReleaseMemory()
+	if size := v.ContentSize(); size != contentSize {
+		t.Fatalf("content size %d, expected %d", size, contentSize)
+	}
+	if size := v.Size(); size != dataSize {
+		t.Fatalf("torn needle is not truncated, data file size %d, expected %d", size, dataSize)
+	}
+}
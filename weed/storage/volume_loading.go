@@ -3,6 +3,7 @@ package storage
 import (
 	"fmt"
 	"os"
+	"sync/atomic"
 	"time"
 
 	"github.com/chrislusf/seaweedfs/weed/glog"
@@ -40,7 +41,7 @@ func (v *Volume) load(alsoLoadIndex bool, createDatIfMissing bool, needleMapKind
 			//不可写，打开文件，设置卷为只读
 			glog.V(0).Infoln("opening " + fileName + ".dat in READONLY mode")
 			v.dataFile, e = os.Open(fileName + ".dat")
-			v.readOnly = true
+			v.setReadOnly(true)
 		}
 	} else {
 		//文件不存在
@@ -52,6 +53,7 @@ func (v *Volume) load(alsoLoadIndex bool, createDatIfMissing bool, needleMapKind
 		}
 	}
 
+	v.loadSequence = atomic.AddUint64(&volumeLoadSequence, 1)
 	if e != nil {
 		if !os.IsPermission(e) { //没有权限时报错
 			return fmt.Errorf("cannot load Volume Data %s.dat: %v", fileName, e)
@@ -63,9 +65,13 @@ func (v *Volume) load(alsoLoadIndex bool, createDatIfMissing bool, needleMapKind
 	} else {
 		e = v.maybeWriteSuperBlock()
 	}
+	if e == nil && v.IsMarkedReadOnly() {
+		glog.V(0).Infoln("volume", v.Id, "is marked read-only")
+		v.setReadOnly(true)
+	}
 	if e == nil && alsoLoadIndex {
 		var indexFile *os.File
-		if v.readOnly {
+		if v.isReadOnly() {
 			glog.V(1).Infoln("open to read file", fileName+".idx")
 			if indexFile, e = os.OpenFile(fileName+".idx", os.O_RDONLY, 0644); e != nil {
 				return fmt.Errorf("cannot read Volume Index %s.idx: %v", fileName, e)
@@ -76,14 +82,19 @@ func (v *Volume) load(alsoLoadIndex bool, createDatIfMissing bool, needleMapKind
 				return fmt.Errorf("cannot write Volume Index %s.idx: %v", fileName, e)
 			}
 		}
+		if !v.isReadOnly() {
+			if e = recoverVolumeTail(v, indexFile); e != nil {
+				glog.V(0).Infof("recovering the tail of volume %d failed: %v", v.Id, e)
+			}
+		}
 		if e = CheckVolumeDataIntegrity(v, indexFile); e != nil {
-			v.readOnly = true
+			v.setReadOnly(true)
 			glog.V(0).Infof("volumeDataIntegrityChecking failed %v", e)
 		}
 		//根据文件类型，获取不同的nm
 		switch needleMapKind {
 		case NeedleMapInMemory:
-			glog.V(0).Infoln("loading index file", fileName+".idx", "readonly", v.readOnly)
+			glog.V(0).Infoln("loading index file", fileName+".idx", "readonly", v.isReadOnly())
 			if v.nm, e = LoadNeedleMap(indexFile); e != nil {
 				glog.V(0).Infof("loading index %s error: %v", fileName+".idx", e)
 			}
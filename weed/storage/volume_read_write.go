@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"sort"
 	"time"
 
 	"github.com/chrislusf/seaweedfs/weed/glog"
@@ -42,7 +43,7 @@ func (v *Volume) isFileUnchanged(n *Needle) bool {
 // Destroy removes everything related to this volume
 //销毁掉此卷关联的所有的信息
 func (v *Volume) Destroy() (err error) {
-	if v.readOnly { //如果卷只读，报错
+	if v.isReadOnly() { //如果卷只读，报错
 		err = fmt.Errorf("%s is read-only", v.dataFile.Name())
 		return
 	}
@@ -60,7 +61,7 @@ func (v *Volume) Destroy() (err error) {
 // AppendBlob append a blob to end of the data file, used in replication
 //追加对象文件到数据文件末尾，用于复制
 func (v *Volume) AppendBlob(b []byte) (offset int64, err error) {
-	if v.readOnly { //如果只读报错
+	if v.isReadOnly() { //如果只读报错
 		err = fmt.Errorf("%s is read-only", v.dataFile.Name())
 		return
 	}
@@ -88,14 +89,98 @@ func (v *Volume) AppendBlob(b []byte) (offset int64, err error) {
 
 //写文件
 func (v *Volume) writeNeedle(n *Needle) (size uint32, err error) {
+	return v.writeNeedleIf(n, nil)
+}
+
+// writeNeedleIf writes the needle if the condition holds for the current needle with the same id.
+func (v *Volume) writeNeedleIf(n *Needle, condition *NeedleCondition) (size uint32, err error) {
+	return v.appendNeedle(n, condition, func() (uint32, error) {
+		return n.Append(v.dataFile, v.Version())
+	})
+}
+
+// writeNeedleBlob appends a needle already serialized in this volume's version, byte by byte.
+func (v *Volume) writeNeedleBlob(n *Needle, blob []byte) (size uint32, err error) {
+	return v.appendNeedle(n, nil, func() (uint32, error) {
+		if _, err := v.dataFile.Write(blob); err != nil {
+			return 0, err
+		}
+		if v.Version() == Version1 {
+			return n.Size, nil
+		}
+		return n.DataSize, nil
+	})
+}
+
+//在文件末尾追加needle，appendFn负责写入needle的内容，condition不为空时先检查当前needle的ETag
+func (v *Volume) appendNeedle(n *Needle, condition *NeedleCondition, appendFn func() (uint32, error)) (size uint32, err error) {
 	glog.V(4).Infof("writing needle %s", NewFileIdFromNeedle(v.Id, n).String())
-	if v.readOnly { //如果卷只读，报错
+	if v.isReadOnly() { //如果卷只读，报错
 		err = fmt.Errorf("%s is read-only", v.dataFile.Name())
 		return
 	}
 	//加锁
 	v.dataFileAccessLock.Lock()
-	defer v.dataFileAccessLock.Unlock()
+	if err = v.checkCondition(n.Id, condition); err == nil {
+		size, err = v.appendNeedleLocked(n, appendFn)
+	}
+	v.dataFileAccessLock.Unlock()
+	//释放锁之后再刷盘，其它写入可以继续追加
+	if err == nil {
+		err = v.syncAppended()
+	}
+	return
+}
+
+// writeNeedles appends the needles under one lock acquisition, with a size or an error for each needle.
+func (v *Volume) writeNeedles(ns []*Needle) (sizes []uint32, errs []error) {
+	return v.appendNeedles(ns, func(i int) (uint32, error) {
+		return ns[i].Append(v.dataFile, v.Version())
+	})
+}
+
+// writeNeedleBlobs appends needles already serialized in this volume's version, under one lock acquisition.
+func (v *Volume) writeNeedleBlobs(ns []*Needle, blobs [][]byte) (sizes []uint32, errs []error) {
+	return v.appendNeedles(ns, func(i int) (uint32, error) {
+		if _, err := v.dataFile.Write(blobs[i]); err != nil {
+			return 0, err
+		}
+		if v.Version() == Version1 {
+			return ns[i].Size, nil
+		}
+		return ns[i].DataSize, nil
+	})
+}
+
+//批量追加needle，只加一次锁，appendFn负责写入第i个needle的内容
+func (v *Volume) appendNeedles(ns []*Needle, appendFn func(i int) (uint32, error)) (sizes []uint32, errs []error) {
+	sizes, errs = make([]uint32, len(ns)), make([]error, len(ns))
+	if v.isReadOnly() {
+		for i := range ns {
+			errs[i] = fmt.Errorf("%s is read-only", v.dataFile.Name())
+		}
+		return
+	}
+	v.dataFileAccessLock.Lock()
+	for i, n := range ns {
+		glog.V(4).Infof("writing needle %s", NewFileIdFromNeedle(v.Id, n).String())
+		sizes[i], errs[i] = v.appendNeedleLocked(n, func() (uint32, error) {
+			return appendFn(i)
+		})
+	}
+	v.dataFileAccessLock.Unlock()
+	if err := v.syncAppended(); err != nil {
+		for i := range ns {
+			if errs[i] == nil {
+				errs[i] = err
+			}
+		}
+	}
+	return
+}
+
+//持有锁的情况下追加needle
+func (v *Volume) appendNeedleLocked(n *Needle, appendFn func() (uint32, error)) (size uint32, err error) {
 	//如果文件已经写过，直接返回
 	if v.isFileUnchanged(n) {
 		size = n.DataSize
@@ -120,13 +205,13 @@ func (v *Volume) writeNeedle(n *Needle) (size uint32, err error) {
 		}
 	}
 	//写内容，如果出错了，truncate，已经写过了的内容
-	if size, err = n.Append(v.dataFile, v.Version()); err != nil {
+	if size, err = appendFn(); err != nil {
 		if e := v.dataFile.Truncate(offset); e != nil {
 			err = fmt.Errorf("%s\ncannot truncate %s: %v", err, v.dataFile.Name(), e)
 		}
 		return
 	}
-	跟id去获取写入的内容
+	//跟id去获取写入的内容
 	nv, ok := v.nm.Get(n.Id)
 	//如果不对，写日志
 	if !ok || int64(nv.Offset)*NeedlePaddingSize < offset {
@@ -141,15 +226,51 @@ func (v *Volume) writeNeedle(n *Needle) (size uint32, err error) {
 	return
 }
 
+//持有锁的情况下，根据当前needle的ETag检查写入或删除的条件
+func (v *Volume) checkCondition(id uint64, condition *NeedleCondition) error {
+	if condition == nil {
+		return nil
+	}
+	etag := ""
+	if nv, ok := v.nm.Get(id); ok && nv.Offset != 0 && nv.Size != 0 {
+		current := new(Needle)
+		if err := current.ReadData(v.dataFile, int64(nv.Offset)*NeedlePaddingSize, nv.Size, v.Version()); err != nil {
+			return err
+		}
+		etag = current.Etag()
+		current.ReleaseMemory()
+	}
+	if !condition.Matches(etag) {
+		return ErrPreconditionFailed
+	}
+	return nil
+}
+
 //删除文件
 func (v *Volume) deleteNeedle(n *Needle) (uint32, error) {
+	return v.deleteNeedleIf(n, nil)
+}
+
+// deleteNeedleIf deletes the needle if the condition holds for the current needle.
+func (v *Volume) deleteNeedleIf(n *Needle, condition *NeedleCondition) (uint32, error) {
 	glog.V(4).Infof("delete needle %s", NewFileIdFromNeedle(v.Id, n).String())
-	if v.readOnly { //如果卷只读，报错
+	if v.isReadOnly() { //如果卷只读，报错
 		return 0, fmt.Errorf("%s is read-only", v.dataFile.Name())
 	}
+	size, err := v.deleteNeedleLocked(n, condition)
+	if err == nil && size > 0 {
+		err = v.syncAppended()
+	}
+	return size, err
+}
+
+func (v *Volume) deleteNeedleLocked(n *Needle, condition *NeedleCondition) (uint32, error) {
 	//加锁
 	v.dataFileAccessLock.Lock()
 	defer v.dataFileAccessLock.Unlock()
+	if err := v.checkCondition(n.Id, condition); err != nil {
+		return 0, err
+	}
 	//通过id获取内容
 	nv, ok := v.nm.Get(n.Id)
 	//fmt.Println("key", n.Id, "volume offset", nv.Offset, "data_size", n.Size, "cached size", nv.Size)
@@ -181,8 +302,8 @@ func (v *Volume) readNeedle(n *Needle) (int, error) {
 	if !ok || nv.Offset == 0 {
 		return -1, errors.New("Not Found")
 	}
-	//根据offset，读取内容
-	err := n.ReadData(v.dataFile, int64(nv.Offset)*NeedlePaddingSize, nv.Size, v.Version())
+	//根据offset，优先从缓存读取内容
+	err := v.readNeedleData(n, nv)
 	if err != nil {
 		return 0, err
 	}
@@ -210,6 +331,34 @@ func (v *Volume) readNeedle(n *Needle) (int, error) {
 	return -1, errors.New("Not Found")
 }
 
+// readNeedles reads the needles in the order of their offsets in the data file, for sequential I/O.
+// fn is called after each read with the index of the needle in ns.
+func (v *Volume) readNeedles(ns []*Needle, fn func(i int, count int, err error)) {
+	order := make(needleOffsets, len(ns))
+	for i, n := range ns {
+		order[i].index = i
+		if nv, ok := v.nm.Get(n.Id); ok {
+			order[i].offset = nv.Offset
+		}
+	}
+	sort.Stable(order)
+	for _, o := range order {
+		count, err := v.readNeedle(ns[o.index])
+		fn(o.index, count, err)
+	}
+}
+
+type needleOffset struct {
+	index  int
+	offset uint32
+}
+
+type needleOffsets []needleOffset
+
+func (s needleOffsets) Len() int           { return len(s) }
+func (s needleOffsets) Less(i, j int) bool { return s[i].offset < s[j].offset }
+func (s needleOffsets) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
 func ScanVolumeFile(dirname string, collection string, id VolumeId,
 	needleMapKind NeedleMapType,
 	visitSuperBlock func(SuperBlock) error,
@@ -268,3 +417,16 @@ func ScanVolumeFile(dirname string, collection string, id VolumeId,
 
 	return
 }
+
+//通过缓存读取针数据，缓存key由卷的加载序号、偏移和长度组成
+func (v *Volume) readNeedleData(n *Needle, nv *NeedleValue) error {
+	offset := int64(nv.Offset) * NeedlePaddingSize
+	readSize := int((&Needle{Size: nv.Size}).DiskSize())
+	key := fmt.Sprintf("%d:%d:%d", v.loadSequence, nv.Offset, readSize)
+	bytes, block, err := needleCache.getBytesForFileBlock(v.Collection, key, v.dataFile, offset, readSize)
+	if err != nil {
+		return err
+	}
+	n.rawBlock = block
+	return n.parseNeedleData(bytes, nv.Size, v.Version())
+}
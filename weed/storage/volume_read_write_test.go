@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+)
+
+func TestReadNeedlesInOffsetOrder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "volume")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	rp, _ := NewReplicaPlacementFromString("000")
+	v, err := NewVolume(dir, "", 1, NeedleMapInMemory, rp, EMPTY_TTL)
+	if err != nil {
+		t.Fatalf("new volume: %v", err)
+	}
+	defer v.Close()
+
+	var written []*Needle
+	for i := 1; i <= 3; i++ {
+		n := &Needle{Id: uint64(i), Cookie: 0x1234, Data: []byte{byte(i), byte(i)}}
+		n.Checksum = NewCRC(n.Data)
+		written = append(written, n)
+	}
+	_, errs := v.writeNeedles(written)
+	for i, e := range errs {
+		if e != nil {
+			t.Fatalf("write needle %d: %v", i, e)
+		}
+	}
+
+	ns := []*Needle{{Id: 3}, {Id: 42}, {Id: 1}, {Id: 2}}
+	var order []uint64
+	v.readNeedles(ns, func(i int, count int, err error) {
+		if ns[i].Id == 42 {
+			if err == nil {
+				t.Fatalf("expected error for missing needle")
+			}
+			return
+		}
+		if err != nil || count != 2 || ns[i].Data[0] != byte(ns[i].Id) {
+			t.Fatalf("read needle %d: count %d, error %v", ns[i].Id, count, err)
+		}
+		order = append(order, ns[i].Id)
+	})
+	if len(order) != 3 || order[0] != 1 || order[1] != 2 || order[2] != 3 {
+		t.Fatalf("needles are not read in offset order: %v", order)
+	}
+}
+
+func TestConditionalWriteAndDelete(t *testing.T) {
+	dir, err := ioutil.TempDir("", "volume")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	rp, _ := NewReplicaPlacementFromString("000")
+	v, err := NewVolume(dir, "", 1, NeedleMapInMemory, rp, EMPTY_TTL)
+	if err != nil {
+		t.Fatalf("new volume: %v", err)
+	}
+	defer v.Close()
+
+	newNeedle := func(data string) *Needle {
+		n := &Needle{Id: 1, Cookie: 0x1234, Data: []byte("conditional write of " + data)}
+		n.Checksum = NewCRC(n.Data)
+		return n
+	}
+	header := func(name, value string) *NeedleCondition {
+		h := make(http.Header)
+		h.Set(name, value)
+		return NewNeedleCondition(h)
+	}
+
+	if _, err := v.writeNeedleIf(newNeedle("v1"), header("If-Match", "*")); err != ErrPreconditionFailed {
+		t.Fatalf("If-Match on missing needle: %v", err)
+	}
+	first := newNeedle("v1")
+	if _, err := v.writeNeedleIf(first, header("If-None-Match", "*")); err != nil {
+		t.Fatalf("If-None-Match on missing needle: %v", err)
+	}
+	if _, err := v.writeNeedleIf(newNeedle("v1"), header("If-None-Match", "*")); err != ErrPreconditionFailed {
+		t.Fatalf("If-None-Match on existing needle: %v", err)
+	}
+	if _, err := v.writeNeedleIf(newNeedle("v2"), header("If-Match", `"deadbeef"`)); err != ErrPreconditionFailed {
+		t.Fatalf("If-Match with stale etag: %v", err)
+	}
+	second := newNeedle("v2")
+	if _, err := v.writeNeedleIf(second, header("If-Match", `"deadbeef", W/`+first.Etag())); err != nil {
+		t.Fatalf("If-Match with current etag: %v", err)
+	}
+	if _, err := v.deleteNeedleIf(newNeedle(""), header("If-Match", first.Etag())); err != ErrPreconditionFailed {
+		t.Fatalf("delete with stale etag: %v", err)
+	}
+	if _, err := v.deleteNeedleIf(newNeedle(""), header("If-Match", second.Etag())); err != nil {
+		t.Fatalf("delete with current etag: %v", err)
+	}
+	if _, err := v.writeNeedleIf(newNeedle("v3"), header("If-None-Match", "*")); err != nil {
+		t.Fatalf("If-None-Match on deleted needle: %v", err)
+	}
+}
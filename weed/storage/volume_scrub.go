@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+// ScrubResult is the outcome of verifying the live needles of a volume.
+// Corrupted maps the hex needle keys to the verification errors.
+type ScrubResult struct {
+	Volume    VolumeId          `json:"volume"`
+	Checked   int               `json:"checked"`
+	Corrupted map[string]string `json:"corrupted,omitempty"`
+}
+
+// Scrub reads every live needle from the data file, bypassing the read cache,
+// and verifies its checksum and content digest.
+func (v *Volume) Scrub() (*ScrubResult, error) {
+	indexFile, err := os.Open(v.nm.IndexFileName())
+	if err != nil {
+		return nil, fmt.Errorf("Open volume %d index file: %v", v.Id, err)
+	}
+	defer indexFile.Close()
+	nm, err := LoadNeedleMap(indexFile)
+	if err != nil {
+		return nil, fmt.Errorf("Load volume %d index file: %v", v.Id, err)
+	}
+	var live []NeedleValue
+	nm.m.Visit(func(nv NeedleValue) error {
+		if nv.Key != 0 && nv.Offset > 0 && nv.Size > 0 {
+			live = append(live, nv)
+		}
+		return nil
+	})
+	//按偏移顺序读取，顺序读盘
+	sort.Sort(ByOffset(live))
+	ret := &ScrubResult{Volume: v.Id, Corrupted: make(map[string]string)}
+	for _, nv := range live {
+		ret.Checked++
+		if err := v.scrubNeedle(nv); err != nil {
+			ret.Corrupted[fmt.Sprintf("%x", uint64(nv.Key))] = err.Error()
+		}
+	}
+	return ret, nil
+}
+
+func (v *Volume) scrubNeedle(nv NeedleValue) error {
+	blob := make([]byte, (&Needle{Size: nv.Size}).DiskSize())
+	if _, err := v.dataFile.ReadAt(blob, int64(nv.Offset)*NeedlePaddingSize); err != nil {
+		return err
+	}
+	n, err := ParseNeedleBlob(blob, v.Version())
+	if err != nil {
+		return err
+	}
+	if n.Id != uint64(nv.Key) {
+		return fmt.Errorf("needle id %x stored at the offset of %x", n.Id, uint64(nv.Key))
+	}
+	return n.VerifyDigest()
+}
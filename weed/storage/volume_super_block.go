@@ -10,6 +10,8 @@ import (
 
 const (
 	SuperBlockSize = 8
+
+	SuperBlockFlagReadOnly = 0x01
 )
 
 /*
@@ -18,6 +20,7 @@ const (
 * Byte 1: Replica Placement strategy, 000, 001, 002, 010, etc
 * Byte 2 and byte 3: Time to live. See TTL for definition
 * Byte 4 and byte 5: The number of times the volume has been compacted.
+* Byte 6: Flags, see SuperBlockFlagReadOnly
 * Rest bytes: Reserved
  */
 //超级块结构
@@ -26,6 +29,7 @@ type SuperBlock struct {
 	ReplicaPlacement *ReplicaPlacement
 	Ttl              *TTL
 	CompactRevision  uint16
+	Flags            byte
 }
 
 //返回超级块的版本
@@ -45,6 +49,7 @@ func (s *SuperBlock) Bytes() []byte {
 	s.Ttl.ToBytes(header[2:4])
 	//4，5两个字节存储卷已经压实的时间
 	util.Uint16toBytes(header[4:6], s.CompactRevision)
+	header[6] = s.Flags
 	return header
 }
 
@@ -66,7 +71,7 @@ func (v *Volume) maybeWriteSuperBlock() error {
 			//长度为0时，只读时，重建更改只读的状态
 			if v.dataFile, e = os.Create(v.dataFile.Name()); e == nil {
 				if _, e = v.dataFile.Write(v.SuperBlock.Bytes()); e == nil {
-					v.readOnly = false
+					v.setReadOnly(false)
 				}
 			}
 		}
@@ -74,6 +79,18 @@ func (v *Volume) maybeWriteSuperBlock() error {
 	return e
 }
 
+func (s *SuperBlock) IsMarkedReadOnly() bool {
+	return s.Flags&SuperBlockFlagReadOnly > 0
+}
+
+// writeSuperBlock overwrites the super block at the beginning of the data file
+func (v *Volume) writeSuperBlock() error {
+	if _, e := v.dataFile.WriteAt(v.SuperBlock.Bytes(), 0); e != nil {
+		return fmt.Errorf("cannot write volume %d super block: %v", v.Id, e)
+	}
+	return nil
+}
+
 //读取超级块
 func (v *Volume) readSuperBlock() (err error) {
 	//定位到卷到文件到开头
@@ -103,5 +120,6 @@ func ParseSuperBlock(header []byte) (superBlock SuperBlock, err error) {
 	superBlock.Ttl = LoadTTLFromBytes(header[2:4])
 	//从4，5字节解析压实时间
 	superBlock.CompactRevision = util.BytesToUint16(header[4:6])
+	superBlock.Flags = header[6]
 	return
 }
@@ -21,3 +21,21 @@ func TestSuperBlockReadWrite(t *testing.T) {
 	}
 
 }
+
+func TestSuperBlockReadOnlyFlag(t *testing.T) {
+	rp, _ := NewReplicaPlacementFromByte(byte(001))
+	s := &SuperBlock{
+		version:          CurrentVersion,
+		ReplicaPlacement: rp,
+		Ttl:              EMPTY_TTL,
+		Flags:            SuperBlockFlagReadOnly,
+	}
+
+	parsed, err := ParseSuperBlock(s.Bytes())
+	if err != nil {
+		t.Fatalf("parse super block: %v", err)
+	}
+	if !parsed.IsMarkedReadOnly() {
+		t.Errorf("read-only flag is lost: %+v", parsed)
+	}
+}
@@ -11,6 +11,7 @@ import (
 
 	"github.com/chrislusf/seaweedfs/weed/glog"
 	"github.com/chrislusf/seaweedfs/weed/operation"
+	"github.com/chrislusf/seaweedfs/weed/security"
 	"github.com/chrislusf/seaweedfs/weed/util"
 )
 
@@ -45,16 +46,18 @@ optimized more later).
 
 */
 
-func (v *Volume) Synchronize(volumeServer string) (err error) {
+// Synchronize catches up with the volume on the volume server.
+// The secret signs the requests, which the volume server requires once access keys are added.
+func (v *Volume) Synchronize(volumeServer string, secret security.Secret) (err error) {
 	var lastCompactRevision uint16 = 0
 	var compactRevision uint16 = 0
 	var masterMap CompactMap
 	for i := 0; i < 3; i++ {
-		if masterMap, _, compactRevision, err = fetchVolumeFileEntries(volumeServer, v.Id); err != nil {
+		if masterMap, _, compactRevision, err = fetchVolumeFileEntries(volumeServer, v.Id, secret); err != nil {
 			return fmt.Errorf("Failed to sync volume %d entries with %s: %v", v.Id, volumeServer, err)
 		}
 		if lastCompactRevision != compactRevision && lastCompactRevision != 0 {
-			if err = v.Compact(); err != nil {
+			if err = v.Compact(0); err != nil {
 				return fmt.Errorf("Compact Volume before synchronizing %v", err)
 			}
 			if err = v.commitCompact(); err != nil {
@@ -62,7 +65,7 @@ func (v *Volume) Synchronize(volumeServer string) (err error) {
 			}
 		}
 		lastCompactRevision = compactRevision
-		if err = v.trySynchronizing(volumeServer, masterMap, compactRevision); err == nil {
+		if err = v.trySynchronizing(volumeServer, masterMap, compactRevision, secret); err == nil {
 			return
 		}
 	}
@@ -77,7 +80,7 @@ func (a ByOffset) Less(i, j int) bool { return a[i].Offset < a[j].Offset }
 
 // trySynchronizing sync with remote volume server incrementally by
 // make up the local and remote delta.
-func (v *Volume) trySynchronizing(volumeServer string, masterMap CompactMap, compactRevision uint16) error {
+func (v *Volume) trySynchronizing(volumeServer string, masterMap CompactMap, compactRevision uint16, secret security.Secret) error {
 	slaveIdxFile, err := os.Open(v.nm.IndexFileName())
 	if err != nil {
 		return fmt.Errorf("Open volume %d index file: %v", v.Id, err)
@@ -119,7 +122,7 @@ func (v *Volume) trySynchronizing(volumeServer string, masterMap CompactMap, com
 
 	// make up the delta
 	fetchCount := 0
-	volumeDataContentHandlerUrl := "http://" + volumeServer + "/admin/sync/data"
+	volumeDataContentHandlerUrl := util.SchemePrefix + volumeServer + "/admin/sync/data"
 	for _, needleValue := range delta {
 		if needleValue.Size == 0 {
 			// remove file entry from local
@@ -127,7 +130,7 @@ func (v *Volume) trySynchronizing(volumeServer string, masterMap CompactMap, com
 			continue
 		}
 		// add master file entry to local data file
-		if err := v.fetchNeedle(volumeDataContentHandlerUrl, needleValue, compactRevision); err != nil {
+		if err := v.fetchNeedle(volumeDataContentHandlerUrl, needleValue, compactRevision, secret); err != nil {
 			glog.V(0).Infof("Fetch needle %v from %s: %v", needleValue, volumeServer, err)
 			return err
 		}
@@ -137,16 +140,16 @@ func (v *Volume) trySynchronizing(volumeServer string, masterMap CompactMap, com
 	return nil
 }
 
-func fetchVolumeFileEntries(volumeServer string, vid VolumeId) (m CompactMap, lastOffset uint64, compactRevision uint16, err error) {
+func fetchVolumeFileEntries(volumeServer string, vid VolumeId, secret security.Secret) (m CompactMap, lastOffset uint64, compactRevision uint16, err error) {
 	m = NewCompactMap()
 
-	syncStatus, err := operation.GetVolumeSyncStatus(volumeServer, vid.String())
+	syncStatus, err := operation.GetVolumeSyncStatus(volumeServer, vid.String(), security.GenClusterJwt(secret))
 	if err != nil {
 		return m, 0, 0, err
 	}
 
 	total := 0
-	err = operation.GetVolumeIdxEntries(volumeServer, vid.String(), func(key uint64, offset, size uint32) {
+	err = operation.GetVolumeIdxEntries(volumeServer, vid.String(), security.GenClusterJwt(secret), func(key uint64, offset, size uint32) {
 		// println("remote key", key, "offset", offset*NeedlePaddingSize, "size", size)
 		if offset != 0 && size != 0 {
 			m.Set(Key(key), offset, size)
@@ -170,6 +173,7 @@ func (v *Volume) GetVolumeSyncStatus() operation.SyncVolumeResponse {
 	syncStatus.CompactRevision = v.SuperBlock.CompactRevision
 	syncStatus.Ttl = v.SuperBlock.Ttl.String()
 	syncStatus.Replication = v.SuperBlock.ReplicaPlacement.String()
+	syncStatus.Version = uint8(v.Version())
 	return syncStatus
 }
 
@@ -188,7 +192,7 @@ func (v *Volume) removeNeedle(key Key) {
 // The compact revision is checked first in case the remote volume
 // is compacted and the offset is invalid any more.
 func (v *Volume) fetchNeedle(volumeDataContentHandlerUrl string,
-	needleValue NeedleValue, compactRevision uint16) error {
+	needleValue NeedleValue, compactRevision uint16, secret security.Secret) error {
 	// add master file entry to local data file
 	values := make(url.Values)
 	values.Add("revision", strconv.Itoa(int(compactRevision)))
@@ -197,11 +201,20 @@ func (v *Volume) fetchNeedle(volumeDataContentHandlerUrl string,
 	values.Add("offset", strconv.FormatUint(uint64(needleValue.Offset), 10))
 	values.Add("size", strconv.FormatUint(uint64(needleValue.Size), 10))
 	glog.V(4).Infof("Fetch %+v", needleValue)
-	return util.GetUrlStream(volumeDataContentHandlerUrl, values, func(r io.Reader) error {
+	//令牌10秒过期，每个needle重新签发
+	return util.GetUrlStreamWithJwt(volumeDataContentHandlerUrl, values, security.GenClusterJwt(secret), func(r io.Reader) error {
 		b, err := ioutil.ReadAll(r)
 		if err != nil {
 			return fmt.Errorf("Reading from %s error: %v", volumeDataContentHandlerUrl, err)
 		}
+		//追加之前校验crc和内容摘要，避免复制损坏的needle
+		n, err := ParseNeedleBlob(b, v.Version())
+		if err == nil {
+			err = n.VerifyDigest()
+		}
+		if err != nil {
+			return fmt.Errorf("Verifying needle %d from %s error: %v", needleValue.Key, volumeDataContentHandlerUrl, err)
+		}
 		offset, err := v.AppendBlob(b)
 		if err != nil {
 			return fmt.Errorf("Appending volume %d error: %v", v.Id, err)
@@ -6,13 +6,16 @@ import (
 	"time"
 
 	"github.com/chrislusf/seaweedfs/weed/glog"
+	"github.com/chrislusf/seaweedfs/weed/util"
 )
 
 func (v *Volume) garbageLevel() float64 {
 	return float64(v.nm.DeletedSize()) / float64(v.ContentSize())
 }
 
-func (v *Volume) Compact() error {
+// Compact copies the live needles into .cpd and .cpx files.
+// The copying is limited to compactionBytePerSecond if it is positive.
+func (v *Volume) Compact(compactionBytePerSecond int64) error {
 	glog.V(3).Infof("Compacting ...")
 	//no need to lock for copy on write
 	//v.accessLock.Lock()
@@ -21,13 +24,16 @@ func (v *Volume) Compact() error {
 
 	filePath := v.FileName()
 	glog.V(3).Infof("creating copies for volume %d ...", v.Id)
-	return v.copyDataAndGenerateIndexFile(filePath+".cpd", filePath+".cpx")
+	return v.copyDataAndGenerateIndexFile(filePath+".cpd", filePath+".cpx", compactionBytePerSecond)
 }
 func (v *Volume) commitCompact() error {
 	glog.V(3).Infof("Committing vacuuming...")
 	v.dataFileAccessLock.Lock()
 	defer v.dataFileAccessLock.Unlock()
 	glog.V(3).Infof("Got Committing lock...")
+	//等待进行中的刷盘，不能在刷盘时替换文件
+	v.syncLock.Lock()
+	defer v.syncLock.Unlock()
 	v.nm.Close()
 	_ = v.dataFile.Close()
 	var e error
@@ -46,7 +52,7 @@ func (v *Volume) commitCompact() error {
 	return nil
 }
 
-func (v *Volume) copyDataAndGenerateIndexFile(dstName, idxName string) (err error) {
+func (v *Volume) copyDataAndGenerateIndexFile(dstName, idxName string, compactionBytePerSecond int64) (err error) {
 	var (
 		dst, idx *os.File
 	)
@@ -64,6 +70,7 @@ func (v *Volume) copyDataAndGenerateIndexFile(dstName, idxName string) (err erro
 	new_offset := int64(SuperBlockSize)
 
 	now := uint64(time.Now().Unix())
+	writeThrottler := util.NewWriteThrottler(compactionBytePerSecond)
 
 	err = ScanVolumeFile(v.dir, v.Collection, v.Id, v.needleMapKind,
 		func(superBlock SuperBlock) error {
@@ -84,10 +91,16 @@ func (v *Volume) copyDataAndGenerateIndexFile(dstName, idxName string) (err erro
 					return fmt.Errorf("cannot append needle: %s", err)
 				}
 				new_offset += n.DiskSize()
+				writeThrottler.MaybeSlowdown(n.DiskSize())
 				glog.V(3).Infoln("saving key", n.Id, "volume offset", offset, "=>", new_offset, "data_size", n.Size)
 			}
 			return nil
 		})
-
+	//压缩后的文件在替换原文件之前刷盘
+	if err == nil && v.durability != DurabilityNone {
+		if err = dst.Sync(); err == nil {
+			err = idx.Sync()
+		}
+	}
 	return
 }
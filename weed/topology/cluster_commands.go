@@ -3,6 +3,7 @@ package topology
 import (
 	"github.com/chrislusf/raft"
 	"github.com/chrislusf/seaweedfs/weed/glog"
+	"github.com/chrislusf/seaweedfs/weed/security"
 	"github.com/chrislusf/seaweedfs/weed/storage"
 )
 
@@ -29,3 +30,53 @@ func (c *MaxVolumeIdCommand) Apply(server raft.Server) (interface{}, error) {
 
 	return nil, nil
 }
+
+// JwtKeysCommand replaces the JWT keys on all masters.
+type JwtKeysCommand struct {
+	Keys         []security.SigningKey `json:"keys"`
+	SigningKeyId string                `json:"signingKeyId"`
+}
+
+func NewJwtKeysCommand(keys []security.SigningKey, signingKeyId string) *JwtKeysCommand {
+	return &JwtKeysCommand{
+		Keys:         keys,
+		SigningKeyId: signingKeyId,
+	}
+}
+
+func (c *JwtKeysCommand) CommandName() string {
+	return "JwtKeys"
+}
+
+func (c *JwtKeysCommand) Apply(server raft.Server) (interface{}, error) {
+	topo := server.Context().(*Topology)
+	if err := topo.JwtKeys.Set(c.Keys, c.SigningKeyId); err != nil {
+		return nil, err
+	}
+	glog.V(0).Infoln("jwt signing key ==>", c.SigningKeyId)
+	return nil, nil
+}
+
+// AccessKeysCommand replaces the access keys on all masters.
+type AccessKeysCommand struct {
+	Keys []security.AccessKey `json:"keys"`
+}
+
+func NewAccessKeysCommand(keys []security.AccessKey) *AccessKeysCommand {
+	return &AccessKeysCommand{
+		Keys: keys,
+	}
+}
+
+func (c *AccessKeysCommand) CommandName() string {
+	return "AccessKeys"
+}
+
+func (c *AccessKeysCommand) Apply(server raft.Server) (interface{}, error) {
+	topo := server.Context().(*Topology)
+	if err := topo.AccessKeys.Set(c.Keys); err != nil {
+		return nil, err
+	}
+	glog.V(0).Infoln("access keys ==>", len(c.Keys))
+	return nil, nil
+}
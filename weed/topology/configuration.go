@@ -1,7 +1,14 @@
 package topology
 
 import (
+	"bytes"
+	"encoding/json"
 	"encoding/xml"
+	"fmt"
+	"net"
+	"path"
+
+	"gopkg.in/yaml.v2"
 )
 
 //机架和数据中心的位置
@@ -10,21 +17,35 @@ type loc struct {
 	rackName string
 }
 
-//机架，包括名称和ip列表
+//机架，包括名称，ip列表，CIDR网段列表和主机名模式列表
 type rack struct {
-	Name string   `xml:"name,attr"`
-	Ips  []string `xml:"Ip"`
+	Name  string   `xml:"name,attr" json:"name" yaml:"name"`
+	Ips   []string `xml:"Ip" json:"ips,omitempty" yaml:"ips,omitempty"`
+	Cidrs []string `xml:"Cidr" json:"cidrs,omitempty" yaml:"cidrs,omitempty"`
+	Hosts []string `xml:"Host" json:"hosts,omitempty" yaml:"hosts,omitempty"`
 }
 
 //数据中心，包括名称和机架列表
 type dataCenter struct {
-	Name  string `xml:"name,attr"`
-	Racks []rack `xml:"Rack"`
+	Name  string `xml:"name,attr" json:"name" yaml:"name"`
+	Racks []rack `xml:"Rack" json:"racks" yaml:"racks"`
 }
 
 //顶级拓扑，包括数据中心列表
 type topology struct {
-	DataCenters []dataCenter `xml:"DataCenter"`
+	DataCenters []dataCenter `xml:"DataCenter" json:"dataCenters" yaml:"dataCenters"`
+}
+
+//CIDR网段到位置的映射
+type cidrLocation struct {
+	ipNet *net.IPNet
+	loc
+}
+
+//主机名模式到位置的映射
+type hostLocation struct {
+	pattern string
+	loc
 }
 
 //config配置结构
@@ -35,22 +56,91 @@ type Configuration struct {
 	Topo topology `xml:"Topology"`
 	//根据ip反查位置的映射表
 	ip2location map[string]loc
+	//按照前缀长度从长到短排列的网段
+	cidrs []cidrLocation
+	//按照配置顺序排列的主机名模式
+	hosts []hostLocation
 }
 
-//config文件的构造函数
+//config文件的构造函数, 根据内容自动识别xml, json或者yaml格式
 func NewConfiguration(b []byte) (*Configuration, error) {
 	c := &Configuration{}
-	err := xml.Unmarshal(b, c)
+	var err error
+	trimmed := bytes.TrimSpace(b)
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("<")):
+		err = xml.Unmarshal(trimmed, c)
+	case bytes.HasPrefix(trimmed, []byte("{")):
+		err = json.Unmarshal(trimmed, &c.Topo)
+	default:
+		err = yaml.Unmarshal(trimmed, &c.Topo)
+	}
+	if err != nil {
+		return c, err
+	}
+	return c, c.compile()
+}
+
+//建立ip，网段和主机名到位置的映射关系，并检查配置中的冲突
+func (c *Configuration) compile() error {
 	c.ip2location = make(map[string]loc)
-	//直接循环数据中心和机架，用ip做key，来映射ip和数据中心，机架之间的映射关系
+	cidr2location := make(map[string]loc)
 	for _, dc := range c.Topo.DataCenters {
+		if dc.Name == "" {
+			return fmt.Errorf("data center name is empty")
+		}
 		for _, rack := range dc.Racks {
+			if rack.Name == "" {
+				return fmt.Errorf("rack name is empty in data center %s", dc.Name)
+			}
+			l := loc{dcName: dc.Name, rackName: rack.Name}
 			for _, ip := range rack.Ips {
-				c.ip2location[ip] = loc{dcName: dc.Name, rackName: rack.Name}
+				if old, ok := c.ip2location[ip]; ok && old != l {
+					return fmt.Errorf("ip %s is in both %s and %s", ip, old, l)
+				}
+				c.ip2location[ip] = l
+			}
+			for _, cidr := range rack.Cidrs {
+				_, ipNet, err := net.ParseCIDR(cidr)
+				if err != nil {
+					return fmt.Errorf("invalid cidr %s in %s: %v", cidr, l, err)
+				}
+				if old, ok := cidr2location[ipNet.String()]; ok {
+					if old != l {
+						return fmt.Errorf("cidr %s is in both %s and %s", ipNet, old, l)
+					}
+					continue
+				}
+				cidr2location[ipNet.String()] = l
+				c.addCidr(cidrLocation{ipNet: ipNet, loc: l})
+			}
+			for _, host := range rack.Hosts {
+				if _, err := path.Match(host, ""); err != nil {
+					return fmt.Errorf("invalid host pattern %s in %s: %v", host, l, err)
+				}
+				c.hosts = append(c.hosts, hostLocation{pattern: host, loc: l})
 			}
 		}
 	}
-	return c, err
+	return nil
+}
+
+//按照前缀长度插入网段，保证最长前缀优先匹配
+func (c *Configuration) addCidr(cl cidrLocation) {
+	ones, _ := cl.ipNet.Mask.Size()
+	i := 0
+	for ; i < len(c.cidrs); i++ {
+		if o, _ := c.cidrs[i].ipNet.Mask.Size(); o < ones {
+			break
+		}
+	}
+	c.cidrs = append(c.cidrs, cidrLocation{})
+	copy(c.cidrs[i+1:], c.cidrs[i:])
+	c.cidrs[i] = cl
+}
+
+func (l loc) String() string {
+	return l.dcName + "/" + l.rackName
 }
 
 //实现字符串化方法
@@ -61,13 +151,34 @@ func (c *Configuration) String() string {
 	return ""
 }
 
+//根据ip或主机名查找配置中的位置，依次匹配ip，网段和主机名模式
+func (c *Configuration) lookup(ip string) (loc, bool) {
+	if c == nil || c.ip2location == nil {
+		return loc{}, false
+	}
+	if l, ok := c.ip2location[ip]; ok {
+		return l, true
+	}
+	if parsed := net.ParseIP(ip); parsed != nil {
+		for _, cl := range c.cidrs {
+			if cl.ipNet.Contains(parsed) {
+				return cl.loc, true
+			}
+		}
+	}
+	for _, hl := range c.hosts {
+		if matched, _ := path.Match(hl.pattern, ip); matched {
+			return hl.loc, true
+		}
+	}
+	return loc{}, false
+}
+
 //根据ip去找到映射关系
 func (c *Configuration) Locate(ip string, dcName string, rackName string) (dc string, rack string) {
-	//如果能用ip找到映射关系，直接返回ip映射中的机架名称和数据中心的名称
-	if c != nil && c.ip2location != nil {
-		if loc, ok := c.ip2location[ip]; ok {
-			return loc.dcName, loc.rackName
-		}
+	//如果能用ip找到映射关系，直接返回映射中的机架名称和数据中心的名称
+	if l, ok := c.lookup(ip); ok {
+		return l.dcName, l.rackName
 	}
 
 	//如果没找到返回默认的数据中心值
@@ -40,3 +40,72 @@ func TestLoadConfiguration(t *testing.T) {
 		t.Fatalf("unmarshal error:%s", c)
 	}
 }
+
+func TestLoadJsonConfiguration(t *testing.T) {
+	confContent := `{
+  "dataCenters": [
+    {
+      "name": "dc1",
+      "racks": [
+        {"name": "rack1", "ips": ["192.168.1.1"], "cidrs": ["10.0.0.0/16"]},
+        {"name": "rack2", "cidrs": ["10.0.1.0/24"], "hosts": ["web-*.dc1"]}
+      ]
+    }
+  ]
+}`
+	c, err := NewConfiguration([]byte(confContent))
+	if err != nil {
+		t.Fatalf("unmarshal error:%v", err)
+	}
+
+	tests := []struct {
+		ip       string
+		dc, rack string
+	}{
+		{"192.168.1.1", "dc1", "rack1"},
+		{"10.0.2.3", "dc1", "rack1"},
+		{"10.0.1.3", "dc1", "rack2"},
+		{"web-3.dc1", "dc1", "rack2"},
+		{"192.168.1.9", "DefaultDataCenter", "DefaultRack"},
+	}
+	for _, tt := range tests {
+		if dc, rack := c.Locate(tt.ip, "", ""); dc != tt.dc || rack != tt.rack {
+			t.Errorf("Locate(%s) = %s/%s, expected %s/%s", tt.ip, dc, rack, tt.dc, tt.rack)
+		}
+	}
+}
+
+func TestLoadYamlConfiguration(t *testing.T) {
+	confContent := `
+dataCenters:
+  - name: dc1
+    racks:
+      - name: rack1
+        cidrs: [10.0.0.0/24]
+  - name: dc2
+    racks:
+      - name: rack1
+        ips: [192.168.1.2]
+`
+	c, err := NewConfiguration([]byte(confContent))
+	if err != nil {
+		t.Fatalf("unmarshal error:%v", err)
+	}
+	if dc, rack := c.Locate("192.168.1.2", "x", "y"); dc != "dc2" || rack != "rack1" {
+		t.Errorf("unexpected location %s/%s", dc, rack)
+	}
+}
+
+func TestConfigurationConflicts(t *testing.T) {
+	confs := []string{
+		`{"dataCenters":[{"name":"dc1","racks":[{"name":"r1","ips":["1.1.1.1"]},{"name":"r2","ips":["1.1.1.1"]}]}]}`,
+		`{"dataCenters":[{"name":"dc1","racks":[{"name":"r1","cidrs":["10.0.0.0/8"]},{"name":"r2","cidrs":["10.1.0.0/8"]}]}]}`,
+		`{"dataCenters":[{"name":"dc1","racks":[{"name":"r1","cidrs":["10.0.0.0/33"]}]}]}`,
+		`{"dataCenters":[{"name":"dc1","racks":[{"name":"r1","hosts":["web-["]}]}]}`,
+	}
+	for _, conf := range confs {
+		if _, err := NewConfiguration([]byte(conf)); err == nil {
+			t.Errorf("expected error for %s", conf)
+		}
+	}
+}
@@ -23,6 +23,11 @@ type DataNode struct {
 	LastSeen int64 // unix time in seconds
 	//是否是死节点
 	Dead bool
+	//数据节点自己上报的数据中心和机架
+	ReportedDataCenter string
+	ReportedRack       string
+	//数据节点上报的故障磁盘目录
+	FailedDirs []string
 }
 
 //数据节点的构造函数
@@ -94,6 +99,38 @@ func (dn *DataNode) UpdateVolumes(actualVolumes []storage.VolumeInfo) (deletedVo
 	return
 }
 
+// MarkVolumeReadOnly updates the cached volume information until the next heartbeat confirms it
+func (dn *DataNode) MarkVolumeReadOnly(id storage.VolumeId, readOnly bool) {
+	dn.Lock()
+	defer dn.Unlock()
+	v, ok := dn.volumes[id]
+	if !ok || v.ReadOnly == readOnly {
+		return
+	}
+	v.ReadOnly = readOnly
+	dn.volumes[id] = v
+	if readOnly {
+		dn.UpAdjustActiveVolumeCountDelta(-1)
+	} else {
+		dn.UpAdjustActiveVolumeCountDelta(1)
+	}
+}
+
+// DeleteVolume forgets a volume that has been deleted from this data node
+func (dn *DataNode) DeleteVolume(id storage.VolumeId) {
+	dn.Lock()
+	defer dn.Unlock()
+	v, ok := dn.volumes[id]
+	if !ok {
+		return
+	}
+	delete(dn.volumes, id)
+	dn.UpAdjustVolumeCountDelta(-1)
+	if !v.ReadOnly {
+		dn.UpAdjustActiveVolumeCountDelta(-1)
+	}
+}
+
 //获取数据节点的所有卷
 func (dn *DataNode) GetVolumes() (ret []storage.VolumeInfo) {
 	//加锁
@@ -159,5 +196,8 @@ func (dn *DataNode) ToMap() interface{} {
 	ret["Max"] = dn.GetMaxVolumeCount()
 	ret["Free"] = dn.FreeSpace()
 	ret["PublicUrl"] = dn.PublicUrl
+	if len(dn.FailedDirs) > 0 {
+		ret["FailedDirs"] = dn.FailedDirs
+	}
 	return ret
 }
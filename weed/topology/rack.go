@@ -50,6 +50,8 @@ func (r *Rack) GetOrCreateDataNode(ip string, port int, publicUrl string, maxVol
 				dn.Dead = false
 				r.GetTopology().chanRecoveredDataNodes <- dn
 				dn.UpAdjustMaxVolumeCountDelta(maxVolumeCount - dn.maxVolumeCount)
+			} else if maxVolumeCount != dn.maxVolumeCount { //数据节点增减了磁盘目录
+				dn.UpAdjustMaxVolumeCountDelta(maxVolumeCount - dn.maxVolumeCount)
 			}
 			return dn
 		}
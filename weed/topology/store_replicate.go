@@ -5,8 +5,10 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 
 	"net/url"
 
@@ -17,51 +19,116 @@ import (
 	"github.com/chrislusf/seaweedfs/weed/util"
 )
 
+// ReplicationOption controls how writes and deletes are replicated.
+type ReplicationOption struct {
+	// WriteQuorum is the number of copies, including the local one, that must succeed. 0 means all copies.
+	WriteQuorum int
+	// Hints keeps operations failed on replicas to replay later. nil disables hinted handoff.
+	Hints *storage.HintQueue
+	// Keys signs the requests to the replicas as a cluster member. Without keys the client's token is forwarded.
+	Keys *security.KeySet
+	// Replicas remembers the replicas of the volumes, to keep hints for those the master no longer lists.
+	Replicas *KnownReplicas
+}
+
+// KnownReplicas remembers the replicas of each volume seen in the lookups, so that the hints are kept
+// for a replica that is down or leaving after the master has unregistered it.
+type KnownReplicas struct {
+	sync.Mutex
+	replicas map[storage.VolumeId]map[string]bool
+}
+
+func NewKnownReplicas() *KnownReplicas {
+	return &KnownReplicas{replicas: make(map[storage.VolumeId]map[string]bool)}
+}
+
+// missing remembers the other replicas listed by the master, and returns the remembered ones not listed.
+// Once the master lists all the other copies, the unlisted ones are forgotten, e.g., a moved replica.
+func (kr *KnownReplicas) missing(volumeId storage.VolumeId, listed []string, otherCopies int) (missing []string) {
+	if kr == nil {
+		return nil
+	}
+	kr.Lock()
+	defer kr.Unlock()
+	known := make(map[string]bool)
+	for _, url := range listed {
+		known[url] = true
+	}
+	if len(listed) < otherCopies {
+		for url := range kr.replicas[volumeId] {
+			if !known[url] {
+				missing = append(missing, url)
+				known[url] = true
+			}
+		}
+	}
+	kr.replicas[volumeId] = known
+	sort.Strings(missing)
+	return missing
+}
+
+//复制请求只涉及同一个卷，本机已经检查过客户端的权限
+func (option *ReplicationOption) jwt(r *http.Request) security.EncodedJwt {
+	if option != nil && option.Keys != nil {
+		if jwt := option.Keys.SignCluster(); jwt != "" {
+			return jwt
+		}
+	}
+	return security.GetJwt(r)
+}
+
+// ReplicatedWrite writes the needle locally and to the other replicas.
+// The If-Match and If-None-Match headers of the request are checked against the local needle,
+// and storage.ErrPreconditionFailed is returned if they do not hold.
 func ReplicatedWrite(masterNode string, s *storage.Store,
 	volumeId storage.VolumeId, needle *storage.Needle,
-	r *http.Request) (size uint32, errorStatus string) {
+	r *http.Request, option *ReplicationOption) (size uint32, writeErr error) {
 
-	//check JWT
-	jwt := security.GetJwt(r)
+	jwt := option.jwt(r)
 
-	ret, err := s.Write(volumeId, needle)
+	var condition *storage.NeedleCondition
+	if r.FormValue("type") != "replicate" {
+		condition = storage.NewNeedleCondition(r.Header)
+	}
+	ret, err := s.WriteIf(volumeId, needle, condition)
+	if err == storage.ErrPreconditionFailed {
+		return 0, err
+	}
 	needToReplicate := !s.HasVolume(volumeId)
 	if err != nil {
-		errorStatus = "Failed to write to local disk (" + err.Error() + ")"
+		writeErr = fmt.Errorf("Failed to write to local disk (%v)", err)
 	} else if ret > 0 {
 		needToReplicate = needToReplicate || s.GetVolume(volumeId).NeedToReplicate()
 	} else {
-		errorStatus = "Failed to write to local disk"
+		writeErr = errors.New("Failed to write to local disk")
 	}
 	if !needToReplicate && ret > 0 {
 		needToReplicate = s.GetVolume(volumeId).NeedToReplicate()
 	}
 	if needToReplicate { //send to other replica locations
 		if r.FormValue("type") != "replicate" {
-
-			if err = distributedOperation(masterNode, s, volumeId, func(location operation.Location) error {
-				u := url.URL{
-					Scheme: "http",
-					Host:   location.Url,
-					Path:   r.URL.Path,
-				}
-				q := url.Values{
-					"type": {"replicate"},
-				}
-				if needle.LastModified > 0 {
-					q.Set("ts", strconv.FormatUint(needle.LastModified, 10))
+			var blob []byte
+			var version storage.Version
+			if v := s.GetVolume(volumeId); v != nil {
+				version = v.Version()
+				var buf bytes.Buffer
+				if _, err = needle.Append(&buf, version); err == nil {
+					blob = buf.Bytes()
 				}
-				if needle.IsChunkedManifest() {
-					q.Set("cm", "true")
+			}
+			var hint *storage.Hint
+			if blob != nil {
+				hint = &storage.Hint{Type: storage.HintTypeWrite, VolumeId: volumeId, Version: version, Payload: blob}
+			}
+
+			if err = replicatedOperation(masterNode, s, volumeId, ret > 0, option, hint, func(location operation.Location) error {
+				if blob != nil {
+					return replicateNeedle(location.Url, volumeId, version, blob, jwt)
 				}
-				u.RawQuery = q.Encode()
-				_, err := operation.Upload(u.String(),
-					string(needle.Name), bytes.NewReader(needle.Data), needle.IsGzipped(), string(needle.Mime),
-					jwt)
-				return err
+				return uploadNeedle(location.Url, r.URL.Path, needle, jwt)
 			}); err != nil {
 				ret = 0
-				errorStatus = fmt.Sprintf("Failed to write to replicas for volume %d: %v", volumeId, err)
+				writeErr = fmt.Errorf("Failed to write to replicas for volume %d: %v", volumeId, err)
 			}
 		}
 	}
@@ -69,14 +136,69 @@ func ReplicatedWrite(masterNode string, s *storage.Store,
 	return
 }
 
+// ReplicatedBatchWrite appends the needles to the local volume under one lock acquisition,
+// and replicates the written ones in one request per replica.
+// It returns a size or an error for each needle.
+func ReplicatedBatchWrite(masterNode string, s *storage.Store,
+	volumeId storage.VolumeId, needles []*storage.Needle,
+	r *http.Request, option *ReplicationOption) (sizes []uint32, errs []error) {
+
+	jwt := option.jwt(r)
+
+	sizes, errs, err := s.WriteNeedles(volumeId, needles)
+	if err != nil {
+		sizes, errs = make([]uint32, len(needles)), make([]error, len(needles))
+		for i := range errs {
+			errs[i] = fmt.Errorf("Failed to write to local disk (%v)", err)
+		}
+		return
+	}
+	v := s.GetVolume(volumeId)
+	if v == nil || !v.NeedToReplicate() {
+		return
+	}
+	//只复制本地写成功的needle，拼接成一个请求
+	var buf bytes.Buffer
+	var written []int
+	for i, needle := range needles {
+		if errs[i] != nil {
+			continue
+		}
+		if _, err = needle.Append(&buf, v.Version()); err != nil {
+			errs[i] = fmt.Errorf("Failed to serialize needle: %v", err)
+			continue
+		}
+		written = append(written, i)
+	}
+	if len(written) == 0 {
+		return
+	}
+	blob := buf.Bytes()
+	hint := &storage.Hint{Type: storage.HintTypeWrite, VolumeId: volumeId, Version: v.Version(), Payload: blob}
+	if err = replicatedOperation(masterNode, s, volumeId, true, option, hint, func(location operation.Location) error {
+		return replicateNeedle(location.Url, volumeId, v.Version(), blob, jwt)
+	}); err != nil {
+		for _, i := range written {
+			sizes[i] = 0
+			errs[i] = fmt.Errorf("Failed to write to replicas for volume %d: %v", volumeId, err)
+		}
+	}
+	return
+}
+
+// ReplicatedDelete deletes the needle locally and on the other replicas,
+// checking the conditional headers of the request like ReplicatedWrite.
 func ReplicatedDelete(masterNode string, store *storage.Store,
 	volumeId storage.VolumeId, n *storage.Needle,
-	r *http.Request) (uint32, error) {
+	r *http.Request, option *ReplicationOption) (uint32, error) {
 
-	//check JWT
-	jwt := security.GetJwt(r)
+	jwt := option.jwt(r)
 
-	ret, err := store.Delete(volumeId, n)
+	var condition *storage.NeedleCondition
+	if r.FormValue("type") != "replicate" {
+		condition = storage.NewNeedleCondition(r.Header)
+	}
+	ret, err := store.DeleteIf(volumeId, n, condition)
 	if err != nil {
 		glog.V(0).Infoln("delete error:", err)
 		return ret, err
@@ -88,8 +210,9 @@ func ReplicatedDelete(masterNode string, store *storage.Store,
 	}
 	if needToReplicate { //send to other replica locations
 		if r.FormValue("type") != "replicate" {
-			if err = distributedOperation(masterNode, store, volumeId, func(location operation.Location) error {
-				return util.Delete("http://"+location.Url+r.URL.Path+"?type=replicate", jwt)
+			hint := &storage.Hint{Type: storage.HintTypeDelete, VolumeId: volumeId, Payload: []byte(r.URL.Path)}
+			if err = replicatedOperation(masterNode, store, volumeId, true, option, hint, func(location operation.Location) error {
+				return util.Delete(util.SchemePrefix+location.Url+r.URL.Path+"?type=replicate", jwt)
 			}); err != nil {
 				ret = 0
 			}
@@ -98,6 +221,83 @@ func ReplicatedDelete(masterNode string, store *storage.Store,
 	return ret, err
 }
 
+// ReplayHints sends the hinted writes and deletes to the replicas, in the order they were hinted.
+// The requests are signed with the keys as a cluster member, because the tokens of the original requests may have expired.
+func ReplayHints(hints *storage.HintQueue, keys *security.KeySet) {
+	for replica := range hints.Pending() {
+		replayed, err := hints.Replay(replica, func(h *storage.Hint) (err error) {
+			switch h.Type {
+			case storage.HintTypeWrite:
+				err = replicateNeedle(replica, h.VolumeId, h.Version, h.Payload, keys.SignCluster())
+			case storage.HintTypeDelete:
+				err = util.Delete(util.SchemePrefix+replica+string(h.Payload)+"?type=replicate", keys.SignCluster())
+			default:
+				glog.V(0).Infof("Skip hint of unknown type %d for %s", h.Type, replica)
+			}
+			if rejected(err) {
+				return storage.HintRejected{Err: err}
+			}
+			return err
+		})
+		if replayed > 0 {
+			glog.V(0).Infof("Replayed %d hints to %s", replayed, replica)
+		}
+		if err != nil {
+			glog.V(1).Infof("Failed to replay hints to %s: %v", replica, err)
+		}
+	}
+}
+
+// rejected tells if the replica answered with a client error, which retrying does not fix,
+// e.g., the volume is no longer on the replica. Authentication and throttling may change later.
+func rejected(err error) bool {
+	e, ok := err.(*util.StatusError)
+	if !ok {
+		return false
+	}
+	switch e.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return false
+	}
+	return e.StatusCode >= 400 && e.StatusCode < 500
+}
+
+// replicateNeedle sends the serialized needle, or uploads it if the replica does not support that yet.
+func replicateNeedle(replica string, volumeId storage.VolumeId, version storage.Version, blob []byte, jwt security.EncodedJwt) error {
+	_, err := operation.ReplicateNeedle(replica, volumeId.String(), uint8(version), blob, jwt)
+	if err != operation.ErrNeedleReplicationNotSupported {
+		return err
+	}
+	glog.V(1).Infof("%s does not support needle replication, uploading instead", replica)
+	ns, _, err := storage.SplitNeedleBlobs(blob, version)
+	if err != nil {
+		return err
+	}
+	for _, n := range ns {
+		if err = uploadNeedle(replica, "/"+storage.NewFileIdFromNeedle(volumeId, n).String(), n, jwt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func uploadNeedle(replica string, path string, needle *storage.Needle, jwt security.EncodedJwt) error {
+	q := url.Values{
+		"type": {"replicate"},
+	}
+	if needle.LastModified > 0 {
+		q.Set("ts", strconv.FormatUint(needle.LastModified, 10))
+	}
+	if needle.IsChunkedManifest() {
+		q.Set("cm", "true")
+	}
+	//operation.Upload走util.Transport，启用TLS后同样生效
+	_, err := operation.Upload(util.SchemePrefix+replica+path+"?"+q.Encode(),
+		string(needle.Name), bytes.NewReader(needle.Data), needle.IsGzipped(), string(needle.Mime),
+		needle.PairMap(), jwt)
+	return err
+}
+
 type DistributedOperationResult map[string]error
 
 func (dr DistributedOperationResult) Error() error {
@@ -118,33 +318,90 @@ type RemoteResult struct {
 	Error error
 }
 
-func distributedOperation(masterNode string, store *storage.Store, volumeId storage.VolumeId, op func(location operation.Location) error) error {
-	if lookupResult, lookupErr := operation.Lookup(masterNode, volumeId.String()); lookupErr == nil {
-		length := 0
-		selfUrl := (store.Ip + ":" + strconv.Itoa(store.Port))
-		results := make(chan RemoteResult)
-		for _, location := range lookupResult.Locations {
-			if location.Url != selfUrl {
-				length++
-				go func(location operation.Location, results chan RemoteResult) {
-					results <- RemoteResult{location.Url, op(location)}
-				}(location, results)
-			}
+// replicatedOperation runs op on all other replicas of the volume. It succeeds if the local copy,
+// when localSucceeded, and the succeeded replicas reach the write quorum.
+// The hint, if not nil, is queued for each failed replica, for replicas still replaying hints of the volume,
+// so that operations reach a replica in order, and for known replicas the master does not list.
+func replicatedOperation(masterNode string, store *storage.Store, volumeId storage.VolumeId, localSucceeded bool,
+	option *ReplicationOption, hint *storage.Hint, op func(location operation.Location) error) error {
+	lookupResult, lookupErr := operation.Lookup(masterNode, volumeId.String())
+	if lookupErr != nil {
+		return fmt.Errorf("Failed to lookup for %d: %v", volumeId, lookupErr)
+	}
+	var hints *storage.HintQueue
+	var replicas *KnownReplicas
+	if option != nil {
+		replicas = option.Replicas
+		if hint != nil {
+			hints = option.Hints
+		}
+	}
+	copyCount := 0
+	if volume := store.GetVolume(volumeId); volume != nil {
+		copyCount = volume.ReplicaPlacement.GetCopyCount()
+	}
+	length := 0
+	selfUrl := (store.Ip + ":" + strconv.Itoa(store.Port))
+	results := make(chan RemoteResult)
+	ret := DistributedOperationResult(make(map[string]error))
+	var listed []string
+	for _, location := range lookupResult.Locations {
+		if location.Url != selfUrl {
+			listed = append(listed, location.Url)
 		}
-		ret := DistributedOperationResult(make(map[string]error))
-		for i := 0; i < length; i++ {
-			result := <-results
-			ret[result.Host] = result.Error
+	}
+	for _, url := range replicas.missing(volumeId, listed, copyCount-1) {
+		ret[url] = fmt.Errorf("not listed by the master")
+	}
+	for _, location := range lookupResult.Locations {
+		if location.Url == selfUrl {
+			continue
+		}
+		if hints != nil && hints.HasHints(location.Url, volumeId) {
+			ret[location.Url] = fmt.Errorf("replaying hints")
+			continue
+		}
+		length++
+		go func(location operation.Location, results chan RemoteResult) {
+			results <- RemoteResult{location.Url, op(location)}
+		}(location, results)
+	}
+	for i := 0; i < length; i++ {
+		result := <-results
+		ret[result.Host] = result.Error
+	}
+
+	succeeded := 0
+	if localSucceeded {
+		succeeded++
+	}
+	for host, err := range ret {
+		if err == nil {
+			succeeded++
+			continue
 		}
-		if volume := store.GetVolume(volumeId); volume != nil {
-			if length+1 < volume.ReplicaPlacement.GetCopyCount() {
-				return fmt.Errorf("replicating opetations [%d] is less than volume's replication copy count [%d]", length+1, volume.ReplicaPlacement.GetCopyCount())
+		if hints != nil {
+			if e := hints.Add(host, hint); e != nil {
+				glog.V(0).Infof("Failed to keep hint for %s on volume %d: %v", host, volumeId, e)
 			}
 		}
-		return ret.Error()
-	} else {
-		glog.V(0).Infoln()
-		return fmt.Errorf("Failed to lookup for %d: %v", volumeId, lookupErr)
 	}
-	return nil
+
+	if copyCount == 0 {
+		copyCount = len(ret) + 1
+	}
+	quorum := copyCount
+	if option != nil && option.WriteQuorum > 0 && option.WriteQuorum < copyCount {
+		quorum = option.WriteQuorum
+	}
+	if succeeded >= quorum {
+		if err := ret.Error(); err != nil {
+			glog.V(0).Infof("%d of %d copies of volume %d succeeded, quorum %d: %v", succeeded, copyCount, volumeId, quorum, err)
+		}
+		return nil
+	}
+	if len(ret)+1 < copyCount {
+		return fmt.Errorf("replicating opetations [%d] is less than volume's replication copy count [%d]", len(ret)+1, copyCount)
+	}
+	return fmt.Errorf("%d of %d copies succeeded, less than write quorum %d: %v", succeeded, copyCount, quorum, ret.Error())
 }
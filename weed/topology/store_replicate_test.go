@@ -0,0 +1,54 @@
+package topology
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/chrislusf/seaweedfs/weed/util"
+)
+
+func TestKnownReplicasMissing(t *testing.T) {
+	kr := NewKnownReplicas()
+	if missing := kr.missing(1, []string{"a:8080", "b:8080"}, 2); len(missing) != 0 {
+		t.Fatalf("unexpected missing replicas %v", missing)
+	}
+	//b离开后master不再列出，仍然要记录hint
+	if missing := kr.missing(1, []string{"a:8080"}, 2); len(missing) != 1 || missing[0] != "b:8080" {
+		t.Fatalf("expected b:8080 missing, got %v", missing)
+	}
+	if missing := kr.missing(2, []string{"a:8080"}, 2); len(missing) != 0 {
+		t.Fatalf("unexpected missing replicas of another volume %v", missing)
+	}
+	//副本被c补齐之后忘记b
+	if missing := kr.missing(1, []string{"a:8080", "c:8080"}, 2); len(missing) != 0 {
+		t.Fatalf("unexpected missing replicas %v", missing)
+	}
+	if missing := kr.missing(1, []string{"a:8080"}, 2); len(missing) != 1 || missing[0] != "c:8080" {
+		t.Fatalf("expected c:8080 missing, got %v", missing)
+	}
+	var nilReplicas *KnownReplicas
+	if missing := nilReplicas.missing(1, nil, 2); len(missing) != 0 {
+		t.Fatalf("unexpected missing replicas %v", missing)
+	}
+}
+
+func TestRejected(t *testing.T) {
+	cases := []struct {
+		err      error
+		rejected bool
+	}{
+		{nil, false},
+		{errors.New("connection refused"), false},
+		{&util.StatusError{StatusCode: http.StatusGone}, true},
+		{&util.StatusError{StatusCode: http.StatusNotAcceptable}, true},
+		{&util.StatusError{StatusCode: http.StatusUnauthorized}, false},
+		{&util.StatusError{StatusCode: http.StatusTooManyRequests}, false},
+		{&util.StatusError{StatusCode: http.StatusInternalServerError}, false},
+	}
+	for _, c := range cases {
+		if rejected(c.err) != c.rejected {
+			t.Errorf("expected rejected(%v) to be %v", c.err, c.rejected)
+		}
+	}
+}
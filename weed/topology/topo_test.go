@@ -2,6 +2,10 @@ package topology
 
 import (
 	"testing"
+
+	"github.com/chrislusf/seaweedfs/weed/operation"
+	"github.com/chrislusf/seaweedfs/weed/sequence"
+	"github.com/golang/protobuf/proto"
 )
 
 func TestRemoveDataCenter(t *testing.T) {
@@ -15,3 +19,47 @@ func TestRemoveDataCenter(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestDataNodeLeaving(t *testing.T) {
+	topo, err := NewTopology("weedfs", "/etc/weedfs/weedfs.conf", sequence.NewMemorySequencer(), 32*1024, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	joinMessage := &operation.JoinMessage{
+		IsInit:         proto.Bool(true),
+		Ip:             proto.String("127.0.0.1"),
+		Port:           proto.Uint32(8080),
+		PublicUrl:      proto.String("127.0.0.1:8080"),
+		MaxVolumeCount: proto.Uint32(7),
+		MaxFileKey:     proto.Uint64(0),
+		DataCenter:     proto.String("dc1"),
+		Rack:           proto.String("rack1"),
+		Volumes: []*operation.VolumeInformationMessage{{
+			Id:               proto.Uint32(1),
+			Size:             proto.Uint64(1024),
+			Collection:       proto.String(""),
+			FileCount:        proto.Uint64(1),
+			DeleteCount:      proto.Uint64(0),
+			DeletedByteCount: proto.Uint64(0),
+			ReadOnly:         proto.Bool(false),
+			ReplicaPlacement: proto.Uint32(0),
+			Version:          proto.Uint32(2),
+			Ttl:              proto.Uint32(0),
+		}},
+	}
+	topo.ProcessJoinMessage(joinMessage)
+	if topo.GetMaxVolumeCount() != 7 || topo.GetVolumeCount() != 1 {
+		t.Fatalf("unexpected counts after joining: max %d volumes %d", topo.GetMaxVolumeCount(), topo.GetVolumeCount())
+	}
+
+	joinMessage.IsInit = proto.Bool(false)
+	joinMessage.IsLeaving = proto.Bool(true)
+	joinMessage.Volumes = nil
+	topo.ProcessJoinMessage(joinMessage)
+	if topo.GetMaxVolumeCount() != 0 || topo.GetVolumeCount() != 0 {
+		t.Fatalf("unexpected counts after leaving: max %d volumes %d", topo.GetMaxVolumeCount(), topo.GetVolumeCount())
+	}
+	if dns := topo.Lookup("", 1); len(dns) != 0 {
+		t.Fatalf("volume 1 should not be found after its server left: %v", dns)
+	}
+}
@@ -4,10 +4,12 @@ import (
 	"errors"
 	"io/ioutil"
 	"math/rand"
+	"sync"
 
 	"github.com/chrislusf/raft"
 	"github.com/chrislusf/seaweedfs/weed/glog"
 	"github.com/chrislusf/seaweedfs/weed/operation"
+	"github.com/chrislusf/seaweedfs/weed/security"
 	"github.com/chrislusf/seaweedfs/weed/sequence"
 	"github.com/chrislusf/seaweedfs/weed/storage"
 	"github.com/chrislusf/seaweedfs/weed/util"
@@ -31,9 +33,20 @@ type Topology struct {
 	//满了的数据节点
 	chanFullVolumes chan storage.VolumeInfo
 
-	configuration *Configuration
+	configuration     *Configuration
+	configurationFile string
+	configurationLock sync.RWMutex
+
+	vacuumStatus VacuumStatus
+
+	lastReplicaCheck []*VolumeCheckResult
+	replicaCheckLock sync.RWMutex
 
 	RaftServer raft.Server
+	//JWT密钥，通过raft在各master之间同步
+	JwtKeys *security.KeySet
+	//访问密钥，同样通过raft同步
+	AccessKeys *security.AccessKeys
 }
 
 func NewTopology(id string, confFile string, seq sequence.Sequencer, volumeSizeLimit uint64, pulse int) (*Topology, error) {
@@ -55,6 +68,8 @@ func NewTopology(id string, confFile string, seq sequence.Sequencer, volumeSizeL
 	t.chanDeadDataNodes = make(chan *DataNode)
 	t.chanRecoveredDataNodes = make(chan *DataNode)
 	t.chanFullVolumes = make(chan storage.VolumeInfo)
+	t.JwtKeys = security.NewKeySet("")
+	t.AccessKeys = security.NewAccessKeys()
 	//加载配置文件
 	err := t.loadConfiguration(confFile)
 
@@ -90,6 +105,7 @@ func (t *Topology) Leader() (string, error) {
 
 //加载config文件
 func (t *Topology) loadConfiguration(configurationFile string) error {
+	t.configurationFile = configurationFile
 	//读取文件
 	b, e := ioutil.ReadFile(configurationFile)
 	if e == nil {
@@ -163,16 +179,29 @@ func (t *Topology) UnRegisterVolumeLayout(v storage.VolumeInfo, dn *DataNode) {
 
 func (t *Topology) ProcessJoinMessage(joinMessage *operation.JoinMessage) {
 	t.Sequence.SetMax(*joinMessage.MaxFileKey)
-	dcName, rackName := t.configuration.Locate(*joinMessage.Ip, *joinMessage.DataCenter, *joinMessage.Rack)
+	dcName, rackName := t.getConfiguration().Locate(*joinMessage.Ip, *joinMessage.DataCenter, *joinMessage.Rack)
 	dc := t.GetOrCreateDataCenter(dcName)
 	rack := dc.GetOrCreateRack(rackName)
 	dn := rack.FindDataNode(*joinMessage.Ip, int(*joinMessage.Port))
+	//数据节点正常退出，立即注销，不再等待心跳超时
+	if joinMessage.GetIsLeaving() {
+		if dn != nil {
+			glog.V(0).Infoln("data node", dn.Url(), "is leaving")
+			t.UnRegisterDataNode(dn)
+		}
+		return
+	}
 	if *joinMessage.IsInit && dn != nil {
 		t.UnRegisterDataNode(dn)
 	}
 	dn = rack.GetOrCreateDataNode(*joinMessage.Ip,
 		int(*joinMessage.Port), *joinMessage.PublicUrl,
 		int(*joinMessage.MaxVolumeCount))
+	dn.ReportedDataCenter, dn.ReportedRack = *joinMessage.DataCenter, *joinMessage.Rack
+	if len(joinMessage.FailedDirs) > len(dn.FailedDirs) {
+		glog.Warningf("data node %s reports failed disks %v", dn.Url(), joinMessage.FailedDirs)
+	}
+	dn.FailedDirs = joinMessage.FailedDirs
 	var volumeInfos []storage.VolumeInfo
 	for _, v := range joinMessage.Volumes {
 		if vi, err := storage.NewVolumeInfo(v); err == nil {
@@ -0,0 +1,88 @@
+package topology
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/chrislusf/seaweedfs/weed/glog"
+)
+
+//机架映射和数据节点上报位置的冲突
+type ConfigurationConflict struct {
+	Url                string `json:"url"`
+	ReportedDataCenter string `json:"reportedDataCenter"`
+	ReportedRack       string `json:"reportedRack"`
+	DataCenter         string `json:"dataCenter"`
+	Rack               string `json:"rack"`
+}
+
+func (t *Topology) getConfiguration() *Configuration {
+	t.configurationLock.RLock()
+	defer t.configurationLock.RUnlock()
+	return t.configuration
+}
+
+// ReloadConfiguration re-reads the rack map file. An invalid file keeps the current map.
+// Data nodes whose location changed are unregistered, and join the new rack on the next heartbeat.
+func (t *Topology) ReloadConfiguration() ([]ConfigurationConflict, error) {
+	b, err := ioutil.ReadFile(t.configurationFile)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %v", t.configurationFile, err)
+	}
+	c, err := NewConfiguration(b)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %v", t.configurationFile, err)
+	}
+	t.configurationLock.Lock()
+	t.configuration = c
+	t.configurationLock.Unlock()
+	glog.V(0).Infoln("Reloaded topology configuration from", t.configurationFile)
+
+	for _, dn := range t.dataNodes() {
+		dcName, rackName := c.Locate(dn.Ip, dn.ReportedDataCenter, dn.ReportedRack)
+		if dcName != string(dn.GetDataCenter().Id()) || rackName != string(dn.GetRack().Id()) {
+			glog.V(0).Infof("Moving data node %s from %s/%s to %s/%s", dn.Url(),
+				dn.GetDataCenter().Id(), dn.GetRack().Id(), dcName, rackName)
+			t.UnRegisterDataNode(dn)
+		}
+	}
+	return t.ConfigurationConflicts(), nil
+}
+
+// ConfigurationConflicts lists data nodes whose reported data center or rack differs from the rack map.
+func (t *Topology) ConfigurationConflicts() (conflicts []ConfigurationConflict) {
+	c := t.getConfiguration()
+	for _, dn := range t.dataNodes() {
+		l, ok := c.lookup(dn.Ip)
+		if !ok {
+			continue
+		}
+		if (dn.ReportedDataCenter != "" && dn.ReportedDataCenter != l.dcName) ||
+			(dn.ReportedRack != "" && dn.ReportedRack != l.rackName) {
+			conflicts = append(conflicts, ConfigurationConflict{
+				Url:                dn.Url(),
+				ReportedDataCenter: dn.ReportedDataCenter,
+				ReportedRack:       dn.ReportedRack,
+				DataCenter:         l.dcName,
+				Rack:               l.rackName,
+			})
+		}
+	}
+	return
+}
+
+// DataNodes lists the data nodes of all data centers and racks.
+func (t *Topology) DataNodes() []*DataNode {
+	return t.dataNodes()
+}
+
+func (t *Topology) dataNodes() (ret []*DataNode) {
+	for _, c := range t.Children() {
+		for _, r := range c.Children() {
+			for _, n := range r.Children() {
+				ret = append(ret, n.(*DataNode))
+			}
+		}
+	}
+	return
+}
@@ -8,7 +8,7 @@ import (
 	"github.com/chrislusf/seaweedfs/weed/storage"
 )
 
-func (t *Topology) StartRefreshWritableVolumes(garbageThreshold string) {
+func (t *Topology) StartRefreshWritableVolumes(vacuumPolicy *VacuumPolicy) {
 	go func() {
 		for {
 			if t.IsLeader() {
@@ -18,14 +18,16 @@ func (t *Topology) StartRefreshWritableVolumes(garbageThreshold string) {
 			time.Sleep(time.Duration(float32(t.pulse*1e3)*(1+rand.Float32())) * time.Millisecond)
 		}
 	}()
-	go func(garbageThreshold string) {
-		c := time.Tick(15 * time.Minute)
+	go func(vacuumPolicy *VacuumPolicy) {
+		c := time.Tick(vacuumPolicy.Interval)
 		for _ = range c {
-			if t.IsLeader() {
-				t.Vacuum(garbageThreshold)
+			if t.IsLeader() && vacuumPolicy.IsInWindow(time.Now()) {
+				if err := t.Vacuum(vacuumPolicy, true); err != nil {
+					glog.V(0).Infoln("Skip vacuum:", err)
+				}
 			}
 		}
-	}(garbageThreshold)
+	}(vacuumPolicy)
 	go func() {
 		for {
 			select {
@@ -0,0 +1,36 @@
+package topology
+
+import (
+	"errors"
+
+	"github.com/chrislusf/seaweedfs/weed/security"
+)
+
+// UpdateJwtKeys applies the change to a copy of the JWT keys,
+// and replicates the result to all masters through raft.
+func (t *Topology) UpdateJwtKeys(change func(keys *security.KeySet) error) error {
+	if t.RaftServer == nil {
+		return errors.New("Raft Server not initialized!")
+	}
+	ks := t.JwtKeys.Clone()
+	if err := change(ks); err != nil {
+		return err
+	}
+	keys, signingKeyId := ks.Keys()
+	_, err := t.RaftServer.Do(NewJwtKeysCommand(keys, signingKeyId))
+	return err
+}
+
+// UpdateAccessKeys applies the change to a copy of the access keys,
+// and replicates the result to all masters through raft.
+func (t *Topology) UpdateAccessKeys(change func(keys *security.AccessKeys) error) error {
+	if t.RaftServer == nil {
+		return errors.New("Raft Server not initialized!")
+	}
+	ak := t.AccessKeys.Clone()
+	if err := change(ak); err != nil {
+		return err
+	}
+	_, err := t.RaftServer.Do(NewAccessKeysCommand(ak.List()))
+	return err
+}
@@ -4,19 +4,21 @@ import (
 	"encoding/json"
 	"errors"
 	"net/url"
+	"sync"
 	"time"
 
 	"github.com/chrislusf/seaweedfs/weed/glog"
+	"github.com/chrislusf/seaweedfs/weed/security"
 	"github.com/chrislusf/seaweedfs/weed/storage"
 	"github.com/chrislusf/seaweedfs/weed/util"
 )
 
-func batchVacuumVolumeCheck(vl *VolumeLayout, vid storage.VolumeId, locationlist *VolumeLocationList, garbageThreshold string) bool {
+func batchVacuumVolumeCheck(vl *VolumeLayout, vid storage.VolumeId, locationlist *VolumeLocationList, garbageThreshold string, jwt security.EncodedJwt) bool {
 	ch := make(chan bool, locationlist.Length())
 	for index, dn := range locationlist.list {
 		go func(index int, url string, vid storage.VolumeId) {
 			//glog.V(0).Infoln(index, "Check vacuuming", vid, "on", dn.Url())
-			if e, ret := vacuumVolume_Check(url, vid, garbageThreshold); e != nil {
+			if e, ret := vacuumVolume_Check(url, vid, garbageThreshold, jwt); e != nil {
 				//glog.V(0).Infoln(index, "Error when checking vacuuming", vid, "on", url, e)
 				ch <- false
 			} else {
@@ -37,13 +39,13 @@ func batchVacuumVolumeCheck(vl *VolumeLayout, vid storage.VolumeId, locationlist
 	}
 	return isCheckSuccess
 }
-func batchVacuumVolumeCompact(vl *VolumeLayout, vid storage.VolumeId, locationlist *VolumeLocationList) bool {
-	vl.removeFromWritable(vid)
+func batchVacuumVolumeCompact(vl *VolumeLayout, vid storage.VolumeId, locationlist *VolumeLocationList, jwt security.EncodedJwt) bool {
+	vl.SetVolumeReadOnly(vid)
 	ch := make(chan bool, locationlist.Length())
 	for index, dn := range locationlist.list {
 		go func(index int, url string, vid storage.VolumeId) {
 			glog.V(0).Infoln(index, "Start vacuuming", vid, "on", url)
-			if e := vacuumVolume_Compact(url, vid); e != nil {
+			if e := vacuumVolume_Compact(url, vid, jwt); e != nil {
 				glog.V(0).Infoln(index, "Error when vacuuming", vid, "on", url, e)
 				ch <- false
 			} else {
@@ -55,7 +57,8 @@ func batchVacuumVolumeCompact(vl *VolumeLayout, vid storage.VolumeId, locationli
 	isVacuumSuccess := true
 	for _ = range locationlist.list {
 		select {
-		case _ = <-ch:
+		case ok := <-ch:
+			isVacuumSuccess = isVacuumSuccess && ok
 		case <-time.After(30 * time.Minute):
 			isVacuumSuccess = false
 			break
@@ -63,11 +66,11 @@ func batchVacuumVolumeCompact(vl *VolumeLayout, vid storage.VolumeId, locationli
 	}
 	return isVacuumSuccess
 }
-func batchVacuumVolumeCommit(vl *VolumeLayout, vid storage.VolumeId, locationlist *VolumeLocationList) bool {
+func batchVacuumVolumeCommit(vl *VolumeLayout, vid storage.VolumeId, locationlist *VolumeLocationList, jwt security.EncodedJwt) bool {
 	isCommitSuccess := true
 	for _, dn := range locationlist.list {
 		glog.V(0).Infoln("Start Commiting vacuum", vid, "on", dn.Url())
-		if e := vacuumVolume_Commit(dn.Url(), vid); e != nil {
+		if e := vacuumVolume_Commit(dn.Url(), vid, jwt); e != nil {
 			glog.V(0).Infoln("Error when committing vacuum", vid, "on", dn.Url(), e)
 			isCommitSuccess = false
 		} else {
@@ -79,25 +82,194 @@ func batchVacuumVolumeCommit(vl *VolumeLayout, vid storage.VolumeId, locationlis
 	}
 	return isCommitSuccess
 }
-func (t *Topology) Vacuum(garbageThreshold string) int {
-	glog.V(0).Infof("Start vacuum on demand with threshold:%s", garbageThreshold)
+
+type vacuumTask struct {
+	collection   string
+	volumeLayout *VolumeLayout
+	vid          storage.VolumeId
+	locationlist *VolumeLocationList
+}
+
+// VacuumStatus tracks the progress of the current or last vacuum run
+type VacuumStatus struct {
+	sync.Mutex
+	isRunning  bool
+	startTime  time.Time
+	finishTime time.Time
+	total      int
+	checked    int
+	compacted  int
+	failed     int
+	inProgress map[storage.VolumeId][]string
+	//master启动以来的累计值
+	runs           int64
+	totalChecked   int64
+	totalCompacted int64
+	totalFailed    int64
+}
+
+func (vs *VacuumStatus) begin(total int) bool {
+	vs.Lock()
+	defer vs.Unlock()
+	if vs.isRunning {
+		return false
+	}
+	vs.isRunning, vs.startTime, vs.total = true, time.Now(), total
+	vs.runs++
+	vs.checked, vs.compacted, vs.failed = 0, 0, 0
+	vs.inProgress = make(map[storage.VolumeId][]string)
+	return true
+}
+
+func (vs *VacuumStatus) end() {
+	vs.Lock()
+	defer vs.Unlock()
+	vs.isRunning, vs.finishTime = false, time.Now()
+}
+
+func (vs *VacuumStatus) startVolume(vid storage.VolumeId, locationlist *VolumeLocationList) {
+	vs.Lock()
+	defer vs.Unlock()
+	var urls []string
+	for _, dn := range locationlist.list {
+		urls = append(urls, dn.Url())
+	}
+	vs.inProgress[vid] = urls
+}
+
+func (vs *VacuumStatus) finishVolume(vid storage.VolumeId, isCompacted, isSuccess bool) {
+	vs.Lock()
+	defer vs.Unlock()
+	delete(vs.inProgress, vid)
+	vs.checked++
+	vs.totalChecked++
+	if isCompacted {
+		vs.compacted++
+		vs.totalCompacted++
+	}
+	if !isSuccess {
+		vs.failed++
+		vs.totalFailed++
+	}
+}
+
+// VacuumCounters are the vacuum activities since the master started.
+type VacuumCounters struct {
+	IsRunning  bool
+	FinishTime time.Time
+	Runs       int64
+	Checked    int64
+	Compacted  int64
+	Failed     int64
+}
+
+func (vs *VacuumStatus) Counters() VacuumCounters {
+	vs.Lock()
+	defer vs.Unlock()
+	return VacuumCounters{
+		IsRunning:  vs.isRunning,
+		FinishTime: vs.finishTime,
+		Runs:       vs.runs,
+		Checked:    vs.totalChecked,
+		Compacted:  vs.totalCompacted,
+		Failed:     vs.totalFailed,
+	}
+}
+
+func (vs *VacuumStatus) ToMap() map[string]interface{} {
+	vs.Lock()
+	defer vs.Unlock()
+	m := make(map[string]interface{})
+	m["IsRunning"] = vs.isRunning
+	m["StartTime"] = vs.startTime
+	m["FinishTime"] = vs.finishTime
+	m["Total"] = vs.total
+	m["Checked"] = vs.checked
+	m["Compacted"] = vs.compacted
+	m["Failed"] = vs.failed
+	inProgress := make(map[string][]string)
+	for vid, urls := range vs.inProgress {
+		inProgress[vid.String()] = urls
+	}
+	m["InProgress"] = inProgress
+	return m
+}
+
+// Vacuum checks and compacts all volumes, limited by the policy's concurrency per data node.
+// If checkWindow is true, no new volume is vacuumed outside of the policy's time windows.
+func (t *Topology) Vacuum(policy *VacuumPolicy, checkWindow bool) error {
+	glog.V(0).Infof("Start vacuum with policy:%+v", policy)
+	var tasks []*vacuumTask
 	for _, col := range t.collectionMap.Items() {
 		c := col.(*Collection)
 		for _, vl := range c.storageType2VolumeLayout.Items() {
 			if vl != nil {
 				volumeLayout := vl.(*VolumeLayout)
+				volumeLayout.accessLock.RLock()
 				for vid, locationlist := range volumeLayout.vid2location {
-					glog.V(0).Infof("check vacuum on collection:%s volume:%d", c.Name, vid)
-					if batchVacuumVolumeCheck(volumeLayout, vid, locationlist, garbageThreshold) {
-						if batchVacuumVolumeCompact(volumeLayout, vid, locationlist) {
-							batchVacuumVolumeCommit(volumeLayout, vid, locationlist)
-						}
-					}
+					tasks = append(tasks, &vacuumTask{c.Name, volumeLayout, vid, locationlist})
 				}
+				volumeLayout.accessLock.RUnlock()
 			}
 		}
 	}
-	return 0
+	if !t.vacuumStatus.begin(len(tasks)) {
+		return errors.New("vacuum is already running")
+	}
+	defer t.vacuumStatus.end()
+
+	nodeSlots := make(map[NodeId]chan bool)
+	var wg sync.WaitGroup
+	for _, task := range tasks {
+		if checkWindow && !policy.IsInWindow(time.Now()) {
+			glog.V(0).Infof("Stop vacuum outside of time windows %v", policy.Windows)
+			break
+		}
+		// only this loop acquires slots, so there is no dead lock
+		var slots []chan bool
+		for _, dn := range task.locationlist.list {
+			slot, ok := nodeSlots[dn.Id()]
+			if !ok {
+				slot = make(chan bool, policy.MaxConcurrentPerNode)
+				nodeSlots[dn.Id()] = slot
+			}
+			slot <- true
+			slots = append(slots, slot)
+		}
+		wg.Add(1)
+		go func(task *vacuumTask, slots []chan bool) {
+			defer wg.Done()
+			defer func() {
+				for _, slot := range slots {
+					<-slot
+				}
+			}()
+			t.vacuumOneVolume(task, policy.Threshold(task.collection))
+		}(task, slots)
+	}
+	wg.Wait()
+	return nil
+}
+
+func (t *Topology) vacuumOneVolume(task *vacuumTask, garbageThreshold string) {
+	glog.V(0).Infof("check vacuum on collection:%s volume:%d", task.collection, task.vid)
+	t.vacuumStatus.startVolume(task.vid, task.locationlist)
+	isCompacted, isSuccess := false, true
+	//压缩可能很久，每一步重新签发令牌
+	if batchVacuumVolumeCheck(task.volumeLayout, task.vid, task.locationlist, garbageThreshold, t.clusterJwt()) {
+		isCompacted = true
+		isSuccess = batchVacuumVolumeCompact(task.volumeLayout, task.vid, task.locationlist, t.clusterJwt()) &&
+			batchVacuumVolumeCommit(task.volumeLayout, task.vid, task.locationlist, t.clusterJwt())
+	}
+	t.vacuumStatus.finishVolume(task.vid, isCompacted, isSuccess)
+}
+
+func (t *Topology) VacuumStatus() map[string]interface{} {
+	return t.vacuumStatus.ToMap()
+}
+
+func (t *Topology) VacuumCounters() VacuumCounters {
+	return t.vacuumStatus.Counters()
 }
 
 type VacuumVolumeResult struct {
@@ -105,11 +277,11 @@ type VacuumVolumeResult struct {
 	Error  string
 }
 
-func vacuumVolume_Check(urlLocation string, vid storage.VolumeId, garbageThreshold string) (error, bool) {
+func vacuumVolume_Check(urlLocation string, vid storage.VolumeId, garbageThreshold string, jwt security.EncodedJwt) (error, bool) {
 	values := make(url.Values)
 	values.Add("volume", vid.String())
 	values.Add("garbageThreshold", garbageThreshold)
-	jsonBlob, err := util.Post("http://"+urlLocation+"/admin/vacuum/check", values)
+	jsonBlob, err := util.PostWithJwt(util.SchemePrefix+urlLocation+"/admin/vacuum/check", values, jwt)
 	if err != nil {
 		glog.V(0).Infoln("parameters:", values)
 		return err, false
@@ -123,10 +295,10 @@ func vacuumVolume_Check(urlLocation string, vid storage.VolumeId, garbageThresho
 	}
 	return nil, ret.Result
 }
-func vacuumVolume_Compact(urlLocation string, vid storage.VolumeId) error {
+func vacuumVolume_Compact(urlLocation string, vid storage.VolumeId, jwt security.EncodedJwt) error {
 	values := make(url.Values)
 	values.Add("volume", vid.String())
-	jsonBlob, err := util.Post("http://"+urlLocation+"/admin/vacuum/compact", values)
+	jsonBlob, err := util.PostWithJwt(util.SchemePrefix+urlLocation+"/admin/vacuum/compact", values, jwt)
 	if err != nil {
 		return err
 	}
@@ -139,10 +311,10 @@ func vacuumVolume_Compact(urlLocation string, vid storage.VolumeId) error {
 	}
 	return nil
 }
-func vacuumVolume_Commit(urlLocation string, vid storage.VolumeId) error {
+func vacuumVolume_Commit(urlLocation string, vid storage.VolumeId, jwt security.EncodedJwt) error {
 	values := make(url.Values)
 	values.Add("volume", vid.String())
-	jsonBlob, err := util.Post("http://"+urlLocation+"/admin/vacuum/commit", values)
+	jsonBlob, err := util.PostWithJwt(util.SchemePrefix+urlLocation+"/admin/vacuum/commit", values, jwt)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,146 @@
+package topology
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// VacuumWindow is a daily time window, in minutes since midnight local time.
+// End can be smaller than Start if the window spans midnight.
+type VacuumWindow struct {
+	Start int
+	End   int
+}
+
+func (w VacuumWindow) Contains(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	if w.Start <= w.End {
+		return w.Start <= minute && minute < w.End
+	}
+	return w.Start <= minute || minute < w.End
+}
+
+func (w VacuumWindow) String() string {
+	return fmt.Sprintf("%02d:%02d-%02d:%02d", w.Start/60, w.Start%60, w.End/60, w.End%60)
+}
+
+// VacuumPolicy decides when and how aggressively volumes are vacuumed.
+type VacuumPolicy struct {
+	GarbageThreshold     string
+	CollectionThresholds map[string]string
+	Windows              []VacuumWindow // empty means vacuuming is allowed at any time
+	MaxConcurrentPerNode int
+	Interval             time.Duration
+}
+
+// NewVacuumPolicy parses the collection thresholds in the form of "collection:threshold,..."
+// and the time windows in the form of "HH:MM-HH:MM,...".
+func NewVacuumPolicy(garbageThreshold, collectionThresholds, windows string, maxConcurrentPerNode int, interval time.Duration) (*VacuumPolicy, error) {
+	p := &VacuumPolicy{
+		GarbageThreshold:     garbageThreshold,
+		CollectionThresholds: make(map[string]string),
+		MaxConcurrentPerNode: maxConcurrentPerNode,
+		Interval:             interval,
+	}
+	if _, err := strconv.ParseFloat(garbageThreshold, 32); err != nil {
+		return nil, fmt.Errorf("garbageThreshold %s is not a valid float number", garbageThreshold)
+	}
+	if p.MaxConcurrentPerNode <= 0 {
+		p.MaxConcurrentPerNode = 1
+	}
+	if p.Interval <= 0 {
+		p.Interval = 15 * time.Minute
+	}
+	for _, entry := range strings.Split(collectionThresholds, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		sep := strings.LastIndex(entry, ":")
+		if sep < 0 {
+			return nil, fmt.Errorf("collection threshold %s should be collection:threshold", entry)
+		}
+		collection, threshold := entry[:sep], entry[sep+1:]
+		if _, err := strconv.ParseFloat(threshold, 32); err != nil {
+			return nil, fmt.Errorf("threshold %s for collection %s is not a valid float number", threshold, collection)
+		}
+		p.CollectionThresholds[collection] = threshold
+	}
+	var err error
+	if p.Windows, err = ParseVacuumWindows(windows); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func ParseVacuumWindows(windows string) (ret []VacuumWindow, err error) {
+	for _, entry := range strings.Split(windows, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		parts := strings.Split(entry, "-")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("vacuum window %s should be HH:MM-HH:MM", entry)
+		}
+		var w VacuumWindow
+		if w.Start, err = parseMinuteOfDay(parts[0]); err != nil {
+			return nil, err
+		}
+		if w.End, err = parseMinuteOfDay(parts[1]); err != nil {
+			return nil, err
+		}
+		ret = append(ret, w)
+	}
+	return
+}
+
+func parseMinuteOfDay(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %s: %v", s, err)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Threshold returns the garbage threshold of the collection
+func (p *VacuumPolicy) Threshold(collection string) string {
+	if threshold, ok := p.CollectionThresholds[collection]; ok {
+		return threshold
+	}
+	return p.GarbageThreshold
+}
+
+func (p *VacuumPolicy) IsInWindow(t time.Time) bool {
+	if len(p.Windows) == 0 {
+		return true
+	}
+	for _, w := range p.Windows {
+		if w.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// WithGarbageThreshold returns a copy of the policy using the same threshold for all collections
+func (p *VacuumPolicy) WithGarbageThreshold(garbageThreshold string) *VacuumPolicy {
+	ret := *p
+	ret.GarbageThreshold = garbageThreshold
+	ret.CollectionThresholds = nil
+	return &ret
+}
+
+func (p *VacuumPolicy) ToMap() map[string]interface{} {
+	m := make(map[string]interface{})
+	m["GarbageThreshold"] = p.GarbageThreshold
+	m["CollectionThresholds"] = p.CollectionThresholds
+	var windows []string
+	for _, w := range p.Windows {
+		windows = append(windows, w.String())
+	}
+	m["Windows"] = windows
+	m["MaxConcurrentPerNode"] = p.MaxConcurrentPerNode
+	m["Interval"] = p.Interval.String()
+	return m
+}
@@ -0,0 +1,52 @@
+package topology
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVacuumPolicyThreshold(t *testing.T) {
+	p, err := NewVacuumPolicy("0.3", "logs:0.1, images:0.5", "", 0, 0)
+	if err != nil {
+		t.Fatalf("new vacuum policy: %v", err)
+	}
+	if p.Threshold("logs") != "0.1" || p.Threshold("images") != "0.5" || p.Threshold("") != "0.3" {
+		t.Errorf("unexpected thresholds: %+v", p.CollectionThresholds)
+	}
+	if p.MaxConcurrentPerNode != 1 || p.Interval != 15*time.Minute {
+		t.Errorf("unexpected defaults: %+v", p)
+	}
+	if _, err = NewVacuumPolicy("0.3", "logs", "", 1, time.Minute); err == nil {
+		t.Errorf("expecting error for missing collection threshold")
+	}
+}
+
+func TestVacuumPolicyWindows(t *testing.T) {
+	p, err := NewVacuumPolicy("0.3", "", "01:00-05:00,22:30-00:30", 1, time.Minute)
+	if err != nil {
+		t.Fatalf("new vacuum policy: %v", err)
+	}
+	at := func(hour, minute int) time.Time {
+		return time.Date(2016, 1, 1, hour, minute, 0, 0, time.Local)
+	}
+	for _, c := range []struct {
+		t        time.Time
+		expected bool
+	}{
+		{at(0, 0), true},
+		{at(0, 30), false},
+		{at(1, 0), true},
+		{at(4, 59), true},
+		{at(5, 0), false},
+		{at(12, 0), false},
+		{at(22, 30), true},
+		{at(23, 59), true},
+	} {
+		if p.IsInWindow(c.t) != c.expected {
+			t.Errorf("%v in window should be %v", c.t, c.expected)
+		}
+	}
+	if _, err = ParseVacuumWindows("1am-5am"); err == nil {
+		t.Errorf("expecting error for invalid window")
+	}
+}
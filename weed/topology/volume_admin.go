@@ -0,0 +1,107 @@
+package topology
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/chrislusf/seaweedfs/weed/glog"
+	"github.com/chrislusf/seaweedfs/weed/security"
+	"github.com/chrislusf/seaweedfs/weed/storage"
+	"github.com/chrislusf/seaweedfs/weed/util"
+)
+
+type VolumeAdminResult struct {
+	Error string
+}
+
+// MarkVolumeReadOnly freezes or unfreezes all replicas of a volume.
+func (t *Topology) MarkVolumeReadOnly(collection string, vid storage.VolumeId, readOnly bool) error {
+	vl, locationList, err := t.findVolumeLayout(collection, vid)
+	if err != nil {
+		return err
+	}
+	if readOnly {
+		vl.SetVolumeReadOnly(vid)
+	}
+	path := "/admin/volume/writable"
+	if readOnly {
+		path = "/admin/volume/readonly"
+	}
+	ret := DistributedOperationResult(make(map[string]error))
+	for _, dn := range locationList {
+		if err := volumeAdminOperation(dn.Url(), path, vid, t.clusterJwt()); err != nil {
+			glog.V(0).Infoln("Error when marking volume", vid, "on", dn.Url(), "readonly", readOnly, err)
+			ret[dn.Url()] = err
+			continue
+		}
+		dn.MarkVolumeReadOnly(vid, readOnly)
+	}
+	if err := ret.Error(); err != nil {
+		return err
+	}
+	if !readOnly {
+		vl.SetVolumeWritable(vid)
+	}
+	return nil
+}
+
+// DeleteVolume removes all replicas of a volume from the volume servers.
+func (t *Topology) DeleteVolume(collection string, vid storage.VolumeId) error {
+	vl, locationList, err := t.findVolumeLayout(collection, vid)
+	if err != nil {
+		return err
+	}
+	vl.SetVolumeReadOnly(vid)
+	ret := DistributedOperationResult(make(map[string]error))
+	for _, dn := range locationList {
+		if err := volumeAdminOperation(dn.Url(), "/admin/volume/delete", vid, t.clusterJwt()); err != nil {
+			glog.V(0).Infoln("Error when deleting volume", vid, "on", dn.Url(), err)
+			ret[dn.Url()] = err
+			continue
+		}
+		vl.SetVolumeUnavailable(dn, vid)
+		dn.DeleteVolume(vid)
+	}
+	return ret.Error()
+}
+
+func (t *Topology) findVolumeLayout(collection string, vid storage.VolumeId) (*VolumeLayout, []*DataNode, error) {
+	locationList := t.Lookup(collection, vid)
+	for _, dn := range locationList {
+		if v, err := dn.GetVolumesById(vid); err == nil {
+			return t.GetVolumeLayout(v.Collection, v.ReplicaPlacement, v.Ttl), append([]*DataNode(nil), locationList...), nil
+		}
+	}
+	return nil, nil, fmt.Errorf("volume id %d or collection %s not found", vid, collection)
+}
+
+//卷服务器有访问密钥时需要集群成员的令牌
+func (t *Topology) clusterJwt() security.EncodedJwt {
+	return signCluster(t.JwtKeys)
+}
+
+func signCluster(keys *security.KeySet) security.EncodedJwt {
+	if keys == nil {
+		return ""
+	}
+	return keys.SignCluster()
+}
+
+func volumeAdminOperation(urlLocation string, path string, vid storage.VolumeId, jwt security.EncodedJwt) error {
+	values := make(url.Values)
+	values.Add("volume", vid.String())
+	jsonBlob, err := util.PostWithJwt(util.SchemePrefix+urlLocation+path, values, jwt)
+	if err != nil {
+		return err
+	}
+	var ret VolumeAdminResult
+	if err := json.Unmarshal(jsonBlob, &ret); err != nil {
+		return fmt.Errorf("Invalid JSON result for %s: %s", path, string(jsonBlob))
+	}
+	if ret.Error != "" {
+		return errors.New(ret.Error)
+	}
+	return nil
+}
@@ -0,0 +1,336 @@
+package topology
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/chrislusf/seaweedfs/weed/glog"
+	"github.com/chrislusf/seaweedfs/weed/operation"
+	"github.com/chrislusf/seaweedfs/weed/security"
+	"github.com/chrislusf/seaweedfs/weed/storage"
+	"github.com/chrislusf/seaweedfs/weed/util"
+)
+
+// VolumeCheckOption controls how replicas are compared and repaired.
+type VolumeCheckOption struct {
+	// CopyMissing copies needles missing on a replica from another replica
+	CopyMissing bool
+	// ApplyTombstones deletes needles that are already deleted on another replica
+	ApplyTombstones bool
+	// MaxSamples limits the needle keys listed for each kind of difference
+	MaxSamples int
+	// Keys signs the requests to the replicas, which need a token once access keys are added
+	Keys *security.KeySet
+}
+
+// NeedleDiff counts the needles with one kind of difference, and lists the keys of some of them in hex.
+// The index has no cookies, so the keys are not file ids.
+type NeedleDiff struct {
+	Count int      `json:"count"`
+	Keys  []string `json:"keys,omitempty"`
+}
+
+func (d *NeedleDiff) add(key uint64, maxSamples int) {
+	d.Count++
+	if len(d.Keys) < maxSamples {
+		d.Keys = append(d.Keys, fmt.Sprintf("%x", key))
+	}
+}
+
+type ReplicaCheckResult struct {
+	Url     string `json:"url"`
+	Needles int    `json:"needles"`
+	// Missing needles are live on another replica, but unknown here
+	Missing NeedleDiff `json:"missing"`
+	// Extra needles are live here, but unknown on another replica
+	Extra NeedleDiff `json:"extra"`
+	// MissingTombstones are live here, but deleted on another replica
+	MissingTombstones NeedleDiff `json:"missingTombstones"`
+	// SizeMismatches are live here and on another replica, with different sizes
+	SizeMismatches NeedleDiff `json:"sizeMismatches"`
+	Copied         int        `json:"copied,omitempty"`
+	Deleted        int        `json:"deleted,omitempty"`
+	Error          string     `json:"error,omitempty"`
+}
+
+type VolumeCheckResult struct {
+	VolumeId   storage.VolumeId      `json:"volumeId"`
+	Consistent bool                  `json:"consistent"`
+	Replicas   []*ReplicaCheckResult `json:"replicas"`
+	Error      string                `json:"error,omitempty"`
+}
+
+//复制节点上索引的内容，size为0表示已经删除
+type replicaIndex struct {
+	url    string
+	status *operation.SyncVolumeResponse
+	idx    map[uint64]needleIndexEntry
+}
+
+type needleIndexEntry struct {
+	offset uint32
+	size   uint32
+}
+
+func (e needleIndexEntry) isDeleted() bool {
+	return e.offset == 0 || e.size == 0
+}
+
+func fetchReplicaIndex(url string, vid storage.VolumeId, keys *security.KeySet) (*replicaIndex, error) {
+	status, err := operation.GetVolumeSyncStatus(url, vid.String(), signCluster(keys))
+	if err != nil {
+		return nil, err
+	}
+	ri := &replicaIndex{url: url, status: status, idx: make(map[uint64]needleIndexEntry)}
+	err = operation.GetVolumeIdxEntries(url, vid.String(), signCluster(keys), func(key uint64, offset, size uint32) {
+		ri.idx[key] = needleIndexEntry{offset: offset, size: size}
+	})
+	return ri, err
+}
+
+// CheckVolumeReplicas compares the needles of all replicas of a volume, and optionally repairs them.
+// A needle deleted before a compaction is unknown to the compacted replicas, so copying missing
+// needles can bring it back if another replica missed the deletion. For the same reason,
+// missing needles are not copied while the replicas have different compaction revisions.
+func CheckVolumeReplicas(vid storage.VolumeId, urls []string, option *VolumeCheckOption) *VolumeCheckResult {
+	ret := &VolumeCheckResult{VolumeId: vid}
+	var replicas []*replicaIndex
+	for _, url := range urls {
+		rr := &ReplicaCheckResult{Url: url}
+		ret.Replicas = append(ret.Replicas, rr)
+		ri, err := fetchReplicaIndex(url, vid, option.Keys)
+		if err != nil {
+			rr.Error = err.Error()
+			ret.Error = fmt.Sprintf("failed to read index from %s: %v", url, err)
+			continue
+		}
+		replicas = append(replicas, ri)
+	}
+	if ret.Error != "" {
+		return ret
+	}
+	if len(replicas) < 2 {
+		ret.Consistent = true
+		return ret
+	}
+
+	var keys []uint64
+	seen := make(map[uint64]bool)
+	for _, ri := range replicas {
+		for key := range ri.idx {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Sort(uint64Slice(keys))
+
+	ret.Consistent = true
+	for i, ri := range replicas {
+		rr := ret.Replicas[i]
+		for _, key := range keys {
+			e, found := ri.idx[key]
+			if found && !e.isDeleted() {
+				rr.Needles++
+			}
+			for j, other := range replicas {
+				if i == j {
+					continue
+				}
+				o, otherFound := other.idx[key]
+				switch {
+				case !found && otherFound && !o.isDeleted():
+					rr.Missing.add(key, option.MaxSamples)
+				case found && !e.isDeleted() && !otherFound:
+					rr.Extra.add(key, option.MaxSamples)
+				case found && !e.isDeleted() && otherFound && o.isDeleted():
+					rr.MissingTombstones.add(key, option.MaxSamples)
+				case found && !e.isDeleted() && otherFound && !o.isDeleted() && e.size != o.size:
+					rr.SizeMismatches.add(key, option.MaxSamples)
+				default:
+					continue
+				}
+				ret.Consistent = false
+				break
+			}
+		}
+	}
+	if !ret.Consistent && (option.CopyMissing || option.ApplyTombstones) {
+		repairOption := option
+		if option.CopyMissing && !sameCompactRevision(replicas) {
+			//一边压缩过的卷会把已删除的needle当作缺失，等压缩都完成后再复制
+			ret.Error = "replicas have different compaction revisions, not copying missing needles"
+			noCopy := *option
+			noCopy.CopyMissing = false
+			repairOption = &noCopy
+		}
+		repairVolumeReplicas(vid, replicas, ret, repairOption)
+	}
+	return ret
+}
+
+func sameCompactRevision(replicas []*replicaIndex) bool {
+	for _, ri := range replicas {
+		if ri.status.CompactRevision != replicas[0].status.CompactRevision {
+			return false
+		}
+	}
+	return true
+}
+
+func repairVolumeReplicas(vid storage.VolumeId, replicas []*replicaIndex, ret *VolumeCheckResult, option *VolumeCheckOption) {
+	for i, ri := range replicas {
+		rr := ret.Replicas[i]
+		for key, e := range ri.idx {
+			if option.ApplyTombstones && !e.isDeleted() && isDeletedOnOtherReplica(replicas, i, key) {
+				if err := deleteReplicaNeedle(vid, ri, key, e, option.Keys); err != nil {
+					glog.V(0).Infof("Failed to delete %d,%x on %s: %v", vid, key, ri.url, err)
+					rr.Error = err.Error()
+				} else {
+					rr.Deleted++
+				}
+			}
+		}
+		if !option.CopyMissing {
+			continue
+		}
+		for j, source := range replicas {
+			if i == j {
+				continue
+			}
+			for key, e := range source.idx {
+				if _, found := ri.idx[key]; found || e.isDeleted() || isDeletedOnOtherReplica(replicas, j, key) {
+					continue
+				}
+				if err := copyReplicaNeedle(vid, source, ri, key, e, option.Keys); err != nil {
+					glog.V(0).Infof("Failed to copy %d,%x from %s to %s: %v", vid, key, source.url, ri.url, err)
+					rr.Error = err.Error()
+					continue
+				}
+				ri.idx[key] = e
+				rr.Copied++
+			}
+		}
+	}
+}
+
+func isDeletedOnOtherReplica(replicas []*replicaIndex, i int, key uint64) bool {
+	for j, other := range replicas {
+		if o, found := other.idx[key]; j != i && found && o.isDeleted() {
+			return true
+		}
+	}
+	return false
+}
+
+func copyReplicaNeedle(vid storage.VolumeId, source, target *replicaIndex, key uint64, e needleIndexEntry, keys *security.KeySet) error {
+	blob, err := operation.GetVolumeNeedleBlob(source.url, vid.String(), key, e.offset, e.size, source.status.CompactRevision, signCluster(keys))
+	if err != nil {
+		return err
+	}
+	_, err = operation.ReplicateNeedle(target.url, vid.String(), source.status.Version, blob, signCluster(keys))
+	return err
+}
+
+//删除需要needle的cookie，从复制节点自己的数据中读取
+func deleteReplicaNeedle(vid storage.VolumeId, ri *replicaIndex, key uint64, e needleIndexEntry, keys *security.KeySet) error {
+	blob, err := operation.GetVolumeNeedleBlob(ri.url, vid.String(), key, e.offset, e.size, ri.status.CompactRevision, signCluster(keys))
+	if err != nil {
+		return err
+	}
+	if len(blob) < storage.NeedleHeaderSize {
+		return fmt.Errorf("needle blob of %d bytes is too short", len(blob))
+	}
+	n := new(storage.Needle)
+	n.ParseNeedleHeader(blob)
+	fid := storage.NewFileId(vid, key, n.Cookie)
+	var jwt security.EncodedJwt
+	if keys != nil {
+		jwt = keys.Sign(fid.String())
+	}
+	return util.Delete(util.SchemePrefix+ri.url+"/"+fid.String()+"?type=replicate", jwt)
+}
+
+type uint64Slice []uint64
+
+func (s uint64Slice) Len() int           { return len(s) }
+func (s uint64Slice) Less(i, j int) bool { return s[i] < s[j] }
+func (s uint64Slice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// CheckReplicas checks all volumes with more than one copy.
+func (t *Topology) CheckReplicas(option *VolumeCheckOption) (results []*VolumeCheckResult) {
+	for _, col := range t.collectionMap.Items() {
+		c := col.(*Collection)
+		for _, vl := range c.storageType2VolumeLayout.Items() {
+			if vl == nil {
+				continue
+			}
+			volumeLayout := vl.(*VolumeLayout)
+			volumeLayout.accessLock.RLock()
+			vid2urls := make(map[storage.VolumeId][]string)
+			for vid, locationList := range volumeLayout.vid2location {
+				for _, dn := range locationList.list {
+					vid2urls[vid] = append(vid2urls[vid], dn.Url())
+				}
+			}
+			volumeLayout.accessLock.RUnlock()
+			for vid, urls := range vid2urls {
+				if len(urls) < 2 {
+					continue
+				}
+				result := CheckVolumeReplicas(vid, urls, t.signedCheckOption(option))
+				if !result.Consistent {
+					glog.V(0).Infof("Volume %d replicas are inconsistent: %+v", vid, result.Replicas)
+				}
+				results = append(results, result)
+			}
+		}
+	}
+	t.replicaCheckLock.Lock()
+	t.lastReplicaCheck = results
+	t.replicaCheckLock.Unlock()
+	return
+}
+
+// CheckVolume compares the replicas of one volume, located by topology lookup.
+func (t *Topology) CheckVolume(collection string, vid storage.VolumeId, option *VolumeCheckOption) (*VolumeCheckResult, error) {
+	dataNodes := t.Lookup(collection, vid)
+	if len(dataNodes) == 0 {
+		return nil, fmt.Errorf("volume %d not found", vid)
+	}
+	var urls []string
+	for _, dn := range dataNodes {
+		urls = append(urls, dn.Url())
+	}
+	return CheckVolumeReplicas(vid, urls, t.signedCheckOption(option)), nil
+}
+
+//未指定密钥时用master的JWT密钥签名
+func (t *Topology) signedCheckOption(option *VolumeCheckOption) *VolumeCheckOption {
+	if option.Keys != nil {
+		return option
+	}
+	signed := *option
+	signed.Keys = t.JwtKeys
+	return &signed
+}
+
+// LastReplicaCheck returns the results of the last full replica check.
+func (t *Topology) LastReplicaCheck() []*VolumeCheckResult {
+	t.replicaCheckLock.RLock()
+	defer t.replicaCheckLock.RUnlock()
+	return t.lastReplicaCheck
+}
+
+// StartReplicaCheck checks all replicas periodically, while being the leader.
+func (t *Topology) StartReplicaCheck(interval time.Duration, option *VolumeCheckOption) {
+	go func() {
+		for _ = range time.Tick(interval) {
+			if t.IsLeader() {
+				t.CheckReplicas(option)
+			}
+		}
+	}()
+}
@@ -0,0 +1,141 @@
+package topology
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/chrislusf/seaweedfs/weed/security"
+	"github.com/chrislusf/seaweedfs/weed/util"
+)
+
+// fakeReplica serves the index entries of one volume replica
+func fakeReplica(entries [][3]uint64) *httptest.Server {
+	return fakeCompactedReplica(entries, 0, nil)
+}
+
+// fakeCompactedReplica also reports the compaction revision, and counts the other requests
+func fakeCompactedReplica(entries [][3]uint64, revision int, others *int) *httptest.Server {
+	return httptest.NewServer(replicaHandler(entries, revision, others))
+}
+
+func replicaHandler(entries [][3]uint64, revision int, others *int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/admin/sync/status":
+			fmt.Fprintf(w, `{"Version":2,"CompactRevision":%d}`, revision)
+		case "/admin/sync/index":
+			line := make([]byte, 16)
+			for _, e := range entries {
+				util.Uint64toBytes(line[0:8], e[0])
+				util.Uint32toBytes(line[8:12], uint32(e[1]))
+				util.Uint32toBytes(line[12:16], uint32(e[2]))
+				w.Write(line)
+			}
+		default:
+			if others != nil {
+				*others++
+			}
+			http.NotFound(w, r)
+		}
+	}
+}
+
+func TestCheckVolumeReplicas(t *testing.T) {
+	a := fakeReplica([][3]uint64{{1, 1, 100}, {2, 2, 100}, {3, 3, 100}, {4, 4, 100}})
+	defer a.Close()
+	// needle 2 is missing, 3 is deleted, 4 has another size, 5 is extra
+	b := fakeReplica([][3]uint64{{1, 1, 100}, {3, 3, 100}, {3, 0, 0}, {4, 4, 90}, {5, 5, 100}})
+	defer b.Close()
+
+	urlA, urlB := strings.TrimPrefix(a.URL, "http://"), strings.TrimPrefix(b.URL, "http://")
+	result := CheckVolumeReplicas(7, []string{urlA, urlB}, &VolumeCheckOption{MaxSamples: 10})
+	if result.Error != "" {
+		t.Fatalf("check error: %s", result.Error)
+	}
+	if result.Consistent {
+		t.Fatalf("expected inconsistent replicas")
+	}
+	ra, rb := result.Replicas[0], result.Replicas[1]
+	if ra.Needles != 4 || rb.Needles != 3 {
+		t.Errorf("unexpected needle counts %d, %d", ra.Needles, rb.Needles)
+	}
+	if ra.Missing.Count != 1 || ra.Missing.Keys[0] != "5" {
+		t.Errorf("unexpected missing on a: %+v", ra.Missing)
+	}
+	if ra.Extra.Count != 1 || ra.Extra.Keys[0] != "2" {
+		t.Errorf("unexpected extra on a: %+v", ra.Extra)
+	}
+	if ra.MissingTombstones.Count != 1 || ra.MissingTombstones.Keys[0] != "3" {
+		t.Errorf("unexpected missing tombstones on a: %+v", ra.MissingTombstones)
+	}
+	if ra.SizeMismatches.Count != 1 || rb.SizeMismatches.Count != 1 {
+		t.Errorf("unexpected size mismatches: %+v, %+v", ra.SizeMismatches, rb.SizeMismatches)
+	}
+	if rb.Missing.Count != 1 || rb.Extra.Count != 1 || rb.MissingTombstones.Count != 0 {
+		t.Errorf("unexpected differences on b: %+v", rb)
+	}
+}
+
+func TestCheckVolumeReplicasCompacted(t *testing.T) {
+	var requests int
+	// needle 2 was deleted and compacted away on a, but b missed the deletion
+	a := fakeCompactedReplica([][3]uint64{{1, 1, 100}}, 1, &requests)
+	defer a.Close()
+	b := fakeCompactedReplica([][3]uint64{{1, 1, 100}, {2, 2, 100}}, 0, &requests)
+	defer b.Close()
+
+	urlA, urlB := strings.TrimPrefix(a.URL, "http://"), strings.TrimPrefix(b.URL, "http://")
+	result := CheckVolumeReplicas(7, []string{urlA, urlB}, &VolumeCheckOption{CopyMissing: true, MaxSamples: 10})
+	if result.Consistent || result.Replicas[0].Missing.Count != 1 {
+		t.Fatalf("expected needle 2 missing on a: %+v", result.Replicas[0])
+	}
+	if result.Error == "" || result.Replicas[0].Copied != 0 || requests != 0 {
+		t.Fatalf("expected no copy across compaction revisions, got %d requests: %+v", requests, result)
+	}
+}
+
+// securedReplica serves one needle blob, and only answers the admin requests of cluster members
+func securedReplica(entries [][3]uint64, guard *security.Guard, copied *int) *httptest.Server {
+	replica := replicaHandler(entries, 0, nil)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !guard.IsClusterMember(r) {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":"unauthorized"}`))
+			return
+		}
+		switch r.URL.Path {
+		case "/admin/sync/data":
+			w.Write(make([]byte, 32))
+		case "/admin/replicate_needle":
+			*copied++
+			w.Write([]byte(`{"size":32}`))
+		default:
+			replica(w, r)
+		}
+	}))
+}
+
+func TestCheckVolumeReplicasWithAccessKeys(t *testing.T) {
+	guard, err := security.NewGuard(nil, "secret", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var copied int
+	a := securedReplica([][3]uint64{{1, 1, 100}, {2, 2, 100}}, guard, &copied)
+	defer a.Close()
+	b := securedReplica([][3]uint64{{1, 1, 100}}, guard, &copied)
+	defer b.Close()
+
+	urlA, urlB := strings.TrimPrefix(a.URL, "http://"), strings.TrimPrefix(b.URL, "http://")
+	option := &VolumeCheckOption{CopyMissing: true, MaxSamples: 10, Keys: security.NewKeySet("secret")}
+	result := CheckVolumeReplicas(7, []string{urlA, urlB}, option)
+	if result.Error != "" || result.Replicas[1].Error != "" {
+		t.Fatalf("check error: %+v", result)
+	}
+	if result.Replicas[1].Copied != 1 || copied != 1 {
+		t.Fatalf("expected needle 2 copied to b with a cluster token, got %d", copied)
+	}
+}
@@ -24,6 +24,8 @@ type VolumeGrowOption struct {
 	DataCenter       string
 	Rack             string
 	DataNode         string
+	// Preferred makes DataCenter, Rack and DataNode a preference instead of a requirement.
+	Preferred bool
 }
 
 type VolumeGrowth struct {
@@ -31,7 +33,30 @@ type VolumeGrowth struct {
 }
 
 func (o *VolumeGrowOption) String() string {
-	return fmt.Sprintf("Collection:%s, ReplicaPlacement:%v, Ttl:%v, DataCenter:%s, Rack:%s, DataNode:%s", o.Collection, o.ReplicaPlacement, o.Ttl, o.DataCenter, o.Rack, o.DataNode)
+	return fmt.Sprintf("Collection:%s, ReplicaPlacement:%v, Ttl:%v, DataCenter:%s, Rack:%s, DataNode:%s, Preferred:%v", o.Collection, o.ReplicaPlacement, o.Ttl, o.DataCenter, o.Rack, o.DataNode, o.Preferred)
+}
+
+// RelaxedOptions returns the option itself, followed by copies with the data node,
+// rack and data center constraints dropped one by one, from the nearest to the farthest location.
+func (o *VolumeGrowOption) RelaxedOptions() (options []*VolumeGrowOption) {
+	options = append(options, o)
+	relaxed := *o
+	if relaxed.DataNode != "" {
+		relaxed.DataNode = ""
+		nodeRelaxed := relaxed
+		options = append(options, &nodeRelaxed)
+	}
+	if relaxed.Rack != "" {
+		relaxed.Rack = ""
+		rackRelaxed := relaxed
+		options = append(options, &rackRelaxed)
+	}
+	if relaxed.DataCenter != "" {
+		relaxed.DataCenter = ""
+		dcRelaxed := relaxed
+		options = append(options, &dcRelaxed)
+	}
+	return
 }
 
 func NewDefaultVolumeGrowth() *VolumeGrowth {
@@ -190,7 +215,7 @@ func (vg *VolumeGrowth) findEmptySlotsForOneVolume(topo *Topology, option *Volum
 
 func (vg *VolumeGrowth) grow(topo *Topology, vid storage.VolumeId, option *VolumeGrowOption, servers ...*DataNode) error {
 	for _, server := range servers {
-		if err := AllocateVolume(server, vid, option); err == nil {
+		if err := AllocateVolume(server, vid, option, topo.clusterJwt()); err == nil {
 			vi := storage.VolumeInfo{
 				Id:               vid,
 				Size:             0,
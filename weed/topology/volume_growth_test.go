@@ -133,3 +133,32 @@ func TestFindEmptySlotsForOneVolume(t *testing.T) {
 		fmt.Println("assigned node :", server.Id())
 	}
 }
+
+func TestRelaxedOptions(t *testing.T) {
+	option := &VolumeGrowOption{
+		DataCenter: "dc1",
+		Rack:       "rack1",
+		DataNode:   "server1",
+		Preferred:  true,
+	}
+	options := option.RelaxedOptions()
+	expected := [][3]string{
+		{"dc1", "rack1", "server1"},
+		{"dc1", "rack1", ""},
+		{"dc1", "", ""},
+		{"", "", ""},
+	}
+	if len(options) != len(expected) {
+		t.Fatalf("expected %d options, got %d", len(expected), len(options))
+	}
+	for i, o := range options {
+		if [3]string{o.DataCenter, o.Rack, o.DataNode} != expected[i] {
+			t.Errorf("option %d: %s", i, o)
+		}
+	}
+
+	options = (&VolumeGrowOption{Preferred: true}).RelaxedOptions()
+	if len(options) != 1 {
+		t.Errorf("expected only the original option, got %d", len(options))
+	}
+}
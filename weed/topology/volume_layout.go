@@ -158,6 +158,9 @@ func (vl *VolumeLayout) PickForWrite(count uint64, option *VolumeGrowOption) (*s
 			}
 		}
 	}
+	if counter == 0 {
+		return nil, 0, nil, errors.New("No more writable volumes in " + option.DataCenter + "!")
+	}
 	return &vid, count, locationList, nil
 }
 
@@ -244,6 +247,27 @@ func (vl *VolumeLayout) SetVolumeCapacityFull(vid storage.VolumeId) bool {
 	return vl.removeFromWritable(vid)
 }
 
+func (vl *VolumeLayout) SetVolumeReadOnly(vid storage.VolumeId) bool {
+	vl.accessLock.Lock()
+	defer vl.accessLock.Unlock()
+
+	return vl.removeFromWritable(vid)
+}
+
+func (vl *VolumeLayout) SetVolumeWritable(vid storage.VolumeId) bool {
+	vl.accessLock.Lock()
+	defer vl.accessLock.Unlock()
+
+	location, ok := vl.vid2location[vid]
+	if !ok || location.Length() < vl.rp.GetCopyCount() {
+		return false
+	}
+	if _, ok := vl.oversizedVolumes[vid]; ok {
+		return false
+	}
+	return vl.setVolumeWritable(vid)
+}
+
 func (vl *VolumeLayout) ToMap() map[string]interface{} {
 	m := make(map[string]interface{})
 	m["replication"] = vl.rp.String()
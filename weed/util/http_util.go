@@ -3,7 +3,6 @@ package util
 import (
 	"bytes"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -43,7 +42,20 @@ func PostBytes(url string, body []byte) ([]byte, error) {
 }
 
 func Post(url string, values url.Values) ([]byte, error) {
-	r, err := client.PostForm(url, values)
+	return PostWithJwt(url, values, "")
+}
+
+// PostWithJwt posts the form with the token in the "Authorization" header.
+func PostWithJwt(url string, values url.Values, jwt security.EncodedJwt) ([]byte, error) {
+	req, err := http.NewRequest("POST", url, strings.NewReader(values.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if jwt != "" {
+		req.Header.Set("Authorization", "BEARER "+string(jwt))
+	}
+	r, err := client.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -74,6 +86,18 @@ func Get(url string) ([]byte, error) {
 	return b, nil
 }
 
+func Head(url string) (http.Header, error) {
+	r, err := client.Head(url)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Body.Close()
+	if r.StatusCode >= 400 {
+		return nil, fmt.Errorf("%s: %s", url, r.Status)
+	}
+	return r.Header, nil
+}
+
 func Delete(url string, jwt security.EncodedJwt) error {
 	req, err := http.NewRequest("DELETE", url, nil)
 	if jwt != "" {
@@ -96,16 +120,31 @@ func Delete(url string, jwt security.EncodedJwt) error {
 		return nil
 	}
 	m := make(map[string]interface{})
-	if e := json.Unmarshal(body, m); e == nil {
+	if e := json.Unmarshal(body, &m); e == nil {
 		if s, ok := m["error"].(string); ok {
-			return errors.New(s)
+			return &StatusError{StatusCode: resp.StatusCode, Message: s}
 		}
 	}
-	return errors.New(string(body))
+	return &StatusError{StatusCode: resp.StatusCode, Message: string(body)}
+}
+
+// StatusError is an error answered by the server, with the http status.
+type StatusError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *StatusError) Error() string {
+	return e.Message
 }
 
 func GetBufferStream(url string, values url.Values, allocatedBytes []byte, eachBuffer func([]byte)) error {
-	r, err := client.PostForm(url, values)
+	return GetBufferStreamWithJwt(url, values, "", allocatedBytes, eachBuffer)
+}
+
+// GetBufferStreamWithJwt is GetBufferStream with the token in the "Authorization" header.
+func GetBufferStreamWithJwt(url string, values url.Values, jwt security.EncodedJwt, allocatedBytes []byte, eachBuffer func([]byte)) error {
+	r, err := postForm(url, values, jwt)
 	if err != nil {
 		return err
 	}
@@ -130,7 +169,12 @@ func GetBufferStream(url string, values url.Values, allocatedBytes []byte, eachB
 }
 
 func GetUrlStream(url string, values url.Values, readFn func(io.Reader) error) error {
-	r, err := client.PostForm(url, values)
+	return GetUrlStreamWithJwt(url, values, "", readFn)
+}
+
+// GetUrlStreamWithJwt is GetUrlStream with the token in the "Authorization" header.
+func GetUrlStreamWithJwt(url string, values url.Values, jwt security.EncodedJwt, readFn func(io.Reader) error) error {
+	r, err := postForm(url, values, jwt)
 	if err != nil {
 		return err
 	}
@@ -141,6 +185,18 @@ func GetUrlStream(url string, values url.Values, readFn func(io.Reader) error) e
 	return readFn(r.Body)
 }
 
+func postForm(url string, values url.Values, jwt security.EncodedJwt) (*http.Response, error) {
+	req, err := http.NewRequest("POST", url, strings.NewReader(values.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if jwt != "" {
+		req.Header.Set("Authorization", "BEARER "+string(jwt))
+	}
+	return client.Do(req)
+}
+
 func DownloadUrl(fileUrl string) (filename string, rc io.ReadCloser, e error) {
 	response, err := client.Get(fileUrl)
 	if err != nil {
@@ -165,5 +221,5 @@ func NormalizeUrl(url string) string {
 	if strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://") {
 		return url
 	}
-	return "http://" + url
+	return SchemePrefix + url
 }
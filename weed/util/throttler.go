@@ -0,0 +1,35 @@
+package util
+
+import "time"
+
+// WriteThrottler limits the write rate of a single writer, e.g. a volume compaction.
+// A zero or negative rate means no limit.
+type WriteThrottler struct {
+	bytesPerSecond    int64
+	lastSizeCounter   int64
+	lastSizeCheckTime time.Time
+}
+
+func NewWriteThrottler(bytesPerSecond int64) *WriteThrottler {
+	return &WriteThrottler{
+		bytesPerSecond:    bytesPerSecond,
+		lastSizeCheckTime: time.Now(),
+	}
+}
+
+// MaybeSlowdown records delta bytes written, and sleeps if the writer is going too fast.
+func (wt *WriteThrottler) MaybeSlowdown(delta int64) {
+	if wt.bytesPerSecond <= 0 {
+		return
+	}
+	wt.lastSizeCounter += delta
+	elapsed := time.Since(wt.lastSizeCheckTime)
+	if elapsed < 100*time.Millisecond {
+		return
+	}
+	expected := time.Duration(float64(wt.lastSizeCounter) / float64(wt.bytesPerSecond) * float64(time.Second))
+	if expected > elapsed {
+		time.Sleep(expected - elapsed)
+	}
+	wt.lastSizeCounter, wt.lastSizeCheckTime = 0, time.Now()
+}
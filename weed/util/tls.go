@@ -0,0 +1,107 @@
+package util
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+)
+
+// SchemePrefix starts the urls of requests between cluster members.
+// It becomes "https://" after SetupTLS.
+var SchemePrefix = "http://"
+
+var (
+	serverTLSConfig       *tls.Config //集群内部端口使用，启用双向认证时校验客户端证书
+	publicServerTLSConfig *tls.Config //公共读端口使用，不校验客户端证书
+)
+
+// TLSOption configures TLS for the servers and the shared client transport.
+// Without CertFile and KeyFile all traffic stays in plain http.
+type TLSOption struct {
+	CertFile string
+	KeyFile  string
+	CAFile   string //为空时使用系统根证书
+	Mutual   bool   //集群成员之间双向认证，使用同一证书作为客户端证书，需要CAFile
+}
+
+func (option TLSOption) Enabled() bool {
+	return option.CertFile != "" || option.KeyFile != ""
+}
+
+// SetupTLS loads the certificates, switches the cluster urls to https,
+// and makes the shared client transport trust the CA and present the certificate.
+// It should be called once at startup, before any request is sent.
+func SetupTLS(option TLSOption) error {
+	if !option.Enabled() {
+		if option.CAFile != "" || option.Mutual {
+			return fmt.Errorf("-tls.ca and -tls.mutual need -tls.cert and -tls.key")
+		}
+		return nil
+	}
+	//不指定CA时会用系统根证书校验客户端，任何公开签发的证书都能通过
+	if option.Mutual && option.CAFile == "" {
+		return fmt.Errorf("-tls.mutual needs -tls.ca to verify the client certificates")
+	}
+	cert, err := tls.LoadX509KeyPair(option.CertFile, option.KeyFile)
+	if err != nil {
+		return fmt.Errorf("load certificate %s and key %s: %v", option.CertFile, option.KeyFile, err)
+	}
+	var pool *x509.CertPool
+	if option.CAFile != "" {
+		pem, err := ioutil.ReadFile(option.CAFile)
+		if err != nil {
+			return fmt.Errorf("read CA file %s: %v", option.CAFile, err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificate found in CA file %s", option.CAFile)
+		}
+	}
+
+	publicServerTLSConfig = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	serverTLSConfig = publicServerTLSConfig.Clone()
+	clientTLSConfig := &tls.Config{
+		RootCAs:    pool,
+		MinVersion: tls.VersionTLS12,
+	}
+	if option.Mutual {
+		serverTLSConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		serverTLSConfig.ClientCAs = pool
+		clientTLSConfig.Certificates = []tls.Certificate{cert}
+	}
+	Transport.TLSClientConfig = clientTLSConfig
+	SchemePrefix = "https://"
+	return nil
+}
+
+func TLSEnabled() bool {
+	return serverTLSConfig != nil
+}
+
+// NewTLSListener serves TLS on the listener if TLS is set up,
+// requiring client certificates if mutual TLS is on.
+func NewTLSListener(l net.Listener) net.Listener {
+	if serverTLSConfig == nil {
+		return l
+	}
+	return tls.NewListener(l, serverTLSConfig)
+}
+
+// NewPublicTLSListener serves TLS on a public port, without asking for client certificates.
+func NewPublicTLSListener(l net.Listener) net.Listener {
+	if publicServerTLSConfig == nil {
+		return l
+	}
+	return tls.NewListener(l, publicServerTLSConfig)
+}
+
+// TrimScheme returns the host:port of a url built with SchemePrefix.
+func TrimScheme(url string) string {
+	return strings.TrimPrefix(strings.TrimPrefix(url, "http://"), "https://")
+}
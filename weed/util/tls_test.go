@@ -0,0 +1,124 @@
+package util
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writePem(t *testing.T, path, blockType string, bytes []byte) {
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: bytes}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+//生成CA和由CA签发的127.0.0.1证书
+func generateCertificates(t *testing.T, dir string) TLSOption {
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDer, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "seaweedfs"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caTemplate, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+
+	option := TLSOption{
+		CertFile: filepath.Join(dir, "server.crt"),
+		KeyFile:  filepath.Join(dir, "server.key"),
+		CAFile:   filepath.Join(dir, "ca.crt"),
+		Mutual:   true,
+	}
+	writePem(t, option.CAFile, "CERTIFICATE", caDer)
+	writePem(t, option.CertFile, "CERTIFICATE", der)
+	writePem(t, option.KeyFile, "EC PRIVATE KEY", keyDer)
+	return option
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func() {
+		SchemePrefix, serverTLSConfig, publicServerTLSConfig = "http://", nil, nil
+		Transport.TLSClientConfig = nil
+	}()
+
+	if err := SetupTLS(TLSOption{CAFile: "ca.crt"}); err == nil {
+		t.Fatalf("a CA without a certificate should be rejected")
+	}
+	option := generateCertificates(t, dir)
+	if err := SetupTLS(TLSOption{CertFile: option.CertFile, KeyFile: option.KeyFile, Mutual: true}); err == nil {
+		t.Fatalf("mutual TLS without a CA should be rejected")
+	}
+	if err := SetupTLS(option); err != nil {
+		t.Fatalf("setup: %v", err)
+	}
+	if !TLSEnabled() || SchemePrefix != "https://" {
+		t.Fatalf("TLS should be enabled")
+	}
+
+	serve := func(wrap func(net.Listener) net.Listener) string {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		go http.Serve(wrap(l), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("ok"))
+		}))
+		return l.Addr().String()
+	}
+	cluster, public := serve(NewTLSListener), serve(NewPublicTLSListener)
+
+	if b, err := Get(SchemePrefix + cluster + "/"); err != nil || string(b) != "ok" {
+		t.Fatalf("get from a cluster port: %s %v", b, err)
+	}
+	//不带客户端证书只能访问公共端口
+	noCert := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: Transport.TLSClientConfig.RootCAs}}}
+	if _, err := noCert.Get(SchemePrefix + cluster + "/"); err == nil {
+		t.Fatalf("a cluster port should require a client certificate")
+	}
+	if resp, err := noCert.Get(SchemePrefix + public + "/"); err != nil {
+		t.Fatalf("get from the public port without a client certificate: %v", err)
+	} else {
+		resp.Body.Close()
+	}
+	if TrimScheme(SchemePrefix+cluster) != cluster {
+		t.Fatalf("unexpected trimmed url %s", TrimScheme(SchemePrefix+cluster))
+	}
+}